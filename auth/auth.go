@@ -2,7 +2,9 @@ package auth
 
 import (
 	"errors"
-	"os"
+	"fmt"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/froggu-tantei/ToT/db/database"
@@ -10,39 +12,145 @@ import (
 	"github.com/google/uuid"
 )
 
+// Token types carried in the token_type claim
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// Default token lifetimes when no expiry is configured
+const (
+	DefaultAccessExpiry  = "24h"
+	DefaultRefreshExpiry = "168h" // 7 days
+)
+
+// ErrAuthNotConfigured means Configure was never called, so there is no JWT
+// secret to sign or check tokens with: a server misconfiguration rather than
+// a bad token
+var ErrAuthNotConfigured = errors.New("auth not configured: no JWT secret")
+
+// Token validation failures. ErrTokenExpired means the client should refresh,
+// ErrTokenInvalid that it has to log in again.
+var (
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenInvalid = errors.New("invalid token")
+)
+
+// ExpiredError is the ErrTokenExpired for a correctly signed token, carrying
+// when it expired so clients can tell a stale token from a skewed clock
+type ExpiredError struct {
+	ExpiresAt time.Time
+	err       error
+}
+
+func (e *ExpiredError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrTokenExpired, e.err)
+}
+
+// Is makes errors.Is(err, ErrTokenExpired) hold
+func (e *ExpiredError) Is(target error) bool {
+	return target == ErrTokenExpired
+}
+
+// Settings is the JWT configuration Configure installs
+type Settings struct {
+	Secret          string
+	PreviousSecrets []string // Retired secrets still accepted during a rotation
+	Audiences       []string // Empty means tokens carry no audience
+	AccessExpiry    time.Duration
+	RefreshExpiry   time.Duration
+	Leeway          time.Duration // Clock skew tolerated on exp, nbf and iat
+}
+
+// cachedSettings holds the settings installed by Configure
+var cachedSettings atomic.Pointer[Settings]
+
+// Configure installs settings loaded elsewhere, such as by the config
+// package, so nothing is read from the environment afterwards. It fails when
+// the secret is empty or a lifetime isn't positive.
+func Configure(settings Settings) error {
+	if settings.Secret == "" {
+		return errors.New("JWT secret must be set")
+	}
+	if settings.AccessExpiry <= 0 || settings.RefreshExpiry <= 0 {
+		return errors.New("token expiries must be positive")
+	}
+	if settings.Leeway < 0 {
+		return errors.New("token leeway must not be negative")
+	}
+	settings.PreviousSecrets = slices.Clone(settings.PreviousSecrets)
+	settings.Audiences = slices.Clone(settings.Audiences)
+	cachedSettings.Store(&settings)
+	return nil
+}
+
+// Reset forgets the settings installed by Configure, so tokens fail with
+// ErrAuthNotConfigured until it is called again
+func Reset() {
+	cachedSettings.Store(nil)
+}
+
+// loadSettings returns the settings installed by Configure
+func loadSettings() (*Settings, error) {
+	settings := cachedSettings.Load()
+	if settings == nil {
+		return nil, ErrAuthNotConfigured
+	}
+	return settings, nil
+}
+
 // Claims defines the JWT claim structure
 type Claims struct {
-	UserID   uuid.UUID `json:"user_id"`
-	Username string    `json:"username"`
-	Email    string    `json:"email"`
+	UserID    uuid.UUID `json:"user_id"`
+	TokenType string    `json:"token_type,omitempty"` // Empty on tokens issued before refresh support, treated as access
+
+	// Username is only set on identities built in-process, like the internal
+	// service one. Tokens never carry it or anything else a user can change,
+	// since a token would keep the old value until it expires; handlers look
+	// the user up by UserID instead.
+	Username string `json:"-"`
+
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a new JWT token for a user
+// TokenExpiries returns the configured access and refresh token lifetimes
+func TokenExpiries() (access, refresh time.Duration, err error) {
+	settings, err := loadSettings()
+	if err != nil {
+		return 0, 0, err
+	}
+	return settings.AccessExpiry, settings.RefreshExpiry, nil
+}
+
+// GenerateToken creates a new access token for a user
 func GenerateToken(user database.User) (string, error) {
-	// Get JWT secret from environment variables
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", errors.New("JWT_SECRET must be set in environment")
+	settings, err := loadSettings()
+	if err != nil {
+		return "", err
 	}
+	return generateToken(user, TokenTypeAccess, settings.AccessExpiry)
+}
 
-	// Get JWT expiry from environment or use default
-	jwtExpiry := os.Getenv("JWT_EXPIRY")
-	if jwtExpiry == "" {
-		jwtExpiry = "24h" // Default expiry time
+// GenerateRefreshToken creates a new long-lived refresh token for a user
+func GenerateRefreshToken(user database.User) (string, error) {
+	settings, err := loadSettings()
+	if err != nil {
+		return "", err
 	}
+	return generateToken(user, TokenTypeRefresh, settings.RefreshExpiry)
+}
 
-	// Parse expiration duration
-	expiryDuration, err := time.ParseDuration(jwtExpiry)
+// generateToken signs a token of the given type and lifetime
+func generateToken(user database.User, tokenType string, expiryDuration time.Duration) (string, error) {
+	settings, err := loadSettings()
 	if err != nil {
 		return "", err
 	}
 
 	// Set claims
 	claims := Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
+		UserID:    user.ID,
+		TokenType: tokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiryDuration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -51,12 +159,15 @@ func GenerateToken(user database.User) (string, error) {
 			Subject:   user.ID.String(),
 		},
 	}
+	if len(settings.Audiences) > 0 {
+		claims.Audience = jwt.ClaimStrings(settings.Audiences)
+	}
 
 	// Create token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Sign and get the complete token as a string
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	tokenString, err := token.SignedString([]byte(settings.Secret))
 	if err != nil {
 		return "", err
 	}
@@ -64,29 +175,98 @@ func GenerateToken(user database.User) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateToken parses and validates a JWT token
+// ValidateToken parses and validates an access token
 func ValidateToken(tokenString string) (*Claims, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return nil, errors.New("JWT_SECRET must be set in environment")
-	}
-
-	// Parse token
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&Claims{},
-		func(token *jwt.Token) (interface{}, error) {
-			// Validate signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return []byte(jwtSecret), nil
-		},
-	)
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenType != "" && claims.TokenType != TokenTypeAccess {
+		return nil, fmt.Errorf("%w: wrong token type", ErrTokenInvalid)
+	}
+	return claims, nil
+}
 
+// ValidateRefreshToken parses and validates a refresh token
+func ValidateRefreshToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
 	if err != nil {
 		return nil, err
 	}
+	if claims.TokenType != TokenTypeRefresh {
+		return nil, fmt.Errorf("%w: wrong token type", ErrTokenInvalid)
+	}
+	return claims, nil
+}
+
+// parseToken parses a JWT token of any type and checks its signature and
+// expiry. Failures wrap ErrTokenExpired or ErrTokenInvalid.
+func parseToken(tokenString string) (*Claims, error) {
+	claims, err := parseClaims(tokenString)
+	switch {
+	case err == nil, errors.Is(err, ErrAuthNotConfigured):
+		return claims, err
+	case errors.Is(err, jwt.ErrTokenExpired):
+		if claims != nil && claims.ExpiresAt != nil {
+			return nil, &ExpiredError{ExpiresAt: claims.ExpiresAt.Time, err: err}
+		}
+		return nil, fmt.Errorf("%w: %v", ErrTokenExpired, err)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+}
+
+// parseClaims does the parsing for parseToken, returning the jwt errors as-is
+func parseClaims(tokenString string) (*Claims, error) {
+	settings, err := loadSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		// Validate signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		if len(settings.PreviousSecrets) == 0 {
+			return []byte(settings.Secret), nil
+		}
+		// Tokens signed before a rotation stay valid until they expire
+		keys := jwt.VerificationKeySet{Keys: []jwt.VerificationKey{[]byte(settings.Secret)}}
+		for _, old := range settings.PreviousSecrets {
+			keys.Keys = append(keys.Keys, []byte(old))
+		}
+		return keys, nil
+	}
+
+	// Parse token, requiring one of the configured audiences when any are set
+	options := []jwt.ParserOption{jwt.WithLeeway(settings.Leeway)}
+	expected := settings.Audiences
+	if len(expected) == 0 {
+		return checkClaims(jwt.ParseWithClaims(tokenString, &Claims{}, keyFunc, options...))
+	}
+
+	for _, aud := range expected {
+		var claims *Claims
+		claims, err = checkClaims(jwt.ParseWithClaims(tokenString, &Claims{}, keyFunc, append(options, jwt.WithAudience(aud))...))
+		if !errors.Is(err, jwt.ErrTokenInvalidAudience) {
+			return claims, err
+		}
+	}
+	return nil, err
+}
+
+// checkClaims extracts the claims from a parsed token. An expired token's
+// claims come back with the error, its signature has already been checked.
+func checkClaims(token *jwt.Token, err error) (*Claims, error) {
+	if err != nil {
+		if token != nil && errors.Is(err, jwt.ErrTokenExpired) {
+			if claims, ok := token.Claims.(*Claims); ok {
+				return claims, err
+			}
+		}
+		return nil, err
+	}
 
 	// Get claims
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
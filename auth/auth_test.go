@@ -1,21 +1,38 @@
 package auth
 
 import (
-	"os"
+	"encoding/base64"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+// configure installs settings for the rest of the test, filling in a test
+// secret and lifetimes where they are unset
+func configure(t *testing.T, settings Settings) {
+	t.Helper()
+	if settings.Secret == "" {
+		settings.Secret = "test_secret_key"
+	}
+	if settings.AccessExpiry == 0 {
+		settings.AccessExpiry = time.Hour
+	}
+	if settings.RefreshExpiry == 0 {
+		settings.RefreshExpiry = 7 * 24 * time.Hour
+	}
+	if err := Configure(settings); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	t.Cleanup(Reset)
+}
+
 func TestGenerateToken(t *testing.T) {
-	// Setup environment for all tests
-	os.Setenv("JWT_SECRET", "test_secret_key")
-	os.Setenv("JWT_EXPIRATION", "1h")
-	defer func() {
-		os.Unsetenv("JWT_SECRET")
-		os.Unsetenv("JWT_EXPIRATION")
-	}()
+	configure(t, Settings{})
 
 	tests := []struct {
 		name        string
@@ -83,65 +100,23 @@ func TestGenerateToken(t *testing.T) {
 	}
 }
 
-func TestGenerateTokenEnvironmentErrors(t *testing.T) {
-	// Save original environment
-	originalSecret := os.Getenv("JWT_SECRET")
-	originalExpiry := os.Getenv("JWT_EXPIRY")
-
-	defer func() {
-		// Restore original environment
-		os.Setenv("JWT_SECRET", originalSecret)
-		os.Setenv("JWT_EXPIRY", originalExpiry)
-	}()
+func TestGenerateTokenNotConfigured(t *testing.T) {
+	Reset()
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "test@example.com"}
 
-	tests := []struct {
-		name          string
-		jwtSecret     string
-		jwtExpiry     string
-		expectedError bool
-	}{
-		{
-			name:          "missing_secret",
-			jwtSecret:     "",
-			jwtExpiry:     "24h",
-			expectedError: true,
-		},
-		{
-			name:          "invalid_expiration",
-			jwtSecret:     "test-secret",
-			jwtExpiry:     "invalid-duration", // This should cause an error
-			expectedError: true,
-		},
+	if _, err := GenerateToken(user); !errors.Is(err, ErrAuthNotConfigured) {
+		t.Errorf("Expected ErrAuthNotConfigured for an access token, got %v", err)
 	}
-
-	// Create a mock user for testing
-	mockUser := database.User{
-		ID:       uuid.New(),
-		Username: "testuser",
-		Email:    "test@example.com",
+	if _, err := GenerateRefreshToken(user); !errors.Is(err, ErrAuthNotConfigured) {
+		t.Errorf("Expected ErrAuthNotConfigured for a refresh token, got %v", err)
 	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Set test environment
-			os.Setenv("JWT_SECRET", tt.jwtSecret)
-			os.Setenv("JWT_EXPIRY", tt.jwtExpiry)
-
-			_, err := GenerateToken(mockUser)
-
-			if tt.expectedError && err == nil {
-				t.Errorf("Expected error but got none")
-			} else if !tt.expectedError && err != nil {
-				t.Errorf("Expected no error but got: %v", err)
-			}
-		})
+	if _, _, err := TokenExpiries(); !errors.Is(err, ErrAuthNotConfigured) {
+		t.Errorf("Expected ErrAuthNotConfigured for the lifetimes, got %v", err)
 	}
 }
 
 func TestValidateToken(t *testing.T) {
-	// Setup environment
-	os.Setenv("JWT_SECRET", "test_secret_key")
-	defer os.Unsetenv("JWT_SECRET")
+	configure(t, Settings{})
 
 	// Generate a valid token first
 	testUser := database.User{
@@ -221,11 +196,8 @@ func TestValidateToken(t *testing.T) {
 					if claims.UserID != testUser.ID {
 						t.Errorf("Expected user ID %v, got %v", testUser.ID, claims.UserID)
 					}
-					if claims.Username != testUser.Username {
-						t.Errorf("Expected username %q, got %q", testUser.Username, claims.Username)
-					}
-					if claims.Email != testUser.Email {
-						t.Errorf("Expected email %q, got %q", testUser.Email, claims.Email)
+					if claims.Username != "" {
+						t.Errorf("Expected no username in the token, got %q", claims.Username)
 					}
 				}
 			}
@@ -233,9 +205,170 @@ func TestValidateToken(t *testing.T) {
 	}
 }
 
+func TestTokenOmitsMutableFields(t *testing.T) {
+	configure(t, Settings{})
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "test@example.com"}
+
+	token, err := GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[1])
+	if err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+	for _, field := range []string{`"username"`, `"email"`, user.Username, user.Email} {
+		if strings.Contains(string(payload), field) {
+			t.Errorf("Expected the token not to carry %s, got %s", field, payload)
+		}
+	}
+
+	// Tokens issued before still validate, but their stale username is ignored
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":  user.ID.String(),
+		"username": "old_name",
+		"email":    "old@example.com",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("test_secret_key"))
+	if err != nil {
+		t.Fatalf("Failed to sign legacy token: %v", err)
+	}
+	claims, err := ValidateToken(legacy)
+	if err != nil {
+		t.Fatalf("Expected the legacy token to validate, got %v", err)
+	}
+	if claims.UserID != user.ID || claims.Username != "" {
+		t.Errorf("Expected only the user ID from the legacy token, got %+v", claims)
+	}
+}
+
+func TestTokenLeeway(t *testing.T) {
+	t.Cleanup(Reset)
+	if err := Configure(Settings{Secret: "test_secret_key", AccessExpiry: time.Hour, RefreshExpiry: time.Hour, Leeway: -time.Second}); err == nil {
+		t.Error("Expected an error configuring a negative leeway")
+	}
+	if err := Configure(Settings{Secret: "test_secret_key", AccessExpiry: time.Hour, RefreshExpiry: time.Hour, Leeway: time.Minute}); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	user := database.User{ID: uuid.New()}
+
+	// Within the leeway a slightly fast client clock doesn't matter
+	recent, err := generateToken(user, TokenTypeAccess, -30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if _, err := ValidateToken(recent); err != nil {
+		t.Errorf("Expected a token 30s past expiry to be accepted, got %v", err)
+	}
+
+	// Past it the error says when the token expired
+	stale, err := generateToken(user, TokenTypeAccess, -2*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	_, err = ValidateToken(stale)
+	var expired *ExpiredError
+	if !errors.As(err, &expired) || !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("Expected an ExpiredError, got %v", err)
+	}
+	if expiresAt := time.Now().Add(-2 * time.Minute); expired.ExpiresAt.Sub(expiresAt).Abs() > 2*time.Second {
+		t.Errorf("Expected the token to have expired around %v, got %v", expiresAt, expired.ExpiresAt)
+	}
+
+	// The expiry of a token signed with another key is never trusted
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		UserID:           user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour))},
+	}).SignedString([]byte("some_other_key"))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	if _, err := ValidateToken(forged); !errors.Is(err, ErrTokenInvalid) || errors.As(err, &expired) {
+		t.Errorf("Expected a forged token to be invalid, got %v", err)
+	}
+}
+
+func TestValidateTokenErrorKinds(t *testing.T) {
+	configure(t, Settings{})
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "test@example.com"}
+
+	expired, err := generateToken(user, TokenTypeAccess, -time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate expired token: %v", err)
+	}
+	refresh, err := GenerateRefreshToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{name: "expired", token: expired, want: ErrTokenExpired},
+		{name: "garbage", token: "not.a.jwt", want: ErrTokenInvalid},
+		{name: "wrong_type", token: refresh, want: ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateToken(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateTokenNotConfigured(t *testing.T) {
+	Reset()
+
+	_, err := ValidateToken("any.token.value")
+	if !errors.Is(err, ErrAuthNotConfigured) {
+		t.Errorf("Expected ErrAuthNotConfigured, got %v", err)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	t.Cleanup(Reset)
+
+	if err := Configure(Settings{AccessExpiry: time.Hour, RefreshExpiry: time.Hour}); err == nil {
+		t.Error("Expected an error configuring an empty secret")
+	}
+	if err := Configure(Settings{Secret: "test_secret_key", RefreshExpiry: time.Hour}); err == nil {
+		t.Error("Expected an error configuring a zero access expiry")
+	}
+
+	err := Configure(Settings{
+		Secret:        "test_secret_key",
+		Audiences:     []string{"tot-web"},
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: 2 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "test@example.com"}
+	token, err := GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Expected the configured settings to sign tokens, got %v", err)
+	}
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Expected the configured settings to validate tokens, got %v", err)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "tot-web" {
+		t.Errorf("Expected the configured audience, got %v", claims.Audience)
+	}
+	if lifetime := claims.ExpiresAt.Sub(claims.IssuedAt.Time); lifetime != time.Hour {
+		t.Errorf("Expected a 1h access token, got %v", lifetime)
+	}
+}
+
 func TestValidateTokenWithDifferentSecrets(t *testing.T) {
 	// Generate token with one secret
-	os.Setenv("JWT_SECRET", "original_secret")
+	configure(t, Settings{Secret: "original_secret"})
 	testUser := database.User{
 		ID:       uuid.New(),
 		Username: "testuser",
@@ -265,9 +398,9 @@ func TestValidateTokenWithDifferentSecrets(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.setSecret {
-				os.Setenv("JWT_SECRET", tt.newSecret)
+				configure(t, Settings{Secret: tt.newSecret})
 			} else {
-				os.Unsetenv("JWT_SECRET")
+				Reset()
 			}
 
 			claims, err := ValidateToken(token)
@@ -280,3 +413,133 @@ func TestValidateTokenWithDifferentSecrets(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateTokenPreviousSecrets(t *testing.T) {
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "test@example.com"}
+
+	configure(t, Settings{Secret: "old_secret"})
+	oldToken, err := GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	configure(t, Settings{Secret: "unknown_secret"})
+	unknownToken, err := GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	// Rotate: the old secret moves to the previous ones
+	configure(t, Settings{Secret: "new_secret", PreviousSecrets: []string{"older_secret", "old_secret"}})
+
+	if _, err := ValidateToken(oldToken); err != nil {
+		t.Errorf("Expected a token signed with a previous secret to validate, got %v", err)
+	}
+	if _, err := ValidateToken(unknownToken); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected ErrTokenInvalid for an unknown secret, got %v", err)
+	}
+
+	// New tokens are signed with the primary only
+	newToken, err := GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	configure(t, Settings{Secret: "new_secret"})
+	if _, err := ValidateToken(newToken); err != nil {
+		t.Errorf("Expected a new token to validate with the primary alone, got %v", err)
+	}
+	configure(t, Settings{Secret: "old_secret"})
+	if _, err := ValidateToken(newToken); err == nil {
+		t.Error("Expected a new token not to validate with the old secret")
+	}
+}
+
+func TestTokenTypesHaveDifferentExpiry(t *testing.T) {
+	configure(t, Settings{AccessExpiry: 15 * time.Minute, RefreshExpiry: 72 * time.Hour})
+
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "test@example.com"}
+
+	accessToken, err := GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate access token: %v", err)
+	}
+	refreshToken, err := GenerateRefreshToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+
+	accessClaims, err := ValidateToken(accessToken)
+	if err != nil {
+		t.Fatalf("Access token failed validation: %v", err)
+	}
+	refreshClaims, err := ValidateRefreshToken(refreshToken)
+	if err != nil {
+		t.Fatalf("Refresh token failed validation: %v", err)
+	}
+
+	accessTTL := accessClaims.ExpiresAt.Sub(accessClaims.IssuedAt.Time)
+	refreshTTL := refreshClaims.ExpiresAt.Sub(refreshClaims.IssuedAt.Time)
+	if accessTTL != 15*time.Minute {
+		t.Errorf("Expected access token TTL 15m, got %v", accessTTL)
+	}
+	if refreshTTL != 72*time.Hour {
+		t.Errorf("Expected refresh token TTL 72h, got %v", refreshTTL)
+	}
+
+	// Each token is only accepted where its type belongs
+	if _, err := ValidateToken(refreshToken); err == nil {
+		t.Error("Expected refresh token to be rejected as an access token")
+	}
+	if _, err := ValidateRefreshToken(accessToken); err == nil {
+		t.Error("Expected access token to be rejected as a refresh token")
+	}
+}
+
+func TestTokenAudience(t *testing.T) {
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "test@example.com"}
+
+	// mint issues a token with the given audiences configured
+	mint := func(audiences []string) string {
+		configure(t, Settings{Audiences: audiences})
+		token, err := GenerateToken(user)
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name           string
+		mintAudience   []string
+		expectAudience []string
+		expectError    bool
+	}{
+		{name: "no_audience_configured", mintAudience: nil, expectAudience: nil, expectError: false},
+		{name: "matching_audience", mintAudience: []string{"web"}, expectAudience: []string{"web"}, expectError: false},
+		{name: "mismatched_audience", mintAudience: []string{"mobile"}, expectAudience: []string{"web"}, expectError: true},
+		{name: "missing_audience", mintAudience: nil, expectAudience: []string{"web"}, expectError: true},
+		{name: "one_of_multiple_audiences", mintAudience: []string{"web", "mobile"}, expectAudience: []string{"mobile"}, expectError: false},
+		{name: "any_configured_audience_accepted", mintAudience: []string{"mobile"}, expectAudience: []string{"web", "mobile"}, expectError: false},
+		{name: "unchecked_when_not_configured", mintAudience: []string{"web"}, expectAudience: nil, expectError: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := mint(tt.mintAudience)
+
+			configure(t, Settings{Audiences: tt.expectAudience})
+			claims, err := ValidateToken(token)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected audience validation to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(claims.Audience) != len(tt.mintAudience) {
+				t.Errorf("Expected audiences %v in the token, got %v", tt.mintAudience, claims.Audience)
+			}
+		})
+	}
+}
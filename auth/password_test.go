@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastArgon2id keeps tests quick while exercising the same code paths
+func fastArgon2id() *Argon2idHasher {
+	return &Argon2idHasher{Time: 1, Memory: 64, Threads: 1, KeyLen: 32, SaltLen: 16}
+}
+
+func TestNewHasher(t *testing.T) {
+	tests := []struct {
+		name        string
+		expected    Hasher
+		expectError bool
+	}{
+		{name: "", expected: &BcryptHasher{}},
+		{name: "bcrypt", expected: &BcryptHasher{}},
+		{name: "Argon2id", expected: &Argon2idHasher{}},
+		{name: "md5", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher, err := NewHasher(tt.name)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error for unknown hasher")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			switch tt.expected.(type) {
+			case *BcryptHasher:
+				if _, ok := hasher.(*BcryptHasher); !ok {
+					t.Errorf("Expected bcrypt hasher, got %T", hasher)
+				}
+			case *Argon2idHasher:
+				if _, ok := hasher.(*Argon2idHasher); !ok {
+					t.Errorf("Expected argon2id hasher, got %T", hasher)
+				}
+			}
+		})
+	}
+}
+
+func TestHasherCrossAlgorithmCompare(t *testing.T) {
+	bcryptHasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	argonHasher := fastArgon2id()
+
+	bcryptHash, err := bcryptHasher.Hash("password123")
+	if err != nil {
+		t.Fatalf("Failed to hash with bcrypt: %v", err)
+	}
+	argonHash, err := argonHasher.Hash("password123")
+	if err != nil {
+		t.Fatalf("Failed to hash with argon2id: %v", err)
+	}
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Unexpected argon2id encoding: %s", argonHash)
+	}
+
+	// Either hasher verifies either algorithm
+	for _, hasher := range []Hasher{bcryptHasher, argonHasher} {
+		for _, hash := range []string{bcryptHash, argonHash} {
+			if err := hasher.Compare(hash, "password123"); err != nil {
+				t.Errorf("%T failed to verify %.10s hash: %v", hasher, hash, err)
+			}
+			if err := hasher.Compare(hash, "wrong"); !errors.Is(err, ErrPasswordMismatch) {
+				t.Errorf("%T expected mismatch for %.10s hash, got %v", hasher, hash, err)
+			}
+		}
+	}
+}
+
+func TestArgon2idHashIsSalted(t *testing.T) {
+	hasher := fastArgon2id()
+	first, _ := hasher.Hash("password123")
+	second, _ := hasher.Hash("password123")
+	if first == second {
+		t.Error("Expected different hashes for the same password")
+	}
+}
+
+func TestHasherNeedsRehash(t *testing.T) {
+	bcryptHasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	argonHasher := fastArgon2id()
+
+	bcryptHash, _ := bcryptHasher.Hash("password123")
+	argonHash, _ := argonHasher.Hash("password123")
+
+	tests := []struct {
+		name     string
+		hasher   Hasher
+		hash     string
+		expected bool
+	}{
+		{name: "bcrypt_same_cost", hasher: bcryptHasher, hash: bcryptHash, expected: false},
+		{name: "bcrypt_higher_cost", hasher: &BcryptHasher{Cost: bcrypt.MinCost + 1}, hash: bcryptHash, expected: true},
+		{name: "bcrypt_from_argon2id", hasher: bcryptHasher, hash: argonHash, expected: true},
+		{name: "argon2id_same_params", hasher: argonHasher, hash: argonHash, expected: false},
+		{name: "argon2id_more_memory", hasher: &Argon2idHasher{Time: 1, Memory: 128, Threads: 1, KeyLen: 32, SaltLen: 16}, hash: argonHash, expected: true},
+		{name: "argon2id_from_bcrypt", hasher: argonHasher, hash: bcryptHash, expected: true},
+		{name: "garbage", hasher: argonHasher, hash: "not-a-hash", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.expected {
+				t.Errorf("Expected NeedsRehash %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompareMalformedArgon2idHash(t *testing.T) {
+	hasher := fastArgon2id()
+	for _, hash := range []string{"$argon2id$v=19$m=64", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=64,t=1,p=1$!!$a2V5"} {
+		if err := hasher.Compare(hash, "password123"); err == nil || errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("Expected format error for %q, got %v", hash, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package avatar generates deterministic placeholder avatars for users who
+// haven't uploaded a picture.
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// Style selects how default avatars are drawn
+type Style string
+
+const (
+	StyleInitials  Style = "initials"  // Up to two letters from the username on a colored background
+	StyleIdenticon Style = "identicon" // Symmetric 5x5 block pattern
+)
+
+// DefaultSize is the edge length in pixels of generated avatars
+const DefaultSize = 128
+
+// maxCacheEntries bounds the cache; it is reset when full since avatars are cheap to redraw
+const maxCacheEntries = 1024
+
+// ParseStyle converts an AVATAR_STYLE value into a Style
+func ParseStyle(style string) (Style, error) {
+	switch Style(strings.ToLower(strings.TrimSpace(style))) {
+	case "", StyleInitials:
+		return StyleInitials, nil
+	case StyleIdenticon:
+		return StyleIdenticon, nil
+	default:
+		return "", fmt.Errorf("unknown avatar style %q", style)
+	}
+}
+
+// Generator draws default avatars and caches the encoded PNGs
+type Generator struct {
+	Style Style
+	Size  int
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// NewGenerator creates a generator for the given style at the default size
+func NewGenerator(style Style) *Generator {
+	return &Generator{
+		Style: style,
+		Size:  DefaultSize,
+		cache: make(map[string][]byte),
+	}
+}
+
+// PNG returns the encoded avatar for a user. The same ID and name always
+// produce the same image.
+func (g *Generator) PNG(userID uuid.UUID, name string) ([]byte, error) {
+	key := fmt.Sprintf("%s:%s:%s", g.Style, userID, name)
+
+	g.mu.Lock()
+	if data, ok := g.cache[key]; ok {
+		g.mu.Unlock()
+		return data, nil
+	}
+	g.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, g.Draw(userID, name)); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+
+	g.mu.Lock()
+	if g.cache == nil || len(g.cache) >= maxCacheEntries {
+		g.cache = make(map[string][]byte)
+	}
+	g.cache[key] = data
+	g.mu.Unlock()
+
+	return data, nil
+}
+
+// Draw renders the avatar image for a user
+func (g *Generator) Draw(userID uuid.UUID, name string) image.Image {
+	size := g.Size
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	hash := sha256.Sum256(userID[:])
+	background := colorFromHash(hash)
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	if g.Style == StyleIdenticon {
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{240, 240, 240, 255}), image.Point{}, draw.Src)
+		drawIdenticon(img, hash, background)
+	} else {
+		draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+		drawText(img, Initials(name), color.White)
+	}
+	return img
+}
+
+// Initials returns up to two uppercase letters for a username: the first letter
+// of its first two words (split on spaces, underscores, dots and dashes), or the
+// first two letters of a single-word name
+func Initials(name string) string {
+	words := strings.FieldsFunc(strings.ToUpper(name), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '_' || r == '-' || r == '.'
+	})
+
+	perWord := 1
+	if len(words) == 1 {
+		perWord = 2
+	}
+
+	var initials []rune
+	for _, word := range words {
+		taken := 0
+		for _, r := range word {
+			if _, ok := glyphs[r]; !ok || r == '?' {
+				continue
+			}
+			initials = append(initials, r)
+			if taken++; taken == perWord || len(initials) == 2 {
+				break
+			}
+		}
+		if len(initials) == 2 {
+			break
+		}
+	}
+
+	if len(initials) == 0 {
+		return "?"
+	}
+	return string(initials)
+}
+
+// colorFromHash picks a saturated, mid-lightness color so white text stays readable
+func colorFromHash(hash [32]byte) color.RGBA {
+	hue := float64(uint16(hash[0])<<8|uint16(hash[1])) / 65536 * 360
+	return hslToRGB(hue, 0.55, 0.45)
+}
+
+// hslToRGB converts a hue in degrees and saturation/lightness in [0,1] to RGB
+func hslToRGB(h, s, l float64) color.RGBA {
+	c := (1 - abs(2*l-1)) * s
+	hp := h / 60
+	x := c * (1 - abs(mod(hp, 2)-1))
+
+	var r, g, b float64
+	switch {
+	case hp < 1:
+		r, g, b = c, x, 0
+	case hp < 2:
+		r, g, b = x, c, 0
+	case hp < 3:
+		r, g, b = 0, c, x
+	case hp < 4:
+		r, g, b = 0, x, c
+	case hp < 5:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	m := l - c/2
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 255}
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func mod(v, m float64) float64 {
+	return v - m*float64(int(v/m))
+}
+
+// drawIdenticon fills a mirrored 5x5 grid from the hash bits, with a one-cell margin
+func drawIdenticon(img *image.RGBA, hash [32]byte, fg color.RGBA) {
+	const grid = 5
+	cell := img.Bounds().Dx() / (grid + 1)
+	offset := (img.Bounds().Dx() - cell*grid) / 2
+
+	for row := 0; row < grid; row++ {
+		// Only the left three columns come from the hash; the right two mirror them
+		for col := 0; col < (grid+1)/2; col++ {
+			bit := row*3 + col
+			if hash[2+bit/8]>>(bit%8)&1 == 0 {
+				continue
+			}
+			for _, c := range []int{col, grid - 1 - col} {
+				rect := image.Rect(offset+c*cell, offset+row*cell, offset+(c+1)*cell, offset+(row+1)*cell)
+				draw.Draw(img, rect, image.NewUniform(fg), image.Point{}, draw.Src)
+			}
+		}
+	}
+}
+
+// drawText draws text centered using the bundled bitmap font, scaled so two
+// glyphs take up about half the avatar width
+func drawText(img *image.RGBA, text string, fg color.Color) {
+	runes := []rune(text)
+	size := img.Bounds().Dx()
+
+	// Glyphs are separated by one font pixel
+	textWidth := len(runes)*glyphWidth + len(runes) - 1
+	scale := max(1, size/2/(2*glyphWidth+1))
+	startX := (size - textWidth*scale) / 2
+	startY := (size - glyphHeight*scale) / 2
+
+	fill := image.NewUniform(fg)
+	for i, r := range runes {
+		glyph := glyphs[r]
+		originX := startX + i*(glyphWidth+1)*scale
+		for y, line := range glyph {
+			for x, px := range line {
+				if px != '#' {
+					continue
+				}
+				rect := image.Rect(originX+x*scale, startY+y*scale, originX+(x+1)*scale, startY+(y+1)*scale)
+				draw.Draw(img, rect, fill, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
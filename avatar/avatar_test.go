@@ -0,0 +1,92 @@
+package avatar
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPNGIsDeterministic(t *testing.T) {
+	userID := uuid.MustParse("6f1c2a9e-3b4d-4c5e-8f70-1a2b3c4d5e6f")
+
+	for _, style := range []Style{StyleInitials, StyleIdenticon} {
+		t.Run(string(style), func(t *testing.T) {
+			first, err := NewGenerator(style).PNG(userID, "jane doe")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			// A fresh generator has an empty cache, so this really redraws
+			second, err := NewGenerator(style).PNG(userID, "jane doe")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(first, second) {
+				t.Error("Expected identical PNGs for the same user")
+			}
+
+			img, err := png.Decode(bytes.NewReader(first))
+			if err != nil {
+				t.Fatalf("Expected a valid PNG: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != DefaultSize || b.Dy() != DefaultSize {
+				t.Errorf("Expected %dx%d image, got %v", DefaultSize, DefaultSize, b)
+			}
+
+			other, err := NewGenerator(style).PNG(uuid.New(), "john roe")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if bytes.Equal(first, other) {
+				t.Error("Expected different users to get different avatars")
+			}
+		})
+	}
+}
+
+func TestPNGIsCached(t *testing.T) {
+	g := NewGenerator(StyleInitials)
+	userID := uuid.New()
+
+	first, _ := g.PNG(userID, "tester")
+	second, _ := g.PNG(userID, "tester")
+	if &first[0] != &second[0] {
+		t.Error("Expected the cached PNG to be reused")
+	}
+}
+
+func TestInitials(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "jane doe", expected: "JD"},
+		{name: "jane_doe_smith", expected: "JD"},
+		{name: "froggu", expected: "FR"},
+		{name: "x", expected: "X"},
+		{name: "42cats", expected: "42"},
+		{name: "", expected: "?"},
+		{name: "___", expected: "?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Initials(tt.name); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseStyle(t *testing.T) {
+	if style, err := ParseStyle(""); err != nil || style != StyleInitials {
+		t.Errorf("Expected initials by default, got %q (%v)", style, err)
+	}
+	if style, err := ParseStyle("Identicon"); err != nil || style != StyleIdenticon {
+		t.Errorf("Expected identicon, got %q (%v)", style, err)
+	}
+	if _, err := ParseStyle("gravatar"); err == nil {
+		t.Error("Expected error for unknown style")
+	}
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// entry is a cached value and when it stops being served
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// Cache is a small in-memory store whose entries expire after a TTL. It is
+// safe for concurrent use. A nil *Cache never stores anything, so callers can
+// leave caching disabled without checks.
+type Cache[V any] struct {
+	mu      sync.Mutex
+	entries map[string]entry[V]
+	now     func() time.Time // Swappable for tests
+}
+
+// New creates an empty cache
+func New[V any]() *Cache[V] {
+	return &Cache[V]{
+		entries: make(map[string]entry[V]),
+		now:     time.Now,
+	}
+}
+
+// Get returns the value stored under key, if it hasn't expired
+func (c *Cache[V]) Get(key string) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	// Expired entries are dropped lazily; keys are few, so nothing sweeps them
+	if !c.now().Before(e.expires) {
+		delete(c.entries, key)
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Set stores value under key for ttl. A ttl of zero or less stores nothing.
+func (c *Cache[V]) Set(key string, value V, ttl time.Duration) {
+	if c == nil || ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry[V]{value: value, expires: c.now().Add(ttl)}
+}
+
+// Delete removes key, if present
+func (c *Cache[V]) Delete(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// DeletePrefix removes every key starting with prefix
+func (c *Cache[V]) DeletePrefix(prefix string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
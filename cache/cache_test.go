@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheHitAndMiss(t *testing.T) {
+	c := New[int]()
+
+	if _, ok := c.Get("missing"); ok {
+		t.Error("Expected a miss for an unknown key")
+	}
+
+	c.Set("answer", 42, time.Minute)
+	if value, ok := c.Get("answer"); !ok || value != 42 {
+		t.Errorf("Expected a hit with 42, got %d, %t", value, ok)
+	}
+
+	c.Delete("answer")
+	if _, ok := c.Get("answer"); ok {
+		t.Error("Expected a miss after Delete")
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New[string]()
+	c.now = func() time.Time { return now }
+
+	c.Set("key", "value", 5*time.Second)
+
+	now = now.Add(4 * time.Second)
+	if _, ok := c.Get("key"); !ok {
+		t.Error("Expected a hit before the TTL")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := c.Get("key"); ok {
+		t.Error("Expected a miss once the TTL has passed")
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("Expected the expired entry to be dropped, %d left", len(c.entries))
+	}
+}
+
+func TestCacheZeroTTLStoresNothing(t *testing.T) {
+	c := New[int]()
+	c.Set("key", 1, 0)
+	if _, ok := c.Get("key"); ok {
+		t.Error("Expected a zero TTL not to store the value")
+	}
+}
+
+func TestCacheDeletePrefix(t *testing.T) {
+	c := New[int]()
+	c.Set("leaderboard:10", 1, time.Minute)
+	c.Set("leaderboard:20", 2, time.Minute)
+	c.Set("stats", 3, time.Minute)
+
+	c.DeletePrefix("leaderboard:")
+
+	if _, ok := c.Get("leaderboard:10"); ok {
+		t.Error("Expected leaderboard:10 to be deleted")
+	}
+	if _, ok := c.Get("stats"); !ok {
+		t.Error("Expected stats to be kept")
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache[int]
+	c.Set("key", 1, time.Minute)
+	c.Delete("key")
+	c.DeletePrefix("k")
+	if _, ok := c.Get("key"); ok {
+		t.Error("Expected a nil cache to always miss")
+	}
+}
+
+func TestCacheConcurrentUse(t *testing.T) {
+	c := New[int]()
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Set("key", i, time.Minute)
+			c.Get("key")
+			c.DeletePrefix("other")
+		}()
+	}
+	wg.Wait()
+
+	if _, ok := c.Get("key"); !ok {
+		t.Error("Expected the key to be set")
+	}
+}
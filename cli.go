@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/froggu-tantei/ToT/config"
+	"github.com/froggu-tantei/ToT/storage"
+)
+
+// newFileStorage builds the storage backend named by backend ("local" or
+// "s3"), taking the S3 settings from cfg
+func newFileStorage(backend string, cfg config.StorageConfig) (storage.FileStorage, error) {
+	switch backend {
+	case "", "local":
+		return storage.NewLocalStorage("uploads", ""), nil
+	case "s3":
+		if cfg.S3Bucket == "" || cfg.S3Region == "" {
+			return nil, fmt.Errorf("S3_BUCKET and S3_REGION must be set for the s3 backend")
+		}
+		return storage.NewS3Storage(cfg.S3Bucket, cfg.S3Region, cfg.S3BaseURL, cfg.S3KeyPrefix)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// runMigrateStorage implements `migrate-storage <from> <to>`, copying every
+// stored file between backends, e.g. `migrate-storage local s3`
+func runMigrateStorage(args []string) {
+	if len(args) != 2 {
+		log.Fatal("Usage: migrate-storage <from> <to> (backends: local, s3)")
+	}
+
+	storageConfig := config.LoadStorage()
+	src, err := newFileStorage(args[0], storageConfig)
+	if err != nil {
+		log.Fatal("Invalid source storage: ", err)
+	}
+	dst, err := newFileStorage(args[1], storageConfig)
+	if err != nil {
+		log.Fatal("Invalid destination storage: ", err)
+	}
+
+	// Stop cleanly between files on Ctrl+C; rerunning skips what was already copied
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	migrated, err := storage.Migrate(ctx, src, dst)
+	if err != nil {
+		log.Fatalf("Migration stopped after %d files: %v", migrated, err)
+	}
+	log.Printf("Migration complete: %d files copied", migrated)
+}
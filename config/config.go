@@ -0,0 +1,520 @@
+// Package config loads the server's settings from the environment in one
+// place, applying defaults and reporting every invalid value at once.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/avatar"
+	"github.com/froggu-tantei/ToT/handlers"
+	"github.com/froggu-tantei/ToT/middleware"
+)
+
+// Environments accepted in APP_ENV
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// MinProductionSecretLength is the shortest JWT secret accepted in production
+const MinProductionSecretLength = 32
+
+// Config holds every setting the server reads from the environment
+type Config struct {
+	Environment string // APP_ENV, development unless set
+	Server      ServerConfig
+	Database    DatabaseConfig
+	JWT         auth.Settings
+	RateLimit   RateLimitConfig
+	Storage     StorageConfig
+	CORS        CORSConfig
+	Mail        MailConfig
+	Images      ImageConfig
+
+	InternalNetworks []*net.IPNet // Callers on these networks may use protected routes without a token, set TRUSTED_PROXIES too when behind a proxy
+	CacheTTL         time.Duration
+	TokenCookie      bool
+	PasswordHasher   string
+	LeaderboardMin   int    // Games needed to be ranked
+	TieLastPlace     string // One of the handlers.TieLastPlace* policies
+	LogRequestBodies bool   // Debug only, secrets are masked but the rest of every body is logged
+
+	Logging     middleware.LoggingConfig
+	Maintenance middleware.MaintenanceConfig
+	Security    middleware.SecurityHeadersConfig
+	Metrics     bool
+}
+
+// ServerConfig is where and how the HTTP server listens
+type ServerConfig struct {
+	Addr            string // Built from HOST and PORT
+	H2C             bool
+	Pprof           bool
+	MaxBodySize     int64
+	MultipartMemory int64
+	UploadsMaxAge   time.Duration
+	MaxInFlight     int // Per heavy endpoint, 0 disables
+}
+
+// DatabaseConfig is the Postgres connection and query logging
+type DatabaseConfig struct {
+	URL          string
+	QueryTimeout time.Duration // 0 disables
+	LogLevel     middleware.LogLevel
+	SlowQuery    time.Duration
+
+	ConnectAttempts int           // Pings at startup before giving up
+	ConnectDelay    time.Duration // First wait between pings, doubled after each
+}
+
+// Limit is a number of requests allowed per window
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Rate converts the limit to requests per second
+func (l Limit) Rate() float64 {
+	return float64(l.Requests) / l.Window.Seconds()
+}
+
+// RateLimitConfig holds the limits and the settings every limiter shares
+type RateLimitConfig struct {
+	Enabled          bool
+	Auth             Limit
+	Generic          Limit
+	Mail             Limit // Per recipient, for endpoints that send email
+	RetryAfterFormat string
+	TrustedProxyHops int
+	IPv6PrefixLen    int
+	LegacyHeaders    bool
+	Message          string
+	DetailedBody     bool
+	MethodWeights    map[string]int
+	ClientIPHeaders  []string
+	TrustedProxies   []*net.IPNet
+}
+
+// StorageConfig picks where uploads are kept
+type StorageConfig struct {
+	Backend   string // local or s3
+	S3Bucket  string
+	S3Region  string
+	S3BaseURL string // Optional CDN URL
+	// S3KeyPrefix is the directory objects are stored under, empty for the
+	// bucket root. Existing buckets keep it empty; "uploads" gives the same
+	// paths as local storage, so migrate-storage can move files either way.
+	S3KeyPrefix string
+}
+
+// CORSConfig holds the origins of the two CORS policies
+type CORSConfig struct {
+	AllowedOrigins []string // Empty uses middleware's default
+	PublicOrigins  []string // Leaderboard, stats and avatars, empty uses AllowedOrigins
+}
+
+// MailConfig is email delivery and the links sent by email
+type MailConfig struct {
+	SMTPAddr             string // Empty disables delivery unless LogOnly is set
+	SMTPUsername         string
+	SMTPPassword         string
+	From                 string
+	LogOnly              bool
+	EmailChangeURL       string
+	EmailChangeTTL       time.Duration
+	VerifyEmailURL       string
+	EmailVerificationTTL time.Duration
+}
+
+// ImageConfig covers profile pictures and generated avatars
+type ImageConfig struct {
+	Constraints      handlers.ImageConstraints
+	Compression      handlers.ImageCompression
+	DefaultAvatarURL string
+	AvatarStyle      avatar.Style // Empty when AVATAR_STYLE is none
+	AvatarURLHosts   []string     // Lowercased, empty disables external avatars
+}
+
+// Load reads the configuration from the environment
+func Load() (*Config, error) {
+	return load(os.Getenv)
+}
+
+// LoadStorage reads only the storage settings, for commands that don't start
+// the server
+func LoadStorage() StorageConfig {
+	e := &env{getenv: os.Getenv}
+	return e.storage()
+}
+
+// load reads the configuration through getenv, so tests don't depend on the
+// process environment
+func load(getenv func(string) string) (*Config, error) {
+	e := &env{getenv: getenv}
+	cfg := &Config{}
+
+	cfg.Environment = strings.ToLower(e.str("APP_ENV", EnvDevelopment))
+	if cfg.Environment != EnvDevelopment && cfg.Environment != EnvProduction {
+		e.fail("APP_ENV", fmt.Errorf("unknown environment %q", cfg.Environment))
+	}
+
+	// Server
+	port := e.str("PORT", "")
+	if port == "" {
+		e.fail("PORT", errors.New("must be set"))
+	} else if addr, err := listenAddr(e.str("HOST", ""), port); err != nil {
+		e.fail("HOST/PORT", err)
+	} else {
+		cfg.Server.Addr = addr
+	}
+	cfg.Server.H2C = e.bool("ENABLE_H2C", false)
+	cfg.Server.Pprof = e.bool("ENABLE_PPROF", false)
+	cfg.Server.MaxBodySize = int64(e.int("MAX_BODY_SIZE", middleware.DefaultMaxBodySize))          // Default: 1MB, uploads have their own limit
+	cfg.Server.MultipartMemory = int64(e.int("MULTIPART_MEMORY", handlers.DefaultMultipartMemory)) // Default: 1MB
+	cfg.Server.UploadsMaxAge = e.seconds("UPLOADS_MAX_AGE", handlers.DefaultUploadsMaxAge)         // Default: 1 day, 0 revalidates every time
+	cfg.Server.MaxInFlight = e.int("MAX_IN_FLIGHT", 4)                                             // Default: 4 exports, bulk deletes etc. each, 0 disables
+	if cfg.Server.MaxInFlight < 0 {
+		e.fail("MAX_IN_FLIGHT", errors.New("must not be negative"))
+	}
+
+	// Database
+	cfg.Database.URL = e.getSecret("DB_URL")
+	if cfg.Database.URL == "" {
+		e.fail("DB_URL", errors.New("must be set"))
+	}
+	cfg.Database.QueryTimeout = time.Duration(e.int("DB_QUERY_TIMEOUT_MS", 5000)) * time.Millisecond // Default: 5 seconds, 0 disables
+	cfg.Database.LogLevel = e.logLevel("DB_LOG_LEVEL")
+	cfg.Database.SlowQuery = time.Duration(e.int("SLOW_QUERY_MS", 200)) * time.Millisecond // Default: 200ms
+
+	// Startup waits for the database in case it isn't up yet
+	cfg.Database.ConnectAttempts = e.int("DB_CONNECT_ATTEMPTS", 10)                                 // Default: 10
+	cfg.Database.ConnectDelay = time.Duration(e.int("DB_CONNECT_DELAY_MS", 500)) * time.Millisecond // Default: 500ms, doubled up to 30s
+	if cfg.Database.ConnectAttempts < 1 {
+		e.fail("DB_CONNECT_ATTEMPTS", errors.New("must be at least 1"))
+	}
+	if cfg.Database.ConnectDelay < 0 {
+		e.fail("DB_CONNECT_DELAY_MS", errors.New("must not be negative"))
+	}
+
+	// JWT
+	cfg.JWT.Secret = e.getSecret("JWT_SECRET")
+	if cfg.JWT.Secret == "" {
+		e.fail("JWT_SECRET", errors.New("must be set"))
+	} else if cfg.Environment == EnvProduction && len(cfg.JWT.Secret) < MinProductionSecretLength {
+		e.fail("JWT_SECRET", fmt.Errorf("must be at least %d bytes in production", MinProductionSecretLength))
+	}
+	cfg.JWT.PreviousSecrets = splitList(e.getSecret("JWT_SECRET_OLD"))
+	cfg.JWT.Audiences = e.list("JWT_AUDIENCE", nil)
+	cfg.JWT.AccessExpiry = e.duration("JWT_ACCESS_EXPIRY", e.str("JWT_EXPIRY", auth.DefaultAccessExpiry))
+	cfg.JWT.RefreshExpiry = e.duration("JWT_REFRESH_EXPIRY", auth.DefaultRefreshExpiry)
+	cfg.JWT.Leeway = e.seconds("JWT_LEEWAY", 0) // Default: no clock skew allowed
+
+	// Rate limiting
+	cfg.RateLimit.Enabled = e.bool("RATE_LIMIT_ENABLED", true)
+	cfg.RateLimit.Auth = e.limit("AUTH_RATE_LIMIT", 3, "AUTH_RATE_WINDOW", 60)           // Default: 3 requests a minute
+	cfg.RateLimit.Generic = e.limit("GENERIC_RATE_LIMIT", 30, "GENERIC_RATE_WINDOW", 60) // Default: 30 requests a minute
+	cfg.RateLimit.Mail = e.limit("MAIL_RATE_LIMIT", 3, "MAIL_RATE_WINDOW", 3600)         // Default: 3 emails an hour
+	cfg.RateLimit.RetryAfterFormat = middleware.RetryAfterSeconds
+	if e.str("RATE_LIMIT_RETRY_AFTER_FORMAT", "") == middleware.RetryAfterHTTPDate {
+		cfg.RateLimit.RetryAfterFormat = middleware.RetryAfterHTTPDate
+	}
+	cfg.RateLimit.TrustedProxyHops = e.int("TRUSTED_PROXY_HOPS", 0)   // Default: left-most X-Forwarded-For entry
+	cfg.RateLimit.IPv6PrefixLen = e.int("RATE_LIMIT_IPV6_PREFIX", 64) // Default: one bucket per /64
+	cfg.RateLimit.LegacyHeaders = e.bool("RATE_LIMIT_LEGACY_HEADERS", true)
+	cfg.RateLimit.Message = e.str("RATE_LIMIT_MESSAGE", "") // Default: middleware.DefaultRateLimitMessage
+	cfg.RateLimit.DetailedBody = e.bool("RATE_LIMIT_DETAILED_BODY", false)
+	cfg.RateLimit.ClientIPHeaders = e.list("CLIENT_IP_HEADERS", []string{"X-Forwarded-For", "X-Real-IP"})
+	if weights, err := middleware.ParseMethodWeights(e.str("RATE_LIMIT_METHOD_WEIGHTS", "")); err != nil {
+		e.fail("RATE_LIMIT_METHOD_WEIGHTS", err)
+	} else {
+		cfg.RateLimit.MethodWeights = weights
+	}
+	if proxies, err := middleware.ParseTrustedProxies(e.str("TRUSTED_PROXIES", "")); err != nil {
+		e.fail("TRUSTED_PROXIES", err)
+	} else {
+		cfg.RateLimit.TrustedProxies = proxies
+	}
+	if networks, err := middleware.ParseInternalNetworks(e.str("INTERNAL_NETWORKS", "")); err != nil {
+		e.fail("INTERNAL_NETWORKS", err)
+	} else {
+		cfg.InternalNetworks = networks
+	}
+
+	// Storage
+	cfg.Storage = e.storage()
+	switch cfg.Storage.Backend {
+	case "local":
+	case "s3":
+		if cfg.Storage.S3Bucket == "" || cfg.Storage.S3Region == "" {
+			e.fail("STORAGE_BACKEND", errors.New("S3_BUCKET and S3_REGION must be set for the s3 backend"))
+		}
+	default:
+		e.fail("STORAGE_BACKEND", fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend))
+	}
+
+	// CORS
+	cfg.CORS.AllowedOrigins = e.list("CORS_ALLOWED_ORIGINS", nil)
+	cfg.CORS.PublicOrigins = e.list("CORS_PUBLIC_ORIGINS", nil)
+
+	// Mail, see handlers.APIConfig.Mailer
+	cfg.Mail = MailConfig{
+		SMTPAddr:             e.str("SMTP_ADDR", ""),
+		SMTPUsername:         e.str("SMTP_USERNAME", ""),
+		SMTPPassword:         e.getSecret("SMTP_PASSWORD"),
+		From:                 e.str("MAIL_FROM", ""),
+		LogOnly:              e.bool("MAIL_LOG_ONLY", false),
+		EmailChangeURL:       e.str("EMAIL_CHANGE_URL", ""),
+		EmailChangeTTL:       e.minutes("EMAIL_CHANGE_TTL_MINUTES", handlers.DefaultEmailChangeTTL), // Default: 1 day
+		VerifyEmailURL:       e.str("VERIFY_EMAIL_URL", ""),
+		EmailVerificationTTL: e.minutes("EMAIL_VERIFICATION_TTL_MINUTES", handlers.DefaultEmailVerificationTTL), // Default: 1 day
+	}
+
+	// Profile pictures and avatars, unlimited and uncompressed unless configured
+	cfg.Images.Constraints = handlers.ImageConstraints{
+		MinWidth:       e.int("IMAGE_MIN_WIDTH", 0),
+		MinHeight:      e.int("IMAGE_MIN_HEIGHT", 0),
+		MaxWidth:       e.int("IMAGE_MAX_WIDTH", 0),
+		MaxHeight:      e.int("IMAGE_MAX_HEIGHT", 0),
+		MinAspectRatio: e.float("IMAGE_MIN_ASPECT_RATIO", 0),
+		MaxAspectRatio: e.float("IMAGE_MAX_ASPECT_RATIO", 0),
+	}
+	cfg.Images.Compression = handlers.ImageCompression{
+		Enabled:     e.bool("IMAGE_COMPRESSION", false),
+		JPEGQuality: e.int("IMAGE_JPEG_QUALITY", handlers.DefaultJPEGQuality),
+	}
+	cfg.Images.DefaultAvatarURL = e.str("DEFAULT_AVATAR_URL", "")
+	for _, host := range e.list("AVATAR_URL_HOSTS", nil) {
+		cfg.Images.AvatarURLHosts = append(cfg.Images.AvatarURLHosts, strings.ToLower(host))
+	}
+	if avatarStyle := e.str("AVATAR_STYLE", ""); avatarStyle != "none" {
+		if style, err := avatar.ParseStyle(avatarStyle); err != nil {
+			e.fail("AVATAR_STYLE", err)
+		} else {
+			cfg.Images.AvatarStyle = style
+		}
+	}
+
+	// Game and account behaviour
+	cfg.CacheTTL = e.seconds("CACHE_TTL", 5*time.Second) // Default: 5 seconds, 0 disables
+	cfg.TokenCookie = e.bool("AUTH_COOKIE", false)
+	cfg.LeaderboardMin = e.int("LEADERBOARD_MIN_GAMES", 0)
+	if policy, err := handlers.ParseTieLastPlacePolicy(e.str("TIE_LAST_PLACE_POLICY", "")); err != nil {
+		e.fail("TIE_LAST_PLACE_POLICY", err)
+	} else {
+		cfg.TieLastPlace = policy
+	}
+	cfg.PasswordHasher = e.str("PASSWORD_HASHER", "")
+	if _, err := auth.NewHasher(cfg.PasswordHasher); err != nil {
+		e.fail("PASSWORD_HASHER", err)
+	}
+
+	// Request logging
+	cfg.Logging = middleware.DefaultLoggingConfig()
+	cfg.Logging.Level = e.logLevel("LOG_LEVEL")
+	cfg.Logging.SkipPaths = e.list("LOG_SKIP_PATHS", cfg.Logging.SkipPaths)
+	cfg.Logging.Non2xxOnly = e.bool("LOG_NON_2XX_ONLY", false)
+	if rates, err := middleware.ParseSampleRates(e.str("LOG_SAMPLE_RATES", "")); err != nil {
+		e.fail("LOG_SAMPLE_RATES", err)
+	} else {
+		cfg.Logging.SampleRates = rates
+	}
+	cfg.LogRequestBodies = e.bool("LOG_REQUEST_BODIES", false)
+
+	// Maintenance mode
+	cfg.Maintenance = middleware.DefaultMaintenanceConfig()
+	cfg.Maintenance.Enabled = e.bool("MAINTENANCE_MODE", false)
+	cfg.Maintenance.BlockReads = e.bool("MAINTENANCE_BLOCK_READS", false)
+	cfg.Maintenance.RetryAfter = e.seconds("MAINTENANCE_RETRY_AFTER", cfg.Maintenance.RetryAfter) // Default: 5 minutes
+
+	// Security headers, "off" leaves one out
+	cfg.Security = middleware.DefaultSecurityHeadersConfig()
+	cfg.Security.NoSniff = e.bool("SECURITY_NOSNIFF", cfg.Security.NoSniff)
+	cfg.Security.FrameOptions = e.header("SECURITY_FRAME_OPTIONS", cfg.Security.FrameOptions)
+	cfg.Security.ReferrerPolicy = e.header("SECURITY_REFERRER_POLICY", cfg.Security.ReferrerPolicy)
+	cfg.Security.ContentSecurityPolicy = e.header("SECURITY_CSP", cfg.Security.ContentSecurityPolicy)
+	cfg.Security.HSTSMaxAge = e.seconds("HSTS_MAX_AGE", 0) // Default: off, enable when served over TLS
+	cfg.Security.HSTSSubdomains = e.bool("HSTS_INCLUDE_SUBDOMAINS", false)
+
+	cfg.Metrics = e.bool("METRICS_ENABLED", false)
+
+	if err := errors.Join(e.errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// listenAddr builds the server address from HOST and PORT. An empty host
+// listens on all interfaces.
+func listenAddr(host, port string) (string, error) {
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	host = strings.TrimSpace(host)
+	if host != "" && net.ParseIP(host) == nil && host != "localhost" {
+		return "", fmt.Errorf("invalid host %q, must be an IP address or localhost", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// env reads variables, collecting an error for every value that doesn't parse
+// instead of stopping at the first
+type env struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (e *env) fail(key string, err error) {
+	e.errs = append(e.errs, fmt.Errorf("%s: %w", key, err))
+}
+
+func (e *env) str(key, fallback string) string {
+	if value := strings.TrimSpace(e.getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getSecret reads a secret from the file named by key_FILE, as Docker and
+// Kubernetes mount them, falling back to the key itself. The file wins when
+// both are set. Trailing newlines left by editors and echo are dropped.
+func (e *env) getSecret(key string) string {
+	path := e.str(key+"_FILE", "")
+	if path == "" {
+		return e.str(key, "")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		e.fail(key+"_FILE", err)
+		return ""
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+func (e *env) int(key string, fallback int) int {
+	value := e.str(key, "")
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.fail(key, fmt.Errorf("invalid integer %q", value))
+		return fallback
+	}
+	return n
+}
+
+func (e *env) float(key string, fallback float64) float64 {
+	value := e.str(key, "")
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.fail(key, fmt.Errorf("invalid number %q", value))
+		return fallback
+	}
+	return f
+}
+
+func (e *env) bool(key string, fallback bool) bool {
+	value := e.str(key, "")
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.fail(key, fmt.Errorf("invalid boolean %q", value))
+		return fallback
+	}
+	return b
+}
+
+// list reads a comma-separated list, dropping empty entries
+func (e *env) list(key string, fallback []string) []string {
+	value := e.str(key, "")
+	if value == "" {
+		return fallback
+	}
+	return splitList(value)
+}
+
+// splitList splits a comma separated value, dropping empty items
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// header reads a header value where "off" leaves the header out
+func (e *env) header(key, fallback string) string {
+	value := e.str(key, fallback)
+	if strings.EqualFold(value, "off") {
+		return ""
+	}
+	return value
+}
+
+// duration reads a Go duration such as 24h, fallback being one too
+func (e *env) duration(key, fallback string) time.Duration {
+	value := e.str(key, fallback)
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		e.fail(key, fmt.Errorf("invalid duration %q", value))
+		return 0
+	}
+	return d
+}
+
+// seconds reads a whole number of seconds
+func (e *env) seconds(key string, fallback time.Duration) time.Duration {
+	return time.Duration(e.int(key, int(fallback.Seconds()))) * time.Second
+}
+
+// minutes reads a whole number of minutes
+func (e *env) minutes(key string, fallback time.Duration) time.Duration {
+	return time.Duration(e.int(key, int(fallback.Minutes()))) * time.Minute
+}
+
+// limit reads a request count and a window in seconds, both of which must be positive
+func (e *env) limit(requestsKey string, requests int, windowKey string, window int) Limit {
+	limit := Limit{
+		Requests: e.int(requestsKey, requests),
+		Window:   time.Duration(e.int(windowKey, window)) * time.Second,
+	}
+	if limit.Requests < 1 {
+		e.fail(requestsKey, errors.New("must be at least 1"))
+	}
+	if limit.Window <= 0 {
+		e.fail(windowKey, errors.New("must be at least 1"))
+	}
+	return limit
+}
+
+func (e *env) logLevel(key string) middleware.LogLevel {
+	level, err := middleware.ParseLogLevel(e.getenv(key))
+	if err != nil {
+		e.fail(key, err)
+	}
+	return level
+}
+
+func (e *env) storage() StorageConfig {
+	return StorageConfig{
+		Backend:     e.str("STORAGE_BACKEND", "local"),
+		S3Bucket:    e.str("S3_BUCKET", ""),
+		S3Region:    e.str("S3_REGION", ""),
+		S3BaseURL:   e.str("S3_BASE_URL", ""),
+		S3KeyPrefix: e.str("S3_KEY_PREFIX", ""),
+	}
+}
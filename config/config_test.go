@@ -0,0 +1,248 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/middleware"
+)
+
+// required are the variables Load can't default
+var required = map[string]string{
+	"PORT":       "8080",
+	"DB_URL":     "postgres://localhost:5432/tot",
+	"JWT_SECRET": "test_secret_key",
+}
+
+// lookup serves vars on top of required, an empty value unsetting one
+func lookup(vars map[string]string) func(string) string {
+	return func(key string) string {
+		if value, ok := vars[key]; ok {
+			return value
+		}
+		return required[key]
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(lookup(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if cfg.Environment != EnvDevelopment {
+		t.Errorf("Expected the development environment, got %q", cfg.Environment)
+	}
+	if cfg.Server.Addr != ":8080" {
+		t.Errorf("Expected address :8080, got %q", cfg.Server.Addr)
+	}
+	if cfg.Database.QueryTimeout != 5*time.Second || cfg.Database.SlowQuery != 200*time.Millisecond {
+		t.Errorf("Expected a 5s query timeout and 200ms slow query threshold, got %v and %v", cfg.Database.QueryTimeout, cfg.Database.SlowQuery)
+	}
+	if cfg.JWT.AccessExpiry != 24*time.Hour || cfg.JWT.RefreshExpiry != 7*24*time.Hour {
+		t.Errorf("Expected 24h and 168h token lifetimes, got %v and %v", cfg.JWT.AccessExpiry, cfg.JWT.RefreshExpiry)
+	}
+	if !cfg.RateLimit.Enabled || cfg.RateLimit.Auth != (Limit{Requests: 3, Window: time.Minute}) || cfg.RateLimit.Generic != (Limit{Requests: 30, Window: time.Minute}) {
+		t.Errorf("Expected the default rate limits, got %+v", cfg.RateLimit)
+	}
+	if cfg.RateLimit.Generic.Rate() != 0.5 {
+		t.Errorf("Expected 30 requests a minute to be 0.5/s, got %v", cfg.RateLimit.Generic.Rate())
+	}
+	if cfg.RateLimit.RetryAfterFormat != middleware.RetryAfterSeconds || !cfg.RateLimit.LegacyHeaders {
+		t.Errorf("Expected Retry-After in seconds with legacy headers, got %+v", cfg.RateLimit)
+	}
+	if cfg.Storage.Backend != "local" {
+		t.Errorf("Expected local storage, got %q", cfg.Storage.Backend)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 0 || len(cfg.CORS.PublicOrigins) != 0 {
+		t.Errorf("Expected the default CORS origins, got %+v", cfg.CORS)
+	}
+	if cfg.CacheTTL != 5*time.Second || cfg.Maintenance.RetryAfter != 5*time.Minute {
+		t.Errorf("Expected a 5s cache and 5m maintenance Retry-After, got %v and %v", cfg.CacheTTL, cfg.Maintenance.RetryAfter)
+	}
+	if cfg.Images.AvatarStyle == "" {
+		t.Error("Expected generated avatars by default")
+	}
+	if !cfg.Security.NoSniff || cfg.Security.HSTSMaxAge != 0 {
+		t.Errorf("Expected nosniff without HSTS, got %+v", cfg.Security)
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	cfg, err := load(lookup(map[string]string{
+		"HOST":                   "127.0.0.1",
+		"JWT_EXPIRY":             "1h",
+		"AUTH_RATE_LIMIT":        "5",
+		"AUTH_RATE_WINDOW":       "10",
+		"STORAGE_BACKEND":        "s3",
+		"S3_BUCKET":              "uploads",
+		"S3_REGION":              "eu-west-1",
+		"S3_KEY_PREFIX":          "uploads",
+		"CORS_ALLOWED_ORIGINS":   "https://tot.example, https://admin.tot.example",
+		"AVATAR_STYLE":           "none",
+		"SECURITY_FRAME_OPTIONS": "off",
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if cfg.Server.Addr != "127.0.0.1:8080" {
+		t.Errorf("Expected address 127.0.0.1:8080, got %q", cfg.Server.Addr)
+	}
+	if cfg.JWT.AccessExpiry != time.Hour {
+		t.Errorf("Expected JWT_EXPIRY to set the access lifetime, got %v", cfg.JWT.AccessExpiry)
+	}
+	if cfg.RateLimit.Auth != (Limit{Requests: 5, Window: 10 * time.Second}) {
+		t.Errorf("Expected 5 requests per 10s, got %+v", cfg.RateLimit.Auth)
+	}
+	if cfg.Storage != (StorageConfig{Backend: "s3", S3Bucket: "uploads", S3Region: "eu-west-1", S3KeyPrefix: "uploads"}) {
+		t.Errorf("Expected the S3 settings, got %+v", cfg.Storage)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 2 || cfg.CORS.AllowedOrigins[1] != "https://admin.tot.example" {
+		t.Errorf("Expected two trimmed origins, got %q", cfg.CORS.AllowedOrigins)
+	}
+	if cfg.Images.AvatarStyle != "" {
+		t.Errorf("Expected no avatar style, got %q", cfg.Images.AvatarStyle)
+	}
+	if cfg.Security.FrameOptions != "" {
+		t.Errorf("Expected X-Frame-Options turned off, got %q", cfg.Security.FrameOptions)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		vars     map[string]string
+		expected []string // Every one must be reported
+	}{
+		{name: "missing_required", vars: map[string]string{"PORT": "", "DB_URL": "", "JWT_SECRET": ""}, expected: []string{"PORT: must be set", "DB_URL: must be set", "JWT_SECRET: must be set"}},
+		{name: "invalid_port", vars: map[string]string{"PORT": "http"}, expected: []string{"invalid port"}},
+		{name: "invalid_numbers", vars: map[string]string{"CACHE_TTL": "soon", "IMAGE_COMPRESSION": "maybe"}, expected: []string{"CACHE_TTL", "IMAGE_COMPRESSION"}},
+		{name: "no_connect_attempts", vars: map[string]string{"DB_CONNECT_ATTEMPTS": "0"}, expected: []string{"DB_CONNECT_ATTEMPTS"}},
+		{name: "negative_max_in_flight", vars: map[string]string{"MAX_IN_FLIGHT": "-1"}, expected: []string{"MAX_IN_FLIGHT"}},
+		{name: "zero_rate_window", vars: map[string]string{"GENERIC_RATE_WINDOW": "0"}, expected: []string{"GENERIC_RATE_WINDOW"}},
+		{name: "negative_rate_limit", vars: map[string]string{"AUTH_RATE_LIMIT": "-5", "MAIL_RATE_WINDOW": "-1"}, expected: []string{"AUTH_RATE_LIMIT", "MAIL_RATE_WINDOW"}},
+		{name: "invalid_expiry", vars: map[string]string{"JWT_REFRESH_EXPIRY": "a week"}, expected: []string{"JWT_REFRESH_EXPIRY"}},
+		{name: "s3_without_bucket", vars: map[string]string{"STORAGE_BACKEND": "s3"}, expected: []string{"S3_BUCKET and S3_REGION must be set"}},
+		{name: "unknown_storage", vars: map[string]string{"STORAGE_BACKEND": "ftp"}, expected: []string{"unknown storage backend"}},
+		{name: "invalid_parsed_values", vars: map[string]string{"TRUSTED_PROXIES": "nope", "TIE_LAST_PLACE_POLICY": "coin-flip", "LOG_LEVEL": "loud"}, expected: []string{"TRUSTED_PROXIES", "TIE_LAST_PLACE_POLICY", "LOG_LEVEL"}},
+		{name: "unknown_environment", vars: map[string]string{"APP_ENV": "staging"}, expected: []string{"APP_ENV"}},
+		{name: "short_secret_in_production", vars: map[string]string{"APP_ENV": "production"}, expected: []string{"JWT_SECRET: must be at least 32 bytes in production"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(lookup(tt.vars))
+			if err == nil {
+				t.Fatalf("Expected an error, got %+v", cfg)
+			}
+			for _, expected := range tt.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("Expected %q in %q", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadProductionSecret(t *testing.T) {
+	cfg, err := load(lookup(map[string]string{
+		"APP_ENV":    "production",
+		"JWT_SECRET": strings.Repeat("s", MinProductionSecretLength),
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Environment != EnvProduction {
+		t.Errorf("Expected the production environment, got %q", cfg.Environment)
+	}
+}
+
+func TestGetSecret(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jwt_secret")
+	if err := os.WriteFile(path, []byte("from_file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		vars     map[string]string
+		expected string
+	}{
+		{name: "env", vars: map[string]string{"JWT_SECRET": "from_env"}, expected: "from_env"},
+		{name: "file", vars: map[string]string{"JWT_SECRET_FILE": path}, expected: "from_file"},
+		{name: "file_wins", vars: map[string]string{"JWT_SECRET": "from_env", "JWT_SECRET_FILE": path}, expected: "from_file"},
+		{name: "unset", vars: nil, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &env{getenv: func(key string) string { return tt.vars[key] }}
+			if secret := e.getSecret("JWT_SECRET"); secret != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, secret)
+			}
+			if len(e.errs) != 0 {
+				t.Errorf("Unexpected errors: %v", e.errs)
+			}
+		})
+	}
+
+	// Loading picks the file up for every secret
+	dbPath := filepath.Join(dir, "db_url")
+	if err := os.WriteFile(dbPath, []byte("postgres://db:5432/tot\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := load(lookup(map[string]string{"DB_URL": "", "DB_URL_FILE": dbPath, "JWT_SECRET_FILE": path}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Database.URL != "postgres://db:5432/tot" || cfg.JWT.Secret != "from_file" {
+		t.Errorf("Expected the secrets from the files, got %q and %q", cfg.Database.URL, cfg.JWT.Secret)
+	}
+
+	// A file that can't be read is reported rather than falling back to the env
+	_, err = load(lookup(map[string]string{"JWT_SECRET_FILE": filepath.Join(dir, "missing")}))
+	if err == nil || !strings.Contains(err.Error(), "JWT_SECRET_FILE") {
+		t.Errorf("Expected an error naming JWT_SECRET_FILE, got %v", err)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		name        string
+		host        string
+		port        string
+		expected    string
+		expectError bool
+	}{
+		{name: "host_unset", host: "", port: "8080", expected: ":8080"},
+		{name: "ipv4_host", host: "127.0.0.1", port: "8080", expected: "127.0.0.1:8080"},
+		{name: "ipv6_host", host: "::1", port: "8080", expected: "[::1]:8080"},
+		{name: "localhost", host: "localhost", port: "3000", expected: "localhost:3000"},
+		{name: "invalid_host", host: "not a host", port: "8080", expectError: true},
+		{name: "invalid_port", host: "", port: "http", expectError: true},
+		{name: "port_out_of_range", host: "127.0.0.1", port: "70000", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := listenAddr(tt.host, tt.port)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected an error, got address %q", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if addr != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, addr)
+			}
+		})
+	}
+}
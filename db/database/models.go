@@ -9,14 +9,49 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Block struct {
+	BlockerID uuid.UUID        `json:"blocker_id"`
+	BlockedID uuid.UUID        `json:"blocked_id"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type EmailChange struct {
+	UserID    uuid.UUID        `json:"user_id"`
+	NewEmail  string           `json:"new_email"`
+	TokenHash string           `json:"token_hash"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type EmailVerification struct {
+	UserID    uuid.UUID        `json:"user_id"`
+	TokenHash string           `json:"token_hash"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type Game struct {
+	ID        uuid.UUID        `json:"id"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type GameParticipant struct {
+	GameID    uuid.UUID `json:"game_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Placement int32     `json:"placement"`
+}
+
 type User struct {
-	ID             uuid.UUID        `json:"id"`
-	Email          string           `json:"email"`
-	PasswordHash   string           `json:"password_hash"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
-	Username       string           `json:"username"`
-	LastPlaceCount int32            `json:"last_place_count"`
-	ProfilePicture pgtype.Text      `json:"profile_picture"`
-	Bio            pgtype.Text      `json:"bio"`
+	ID              uuid.UUID        `json:"id"`
+	Email           string           `json:"email"`
+	PasswordHash    string           `json:"password_hash"`
+	CreatedAt       pgtype.Timestamp `json:"created_at"`
+	UpdatedAt       pgtype.Timestamp `json:"updated_at"`
+	Username        string           `json:"username"`
+	LastPlaceCount  int32            `json:"last_place_count"`
+	ProfilePicture  pgtype.Text      `json:"profile_picture"`
+	Bio             pgtype.Text      `json:"bio"`
+	Role            string           `json:"role"`
+	DeletedAt       pgtype.Timestamp `json:"deleted_at"`
+	EmailVerifiedAt pgtype.Timestamp `json:"email_verified_at"`
 }
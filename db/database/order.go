@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidSort is returned for a sort key or direction a query doesn't allow
+var ErrInvalidSort = errors.New("invalid sort")
+
+// orderByClause builds an ORDER BY clause from client input without ever
+// putting that input into the SQL. allowed maps the sort names clients use to
+// the column expressions they sort by; anything else is rejected, as is a
+// direction other than asc or desc. An empty direction sorts ascending.
+// sqlc can't parameterize ORDER BY, which is why SearchUsersSorted needs this.
+func orderByClause(sortKey, order string, allowed map[string]string) (string, error) {
+	column, ok := allowed[sortKey]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown sort key %q", ErrInvalidSort, sortKey)
+	}
+
+	var direction string
+	switch strings.ToLower(order) {
+	case "", "asc":
+		direction = "ASC"
+	case "desc":
+		direction = "DESC"
+	default:
+		return "", fmt.Errorf("%w: unknown order %q, expected asc or desc", ErrInvalidSort, order)
+	}
+	return "ORDER BY " + column + " " + direction, nil
+}
+
+// SearchUsersSortKeys are the columns SearchUsersSorted can order by
+var SearchUsersSortKeys = map[string]string{
+	"created_at":       "created_at",
+	"email":            "email",
+	"last_place_count": "last_place_count",
+	"username":         "username",
+}
+
+// SearchUsersSorted runs SearchUsers ordered by one of SearchUsersSortKeys
+// instead of newest first, with id breaking ties so pages stay stable. An
+// unknown key or direction fails with ErrInvalidSort before querying.
+func (q *Queries) SearchUsersSorted(ctx context.Context, arg SearchUsersParams, sortKey, order string) ([]SearchUsersRow, error) {
+	clause, err := orderByClause(sortKey, order, SearchUsersSortKeys)
+	if err != nil {
+		return nil, err
+	}
+	query := strings.Replace(searchUsers, "ORDER BY created_at DESC, id", clause+", id", 1)
+
+	rows, err := q.db.Query(ctx, query,
+		arg.Email,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Status,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []SearchUsersRow{}
+	for rows.Next() {
+		var i SearchUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Username,
+			&i.Role,
+			&i.LastPlaceCount,
+			&i.ProfilePicture,
+			&i.Bio,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
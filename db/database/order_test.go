@@ -0,0 +1,55 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestOrderByClause(t *testing.T) {
+	allowed := map[string]string{"name": "username", "games": "last_place_count"}
+
+	tests := []struct {
+		name     string
+		sortKey  string
+		order    string
+		expected string
+		wantErr  bool
+	}{
+		{name: "ascending_by_default", sortKey: "name", expected: "ORDER BY username ASC"},
+		{name: "explicit_asc", sortKey: "games", order: "asc", expected: "ORDER BY last_place_count ASC"},
+		{name: "desc_any_case", sortKey: "games", order: "DESC", expected: "ORDER BY last_place_count DESC"},
+		{name: "column_not_a_key", sortKey: "username", wantErr: true},
+		{name: "unknown_key", sortKey: "password_hash", wantErr: true},
+		{name: "empty_key", sortKey: "", wantErr: true},
+		{name: "injected_key", sortKey: "name; DROP TABLE users", wantErr: true},
+		{name: "invalid_direction", sortKey: "name", order: "sideways", wantErr: true},
+		{name: "injected_direction", sortKey: "name", order: "asc, (SELECT 1)", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clause, err := orderByClause(tt.sortKey, tt.order, allowed)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSort) {
+					t.Errorf("Expected ErrInvalidSort, got %q, %v", clause, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if clause != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, clause)
+			}
+		})
+	}
+}
+
+func TestSearchUsersSortedReplacesOrder(t *testing.T) {
+	// SearchUsersSorted swaps out the generated query's ORDER BY, so it must
+	// notice if regenerating SearchUsers changes it
+	if !strings.Contains(searchUsers, "ORDER BY created_at DESC, id") {
+		t.Errorf("SearchUsers no longer orders by created_at DESC, id:\n%s", searchUsers)
+	}
+}
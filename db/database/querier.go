@@ -8,19 +8,75 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Querier interface {
+	// Consumes the pending change with this token and applies it in one statement.
+	// An expired change is consumed without being applied, and a duplicate email
+	// fails the whole statement so the change stays pending. Confirming proves
+	// access to the new address, so it counts as verified.
+	ConfirmEmailChange(ctx context.Context, tokenHash string) (User, error)
+	CountGames(ctx context.Context) (int64, error)
+	// Matches GetLeaderBoard, so its pagination counts the ranked users
+	CountLeaderBoard(ctx context.Context, minGames int32) (int64, error)
+	// Signups in the last 24 hours, computed in the database so its clock and time zone apply
+	CountRecentUsers(ctx context.Context) (int64, error)
+	// Matches SearchUsers, so its pagination counts every match
+	CountSearchUsers(ctx context.Context, arg CountSearchUsersParams) (int64, error)
 	CountUsers(ctx context.Context) (int64, error)
+	// Matches ListUsers, so its pagination counts what the viewer can see
+	CountVisibleUsers(ctx context.Context, viewerID uuid.UUID) (int64, error)
+	// Blocking someone twice is a no-op rather than an error
+	CreateBlock(ctx context.Context, arg CreateBlockParams) error
+	CreateGame(ctx context.Context) (Game, error)
+	CreateGameParticipant(ctx context.Context, arg CreateGameParticipantParams) (GameParticipant, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
-	DeleteUser(ctx context.Context, id uuid.UUID) error
+	DeleteBlock(ctx context.Context, arg DeleteBlockParams) error
+	// Users who have played fewer than min_games games aren't ranked, 0 ranks everyone
 	GetLeaderBoard(ctx context.Context, arg GetLeaderBoardParams) ([]GetLeaderBoardRow, error)
+	// Includes soft-deleted users, since deleting one bumps updated_at and drops them from the board.
+	// New games count too, they can lift a player over the min_games threshold.
+	GetLeaderBoardLastModified(ctx context.Context) (pgtype.Timestamp, error)
+	// The Postgres version string, for debugging which server the API talks to
+	GetServerVersion(ctx context.Context) (string, error)
+	// Soft-deleted users are skipped, matching the unique index on active emails
 	GetUserByEmail(ctx context.Context, email string) (User, error)
 	GetUserByID(ctx context.Context, id uuid.UUID) (User, error)
 	GetUserByUsername(ctx context.Context, username string) (User, error)
 	IncrementLastPlaceCount(ctx context.Context, id uuid.UUID) (User, error)
+	ListGameParticipants(ctx context.Context, gameID uuid.UUID) ([]GameParticipant, error)
+	ListHeadToHeadPlacements(ctx context.Context, arg ListHeadToHeadPlacementsParams) ([]ListHeadToHeadPlacementsRow, error)
+	ListProfilePictures(ctx context.Context) ([]pgtype.Text, error)
+	// Pages active users with a picture by id, so a job can resume where it stopped
+	ListProfilePicturesAfter(ctx context.Context, arg ListProfilePicturesAfterParams) ([]ListProfilePicturesAfterRow, error)
+	// Leaves out anyone the viewer has blocked
 	ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error)
+	// Pages by id so a full export holds one batch at a time, includes soft-deleted
+	// users and never selects the password hash
+	ListUsersForExport(ctx context.Context, arg ListUsersForExportParams) ([]ListUsersForExportRow, error)
+	// Delivered to every listening instance once the transaction commits
+	NotifyLeaderboardChanged(ctx context.Context) error
+	// Only swaps a picture that is still the expected one, so a job can't clobber a fresh upload
+	ReplaceProfilePicture(ctx context.Context, arg ReplaceProfilePictureParams) (int64, error)
+	// Admin search where every filter is optional: email is an ILIKE pattern body,
+	// the date range is [created_after, created_before) and status is all, active or deleted
+	SearchUsers(ctx context.Context, arg SearchUsersParams) ([]SearchUsersRow, error)
+	// Marks every active user in the list deleted in one statement, clearing their
+	// picture and returning it so the caller can delete the file
+	SoftDeleteUsers(ctx context.Context, ids []uuid.UUID) ([]SoftDeleteUsersRow, error)
+	// Includes soft-deleted users, their last places still happened
+	SumLastPlaceCounts(ctx context.Context) (int64, error)
+	// Swaps the picture in one round trip, returning the previous one so the caller can delete it
+	UpdateProfilePicture(ctx context.Context, arg UpdateProfilePictureParams) (UpdateProfilePictureRow, error)
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	// A new request replaces any pending one, so only the latest link works
+	UpsertEmailChange(ctx context.Context, arg UpsertEmailChangeParams) (EmailChange, error)
+	// A new request replaces any pending one, so only the latest link works
+	UpsertEmailVerification(ctx context.Context, arg UpsertEmailVerificationParams) (EmailVerification, error)
+	// Consumes the pending verification with this token and marks the email
+	// verified. An expired verification is consumed without being applied.
+	VerifyEmail(ctx context.Context, tokenHash string) (User, error)
 }
 
 var _ Querier = (*Queries)(nil)
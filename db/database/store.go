@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LeaderboardChangedChannel is notified by NotifyLeaderboardChanged
+const LeaderboardChangedChannel = "leaderboard_changed"
+
+// Store provides all queries plus the ability to run several of them in one transaction.
+// Unlike the rest of this package it is not generated by sqlc.
+type Store interface {
+	Querier
+	ExecTx(ctx context.Context, fn func(Querier) error) error
+	SearchUsersSorted(ctx context.Context, arg SearchUsersParams, sortKey, order string) ([]SearchUsersRow, error)
+}
+
+// SQLStore implements Store on top of a pgx connection pool
+type SQLStore struct {
+	*Queries
+	pool         *pgxpool.Pool
+	queryTimeout time.Duration
+}
+
+// NewStore creates a new SQLStore. Each query fails once it runs longer than
+// queryTimeout, zero disables the limit.
+func NewStore(pool *pgxpool.Pool, queryTimeout time.Duration) *SQLStore {
+	return &SQLStore{
+		Queries:      New(withQueryTimeout(pool, queryTimeout)),
+		pool:         pool,
+		queryTimeout: queryTimeout,
+	}
+}
+
+// ExecTx runs fn inside a transaction, committing if it returns nil and rolling back otherwise
+func (s *SQLStore) ExecTx(ctx context.Context, fn func(Querier) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(New(withQueryTimeout(tx, s.queryTimeout))); err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			return fmt.Errorf("tx error: %v, rollback error: %v", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Listen calls onNotify for every notification on channel until ctx is done or
+// the connection fails. The connection is taken out of the pool for good, so
+// its LISTEN never leaks to other queries.
+func (s *SQLStore) Listen(ctx context.Context, channel string, onNotify func()) error {
+	poolConn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		onNotify()
+	}
+}
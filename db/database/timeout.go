@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// timeoutDBTX gives every query its own deadline, independent of how long the
+// caller's context lives
+type timeoutDBTX struct {
+	db      DBTX
+	timeout time.Duration
+}
+
+// withQueryTimeout wraps db so each query is cancelled after timeout. Zero or
+// less leaves db unchanged.
+func withQueryTimeout(db DBTX, timeout time.Duration) DBTX {
+	if timeout <= 0 {
+		return db
+	}
+	return &timeoutDBTX{db: db, timeout: timeout}
+}
+
+func (t *timeoutDBTX) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.db.Exec(ctx, sql, args...)
+}
+
+// Query keeps the deadline running until the rows are closed, since they are
+// read after Query returns
+func (t *timeoutDBTX) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	rows, err := t.db.Query(ctx, sql, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &timeoutRows{Rows: rows, cancel: cancel}, nil
+}
+
+// QueryRow keeps the deadline running until the row is scanned
+func (t *timeoutDBTX) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	return &timeoutRow{row: t.db.QueryRow(ctx, sql, args...), cancel: cancel}
+}
+
+// timeoutRows releases the query's deadline when closed
+type timeoutRows struct {
+	pgx.Rows
+	cancel context.CancelFunc
+}
+
+func (r *timeoutRows) Close() {
+	r.Rows.Close()
+	r.cancel()
+}
+
+// timeoutRow releases the query's deadline once scanned
+type timeoutRow struct {
+	row    pgx.Row
+	cancel context.CancelFunc
+}
+
+func (r *timeoutRow) Scan(dest ...any) error {
+	defer r.cancel()
+	return r.row.Scan(dest...)
+}
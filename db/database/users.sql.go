@@ -12,8 +12,69 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const countLeaderBoard = `-- name: CountLeaderBoard :one
+SELECT COUNT(*) FROM users
+WHERE deleted_at IS NULL
+  AND ($1::int = 0 OR (
+    SELECT COUNT(*) FROM game_participants
+    WHERE user_id = users.id
+  ) >= $1::int)
+`
+
+// Matches GetLeaderBoard, so its pagination counts the ranked users
+func (q *Queries) CountLeaderBoard(ctx context.Context, minGames int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countLeaderBoard, minGames)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countRecentUsers = `-- name: CountRecentUsers :one
+SELECT COUNT(*) FROM users
+WHERE created_at >= NOW() - INTERVAL '24 hours' AND deleted_at IS NULL
+`
+
+// Signups in the last 24 hours, computed in the database so its clock and time zone apply
+func (q *Queries) CountRecentUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countRecentUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countSearchUsers = `-- name: CountSearchUsers :one
+SELECT COUNT(*) FROM users
+WHERE ($1::text IS NULL OR email ILIKE '%' || $1 || '%')
+  AND ($2::timestamp IS NULL OR created_at >= $2)
+  AND ($3::timestamp IS NULL OR created_at < $3)
+  AND ($4::text = 'all'
+    OR ($4 = 'active' AND deleted_at IS NULL)
+    OR ($4 = 'deleted' AND deleted_at IS NOT NULL))
+`
+
+type CountSearchUsersParams struct {
+	Email         pgtype.Text      `json:"email"`
+	CreatedAfter  pgtype.Timestamp `json:"created_after"`
+	CreatedBefore pgtype.Timestamp `json:"created_before"`
+	Status        string           `json:"status"`
+}
+
+// Matches SearchUsers, so its pagination counts every match
+func (q *Queries) CountSearchUsers(ctx context.Context, arg CountSearchUsersParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countSearchUsers,
+		arg.Email,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Status,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countUsers = `-- name: CountUsers :one
 SELECT COUNT(*) FROM users
+WHERE deleted_at IS NULL
 `
 
 func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
@@ -23,6 +84,23 @@ func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
 	return count, err
 }
 
+const countVisibleUsers = `-- name: CountVisibleUsers :one
+SELECT COUNT(*) FROM users
+WHERE deleted_at IS NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE blocker_id = $1 AND blocked_id = users.id
+  )
+`
+
+// Matches ListUsers, so its pagination counts what the viewer can see
+func (q *Queries) CountVisibleUsers(ctx context.Context, viewerID uuid.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countVisibleUsers, viewerID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (email, password_hash, username, profile_picture, bio)
 VALUES (
@@ -32,7 +110,7 @@ VALUES (
   $4,
   $5
 )
-RETURNING id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio
+RETURNING id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio, role, deleted_at, email_verified_at
 `
 
 type CreateUserParams struct {
@@ -62,30 +140,29 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.LastPlaceCount,
 		&i.ProfilePicture,
 		&i.Bio,
+		&i.Role,
+		&i.DeletedAt,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
-const deleteUser = `-- name: DeleteUser :exec
-DELETE FROM users
-WHERE id = $1
-`
-
-func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, deleteUser, id)
-	return err
-}
-
 const getLeaderBoard = `-- name: GetLeaderBoard :many
 SELECT id, username, last_place_count, profile_picture, bio
 FROM users
-ORDER BY last_place_count DESC
-LIMIT $1 OFFSET $2
+WHERE deleted_at IS NULL
+  AND ($1::int = 0 OR (
+    SELECT COUNT(*) FROM game_participants
+    WHERE user_id = users.id
+  ) >= $1::int)
+ORDER BY last_place_count DESC, id
+LIMIT $2 OFFSET $3
 `
 
 type GetLeaderBoardParams struct {
-	Limit  int32 `json:"limit"`
-	Offset int32 `json:"offset"`
+	MinGames int32 `json:"min_games"`
+	Limit    int32 `json:"limit"`
+	Offset   int32 `json:"offset"`
 }
 
 type GetLeaderBoardRow struct {
@@ -96,8 +173,9 @@ type GetLeaderBoardRow struct {
 	Bio            pgtype.Text `json:"bio"`
 }
 
+// Users who have played fewer than min_games games aren't ranked, 0 ranks everyone
 func (q *Queries) GetLeaderBoard(ctx context.Context, arg GetLeaderBoardParams) ([]GetLeaderBoardRow, error) {
-	rows, err := q.db.Query(ctx, getLeaderBoard, arg.Limit, arg.Offset)
+	rows, err := q.db.Query(ctx, getLeaderBoard, arg.MinGames, arg.Limit, arg.Offset)
 	if err != nil {
 		return nil, err
 	}
@@ -122,11 +200,29 @@ func (q *Queries) GetLeaderBoard(ctx context.Context, arg GetLeaderBoardParams)
 	return items, nil
 }
 
+const getLeaderBoardLastModified = `-- name: GetLeaderBoardLastModified :one
+SELECT GREATEST(
+  COALESCE(MAX(updated_at), 'epoch'),
+  COALESCE((SELECT MAX(created_at) FROM games), 'epoch')
+)::timestamp AS last_modified
+FROM users
+`
+
+// Includes soft-deleted users, since deleting one bumps updated_at and drops them from the board.
+// New games count too, they can lift a player over the min_games threshold.
+func (q *Queries) GetLeaderBoardLastModified(ctx context.Context) (pgtype.Timestamp, error) {
+	row := q.db.QueryRow(ctx, getLeaderBoardLastModified)
+	var last_modified pgtype.Timestamp
+	err := row.Scan(&last_modified)
+	return last_modified, err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio FROM users
-WHERE email = $1
+SELECT id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio, role, deleted_at, email_verified_at FROM users
+WHERE email = $1 AND deleted_at IS NULL
 `
 
+// Soft-deleted users are skipped, matching the unique index on active emails
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
 	row := q.db.QueryRow(ctx, getUserByEmail, email)
 	var i User
@@ -140,13 +236,16 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.LastPlaceCount,
 		&i.ProfilePicture,
 		&i.Bio,
+		&i.Role,
+		&i.DeletedAt,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio FROM users
-WHERE id = $1
+SELECT id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio, role, deleted_at, email_verified_at FROM users
+WHERE id = $1 AND deleted_at IS NULL
 `
 
 func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
@@ -162,13 +261,16 @@ func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.LastPlaceCount,
 		&i.ProfilePicture,
 		&i.Bio,
+		&i.Role,
+		&i.DeletedAt,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
 const getUserByUsername = `-- name: GetUserByUsername :one
-SELECT id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio FROM users
-WHERE username = $1
+SELECT id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio, role, deleted_at, email_verified_at FROM users
+WHERE username = $1 AND deleted_at IS NULL
 `
 
 func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
@@ -184,6 +286,9 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User,
 		&i.LastPlaceCount,
 		&i.ProfilePicture,
 		&i.Bio,
+		&i.Role,
+		&i.DeletedAt,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
@@ -191,8 +296,8 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User,
 const incrementLastPlaceCount = `-- name: IncrementLastPlaceCount :one
 UPDATE users
 SET last_place_count = last_place_count + 1, updated_at = NOW()
-WHERE id = $1
-RETURNING id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio, role, deleted_at, email_verified_at
 `
 
 func (q *Queries) IncrementLastPlaceCount(ctx context.Context, id uuid.UUID) (User, error) {
@@ -208,23 +313,96 @@ func (q *Queries) IncrementLastPlaceCount(ctx context.Context, id uuid.UUID) (Us
 		&i.LastPlaceCount,
 		&i.ProfilePicture,
 		&i.Bio,
+		&i.Role,
+		&i.DeletedAt,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
+const listProfilePictures = `-- name: ListProfilePictures :many
+SELECT profile_picture FROM users
+WHERE profile_picture IS NOT NULL
+`
+
+func (q *Queries) ListProfilePictures(ctx context.Context) ([]pgtype.Text, error) {
+	rows, err := q.db.Query(ctx, listProfilePictures)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []pgtype.Text{}
+	for rows.Next() {
+		var profile_picture pgtype.Text
+		if err := rows.Scan(&profile_picture); err != nil {
+			return nil, err
+		}
+		items = append(items, profile_picture)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProfilePicturesAfter = `-- name: ListProfilePicturesAfter :many
+SELECT id, profile_picture FROM users
+WHERE profile_picture IS NOT NULL AND deleted_at IS NULL AND id > $1
+ORDER BY id
+LIMIT $2
+`
+
+type ListProfilePicturesAfterParams struct {
+	AfterID uuid.UUID `json:"after_id"`
+	Limit   int32     `json:"limit"`
+}
+
+type ListProfilePicturesAfterRow struct {
+	ID             uuid.UUID   `json:"id"`
+	ProfilePicture pgtype.Text `json:"profile_picture"`
+}
+
+// Pages active users with a picture by id, so a job can resume where it stopped
+func (q *Queries) ListProfilePicturesAfter(ctx context.Context, arg ListProfilePicturesAfterParams) ([]ListProfilePicturesAfterRow, error) {
+	rows, err := q.db.Query(ctx, listProfilePicturesAfter, arg.AfterID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListProfilePicturesAfterRow{}
+	for rows.Next() {
+		var i ListProfilePicturesAfterRow
+		if err := rows.Scan(&i.ID, &i.ProfilePicture); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listUsers = `-- name: ListUsers :many
-SELECT id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio FROM users
+SELECT id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio, role, deleted_at, email_verified_at FROM users
+WHERE deleted_at IS NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE blocker_id = $1 AND blocked_id = users.id
+  )
 ORDER BY created_at DESC
-LIMIT $1 OFFSET $2
+LIMIT $2 OFFSET $3
 `
 
 type ListUsersParams struct {
-	Limit  int32 `json:"limit"`
-	Offset int32 `json:"offset"`
+	ViewerID uuid.UUID `json:"viewer_id"`
+	Limit    int32     `json:"limit"`
+	Offset   int32     `json:"offset"`
 }
 
+// Leaves out anyone the viewer has blocked
 func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
-	rows, err := q.db.Query(ctx, listUsers, arg.Limit, arg.Offset)
+	rows, err := q.db.Query(ctx, listUsers, arg.ViewerID, arg.Limit, arg.Offset)
 	if err != nil {
 		return nil, err
 	}
@@ -242,6 +420,164 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, e
 			&i.LastPlaceCount,
 			&i.ProfilePicture,
 			&i.Bio,
+			&i.Role,
+			&i.DeletedAt,
+			&i.EmailVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersForExport = `-- name: ListUsersForExport :many
+SELECT id, email, username, role, last_place_count, profile_picture, bio, created_at, updated_at, deleted_at
+FROM users
+WHERE id > $1
+ORDER BY id
+LIMIT $2
+`
+
+type ListUsersForExportParams struct {
+	AfterID uuid.UUID `json:"after_id"`
+	Limit   int32     `json:"limit"`
+}
+
+type ListUsersForExportRow struct {
+	ID             uuid.UUID        `json:"id"`
+	Email          string           `json:"email"`
+	Username       string           `json:"username"`
+	Role           string           `json:"role"`
+	LastPlaceCount int32            `json:"last_place_count"`
+	ProfilePicture pgtype.Text      `json:"profile_picture"`
+	Bio            pgtype.Text      `json:"bio"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
+	DeletedAt      pgtype.Timestamp `json:"deleted_at"`
+}
+
+// Pages by id so a full export holds one batch at a time, includes soft-deleted
+// users and never selects the password hash
+func (q *Queries) ListUsersForExport(ctx context.Context, arg ListUsersForExportParams) ([]ListUsersForExportRow, error) {
+	rows, err := q.db.Query(ctx, listUsersForExport, arg.AfterID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListUsersForExportRow{}
+	for rows.Next() {
+		var i ListUsersForExportRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Username,
+			&i.Role,
+			&i.LastPlaceCount,
+			&i.ProfilePicture,
+			&i.Bio,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const replaceProfilePicture = `-- name: ReplaceProfilePicture :execrows
+UPDATE users
+SET profile_picture = $1, updated_at = NOW()
+WHERE id = $2 AND profile_picture = $3
+`
+
+type ReplaceProfilePictureParams struct {
+	NewPicture pgtype.Text `json:"new_picture"`
+	ID         uuid.UUID   `json:"id"`
+	OldPicture pgtype.Text `json:"old_picture"`
+}
+
+// Only swaps a picture that is still the expected one, so a job can't clobber a fresh upload
+func (q *Queries) ReplaceProfilePicture(ctx context.Context, arg ReplaceProfilePictureParams) (int64, error) {
+	result, err := q.db.Exec(ctx, replaceProfilePicture, arg.NewPicture, arg.ID, arg.OldPicture)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const searchUsers = `-- name: SearchUsers :many
+SELECT id, email, username, role, last_place_count, profile_picture, bio, created_at, updated_at, deleted_at
+FROM users
+WHERE ($1::text IS NULL OR email ILIKE '%' || $1 || '%')
+  AND ($2::timestamp IS NULL OR created_at >= $2)
+  AND ($3::timestamp IS NULL OR created_at < $3)
+  AND ($4::text = 'all'
+    OR ($4 = 'active' AND deleted_at IS NULL)
+    OR ($4 = 'deleted' AND deleted_at IS NOT NULL))
+ORDER BY created_at DESC, id
+LIMIT $5 OFFSET $6
+`
+
+type SearchUsersParams struct {
+	Email         pgtype.Text      `json:"email"`
+	CreatedAfter  pgtype.Timestamp `json:"created_after"`
+	CreatedBefore pgtype.Timestamp `json:"created_before"`
+	Status        string           `json:"status"`
+	Limit         int32            `json:"limit"`
+	Offset        int32            `json:"offset"`
+}
+
+type SearchUsersRow struct {
+	ID             uuid.UUID        `json:"id"`
+	Email          string           `json:"email"`
+	Username       string           `json:"username"`
+	Role           string           `json:"role"`
+	LastPlaceCount int32            `json:"last_place_count"`
+	ProfilePicture pgtype.Text      `json:"profile_picture"`
+	Bio            pgtype.Text      `json:"bio"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
+	DeletedAt      pgtype.Timestamp `json:"deleted_at"`
+}
+
+// Admin search where every filter is optional: email is an ILIKE pattern body,
+// the date range is [created_after, created_before) and status is all, active or deleted
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]SearchUsersRow, error) {
+	rows, err := q.db.Query(ctx, searchUsers,
+		arg.Email,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Status,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []SearchUsersRow{}
+	for rows.Next() {
+		var i SearchUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Username,
+			&i.Role,
+			&i.LastPlaceCount,
+			&i.ProfilePicture,
+			&i.Bio,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -253,6 +589,104 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, e
 	return items, nil
 }
 
+const softDeleteUsers = `-- name: SoftDeleteUsers :many
+WITH deleted AS (
+  SELECT id, profile_picture FROM users
+  WHERE id = ANY($1::uuid[]) AND deleted_at IS NULL
+  FOR UPDATE
+)
+UPDATE users
+SET deleted_at = NOW(), updated_at = NOW(), profile_picture = NULL
+FROM deleted
+WHERE users.id = deleted.id
+RETURNING users.id, deleted.profile_picture
+`
+
+type SoftDeleteUsersRow struct {
+	ID             uuid.UUID   `json:"id"`
+	ProfilePicture pgtype.Text `json:"profile_picture"`
+}
+
+// Marks every active user in the list deleted in one statement, clearing their
+// picture and returning it so the caller can delete the file
+func (q *Queries) SoftDeleteUsers(ctx context.Context, ids []uuid.UUID) ([]SoftDeleteUsersRow, error) {
+	rows, err := q.db.Query(ctx, softDeleteUsers, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []SoftDeleteUsersRow{}
+	for rows.Next() {
+		var i SoftDeleteUsersRow
+		if err := rows.Scan(&i.ID, &i.ProfilePicture); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const sumLastPlaceCounts = `-- name: SumLastPlaceCounts :one
+SELECT COALESCE(SUM(last_place_count), 0)::bigint AS total
+FROM users
+`
+
+// Includes soft-deleted users, their last places still happened
+func (q *Queries) SumLastPlaceCounts(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, sumLastPlaceCounts)
+	var total int64
+	err := row.Scan(&total)
+	return total, err
+}
+
+const updateProfilePicture = `-- name: UpdateProfilePicture :one
+WITH old AS (
+  SELECT id, profile_picture FROM users
+  WHERE id = $1 AND deleted_at IS NULL
+  FOR UPDATE
+)
+UPDATE users
+SET profile_picture = $2, updated_at = NOW()
+FROM old
+WHERE users.id = old.id
+RETURNING users.id, users.email, users.password_hash, users.created_at, users.updated_at, users.username, users.last_place_count, users.profile_picture, users.bio, users.role, users.deleted_at, users.email_verified_at, old.profile_picture AS old_profile_picture
+`
+
+type UpdateProfilePictureParams struct {
+	ID             uuid.UUID   `json:"id"`
+	ProfilePicture pgtype.Text `json:"profile_picture"`
+}
+
+type UpdateProfilePictureRow struct {
+	User              User        `json:"user"`
+	OldProfilePicture pgtype.Text `json:"old_profile_picture"`
+}
+
+// Swaps the picture in one round trip, returning the previous one so the caller can delete it
+func (q *Queries) UpdateProfilePicture(ctx context.Context, arg UpdateProfilePictureParams) (UpdateProfilePictureRow, error) {
+	row := q.db.QueryRow(ctx, updateProfilePicture, arg.ID, arg.ProfilePicture)
+	var i UpdateProfilePictureRow
+	err := row.Scan(
+		&i.User.ID,
+		&i.User.Email,
+		&i.User.PasswordHash,
+		&i.User.CreatedAt,
+		&i.User.UpdatedAt,
+		&i.User.Username,
+		&i.User.LastPlaceCount,
+		&i.User.ProfilePicture,
+		&i.User.Bio,
+		&i.User.Role,
+		&i.User.DeletedAt,
+		&i.User.EmailVerifiedAt,
+		&i.OldProfilePicture,
+	)
+	return i, err
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET email = $2,
@@ -261,8 +695,8 @@ SET email = $2,
     username = $4,
     bio = $5,
     profile_picture = $6
-WHERE id = $1
-RETURNING id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, email, password_hash, created_at, updated_at, username, last_place_count, profile_picture, bio, role, deleted_at, email_verified_at
 `
 
 type UpdateUserParams struct {
@@ -294,6 +728,9 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.LastPlaceCount,
 		&i.ProfilePicture,
 		&i.Bio,
+		&i.Role,
+		&i.DeletedAt,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
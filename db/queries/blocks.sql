@@ -0,0 +1,9 @@
+-- name: CreateBlock :exec
+-- Blocking someone twice is a no-op rather than an error
+INSERT INTO blocks (blocker_id, blocked_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteBlock :exec
+DELETE FROM blocks
+WHERE blocker_id = $1 AND blocked_id = $2;
@@ -0,0 +1,30 @@
+-- name: UpsertEmailChange :one
+-- A new request replaces any pending one, so only the latest link works
+INSERT INTO email_changes (user_id, new_email, token_hash, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id) DO UPDATE
+SET new_email = EXCLUDED.new_email,
+    token_hash = EXCLUDED.token_hash,
+    expires_at = EXCLUDED.expires_at,
+    created_at = NOW()
+RETURNING *;
+
+-- name: ConfirmEmailChange :one
+-- Consumes the pending change with this token and applies it in one statement.
+-- An expired change is consumed without being applied, and a duplicate email
+-- fails the whole statement so the change stays pending. Confirming proves
+-- access to the new address, so it counts as verified.
+WITH change AS (
+  DELETE FROM email_changes
+  WHERE token_hash = $1
+  RETURNING user_id, new_email, expires_at
+)
+UPDATE users
+SET email = change.new_email,
+    email_verified_at = NOW(),
+    updated_at = NOW()
+FROM change
+WHERE users.id = change.user_id
+  AND change.expires_at > NOW()
+  AND users.deleted_at IS NULL
+RETURNING users.*;
@@ -0,0 +1,26 @@
+-- name: UpsertEmailVerification :one
+-- A new request replaces any pending one, so only the latest link works
+INSERT INTO email_verifications (user_id, token_hash, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET token_hash = EXCLUDED.token_hash,
+    expires_at = EXCLUDED.expires_at,
+    created_at = NOW()
+RETURNING *;
+
+-- name: VerifyEmail :one
+-- Consumes the pending verification with this token and marks the email
+-- verified. An expired verification is consumed without being applied.
+WITH verification AS (
+  DELETE FROM email_verifications
+  WHERE token_hash = $1
+  RETURNING user_id, expires_at
+)
+UPDATE users
+SET email_verified_at = NOW(),
+    updated_at = NOW()
+FROM verification
+WHERE users.id = verification.user_id
+  AND verification.expires_at > NOW()
+  AND users.deleted_at IS NULL
+RETURNING users.*;
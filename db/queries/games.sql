@@ -0,0 +1,28 @@
+-- name: CreateGame :one
+INSERT INTO games DEFAULT VALUES
+RETURNING *;
+
+-- name: CreateGameParticipant :one
+-- Deleted accounts can't be placed, the insert then returns no row
+INSERT INTO game_participants (game_id, user_id, placement)
+SELECT $1, u.id, $3 FROM users u
+WHERE u.id = $2 AND u.deleted_at IS NULL
+RETURNING *;
+
+-- name: ListGameParticipants :many
+SELECT * FROM game_participants
+WHERE game_id = $1
+ORDER BY placement;
+
+-- name: ListHeadToHeadPlacements :many
+SELECT a.game_id, a.placement AS user_placement, b.placement AS other_placement
+FROM game_participants a
+JOIN game_participants b ON b.game_id = a.game_id
+WHERE a.user_id = sqlc.arg(user_id) AND b.user_id = sqlc.arg(other_user_id);
+
+-- name: CountGames :one
+SELECT COUNT(*) FROM games;
+
+-- name: NotifyLeaderboardChanged :exec
+-- Delivered to every listening instance once the transaction commits
+SELECT pg_notify('leaderboard_changed', '');
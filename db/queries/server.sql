@@ -0,0 +1,3 @@
+-- name: GetServerVersion :one
+-- The Postgres version string, for debugging which server the API talks to
+SELECT version();
@@ -10,16 +10,17 @@ VALUES (
 RETURNING *;
 
 -- name: GetUserByEmail :one
+-- Soft-deleted users are skipped, matching the unique index on active emails
 SELECT * FROM users
-WHERE email = $1;
+WHERE email = $1 AND deleted_at IS NULL;
 
 -- name: GetUserByID :one
 SELECT * FROM users
-WHERE id = $1;
+WHERE id = $1 AND deleted_at IS NULL;
 
 -- name: GetUserByUsername :one
 SELECT * FROM users
-WHERE username = $1;
+WHERE username = $1 AND deleted_at IS NULL;
 
 -- name: UpdateUser :one
 UPDATE users
@@ -29,29 +30,152 @@ SET email = $2,
     username = $4,
     bio = $5,
     profile_picture = $6
-WHERE id = $1
+WHERE id = $1 AND deleted_at IS NULL
 RETURNING *;
 
--- name: DeleteUser :exec
-DELETE FROM users
-WHERE id = $1;
+-- name: UpdateProfilePicture :one
+-- Swaps the picture in one round trip, returning the previous one so the caller can delete it
+WITH old AS (
+  SELECT id, profile_picture FROM users
+  WHERE id = $1 AND deleted_at IS NULL
+  FOR UPDATE
+)
+UPDATE users
+SET profile_picture = $2, updated_at = NOW()
+FROM old
+WHERE users.id = old.id
+RETURNING sqlc.embed(users), old.profile_picture AS old_profile_picture;
+
+-- name: SoftDeleteUsers :many
+-- Marks every active user in the list deleted in one statement, clearing their
+-- picture and returning it so the caller can delete the file
+WITH deleted AS (
+  SELECT id, profile_picture FROM users
+  WHERE id = ANY(@ids::uuid[]) AND deleted_at IS NULL
+  FOR UPDATE
+)
+UPDATE users
+SET deleted_at = NOW(), updated_at = NOW(), profile_picture = NULL
+FROM deleted
+WHERE users.id = deleted.id
+RETURNING users.id, deleted.profile_picture;
 
 -- name: ListUsers :many
+-- Leaves out anyone the viewer has blocked
 SELECT * FROM users
+WHERE deleted_at IS NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE blocker_id = sqlc.arg(viewer_id) AND blocked_id = users.id
+  )
 ORDER BY created_at DESC
-LIMIT $1 OFFSET $2;
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: ListUsersForExport :many
+-- Pages by id so a full export holds one batch at a time, includes soft-deleted
+-- users and never selects the password hash
+SELECT id, email, username, role, last_place_count, profile_picture, bio, created_at, updated_at, deleted_at
+FROM users
+WHERE id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg('limit');
+
+-- name: SearchUsers :many
+-- Admin search where every filter is optional: email is an ILIKE pattern body,
+-- the date range is [created_after, created_before) and status is all, active or deleted
+SELECT id, email, username, role, last_place_count, profile_picture, bio, created_at, updated_at, deleted_at
+FROM users
+WHERE (sqlc.narg(email)::text IS NULL OR email ILIKE '%' || sqlc.narg(email) || '%')
+  AND (sqlc.narg(created_after)::timestamp IS NULL OR created_at >= sqlc.narg(created_after))
+  AND (sqlc.narg(created_before)::timestamp IS NULL OR created_at < sqlc.narg(created_before))
+  AND (sqlc.arg(status)::text = 'all'
+    OR (sqlc.arg(status) = 'active' AND deleted_at IS NULL)
+    OR (sqlc.arg(status) = 'deleted' AND deleted_at IS NOT NULL))
+ORDER BY created_at DESC, id
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountSearchUsers :one
+-- Matches SearchUsers, so its pagination counts every match
+SELECT COUNT(*) FROM users
+WHERE (sqlc.narg(email)::text IS NULL OR email ILIKE '%' || sqlc.narg(email) || '%')
+  AND (sqlc.narg(created_after)::timestamp IS NULL OR created_at >= sqlc.narg(created_after))
+  AND (sqlc.narg(created_before)::timestamp IS NULL OR created_at < sqlc.narg(created_before))
+  AND (sqlc.arg(status)::text = 'all'
+    OR (sqlc.arg(status) = 'active' AND deleted_at IS NULL)
+    OR (sqlc.arg(status) = 'deleted' AND deleted_at IS NOT NULL));
 
 -- name: CountUsers :one
-SELECT COUNT(*) FROM users;
+SELECT COUNT(*) FROM users
+WHERE deleted_at IS NULL;
+
+-- name: CountVisibleUsers :one
+-- Matches ListUsers, so its pagination counts what the viewer can see
+SELECT COUNT(*) FROM users
+WHERE deleted_at IS NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE blocker_id = sqlc.arg(viewer_id) AND blocked_id = users.id
+  );
+
+-- name: CountRecentUsers :one
+-- Signups in the last 24 hours, computed in the database so its clock and time zone apply
+SELECT COUNT(*) FROM users
+WHERE created_at >= NOW() - INTERVAL '24 hours' AND deleted_at IS NULL;
+
+-- name: SumLastPlaceCounts :one
+-- Includes soft-deleted users, their last places still happened
+SELECT COALESCE(SUM(last_place_count), 0)::bigint AS total
+FROM users;
 
 -- name: GetLeaderBoard :many
+-- Users who have played fewer than min_games games aren't ranked, 0 ranks everyone
 SELECT id, username, last_place_count, profile_picture, bio
 FROM users
-ORDER BY last_place_count DESC
-LIMIT $1 OFFSET $2;
+WHERE deleted_at IS NULL
+  AND (sqlc.arg(min_games)::int = 0 OR (
+    SELECT COUNT(*) FROM game_participants
+    WHERE user_id = users.id
+  ) >= sqlc.arg(min_games)::int)
+ORDER BY last_place_count DESC, id
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountLeaderBoard :one
+-- Matches GetLeaderBoard, so its pagination counts the ranked users
+SELECT COUNT(*) FROM users
+WHERE deleted_at IS NULL
+  AND (sqlc.arg(min_games)::int = 0 OR (
+    SELECT COUNT(*) FROM game_participants
+    WHERE user_id = users.id
+  ) >= sqlc.arg(min_games)::int);
+
+-- name: GetLeaderBoardLastModified :one
+-- Includes soft-deleted users, since deleting one bumps updated_at and drops them from the board.
+-- New games count too, they can lift a player over the min_games threshold.
+SELECT GREATEST(
+  COALESCE(MAX(updated_at), 'epoch'),
+  COALESCE((SELECT MAX(created_at) FROM games), 'epoch')
+)::timestamp AS last_modified
+FROM users;
 
 -- name: IncrementLastPlaceCount :one
 UPDATE users
 SET last_place_count = last_place_count + 1, updated_at = NOW()
-WHERE id = $1
-RETURNING *;
\ No newline at end of file
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: ListProfilePictures :many
+SELECT profile_picture FROM users
+WHERE profile_picture IS NOT NULL;
+
+-- name: ListProfilePicturesAfter :many
+-- Pages active users with a picture by id, so a job can resume where it stopped
+SELECT id, profile_picture FROM users
+WHERE profile_picture IS NOT NULL AND deleted_at IS NULL AND id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg('limit');
+
+-- name: ReplaceProfilePicture :execrows
+-- Only swaps a picture that is still the expected one, so a job can't clobber a fresh upload
+UPDATE users
+SET profile_picture = sqlc.arg(new_picture), updated_at = NOW()
+WHERE id = sqlc.arg(id) AND profile_picture = sqlc.arg(old_picture);
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE games (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE game_participants (
+  game_id UUID NOT NULL REFERENCES games(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  placement INTEGER NOT NULL,
+  PRIMARY KEY (game_id, user_id)
+);
+
+CREATE INDEX game_participants_user_id_idx ON game_participants (user_id);
+
+-- +goose Down
+DROP TABLE game_participants;
+DROP TABLE games;
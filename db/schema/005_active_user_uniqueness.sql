@@ -0,0 +1,14 @@
+-- +goose Up
+-- Soft-deleted accounts no longer hold on to their email and username, so the
+-- same person can sign up again. The indexes keep the constraint names so
+-- duplicate errors still map to the right field.
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+ALTER TABLE users DROP CONSTRAINT users_username_key;
+CREATE UNIQUE INDEX users_email_key ON users (email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX users_username_key ON users (username) WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX users_username_key;
+DROP INDEX users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
@@ -0,0 +1,13 @@
+-- +goose Up
+-- A pending email change, applied once the new address confirms it. Only the
+-- hash of the confirmation token is kept, and each user has at most one.
+CREATE TABLE email_changes (
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  new_email TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  expires_at TIMESTAMP NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE email_changes;
@@ -0,0 +1,545 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// StorageGCResult reports what a storage garbage collection run found
+type StorageGCResult struct {
+	Applied bool     `json:"applied"`
+	Orphans []string `json:"orphans"`
+	Deleted []string `json:"deleted"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// MaxBulkDeleteUsers caps how many accounts one bulk delete request can remove
+const MaxBulkDeleteUsers = 100
+
+// Per-user outcomes of a bulk delete
+const (
+	BulkDeleteStatusDeleted  = "deleted"
+	BulkDeleteStatusNotFound = "not_found"
+)
+
+// BulkDeleteUserResult reports what happened to one requested user
+type BulkDeleteUserResult struct {
+	ID     uuid.UUID `json:"id"`
+	Status string    `json:"status"`
+}
+
+// Profile picture reprocessing limits
+const (
+	DefaultReprocessBatch  = 50
+	MaxReprocessBatch      = 500
+	DefaultReprocessDelay  = 100 * time.Millisecond
+	DefaultReprocessBudget = 5 * time.Second // Half the server's WriteTimeout, leaving room for the slowest picture
+)
+
+// Per-user outcomes of profile picture reprocessing
+const (
+	ReprocessStatusReprocessed = "reprocessed"
+	ReprocessStatusUnchanged   = "unchanged" // The current rules leave the picture as it is
+	ReprocessStatusSkipped     = "skipped"   // The user changed their picture while it was processed
+	ReprocessStatusFailed      = "failed"
+)
+
+// ReprocessUserResult reports what reprocessing did to one user's picture
+type ReprocessUserResult struct {
+	ID     uuid.UUID `json:"id"`
+	Status string    `json:"status"`
+	Path   string    `json:"path,omitempty"` // The new picture when reprocessed
+	Error  string    `json:"error,omitempty"`
+}
+
+// ReprocessResult reports one batch of a profile picture reprocessing run
+type ReprocessResult struct {
+	Users     []ReprocessUserResult `json:"users"`
+	Failed    int                   `json:"failed"`
+	NextAfter *uuid.UUID            `json:"next_after,omitempty"` // Pass as ?after to continue, absent once every user is done
+}
+
+// Status filters accepted by the admin user search
+const (
+	UserStatusAll     = "all"
+	UserStatusActive  = "active"
+	UserStatusDeleted = "deleted"
+)
+
+// likeEscaper escapes LIKE wildcards so a search term only matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// RequireAdmin only lets through authenticated users whose role is admin.
+// The role is read from the database so a demotion takes effect immediately.
+func (cfg *APIConfig) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserFromContext(r.Context())
+		if !ok {
+			RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Unauthorized"))
+			return
+		}
+
+		user, err := cfg.DB.GetUserByID(r.Context(), claims.UserID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Unauthorized"))
+			return
+		} else if err != nil {
+			respondWithDBError(w, err, "Database error")
+			return
+		}
+
+		if user.Role != models.RoleAdmin {
+			RespondWithJSON(w, http.StatusForbidden, models.NewErrorResponse("Admin access required"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdminOrInternal is RequireAdmin that also lets through the service
+// identity middleware.AllowInternal gives requests from internal networks, for
+// operational endpoints such as profiling
+func (cfg *APIConfig) RequireAdminOrInternal(next http.Handler) http.Handler {
+	admin := cfg.RequireAdmin(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A signed up user named like the service still has an ID
+		claims, ok := middleware.GetUserFromContext(r.Context())
+		if ok && claims.UserID == uuid.Nil && claims.Username == middleware.ServiceUsername {
+			next.ServeHTTP(w, r)
+			return
+		}
+		admin.ServeHTTP(w, r)
+	})
+}
+
+// findOrphanFiles returns the stored paths no user references as a profile picture
+func findOrphanFiles(stored []string, referenced []pgtype.Text) []string {
+	inUse := make(map[string]bool, len(referenced))
+	for _, picture := range referenced {
+		if picture.Valid {
+			inUse[picture.String] = true
+		}
+	}
+
+	orphans := []string{}
+	for _, path := range stored {
+		if !inUse[path] {
+			orphans = append(orphans, path)
+		}
+	}
+	slices.Sort(orphans)
+	return orphans
+}
+
+// StorageGCHandler finds stored files no user references and, with ?apply=true,
+// deletes them. Without apply it is a dry run that only reports the orphans.
+func (cfg *APIConfig) StorageGCHandler(w http.ResponseWriter, r *http.Request) {
+	apply := false
+	if applyStr := r.URL.Query().Get("apply"); applyStr != "" {
+		var err error
+		if apply, err = strconv.ParseBool(applyStr); err != nil {
+			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid apply parameter"))
+			return
+		}
+	}
+
+	// List storage before reading references, so a file uploaded in between
+	// is referenced by the time we compare and isn't mistaken for an orphan
+	stored, err := cfg.FileStorage.List(r.Context(), "")
+	if err != nil {
+		log.Printf("Failed to list stored files: %v", err)
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error listing stored files"))
+		return
+	}
+
+	referenced, err := cfg.DB.ListProfilePictures(r.Context())
+	if err != nil {
+		respondWithDBError(w, err, "Database error")
+		return
+	}
+
+	result := StorageGCResult{
+		Applied: apply,
+		Orphans: findOrphanFiles(stored, referenced),
+		Deleted: []string{},
+	}
+
+	if apply {
+		for _, path := range result.Orphans {
+			if err := cfg.FileStorage.Delete(path); err != nil {
+				log.Printf("Failed to delete orphaned file %s: %v", path, err)
+				result.Failed = append(result.Failed, path)
+				continue
+			}
+			result.Deleted = append(result.Deleted, path)
+		}
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(result))
+}
+
+// BulkDeleteUsersHandler soft-deletes a list of users in one statement and
+// removes their profile pictures. Users that don't exist or are already
+// deleted are reported as not found rather than failing the whole request.
+func (cfg *APIConfig) BulkDeleteUsersHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Unauthorized"))
+		return
+	}
+
+	var req models.BulkDeleteUsersRequest
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if len(req.UserIDs) == 0 {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("At least one user ID is required"))
+		return
+	}
+	if len(req.UserIDs) > MaxBulkDeleteUsers {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse(fmt.Sprintf("Cannot delete more than %d users at once", MaxBulkDeleteUsers)))
+		return
+	}
+
+	// Parse and dedupe, keeping the request order for the results
+	ids := make([]uuid.UUID, 0, len(req.UserIDs))
+	for _, idStr := range req.UserIDs {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid user ID format: "+idStr))
+			return
+		}
+		if id == claims.UserID {
+			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Cannot delete your own account"))
+			return
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	deleted, err := cfg.DB.SoftDeleteUsers(r.Context(), ids)
+	if err != nil {
+		respondWithDBError(w, err, "Error deleting users")
+		return
+	}
+
+	deletedIDs := make(map[uuid.UUID]bool, len(deleted))
+	for _, row := range deleted {
+		deletedIDs[row.ID] = true
+		if row.ProfilePicture.Valid && row.ProfilePicture.String != "" && !isExternalAvatar(row.ProfilePicture.String) {
+			if err := cfg.FileStorage.Delete(row.ProfilePicture.String); err != nil {
+				log.Printf("Failed to delete profile picture of deleted user %s: %v", row.ID, err)
+			}
+		}
+	}
+
+	results := make([]BulkDeleteUserResult, len(ids))
+	for i, id := range ids {
+		status := BulkDeleteStatusNotFound
+		if deletedIDs[id] {
+			status = BulkDeleteStatusDeleted
+		}
+		results[i] = BulkDeleteUserResult{ID: id, Status: status}
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(results))
+}
+
+// SearchUsersHandler lets admins find accounts by part of their email, a
+// creation date range and whether they are deleted. Every filter is optional
+// and the results are paginated like ListUsersHandler, newest first.
+func (cfg *APIConfig) SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filters database.CountSearchUsersParams
+
+	if email := strings.TrimSpace(query.Get("email")); email != "" {
+		filters.Email = pgtype.Text{String: likeEscaper.Replace(email), Valid: true}
+	}
+
+	var err error
+	if filters.CreatedAfter, err = parseSearchTime(query.Get("created_after")); err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid created_after, expected RFC 3339 or YYYY-MM-DD"))
+		return
+	}
+	if filters.CreatedBefore, err = parseSearchTime(query.Get("created_before")); err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid created_before, expected RFC 3339 or YYYY-MM-DD"))
+		return
+	}
+	if filters.CreatedAfter.Valid && filters.CreatedBefore.Valid && !filters.CreatedAfter.Time.Before(filters.CreatedBefore.Time) {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("created_after must be before created_before"))
+		return
+	}
+
+	switch status := query.Get("status"); status {
+	case "", UserStatusAll:
+		filters.Status = UserStatusAll
+	case UserStatusActive, UserStatusDeleted:
+		filters.Status = status
+	default:
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid status, expected all, active or deleted"))
+		return
+	}
+
+	page := 1
+	if parsedPage, err := strconv.Atoi(query.Get("page")); err == nil && parsedPage > 0 {
+		page = parsedPage
+	}
+	perPage := 10
+	if parsedPerPage, err := strconv.Atoi(query.Get("per_page")); err == nil && parsedPerPage > 0 && parsedPerPage <= 100 {
+		perPage = parsedPerPage
+	}
+
+	totalCount, err := cfg.DB.CountSearchUsers(r.Context(), filters)
+	if err != nil {
+		respondWithDBError(w, err, "Error counting users")
+		return
+	}
+	page = models.ClampPage(page, perPage, totalCount)
+
+	params := database.SearchUsersParams{
+		Email:         filters.Email,
+		CreatedAfter:  filters.CreatedAfter,
+		CreatedBefore: filters.CreatedBefore,
+		Status:        filters.Status,
+		Limit:         int32(perPage),
+		Offset:        int32((page - 1) * perPage),
+	}
+
+	// Newest first unless ?sort or ?order asks otherwise
+	var rows []database.SearchUsersRow
+	sortKey, order := query.Get("sort"), query.Get("order")
+	if sortKey == "" && order == "" {
+		rows, err = cfg.DB.SearchUsers(r.Context(), params)
+	} else {
+		rows, err = cfg.DB.SearchUsersSorted(r.Context(), params, cmp.Or(sortKey, "created_at"), order)
+	}
+	if errors.Is(err, database.ErrInvalidSort) {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid sort, expected created_at, email, last_place_count or username and order asc or desc"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Error searching users")
+		return
+	}
+
+	users := make([]models.UserExport, len(rows))
+	for i, row := range rows {
+		users[i] = exportedUser(database.ListUsersForExportRow(row))
+	}
+
+	response := models.NewPaginatedResponse(users, totalCount, perPage, page)
+	if wantsSummary(r) {
+		if response.Summary, err = cfg.loadSummary(r.Context()); err != nil {
+			respondWithDBError(w, err, "Error summarizing users")
+			return
+		}
+	}
+	RespondWithJSON(w, http.StatusOK, response)
+}
+
+// parseSearchTime parses an optional RFC 3339 time or YYYY-MM-DD date, in UTC
+// like the timestamps the database stores
+func parseSearchTime(value string) (pgtype.Timestamp, error) {
+	if value == "" {
+		return pgtype.Timestamp{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, value); err != nil {
+			return pgtype.Timestamp{}, err
+		}
+	}
+	return pgtype.Timestamp{Time: t.UTC(), Valid: true}, nil
+}
+
+// ReprocessProfilePicturesHandler re-encodes existing profile pictures under
+// the current ImageCompression rules, one batch of users at a time in id
+// order. It pauses ReprocessDelay between users to go easy on storage, and
+// returns next_after to resume from, so a run is a loop of requests that can
+// stop and pick up again at any point. A batch that would outlast
+// ReprocessBudget stops early, so the response and its next_after are written
+// before the server's WriteTimeout cuts the connection.
+func (cfg *APIConfig) ReprocessProfilePicturesHandler(w http.ResponseWriter, r *http.Request) {
+	after := uuid.Nil
+	if afterStr := r.URL.Query().Get("after"); afterStr != "" {
+		var err error
+		if after, err = uuid.Parse(afterStr); err != nil {
+			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid after parameter"))
+			return
+		}
+	}
+	limit := DefaultReprocessBatch
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > MaxReprocessBatch {
+			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse(fmt.Sprintf("Invalid limit, must be between 1 and %d", MaxReprocessBatch)))
+			return
+		}
+		limit = parsed
+	}
+
+	rows, err := cfg.DB.ListProfilePicturesAfter(r.Context(), database.ListProfilePicturesAfterParams{
+		AfterID: after,
+		Limit:   int32(limit),
+	})
+	if err != nil {
+		respondWithDBError(w, err, "Error listing profile pictures")
+		return
+	}
+
+	start := time.Now()
+	result := ReprocessResult{Users: []ReprocessUserResult{}}
+	for i, row := range rows {
+		if i > 0 && cfg.ReprocessBudget > 0 && time.Since(start)+cfg.ReprocessDelay >= cfg.ReprocessBudget {
+			// Out of time, the rest of the batch is left to the next request
+			result.NextAfter = &rows[i-1].ID
+			break
+		}
+		if i > 0 && cfg.ReprocessDelay > 0 {
+			select {
+			case <-time.After(cfg.ReprocessDelay):
+			case <-r.Context().Done():
+				// Nobody is waiting for the response, the next run resumes from here
+				return
+			}
+		}
+
+		userResult := cfg.reprocessProfilePicture(r.Context(), row.ID, row.ProfilePicture.String)
+		if userResult.Status == ReprocessStatusFailed {
+			result.Failed++
+		}
+		result.Users = append(result.Users, userResult)
+	}
+
+	// A full batch may have more users after it
+	if result.NextAfter == nil && len(rows) == limit {
+		result.NextAfter = &rows[len(rows)-1].ID
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(result))
+}
+
+// reprocessProfilePicture re-encodes one user's picture and swaps it in,
+// deleting the old file. The swap only happens if the user still has the
+// picture that was read, otherwise the new file is dropped.
+func (cfg *APIConfig) reprocessProfilePicture(ctx context.Context, userID uuid.UUID, path string) ReprocessUserResult {
+	result := ReprocessUserResult{ID: userID}
+	fail := func(msg string, err error) ReprocessUserResult {
+		log.Printf("Reprocessing profile picture %s of user %s failed: %s: %v", path, userID, msg, err)
+		result.Status, result.Error = ReprocessStatusFailed, msg
+		return result
+	}
+
+	// External avatars aren't ours to re-encode
+	if isExternalAvatar(path) {
+		result.Status = ReprocessStatusUnchanged
+		return result
+	}
+
+	reader, err := cfg.FileStorage.Open(ctx, path)
+	if err != nil {
+		return fail("Error reading picture", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, MaxUploadSize+1))
+	reader.Close()
+	if err != nil {
+		return fail("Error reading picture", err)
+	}
+	if len(data) > MaxUploadSize {
+		return fail("Picture too large", fmt.Errorf("over %d bytes", MaxUploadSize))
+	}
+
+	fileType := http.DetectContentType(data)
+	extension, ok := allowedFileTypes[fileType]
+	if !ok {
+		return fail("Unsupported image type", fmt.Errorf("detected %s", fileType))
+	}
+
+	compressed, extension, err := cfg.ImageCompression.compress(bytes.NewReader(data), extension)
+	if errors.Is(err, ErrImageTooLarge) {
+		return fail("Picture too large", err)
+	} else if err != nil {
+		return fail("Invalid or corrupt image", err)
+	}
+	if compressed == nil {
+		result.Status = ReprocessStatusUnchanged
+		return result
+	}
+
+	newPath, err := cfg.FileStorage.Store(compressed, userID.String()+"_"+cfg.newUUID()+extension)
+	if err != nil {
+		return fail("Error saving picture", err)
+	}
+
+	replaced, err := cfg.DB.ReplaceProfilePicture(ctx, database.ReplaceProfilePictureParams{
+		NewPicture: pgtype.Text{String: newPath, Valid: true},
+		ID:         userID,
+		OldPicture: pgtype.Text{String: path, Valid: true},
+	})
+	if err != nil || replaced == 0 {
+		// The new file is unreferenced, don't leave it behind
+		if deleteErr := cfg.FileStorage.Delete(newPath); deleteErr != nil {
+			log.Printf("Failed to delete unused reprocessed picture %s: %v", newPath, deleteErr)
+		}
+		if err != nil {
+			return fail("Error updating profile picture", err)
+		}
+		result.Status = ReprocessStatusSkipped
+		return result
+	}
+
+	if err := cfg.FileStorage.Delete(path); err != nil {
+		log.Printf("Failed to delete replaced profile picture %s: %v", path, err)
+	}
+	result.Status, result.Path = ReprocessStatusReprocessed, newPath
+	return result
+}
+
+// DebugInfo describes the running build and what it talks to, for confirming
+// an environment during incidents. It must never hold secrets.
+type DebugInfo struct {
+	Version         string `json:"version"`
+	Commit          string `json:"commit"`
+	GoVersion       string `json:"go_version"`
+	DatabaseVersion string `json:"database_version"`
+	StorageBackend  string `json:"storage_backend"`
+}
+
+// DebugInfoHandler reports the app, Go and Postgres versions and the storage backend
+func (cfg *APIConfig) DebugInfoHandler(w http.ResponseWriter, r *http.Request) {
+	dbVersion, err := cfg.DB.GetServerVersion(r.Context())
+	if err != nil {
+		log.Printf("Error reading database version: %v", err)
+		respondWithDBError(w, err, "Failed to read database version")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(DebugInfo{
+		Version:         cmp.Or(cfg.Version, "dev"),
+		Commit:          cmp.Or(cfg.Commit, "unknown"),
+		GoVersion:       runtime.Version(),
+		DatabaseVersion: dbVersion,
+		StorageBackend:  cmp.Or(cfg.StorageBackend, "local"),
+	}))
+}
@@ -0,0 +1,641 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/froggu-tantei/ToT/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestFindOrphanFiles(t *testing.T) {
+	stored := []string{"/uploads/c.png", "/uploads/a.png", "/uploads/b.png"}
+	referenced := []pgtype.Text{
+		{String: "/uploads/b.png", Valid: true},
+		{String: "/uploads/missing.png", Valid: true}, // Referenced but not stored
+		{Valid: false},
+	}
+
+	orphans := findOrphanFiles(stored, referenced)
+	expected := []string{"/uploads/a.png", "/uploads/c.png"}
+	if !slices.Equal(orphans, expected) {
+		t.Errorf("Expected orphans %v, got %v", expected, orphans)
+	}
+
+	if orphans := findOrphanFiles(nil, referenced); len(orphans) != 0 {
+		t.Errorf("Expected no orphans for empty storage, got %v", orphans)
+	}
+}
+
+func TestStorageGCHandler(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		expectedStatus  int
+		expectedApplied bool
+		expectDeleted   bool
+	}{
+		{name: "dry_run_by_default", query: "", expectedStatus: http.StatusOK},
+		{name: "explicit_dry_run", query: "?apply=false", expectedStatus: http.StatusOK},
+		{name: "apply_deletes", query: "?apply=true", expectedStatus: http.StatusOK, expectedApplied: true, expectDeleted: true},
+		{name: "invalid_apply", query: "?apply=maybe", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileStorage := newMockStorage("/uploads/kept.png", "/uploads/orphan.png")
+			db := &mockDB{
+				listProfilePictures: func(ctx context.Context) ([]pgtype.Text, error) {
+					return []pgtype.Text{{String: "/uploads/kept.png", Valid: true}}, nil
+				},
+			}
+			apiCfg := NewAPIConfig(db, fileStorage)
+
+			req := httptest.NewRequest("POST", "/v1/admin/storage/gc"+tt.query, nil)
+			w := httptest.NewRecorder()
+			apiCfg.StorageGCHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Data StorageGCResult `json:"data"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Data.Applied != tt.expectedApplied {
+				t.Errorf("Expected applied %v, got %v", tt.expectedApplied, resp.Data.Applied)
+			}
+			if !slices.Equal(resp.Data.Orphans, []string{"/uploads/orphan.png"}) {
+				t.Errorf("Expected orphan.png reported, got %v", resp.Data.Orphans)
+			}
+
+			_, orphanStillStored := fileStorage.files["/uploads/orphan.png"]
+			if tt.expectDeleted == orphanStillStored {
+				t.Errorf("Expected orphan deleted %v, still stored %v", tt.expectDeleted, orphanStillStored)
+			}
+			if _, ok := fileStorage.files["/uploads/kept.png"]; !ok {
+				t.Error("Referenced file must never be deleted")
+			}
+		})
+	}
+}
+
+// bucketS3 is a bucket shared with other applications, listing and deleting keys
+type bucketS3 struct {
+	storage.S3API
+	keys []string
+}
+
+func (b *bucketS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	out := &s3.ListObjectsV2Output{}
+	for _, key := range b.keys {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			out.Contents = append(out.Contents, types.Object{Key: aws.String(key)})
+		}
+	}
+	return out, nil
+}
+
+func (b *bucketS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	b.keys = slices.DeleteFunc(b.keys, func(key string) bool { return key == aws.ToString(params.Key) })
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func TestStorageGCHandlerSharedBucket(t *testing.T) {
+	client := &bucketS3{keys: []string{"uploads/kept.png", "uploads/orphan.png", "backups/db.sql", "index.html"}}
+	fileStorage := &storage.S3Storage{Client: client, BucketName: "bucket", KeyPrefix: "uploads"}
+	db := &mockDB{
+		listProfilePictures: func(ctx context.Context) ([]pgtype.Text, error) {
+			return []pgtype.Text{{String: "/uploads/kept.png", Valid: true}}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, fileStorage)
+
+	req := httptest.NewRequest("POST", "/v1/admin/storage/gc?apply=true", nil)
+	w := httptest.NewRecorder()
+	apiCfg.StorageGCHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	expected := []string{"uploads/kept.png", "backups/db.sql", "index.html"}
+	if !slices.Equal(client.keys, expected) {
+		t.Errorf("Expected only the orphaned upload deleted, left %v", client.keys)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+	unknownID := uuid.New()
+	db := &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			switch id {
+			case adminID:
+				return database.User{ID: id, Role: models.RoleAdmin}, nil
+			case userID:
+				return database.User{ID: id, Role: models.RoleUser}, nil
+			default:
+				return database.User{}, pgx.ErrNoRows
+			}
+		},
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+	handler := apiCfg.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		userID         *uuid.UUID
+		expectedStatus int
+	}{
+		{name: "admin_allowed", userID: &adminID, expectedStatus: http.StatusOK},
+		{name: "regular_user_forbidden", userID: &userID, expectedStatus: http.StatusForbidden},
+		{name: "unknown_user_unauthorized", userID: &unknownID, expectedStatus: http.StatusUnauthorized},
+		{name: "no_claims_unauthorized", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/admin/storage/gc", nil)
+			if tt.userID != nil {
+				req = withClaims(req, *tt.userID)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestBulkDeleteUsersHandler(t *testing.T) {
+	adminID := uuid.New()
+	withPicture := uuid.New()
+	withoutPicture := uuid.New()
+	missing := uuid.New()
+
+	var deletedIDs []uuid.UUID
+	fileStorage := newMockStorage("/uploads/spam.png")
+	db := &mockDB{
+		softDeleteUsers: func(ctx context.Context, ids []uuid.UUID) ([]database.SoftDeleteUsersRow, error) {
+			deletedIDs = ids
+			return []database.SoftDeleteUsersRow{
+				{ID: withPicture, ProfilePicture: pgtype.Text{String: "/uploads/spam.png", Valid: true}},
+				{ID: withoutPicture},
+			}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, fileStorage)
+
+	body := `{"user_ids": ["` + withPicture.String() + `", "` + missing.String() + `", "` + withoutPicture.String() + `", "` + withPicture.String() + `"]}`
+	req := withClaims(httptest.NewRequest("POST", "/v1/admin/users/bulk-delete", strings.NewReader(body)), adminID)
+	w := httptest.NewRecorder()
+	apiCfg.BulkDeleteUsersHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(deletedIDs) != 3 {
+		t.Errorf("Expected duplicate IDs to be collapsed into 3, got %v", deletedIDs)
+	}
+
+	var resp struct {
+		Data []BulkDeleteUserResult `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expected := []BulkDeleteUserResult{
+		{ID: withPicture, Status: BulkDeleteStatusDeleted},
+		{ID: missing, Status: BulkDeleteStatusNotFound},
+		{ID: withoutPicture, Status: BulkDeleteStatusDeleted},
+	}
+	if !slices.Equal(resp.Data, expected) {
+		t.Errorf("Expected results %v, got %v", expected, resp.Data)
+	}
+
+	if _, ok := fileStorage.files["/uploads/spam.png"]; ok {
+		t.Error("Expected the deleted user's avatar to be removed")
+	}
+}
+
+func TestBulkDeleteUsersHandlerValidation(t *testing.T) {
+	adminID := uuid.New()
+
+	tooMany := make([]string, MaxBulkDeleteUsers+1)
+	for i := range tooMany {
+		tooMany[i] = `"` + uuid.NewString() + `"`
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "self_delete", body: `{"user_ids": ["` + uuid.NewString() + `", "` + adminID.String() + `"]}`},
+		{name: "empty_list", body: `{"user_ids": []}`},
+		{name: "invalid_id", body: `{"user_ids": ["not-a-uuid"]}`},
+		{name: "too_many", body: `{"user_ids": [` + strings.Join(tooMany, ",") + `]}`},
+		{name: "malformed_json", body: `{"user_ids":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No softDeleteUsers stub: reaching the database would panic
+			apiCfg := NewAPIConfig(&mockDB{}, newMockStorage())
+
+			req := withClaims(httptest.NewRequest("POST", "/v1/admin/users/bulk-delete", strings.NewReader(tt.body)), adminID)
+			w := httptest.NewRecorder()
+			apiCfg.BulkDeleteUsersHandler(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
+
+// reprocessDB holds one picture per user and swaps them like ReplaceProfilePicture
+func reprocessDB(pictures map[uuid.UUID]string) *mockDB {
+	return &mockDB{
+		listProfilePicturesAfter: func(ctx context.Context, arg database.ListProfilePicturesAfterParams) ([]database.ListProfilePicturesAfterRow, error) {
+			ids := make([]uuid.UUID, 0, len(pictures))
+			for id := range pictures {
+				ids = append(ids, id)
+			}
+			slices.SortFunc(ids, func(a, b uuid.UUID) int { return strings.Compare(a.String(), b.String()) })
+
+			rows := []database.ListProfilePicturesAfterRow{}
+			for _, id := range ids {
+				if id.String() > arg.AfterID.String() && len(rows) < int(arg.Limit) {
+					rows = append(rows, database.ListProfilePicturesAfterRow{ID: id, ProfilePicture: pgtype.Text{String: pictures[id], Valid: true}})
+				}
+			}
+			return rows, nil
+		},
+		replaceProfilePicture: func(ctx context.Context, arg database.ReplaceProfilePictureParams) (int64, error) {
+			if pictures[arg.ID] != arg.OldPicture.String {
+				return 0, nil
+			}
+			pictures[arg.ID] = arg.NewPicture.String
+			return 1, nil
+		},
+	}
+}
+
+func TestReprocessProfilePicture(t *testing.T) {
+	userID := uuid.New()
+	const oldPath = "/old.png"
+
+	tests := []struct {
+		name        string
+		content     []byte
+		compression bool
+		current     string // The user's picture when the swap happens
+		status      string
+	}{
+		{name: "reencoded", content: photoPNG(t, 64, 64), compression: true, current: oldPath, status: ReprocessStatusReprocessed},
+		{name: "compression_off", content: photoPNG(t, 64, 64), compression: false, current: oldPath, status: ReprocessStatusUnchanged},
+		{name: "changed_meanwhile", content: photoPNG(t, 64, 64), compression: true, current: "/fresh.png", status: ReprocessStatusSkipped},
+		{name: "not_an_image", content: []byte("plain text, not a picture"), compression: true, current: oldPath, status: ReprocessStatusFailed},
+		{name: "already_compressed", content: photoJPEG(t, 64, 64, DefaultJPEGQuality), compression: true, current: oldPath, status: ReprocessStatusUnchanged},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileStorage := newMockStorage()
+			fileStorage.files[oldPath] = tt.content
+			pictures := map[uuid.UUID]string{userID: tt.current}
+			cfg := &APIConfig{
+				DB:               reprocessDB(pictures),
+				FileStorage:      fileStorage,
+				ImageCompression: ImageCompression{Enabled: tt.compression},
+				UUIDGen:          func() string { return "new" },
+			}
+
+			result := cfg.reprocessProfilePicture(context.Background(), userID, oldPath)
+
+			if result.Status != tt.status {
+				t.Fatalf("Expected status %q, got %q (error %q)", tt.status, result.Status, result.Error)
+			}
+			switch tt.status {
+			case ReprocessStatusReprocessed:
+				newPath := "/" + userID.String() + "_new.jpg"
+				if result.Path != newPath || pictures[userID] != newPath {
+					t.Errorf("Expected the picture swapped to %s, got result %q and picture %q", newPath, result.Path, pictures[userID])
+				}
+				if _, ok := fileStorage.files[oldPath]; ok {
+					t.Error("Expected the old picture to be deleted")
+				}
+				if len(fileStorage.files[newPath]) >= len(tt.content) {
+					t.Errorf("Expected the new picture to be smaller than %d bytes, got %d", len(tt.content), len(fileStorage.files[newPath]))
+				}
+			case ReprocessStatusFailed:
+				if result.Error == "" {
+					t.Error("Expected an error message for a failure")
+				}
+				fallthrough
+			default:
+				if len(fileStorage.files) != 1 || fileStorage.files[oldPath] == nil {
+					t.Errorf("Expected storage to hold only the old picture, got %d files", len(fileStorage.files))
+				}
+				if pictures[userID] != tt.current {
+					t.Errorf("Expected the picture to stay %q, got %q", tt.current, pictures[userID])
+				}
+			}
+		})
+	}
+}
+
+func TestReprocessProfilePicturesHandlerResumes(t *testing.T) {
+	fileStorage := newMockStorage()
+	pictures := make(map[uuid.UUID]string)
+	for i := range 3 {
+		id := uuid.New()
+		path := "/" + id.String() + ".png"
+		pictures[id] = path
+		fileStorage.files[path] = photoPNG(t, 32+i, 32)
+	}
+	cfg := NewAPIConfig(reprocessDB(pictures), fileStorage)
+	cfg.ImageCompression = ImageCompression{Enabled: true}
+	cfg.ReprocessDelay = 0
+
+	run := func(query string) ReprocessResult {
+		w := httptest.NewRecorder()
+		cfg.ReprocessProfilePicturesHandler(w, httptest.NewRequest("POST", "/v1/admin/profile-pictures/reprocess"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %q, got %d", query, w.Code)
+		}
+		var resp struct {
+			Data ReprocessResult `json:"data"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp.Data
+	}
+
+	first := run("?limit=2")
+	if len(first.Users) != 2 || first.NextAfter == nil {
+		t.Fatalf("Expected 2 users and a cursor, got %+v", first)
+	}
+	second := run("?limit=2&after=" + first.NextAfter.String())
+	if len(second.Users) != 1 || second.NextAfter != nil {
+		t.Fatalf("Expected the last user and no cursor, got %+v", second)
+	}
+
+	for _, user := range append(first.Users, second.Users...) {
+		if user.Status != ReprocessStatusReprocessed {
+			t.Errorf("Expected user %s reprocessed, got %q (%s)", user.ID, user.Status, user.Error)
+		}
+	}
+	for id, path := range pictures {
+		if !strings.HasSuffix(path, ".jpg") {
+			t.Errorf("Expected user %s to have a re-encoded picture, got %s", id, path)
+		}
+	}
+
+	for _, query := range []string{"?after=nope", "?limit=0", "?limit=501"} {
+		w := httptest.NewRecorder()
+		cfg.ReprocessProfilePicturesHandler(w, httptest.NewRequest("POST", "/v1/admin/profile-pictures/reprocess"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestReprocessProfilePicturesHandlerBudget(t *testing.T) {
+	fileStorage := newMockStorage()
+	pictures := make(map[uuid.UUID]string)
+	for i := range 3 {
+		id := uuid.New()
+		path := "/" + id.String() + ".png"
+		pictures[id] = path
+		fileStorage.files[path] = photoPNG(t, 32+i, 32)
+	}
+	cfg := NewAPIConfig(reprocessDB(pictures), fileStorage)
+	cfg.ImageCompression = ImageCompression{Enabled: true}
+	cfg.ReprocessDelay = 0
+	cfg.ReprocessBudget = time.Nanosecond // Spent by the first user of every batch
+
+	query := ""
+	reprocessed := 0
+	for runs := 1; ; runs++ {
+		w := httptest.NewRecorder()
+		cfg.ReprocessProfilePicturesHandler(w, httptest.NewRequest("POST", "/v1/admin/profile-pictures/reprocess"+query, nil))
+		var resp struct {
+			Data ReprocessResult `json:"data"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(resp.Data.Users) != 1 {
+			t.Fatalf("Expected the budget to stop each batch after one user, got %+v", resp.Data)
+		}
+		reprocessed++
+
+		if resp.Data.NextAfter == nil {
+			break
+		}
+		if *resp.Data.NextAfter != resp.Data.Users[0].ID {
+			t.Fatalf("Expected to resume after the last processed user, got %s", resp.Data.NextAfter)
+		}
+		if runs > len(pictures) {
+			t.Fatal("Expected the run to finish")
+		}
+		query = "?after=" + resp.Data.NextAfter.String()
+	}
+
+	if reprocessed != len(pictures) {
+		t.Errorf("Expected every user reprocessed once, got %d", reprocessed)
+	}
+}
+
+// searchDB records the filters of an admin search and returns one matching user
+func searchDB(filters *database.CountSearchUsersParams) *mockDB {
+	return &mockDB{
+		countSearchUsers: func(ctx context.Context, arg database.CountSearchUsersParams) (int64, error) {
+			*filters = arg
+			return 1, nil
+		},
+		searchUsers: func(ctx context.Context, arg database.SearchUsersParams) ([]database.SearchUsersRow, error) {
+			if arg.Email != filters.Email || arg.CreatedAfter != filters.CreatedAfter || arg.CreatedBefore != filters.CreatedBefore || arg.Status != filters.Status {
+				return nil, errors.New("search and count filters differ")
+			}
+			return []database.SearchUsersRow{{ID: uuid.New(), Email: "support@example.com", Username: "support"}}, nil
+		},
+	}
+}
+
+func TestSearchUsersHandlerEmailFilter(t *testing.T) {
+	var filters database.CountSearchUsersParams
+	apiCfg := NewAPIConfig(searchDB(&filters), newMockStorage())
+
+	w := httptest.NewRecorder()
+	apiCfg.SearchUsersHandler(w, httptest.NewRequest("GET", "/v1/admin/users?email=50%25_off@example.com&status=active", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !filters.Email.Valid || filters.Email.String != `50\%\_off@example.com` {
+		t.Errorf("Expected the email with LIKE wildcards escaped, got %+v", filters.Email)
+	}
+	if filters.Status != UserStatusActive {
+		t.Errorf("Expected status active, got %q", filters.Status)
+	}
+	if filters.CreatedAfter.Valid || filters.CreatedBefore.Valid {
+		t.Errorf("Expected no date filters, got %+v", filters)
+	}
+
+	var resp models.PaginatedResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Pagination.Total != 1 {
+		t.Errorf("Expected 1 match, got %d", resp.Pagination.Total)
+	}
+	if users, ok := resp.Data.([]any); !ok || len(users) != 1 {
+		t.Errorf("Expected 1 user in the page, got %v", resp.Data)
+	}
+}
+
+func TestSearchUsersHandlerDateRange(t *testing.T) {
+	var filters database.CountSearchUsersParams
+	apiCfg := NewAPIConfig(searchDB(&filters), newMockStorage())
+
+	w := httptest.NewRecorder()
+	apiCfg.SearchUsersHandler(w, httptest.NewRequest("GET", "/v1/admin/users?created_after=2025-01-01&created_before=2025-02-01T12:00:00%2B02:00", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if expected := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC); !filters.CreatedAfter.Valid || !filters.CreatedAfter.Time.Equal(expected) {
+		t.Errorf("Expected created_after %v, got %+v", expected, filters.CreatedAfter)
+	}
+	if expected := time.Date(2025, 2, 1, 10, 0, 0, 0, time.UTC); !filters.CreatedBefore.Valid || filters.CreatedBefore.Time != expected {
+		t.Errorf("Expected created_before %v in UTC, got %+v", expected, filters.CreatedBefore)
+	}
+	if filters.Email.Valid {
+		t.Errorf("Expected no email filter, got %+v", filters.Email)
+	}
+	if filters.Status != UserStatusAll {
+		t.Errorf("Expected status all by default, got %q", filters.Status)
+	}
+
+	for _, query := range []string{
+		"?created_after=yesterday",
+		"?created_before=2025-13-01",
+		"?created_after=2025-02-01&created_before=2025-01-01",
+		"?status=suspended",
+	} {
+		w := httptest.NewRecorder()
+		apiCfg.SearchUsersHandler(w, httptest.NewRequest("GET", "/v1/admin/users"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestSearchUsersHandlerSort(t *testing.T) {
+	var filters database.CountSearchUsersParams
+	var sortKey, order string
+	db := searchDB(&filters)
+	db.searchUsersSorted = func(ctx context.Context, arg database.SearchUsersParams, key, direction string) ([]database.SearchUsersRow, error) {
+		sortKey, order = key, direction
+		if _, ok := database.SearchUsersSortKeys[key]; !ok {
+			return nil, fmt.Errorf("%w: unknown sort key %q", database.ErrInvalidSort, key)
+		}
+		return db.searchUsers(ctx, arg)
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedSort   string
+		expectedOrder  string
+	}{
+		{name: "sort_and_order", query: "?sort=username&order=asc", expectedStatus: http.StatusOK, expectedSort: "username", expectedOrder: "asc"},
+		{name: "order_only", query: "?order=asc", expectedStatus: http.StatusOK, expectedSort: "created_at", expectedOrder: "asc"},
+		{name: "unknown_key", query: "?sort=password_hash", expectedStatus: http.StatusBadRequest, expectedSort: "password_hash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			apiCfg.SearchUsersHandler(w, httptest.NewRequest("GET", "/v1/admin/users"+tt.query, nil))
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if sortKey != tt.expectedSort || order != tt.expectedOrder {
+				t.Errorf("Expected sort %q %q, got %q %q", tt.expectedSort, tt.expectedOrder, sortKey, order)
+			}
+		})
+	}
+}
+
+func TestDebugInfoHandler(t *testing.T) {
+	const pgVersion = "PostgreSQL 16.3 on x86_64-pc-linux-gnu"
+	apiCfg := &APIConfig{
+		DB: &mockDB{getServerVersion: func(ctx context.Context) (string, error) {
+			return pgVersion, nil
+		}},
+		Version:        "1.4.0",
+		Commit:         "abc123",
+		StorageBackend: "s3",
+	}
+
+	w := httptest.NewRecorder()
+	apiCfg.DebugInfoHandler(w, httptest.NewRequest("GET", "/v1/debug/info", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Data DebugInfo `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	expected := DebugInfo{Version: "1.4.0", Commit: "abc123", GoVersion: runtime.Version(), DatabaseVersion: pgVersion, StorageBackend: "s3"}
+	if response.Data != expected {
+		t.Errorf("Expected %+v, got %+v", expected, response.Data)
+	}
+
+	// A database that can't answer is reported instead of a partial result
+	apiCfg.DB = &mockDB{getServerVersion: func(ctx context.Context) (string, error) {
+		return "", errors.New("connection refused")
+	}}
+	w = httptest.NewRecorder()
+	apiCfg.DebugInfoHandler(w, httptest.NewRequest("GET", "/v1/debug/info", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+}
@@ -2,23 +2,147 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/avatar"
+	"github.com/froggu-tantei/ToT/cache"
 	"github.com/froggu-tantei/ToT/db/database" // Import database package
+	"github.com/froggu-tantei/ToT/mail"
+	"github.com/froggu-tantei/ToT/models"
 	"github.com/froggu-tantei/ToT/storage"
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
 )
 
 // APIConfig holds the dependencies for the API handlers.
 type APIConfig struct {
-	DB          *database.Queries
+	DB          database.Store
 	FileStorage storage.FileStorage
+	Hasher      auth.Hasher
+
+	// TokenCookie also sets the access token as a Secure, HttpOnly cookie on
+	// signup, login and refresh. Bearer tokens in the body work either way.
+	TokenCookie bool
+
+	// UUIDGen generates IDs for new resources such as uploaded file names.
+	// Nil uses uuid.NewString; tests swap in a fixed sequence.
+	UUIDGen func() string
+
+	// DefaultAvatarURL is where the avatar endpoint redirects for users
+	// without a profile picture, and their profile_picture in user responses
+	// requested with ?default_avatar=true. Empty disables both.
+	DefaultAvatarURL string
+
+	// AvatarURLHosts are the hosts users may point their profile picture at
+	// with avatar_url instead of uploading one, over https only. Empty
+	// disables external avatars.
+	AvatarURLHosts []string
+
+	// Avatars generates a default avatar when there is neither a profile
+	// picture nor a DefaultAvatarURL. Nil disables generation.
+	Avatars *avatar.Generator
+
+	// TieLastPlacePolicy decides who gets a last place when several players
+	// tie for it: TieLastPlaceAll (the default), TieLastPlaceNone or TieLastPlaceRandom.
+	TieLastPlacePolicy string
+
+	// ImageConstraints limits the dimensions and aspect ratio of uploaded
+	// profile pictures. The zero value accepts any size.
+	ImageConstraints ImageConstraints
+
+	// ImageCompression re-encodes profile pictures before storing them. The
+	// zero value stores uploads unchanged.
+	ImageCompression ImageCompression
+
+	// ReprocessDelay is the pause between users when reprocessing profile
+	// pictures, so a run doesn't overload storage. Zero doesn't pause.
+	ReprocessDelay time.Duration
+
+	// ReprocessBudget is how long one reprocessing request may work before it
+	// stops and returns where to resume. It must stay under the server's
+	// WriteTimeout. Zero doesn't limit it.
+	ReprocessBudget time.Duration
+
+	// LeaderboardMinGames is how many games a user must have played to be
+	// ranked, unless a request sets its own minimum with ?min_games. Zero
+	// ranks everyone.
+	LeaderboardMinGames int
+
+	// CacheTTL is how long the first leaderboard page and /v1/stats are
+	// reused before querying again. Recording a game clears them sooner, the
+	// TTL catches any invalidation that gets lost. Zero disables caching.
+	CacheTTL         time.Duration
+	leaderboardCache *cache.Cache[leaderboardPage]
+	statsCache       *cache.Cache[models.Stats]
+	inflight         singleflight.Group // Coalesces identical concurrent leaderboard and stats queries
+
+	// Mailer delivers email change confirmations and verification links. Nil
+	// disables both.
+	Mailer mail.Sender
+
+	// EmailChangeURL is the page a confirmation link points to, with the
+	// token appended as ?token=. The page confirms it by POSTing the token,
+	// so link scanners fetching the URL can't apply the change.
+	EmailChangeURL string
+
+	// EmailChangeTTL is how long a confirmation link stays valid
+	EmailChangeTTL time.Duration
+
+	// VerifyEmailURL is the page a verification link points to, with the
+	// token appended as ?token= like EmailChangeURL
+	VerifyEmailURL string
+
+	// EmailVerificationTTL is how long a verification link stays valid
+	EmailVerificationTTL time.Duration
+
+	// MultipartMemory is the number of bytes of a multipart upload kept in
+	// memory; anything larger spills to a temp file.
+	MultipartMemory int64
+
+	// LogRequestBodies logs every decoded JSON request body for debugging,
+	// with passwords, tokens and other secrets masked
+	LogRequestBodies bool
+
+	// Version and Commit identify the running build on /v1/debug/info, along
+	// with StorageBackend, the name of the configured FileStorage
+	Version        string
+	Commit         string
+	StorageBackend string
 }
 
 // NewAPIConfig creates a new APIConfig.
-func NewAPIConfig(db *database.Queries, fileStorage storage.FileStorage) *APIConfig {
+func NewAPIConfig(db database.Store, fileStorage storage.FileStorage) *APIConfig {
 	return &APIConfig{
-		DB:          db,
-		FileStorage: fileStorage,
+		DB:              db,
+		FileStorage:     fileStorage,
+		Hasher:          auth.NewBcryptHasher(),
+		MultipartMemory: DefaultMultipartMemory,
+		ReprocessDelay:  DefaultReprocessDelay,
+		ReprocessBudget: DefaultReprocessBudget,
+		EmailChangeTTL:  DefaultEmailChangeTTL,
+
+		EmailVerificationTTL: DefaultEmailVerificationTTL,
+
+		leaderboardCache: cache.New[leaderboardPage](),
+		statsCache:       cache.New[models.Stats](),
+	}
+}
+
+// hasher returns the configured password hasher, defaulting to bcrypt
+func (cfg *APIConfig) hasher() auth.Hasher {
+	if cfg.Hasher == nil {
+		return auth.NewBcryptHasher()
+	}
+	return cfg.Hasher
+}
+
+// newUUID returns an ID from the configured generator, defaulting to a random UUID
+func (cfg *APIConfig) newUUID() string {
+	if cfg.UUIDGen == nil {
+		return uuid.NewString()
 	}
+	return cfg.UUIDGen()
 }
 
 // RootHandler handles requests to the root path.
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/froggu-tantei/ToT/models"
 	"github.com/froggu-tantei/ToT/storage"
+	"github.com/google/uuid"
 )
 
 func TestRootHandler(t *testing.T) {
@@ -97,6 +99,10 @@ func TestRespondWithJSON(t *testing.T) {
 	if contentType != "application/json" {
 		t.Errorf("Expected content type application/json, got %s", contentType)
 	}
+
+	if length := w.Header().Get("Content-Length"); length != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Expected Content-Length %d, got %q", w.Body.Len(), length)
+	}
 }
 
 func TestRespondWithError(t *testing.T) {
@@ -107,6 +113,36 @@ func TestRespondWithError(t *testing.T) {
 	if w.Code != 400 {
 		t.Errorf("Expected status 400, got %d", w.Code)
 	}
+	if length := w.Header().Get("Content-Length"); length != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Expected Content-Length %d, got %q", w.Body.Len(), length)
+	}
+}
+
+func TestRequireDifferentUser(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+
+	w := httptest.NewRecorder()
+	if !requireDifferentUser(w, alice, bob, "Cannot block your own account") {
+		t.Error("Expected different users to be allowed")
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected nothing written for different users, got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if requireDifferentUser(w, alice, alice, "Cannot block your own account") {
+		t.Error("Expected the same user to be rejected")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	var resp models.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Error != "Cannot block your own account" {
+		t.Errorf("Unexpected error %q", resp.Error)
+	}
 }
 
 func TestReadinessHandler(t *testing.T) {
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// AvatarCacheControl lets clients and CDNs reuse an avatar redirect briefly,
+// short enough that a new upload shows up within a few minutes
+const AvatarCacheControl = "public, max-age=300"
+
+// isExternalAvatar reports whether a stored profile picture is an external
+// URL set with avatar_url rather than a path FileStorage returned, which
+// always starts with "/"
+func isExternalAvatar(picture string) bool {
+	return strings.HasPrefix(picture, "https://")
+}
+
+// validateAvatarURL checks an external avatar URL before it is stored, so the
+// avatar redirect can't be turned into an open redirect or point clients at
+// internal hosts: it must be https on the default port, without credentials,
+// and its host must be one of allowed exactly. It returns the URL normalized,
+// or a message saying what's wrong with it.
+func validateAvatarURL(raw string, allowed []string) (string, string) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", "Invalid avatar URL"
+	}
+	if u.Scheme != "https" || u.Host == "" || u.Opaque != "" {
+		return "", "Avatar URL must use https"
+	}
+	if u.User != nil || (u.Port() != "" && u.Port() != "443") {
+		return "", "Avatar URL must not include credentials or a port"
+	}
+	if !slices.Contains(allowed, strings.ToLower(u.Hostname())) {
+		return "", "Avatar URL host is not allowed"
+	}
+	u.Host = strings.ToLower(u.Hostname())
+	u.Fragment = ""
+	return u.String(), ""
+}
+
+// wantsDefaultAvatar reports whether the client opted in with ?default_avatar=true
+// to getting DefaultAvatarURL instead of an empty profile_picture
+func (cfg *APIConfig) wantsDefaultAvatar(r *http.Request) bool {
+	wants, _ := strconv.ParseBool(r.URL.Query().Get("default_avatar"))
+	return wants && cfg.DefaultAvatarURL != ""
+}
+
+// userModel converts a database user for a response, filling in the default
+// avatar for users without a picture when the client asked for it
+func (cfg *APIConfig) userModel(r *http.Request, dbUser database.User) models.User {
+	user := models.DatabaseUserToUser(dbUser)
+	if user.ProfilePicture == "" && cfg.wantsDefaultAvatar(r) {
+		user.ProfilePicture = cfg.DefaultAvatarURL
+	}
+	return user
+}
+
+// userModels converts a slice of database users like userModel
+func (cfg *APIConfig) userModels(r *http.Request, dbUsers []database.User) []models.User {
+	users := make([]models.User, len(dbUsers))
+	for i, dbUser := range dbUsers {
+		users[i] = cfg.userModel(r, dbUser)
+	}
+	return users
+}
+
+// GetAvatarHandler redirects to a user's current avatar, so clients can use a
+// stable URL that doesn't depend on the stored filename
+func (cfg *APIConfig) GetAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract and parse ID from path
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid user ID format"))
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Database error")
+		return
+	}
+
+	// Fall back to the placeholder when no picture has been uploaded
+	var target string
+	if user.ProfilePicture.Valid && isExternalAvatar(user.ProfilePicture.String) {
+		target = user.ProfilePicture.String
+	} else if user.ProfilePicture.Valid && user.ProfilePicture.String != "" {
+		target = cfg.FileStorage.GetPublicURL(user.ProfilePicture.String)
+	} else if cfg.DefaultAvatarURL != "" {
+		target = cfg.DefaultAvatarURL
+	} else if cfg.Avatars != nil {
+		cfg.serveGeneratedAvatar(w, user)
+		return
+	} else {
+		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User has no avatar"))
+		return
+	}
+
+	w.Header().Set("Cache-Control", AvatarCacheControl)
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// serveGeneratedAvatar writes the user's generated default avatar as a PNG
+func (cfg *APIConfig) serveGeneratedAvatar(w http.ResponseWriter, user database.User) {
+	data, err := cfg.Avatars.PNG(user.ID, user.Username)
+	if err != nil {
+		log.Printf("Failed to generate avatar for user %s: %v", user.ID, err)
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating avatar"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", AvatarCacheControl)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
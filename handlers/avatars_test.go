@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/froggu-tantei/ToT/avatar"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestGetAvatarHandler(t *testing.T) {
+	withPicture := uuid.New()
+	withoutPicture := uuid.New()
+	db := &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			switch id {
+			case withPicture:
+				return database.User{ID: id, ProfilePicture: pgtype.Text{String: "/uploads/abc.png", Valid: true}}, nil
+			case withoutPicture:
+				return database.User{ID: id}, nil
+			default:
+				return database.User{}, pgx.ErrNoRows
+			}
+		},
+	}
+
+	tests := []struct {
+		name             string
+		id               string
+		defaultAvatarURL string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{
+			name:             "redirects_to_uploaded_picture",
+			id:               withPicture.String(),
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://cdn.example.com/uploads/abc.png",
+		},
+		{
+			name:             "redirects_to_placeholder_when_unset",
+			id:               withoutPicture.String(),
+			defaultAvatarURL: "https://cdn.example.com/default.png",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://cdn.example.com/default.png",
+		},
+		{
+			name:           "not_found_without_placeholder",
+			id:             withoutPicture.String(),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "unknown_user",
+			id:             uuid.New().String(),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid_id",
+			id:             "not-a-uuid",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiCfg := NewAPIConfig(db, storage.NewLocalStorage("uploads", "https://cdn.example.com"))
+			apiCfg.DefaultAvatarURL = tt.defaultAvatarURL
+
+			req := withURLParams(httptest.NewRequest("GET", "/v1/users/"+tt.id+"/avatar", nil), map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+			apiCfg.GetAvatarHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusFound {
+				return
+			}
+			if got := w.Header().Get("Location"); got != tt.expectedLocation {
+				t.Errorf("Expected Location %q, got %q", tt.expectedLocation, got)
+			}
+			if got := w.Header().Get("Cache-Control"); got != AvatarCacheControl {
+				t.Errorf("Expected Cache-Control %q, got %q", AvatarCacheControl, got)
+			}
+		})
+	}
+}
+
+func TestGetAvatarHandlerGeneratesDefault(t *testing.T) {
+	userID := uuid.New()
+	db := &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			return database.User{ID: id, Username: "tester"}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+	apiCfg.Avatars = avatar.NewGenerator(avatar.StyleInitials)
+
+	req := withURLParams(httptest.NewRequest("GET", "/v1/users/"+userID.String()+"/avatar", nil), map[string]string{"id": userID.String()})
+	w := httptest.NewRecorder()
+	apiCfg.GetAvatarHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Expected image/png, got %q", got)
+	}
+
+	expected, _ := apiCfg.Avatars.PNG(userID, "tester")
+	if !bytes.Equal(w.Body.Bytes(), expected) {
+		t.Error("Expected the generated avatar for the user")
+	}
+}
+
+func TestGetUserByIDDefaultAvatar(t *testing.T) {
+	const placeholder = "https://cdn.example.com/placeholder.png"
+
+	tests := []struct {
+		name          string
+		query         string
+		picture       pgtype.Text
+		expectPicture string
+	}{
+		{name: "off_by_default", query: "", expectPicture: ""},
+		{name: "opted_in", query: "?default_avatar=true", expectPicture: placeholder},
+		{name: "opted_out", query: "?default_avatar=false", expectPicture: ""},
+		{name: "own_picture_kept", query: "?default_avatar=true", picture: pgtype.Text{String: "/uploads/me.png", Valid: true}, expectPicture: "/uploads/me.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := uuid.New()
+			db := &mockDB{
+				getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+					return database.User{ID: id, Username: "tester", ProfilePicture: tt.picture}, nil
+				},
+			}
+			apiCfg := NewAPIConfig(db, newMockStorage())
+			apiCfg.DefaultAvatarURL = placeholder
+
+			req := withURLParams(httptest.NewRequest("GET", "/v1/users/"+userID.String()+tt.query, nil), map[string]string{"id": userID.String()})
+			w := httptest.NewRecorder()
+			apiCfg.GetUserByIDHandler(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			var resp struct {
+				Data map[string]any `json:"data"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			picture, present := resp.Data["profile_picture"]
+			if tt.expectPicture == "" {
+				// omitempty keeps the field out entirely for existing clients
+				if present {
+					t.Errorf("Expected profile_picture to be omitted, got %v", picture)
+				}
+			} else if picture != tt.expectPicture {
+				t.Errorf("Expected profile_picture %q, got %v", tt.expectPicture, picture)
+			}
+		})
+	}
+}
+
+func TestValidateAvatarURL(t *testing.T) {
+	allowed := []string{"avatars.example.com"}
+
+	tests := []struct {
+		name        string
+		raw         string
+		expectedURL string
+		expectedMsg string
+	}{
+		{
+			name:        "allowed_host",
+			raw:         "https://avatars.example.com/u/1.png",
+			expectedURL: "https://avatars.example.com/u/1.png",
+		},
+		{
+			name:        "normalizes_host_and_drops_fragment",
+			raw:         "https://Avatars.Example.com:443/u/1.png#top",
+			expectedURL: "https://avatars.example.com/u/1.png",
+		},
+		{
+			name:        "plain_http",
+			raw:         "http://avatars.example.com/u/1.png",
+			expectedMsg: "Avatar URL must use https",
+		},
+		{
+			name:        "other_host",
+			raw:         "https://evil.example.com/u/1.png",
+			expectedMsg: "Avatar URL host is not allowed",
+		},
+		{
+			name:        "subdomain_of_allowed_host",
+			raw:         "https://x.avatars.example.com/u/1.png",
+			expectedMsg: "Avatar URL host is not allowed",
+		},
+		{
+			name:        "credentials",
+			raw:         "https://avatars.example.com@evil.example.com/u/1.png",
+			expectedMsg: "Avatar URL must not include credentials or a port",
+		},
+		{
+			name:        "other_port",
+			raw:         "https://avatars.example.com:8443/u/1.png",
+			expectedMsg: "Avatar URL must not include credentials or a port",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, msg := validateAvatarURL(tt.raw, allowed)
+			if msg != tt.expectedMsg {
+				t.Errorf("Expected message %q, got %q", tt.expectedMsg, msg)
+			}
+			if got != tt.expectedURL {
+				t.Errorf("Expected URL %q, got %q", tt.expectedURL, got)
+			}
+		})
+	}
+}
+
+func TestGetAvatarHandlerExternal(t *testing.T) {
+	db := &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			return database.User{ID: id, ProfilePicture: pgtype.Text{String: "https://avatars.example.com/u/1.png", Valid: true}}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, storage.NewLocalStorage("uploads", "https://cdn.example.com"))
+
+	id := uuid.New().String()
+	req := withURLParams(httptest.NewRequest("GET", "/v1/users/"+id+"/avatar", nil), map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	apiCfg.GetAvatarHandler(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusFound, w.Code)
+	}
+	if got := w.Header().Get("Location"); got != "https://avatars.example.com/u/1.png" {
+		t.Errorf("Expected Location of the external avatar, got %q", got)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// blockParams reads the caller and the user in the path for the block
+// endpoints, answering the request itself when they aren't usable
+func blockParams(w http.ResponseWriter, r *http.Request) (database.CreateBlockParams, bool) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Unauthorized"))
+		return database.CreateBlockParams{}, false
+	}
+
+	blockedID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid user ID format"))
+		return database.CreateBlockParams{}, false
+	}
+
+	if !requireDifferentUser(w, claims.UserID, blockedID, "Cannot block your own account") {
+		return database.CreateBlockParams{}, false
+	}
+	return database.CreateBlockParams{BlockerID: claims.UserID, BlockedID: blockedID}, true
+}
+
+// BlockUserHandler hides another user from the caller's user listings
+func (cfg *APIConfig) BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	params, ok := blockParams(w, r)
+	if !ok {
+		return
+	}
+
+	// Soft-deleted users still have a row, so check they're active before blocking
+	if _, err := cfg.DB.GetUserByID(r.Context(), params.BlockedID); errors.Is(err, pgx.ErrNoRows) {
+		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Database error")
+		return
+	}
+
+	err := cfg.DB.CreateBlock(r.Context(), params)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		// Hard-deleted between the check and the insert
+		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Error blocking user")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(map[string]string{
+		"message": "User blocked successfully",
+	}))
+}
+
+// UnblockUserHandler lifts a block. Unblocking someone who isn't blocked succeeds.
+func (cfg *APIConfig) UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	params, ok := blockParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.DeleteBlock(r.Context(), database.DeleteBlockParams(params)); err != nil {
+		respondWithDBError(w, err, "Error unblocking user")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(map[string]string{
+		"message": "User unblocked successfully",
+	}))
+}
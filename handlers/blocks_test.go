@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// blockRequest is a request from caller about the user with targetID in the path
+func blockRequest(method string, caller uuid.UUID, targetID string) *http.Request {
+	req := httptest.NewRequest(method, "/v1/users/"+targetID+"/block", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserContextKey, &auth.Claims{UserID: caller})
+	return withURLParams(req.WithContext(ctx), map[string]string{"id": targetID})
+}
+
+// blocksDB keeps blocks in memory and lists the given users minus whoever the viewer blocked
+func blocksDB(users []database.User) *mockDB {
+	blocked := map[database.CreateBlockParams]bool{}
+	visible := func(viewerID uuid.UUID) []database.User {
+		result := []database.User{}
+		for _, user := range users {
+			if !blocked[database.CreateBlockParams{BlockerID: viewerID, BlockedID: user.ID}] {
+				result = append(result, user)
+			}
+		}
+		return result
+	}
+
+	return &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			for _, user := range users {
+				if user.ID == id {
+					return user, nil
+				}
+			}
+			return database.User{}, pgx.ErrNoRows
+		},
+		createBlock: func(ctx context.Context, arg database.CreateBlockParams) error {
+			blocked[arg] = true
+			return nil
+		},
+		deleteBlock: func(ctx context.Context, arg database.DeleteBlockParams) error {
+			delete(blocked, database.CreateBlockParams(arg))
+			return nil
+		},
+		countVisibleUsers: func(ctx context.Context, viewerID uuid.UUID) (int64, error) {
+			return int64(len(visible(viewerID))), nil
+		},
+		listUsers: func(ctx context.Context, arg database.ListUsersParams) ([]database.User, error) {
+			return visible(arg.ViewerID), nil
+		},
+	}
+}
+
+func TestBlockUserHandler(t *testing.T) {
+	caller := uuid.New()
+	other := uuid.New()
+	apiCfg := &APIConfig{DB: blocksDB([]database.User{{ID: caller}, {ID: other}})}
+
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+	}{
+		{name: "blocks_user", target: other.String(), expectedStatus: http.StatusOK},
+		{name: "blocking_again_is_fine", target: other.String(), expectedStatus: http.StatusOK},
+		{name: "self_block", target: caller.String(), expectedStatus: http.StatusBadRequest},
+		{name: "unknown_user", target: uuid.NewString(), expectedStatus: http.StatusNotFound},
+		{name: "invalid_id", target: "not-a-uuid", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			apiCfg.BlockUserHandler(w, blockRequest("POST", caller, tt.target))
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestListUsersHidesBlockedUsers(t *testing.T) {
+	caller, blocked, other := uuid.New(), uuid.New(), uuid.New()
+	apiCfg := &APIConfig{DB: blocksDB([]database.User{{ID: caller}, {ID: blocked}, {ID: other}})}
+
+	listed := func() map[uuid.UUID]bool {
+		t.Helper()
+		w := httptest.NewRecorder()
+		apiCfg.ListUsersHandler(w, withClaims(httptest.NewRequest("GET", "/v1/users", nil), caller))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		var resp struct {
+			Data       []models.User     `json:"data"`
+			Pagination models.Pagination `json:"pagination"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Pagination.Total != int64(len(resp.Data)) {
+			t.Errorf("Expected the total to match the visible users, got %d for %d", resp.Pagination.Total, len(resp.Data))
+		}
+		ids := map[uuid.UUID]bool{}
+		for _, user := range resp.Data {
+			ids[user.ID] = true
+		}
+		return ids
+	}
+
+	apiCfg.BlockUserHandler(httptest.NewRecorder(), blockRequest("POST", caller, blocked.String()))
+	if ids := listed(); ids[blocked] || !ids[other] {
+		t.Errorf("Expected the blocked user hidden and the others listed, got %v", ids)
+	}
+
+	apiCfg.UnblockUserHandler(httptest.NewRecorder(), blockRequest("DELETE", caller, blocked.String()))
+	if ids := listed(); !ids[blocked] {
+		t.Errorf("Expected the user listed again after unblocking, got %v", ids)
+	}
+}
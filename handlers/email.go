@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/mail"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// DefaultEmailChangeTTL is how long an email change confirmation link stays valid
+const DefaultEmailChangeTTL = 24 * time.Hour
+
+// DefaultEmailVerificationTTL is how long an email verification link stays valid
+const DefaultEmailVerificationTTL = 24 * time.Hour
+
+// hashEmailToken is what the database stores instead of a token sent by
+// email, so a leaked table can't be used to confirm anything
+func hashEmailToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// emailLink returns the page URL base carrying token
+func emailLink(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// RequestEmailChangeHandler starts changing the caller's email. The new
+// address gets a confirmation link and the old one a notice; the email only
+// changes once ConfirmEmailChangeHandler is called with the link's token, so
+// a typo or a stolen session can't take over the account's recovery address.
+func (cfg *APIConfig) RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Unauthorized"))
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid user ID format"))
+		return
+	}
+	if claims.UserID != id {
+		RespondWithJSON(w, http.StatusForbidden, models.NewErrorResponse("Cannot change another user's email"))
+		return
+	}
+
+	if cfg.Mailer == nil || cfg.EmailChangeURL == "" {
+		RespondWithJSON(w, http.StatusServiceUnavailable, models.NewErrorResponse("Email changes are not available"))
+		return
+	}
+
+	var req models.EmailChangeRequest
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+	newEmail := strings.TrimSpace(req.Email)
+	if !isValidEmail(newEmail) {
+		RespondWithJSON(w, http.StatusUnprocessableEntity, models.NewErrorResponse("Invalid email format"))
+		return
+	}
+
+	currentUser, err := cfg.DB.GetUserByID(r.Context(), id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Database error")
+		return
+	}
+	if newEmail == currentUser.Email {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("That is already your email"))
+		return
+	}
+
+	// Checked again when the change is confirmed, someone may sign up with it meanwhile
+	if _, err := cfg.DB.GetUserByEmail(r.Context(), newEmail); err == nil {
+		RespondWithJSON(w, http.StatusConflict, models.NewErrorResponse("Email already in use"))
+		return
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		respondWithDBError(w, err, "Database error")
+		return
+	}
+
+	token := rand.Text()
+	link, err := emailLink(cfg.EmailChangeURL, token)
+	if err != nil {
+		log.Printf("Invalid email change URL %q: %v", cfg.EmailChangeURL, err)
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error creating confirmation link"))
+		return
+	}
+
+	change, err := cfg.DB.UpsertEmailChange(r.Context(), database.UpsertEmailChangeParams{
+		UserID:    id,
+		NewEmail:  newEmail,
+		TokenHash: hashEmailToken(token),
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().UTC().Add(cfg.EmailChangeTTL), Valid: true},
+	})
+	if err != nil {
+		respondWithDBError(w, err, "Error saving email change")
+		return
+	}
+
+	err = cfg.Mailer.Send(r.Context(), mail.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body:    "Hi " + currentUser.Username + ",\n\nOpen this link to use this address for your account:\n" + link + "\n\nIt expires in " + cfg.EmailChangeTTL.String() + ". If you didn't ask for this, ignore this email.",
+	})
+	if err != nil {
+		log.Printf("Failed to send email change confirmation for user %s: %v", id, err)
+		RespondWithJSON(w, http.StatusBadGateway, models.NewErrorResponse("Could not send the confirmation email"))
+		return
+	}
+
+	// The old address stays in charge until confirmation, let it know something is happening
+	err = cfg.Mailer.Send(r.Context(), mail.Message{
+		To:      currentUser.Email,
+		Subject: "Your email address is being changed",
+		Body:    "Hi " + currentUser.Username + ",\n\nSomeone asked to change your account's email to " + newEmail + ". It won't change until that address confirms it. If this wasn't you, change your password.",
+	})
+	if err != nil {
+		log.Printf("Failed to send email change notice for user %s: %v", id, err)
+	}
+
+	RespondWithJSON(w, http.StatusAccepted, models.NewSuccessResponse(models.PendingEmailChange{
+		Email:     change.NewEmail,
+		ExpiresAt: change.ExpiresAt.Time,
+	}))
+}
+
+// ConfirmEmailChangeHandler applies the pending email change a confirmation
+// link was sent for. It doesn't need a session, holding the token proves
+// access to the new address.
+func (cfg *APIConfig) ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.ConfirmEmailChangeRequest
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+	if req.Token == "" {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Token is required"))
+		return
+	}
+
+	user, err := cfg.DB.ConfirmEmailChange(r.Context(), hashEmailToken(req.Token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid or expired confirmation token"))
+		return
+	} else if msg := duplicateUserMessage(err); msg != "" {
+		RespondWithJSON(w, http.StatusConflict, models.NewErrorResponse(msg))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Error confirming email change")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(cfg.userModel(r, user)))
+}
+
+// ResendVerificationHandler mails the caller a new link to verify their
+// current email. Any earlier link stops working. Verified accounts get a 409
+// so clients can tell there is nothing left to do.
+func (cfg *APIConfig) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Unauthorized"))
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), claims.UserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Database error")
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		RespondWithJSON(w, http.StatusConflict, models.NewErrorResponse("Email already verified"))
+		return
+	}
+
+	if cfg.Mailer == nil || cfg.VerifyEmailURL == "" {
+		RespondWithJSON(w, http.StatusServiceUnavailable, models.NewErrorResponse("Email verification is not available"))
+		return
+	}
+
+	token := rand.Text()
+	link, err := emailLink(cfg.VerifyEmailURL, token)
+	if err != nil {
+		log.Printf("Invalid email verification URL %q: %v", cfg.VerifyEmailURL, err)
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error creating verification link"))
+		return
+	}
+
+	verification, err := cfg.DB.UpsertEmailVerification(r.Context(), database.UpsertEmailVerificationParams{
+		UserID:    user.ID,
+		TokenHash: hashEmailToken(token),
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().UTC().Add(cfg.EmailVerificationTTL), Valid: true},
+	})
+	if err != nil {
+		respondWithDBError(w, err, "Error saving email verification")
+		return
+	}
+
+	err = cfg.Mailer.Send(r.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    "Hi " + user.Username + ",\n\nOpen this link to verify your email address:\n" + link + "\n\nIt expires in " + cfg.EmailVerificationTTL.String() + ". If you didn't ask for this, ignore this email.",
+	})
+	if err != nil {
+		log.Printf("Failed to send email verification for user %s: %v", user.ID, err)
+		RespondWithJSON(w, http.StatusBadGateway, models.NewErrorResponse("Could not send the verification email"))
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(models.PendingEmailVerification{
+		Email:     user.Email,
+		ExpiresAt: verification.ExpiresAt.Time,
+	}))
+}
+
+// VerifyEmailHandler marks the email a verification link was sent to as
+// verified. Like ConfirmEmailChangeHandler it doesn't need a session.
+func (cfg *APIConfig) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.VerifyEmailRequest
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+	if req.Token == "" {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Token is required"))
+		return
+	}
+
+	user, err := cfg.DB.VerifyEmail(r.Context(), hashEmailToken(req.Token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid or expired verification token"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Error verifying email")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(cfg.userModel(r, user)))
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/mail"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// recordingMailer keeps every message it is asked to send
+type recordingMailer struct {
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// emailChangeDB holds one user and their pending email change, applying it like ConfirmEmailChange
+func emailChangeDB(user *database.User, pending **database.EmailChange) *mockDB {
+	return &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			return *user, nil
+		},
+		getUserByEmail: func(ctx context.Context, email string) (database.User, error) {
+			if email == user.Email {
+				return *user, nil
+			}
+			return database.User{}, pgx.ErrNoRows
+		},
+		upsertEmailChange: func(ctx context.Context, arg database.UpsertEmailChangeParams) (database.EmailChange, error) {
+			*pending = &database.EmailChange{UserID: arg.UserID, NewEmail: arg.NewEmail, TokenHash: arg.TokenHash, ExpiresAt: arg.ExpiresAt}
+			return **pending, nil
+		},
+		confirmEmailChange: func(ctx context.Context, tokenHash string) (database.User, error) {
+			change := *pending
+			if change == nil || change.TokenHash != tokenHash {
+				return database.User{}, pgx.ErrNoRows
+			}
+			*pending = nil
+			if !change.ExpiresAt.Time.After(time.Now()) {
+				return database.User{}, pgx.ErrNoRows
+			}
+			user.Email = change.NewEmail
+			return *user, nil
+		},
+	}
+}
+
+func TestEmailChangeAppliesOnlyAfterConfirmation(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "old@example.com", Username: "tester"}
+	var pending *database.EmailChange
+	mailer := &recordingMailer{}
+	apiCfg := NewAPIConfig(emailChangeDB(&user, &pending), newMockStorage())
+	apiCfg.Mailer = mailer
+	apiCfg.EmailChangeURL = "https://tot.example/confirm-email"
+
+	w := httptest.NewRecorder()
+	apiCfg.RequestEmailChangeHandler(w, withClaims(httptest.NewRequest("POST", "/v1/users/"+user.ID.String()+"/email-change", strings.NewReader(`{"email":"new@example.com"}`)), user.ID))
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"email":"new@example.com"`) {
+		t.Errorf("Expected the pending email in the response, got %s", w.Body.String())
+	}
+
+	// Pending: the old email still applies and only a hash of the token is stored
+	if user.Email != "old@example.com" {
+		t.Fatalf("Expected the email unchanged before confirmation, got %s", user.Email)
+	}
+	if pending == nil || pending.NewEmail != "new@example.com" || !pending.ExpiresAt.Valid {
+		t.Fatalf("Expected a pending change to new@example.com, got %+v", pending)
+	}
+	if len(mailer.sent) != 2 || mailer.sent[0].To != "new@example.com" || mailer.sent[1].To != "old@example.com" {
+		t.Fatalf("Expected a confirmation to the new address and a notice to the old one, got %+v", mailer.sent)
+	}
+
+	// The token travels in the link sent to the new address
+	var token string
+	for _, field := range strings.Fields(mailer.sent[0].Body) {
+		if link, err := url.Parse(field); err == nil && strings.HasPrefix(field, apiCfg.EmailChangeURL) {
+			token = link.Query().Get("token")
+		}
+	}
+	if token == "" {
+		t.Fatalf("Expected a confirmation link in %q", mailer.sent[0].Body)
+	}
+	if pending.TokenHash == token || strings.Contains(mailer.sent[1].Body, token) {
+		t.Error("Expected the token to stay out of the database and the notice")
+	}
+
+	confirm := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		apiCfg.ConfirmEmailChangeHandler(w, httptest.NewRequest("POST", "/v1/email-change/confirm", strings.NewReader(`{"token":"`+token+`"}`)))
+		return w
+	}
+
+	if w := confirm("wrong-token"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a wrong token, got %d", w.Code)
+	}
+	if user.Email != "old@example.com" {
+		t.Fatalf("Expected a wrong token to leave the email alone, got %s", user.Email)
+	}
+
+	if w := confirm(token); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if user.Email != "new@example.com" {
+		t.Errorf("Expected the email changed after confirmation, got %s", user.Email)
+	}
+	if w := confirm(token); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a used token to be rejected, got %d", w.Code)
+	}
+}
+
+func TestRequestEmailChangeValidation(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "old@example.com", Username: "tester"}
+	taken := database.User{ID: uuid.New(), Email: "taken@example.com"}
+	var pending *database.EmailChange
+	db := emailChangeDB(&user, &pending)
+	db.getUserByEmail = func(ctx context.Context, email string) (database.User, error) {
+		if email == taken.Email {
+			return taken, nil
+		}
+		return database.User{}, pgx.ErrNoRows
+	}
+
+	tests := []struct {
+		name           string
+		mailer         mail.Sender
+		userID         uuid.UUID
+		body           string
+		expectedStatus int
+	}{
+		{name: "not_configured", userID: user.ID, body: `{"email":"new@example.com"}`, expectedStatus: http.StatusServiceUnavailable},
+		{name: "other_user", mailer: &recordingMailer{}, userID: uuid.New(), body: `{"email":"new@example.com"}`, expectedStatus: http.StatusForbidden},
+		{name: "invalid_email", mailer: &recordingMailer{}, userID: user.ID, body: `{"email":"not-an-email"}`, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "same_email", mailer: &recordingMailer{}, userID: user.ID, body: `{"email":"old@example.com"}`, expectedStatus: http.StatusBadRequest},
+		{name: "taken_email", mailer: &recordingMailer{}, userID: user.ID, body: `{"email":"taken@example.com"}`, expectedStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiCfg := NewAPIConfig(db, newMockStorage())
+			apiCfg.Mailer = tt.mailer
+			apiCfg.EmailChangeURL = "https://tot.example/confirm-email"
+
+			req := withClaims(httptest.NewRequest("POST", "/v1/users/"+user.ID.String()+"/email-change", strings.NewReader(tt.body)), tt.userID)
+			req = withURLParams(req, map[string]string{"id": user.ID.String()})
+			w := httptest.NewRecorder()
+			apiCfg.RequestEmailChangeHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if pending != nil {
+				t.Errorf("Expected no pending change, got %+v", pending)
+			}
+		})
+	}
+}
+
+// verificationDB holds one user and their pending verification, applying it like VerifyEmail
+func verificationDB(user *database.User, pending **database.EmailVerification) *mockDB {
+	return &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			if id != user.ID {
+				return database.User{}, pgx.ErrNoRows
+			}
+			return *user, nil
+		},
+		upsertEmailVerification: func(ctx context.Context, arg database.UpsertEmailVerificationParams) (database.EmailVerification, error) {
+			*pending = &database.EmailVerification{UserID: arg.UserID, TokenHash: arg.TokenHash, ExpiresAt: arg.ExpiresAt}
+			return **pending, nil
+		},
+		verifyEmail: func(ctx context.Context, tokenHash string) (database.User, error) {
+			verification := *pending
+			if verification == nil || verification.TokenHash != tokenHash {
+				return database.User{}, pgx.ErrNoRows
+			}
+			*pending = nil
+			user.EmailVerifiedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+			return *user, nil
+		},
+	}
+}
+
+func TestResendVerificationAlreadyVerified(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "player@example.com", Username: "tester", EmailVerifiedAt: pgtype.Timestamp{Time: time.Now(), Valid: true}}
+	var pending *database.EmailVerification
+	mailer := &recordingMailer{}
+	apiCfg := NewAPIConfig(verificationDB(&user, &pending), newMockStorage())
+	apiCfg.Mailer = mailer
+	apiCfg.VerifyEmailURL = "https://tot.example/verify-email"
+
+	w := httptest.NewRecorder()
+	apiCfg.ResendVerificationHandler(w, withClaims(httptest.NewRequest("POST", "/v1/resend-verification", nil), user.ID))
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if pending != nil || len(mailer.sent) != 0 {
+		t.Errorf("Expected no new token and no email, got %+v and %+v", pending, mailer.sent)
+	}
+}
+
+func TestResendVerificationUnverified(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "player@example.com", Username: "tester"}
+	var pending *database.EmailVerification
+	mailer := &recordingMailer{}
+	apiCfg := NewAPIConfig(verificationDB(&user, &pending), newMockStorage())
+	apiCfg.Mailer = mailer
+	apiCfg.VerifyEmailURL = "https://tot.example/verify-email"
+
+	resend := func() string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		apiCfg.ResendVerificationHandler(w, withClaims(httptest.NewRequest("POST", "/v1/resend-verification", nil), user.ID))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		msg := mailer.sent[len(mailer.sent)-1]
+		if msg.To != user.Email {
+			t.Fatalf("Expected the link sent to %s, got %s", user.Email, msg.To)
+		}
+		for _, field := range strings.Fields(msg.Body) {
+			if link, err := url.Parse(field); err == nil && strings.HasPrefix(field, apiCfg.VerifyEmailURL) {
+				return link.Query().Get("token")
+			}
+		}
+		t.Fatalf("Expected a verification link in %q", msg.Body)
+		return ""
+	}
+
+	// Resending regenerates the token, so the first link stops working
+	first := resend()
+	second := resend()
+	if first == second || pending == nil || pending.TokenHash == second || !pending.ExpiresAt.Valid {
+		t.Fatalf("Expected a fresh token stored only as a hash, got %q then %q with %+v", first, second, pending)
+	}
+
+	verify := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		apiCfg.VerifyEmailHandler(w, httptest.NewRequest("POST", "/v1/verify-email", strings.NewReader(`{"token":"`+token+`"}`)))
+		return w
+	}
+
+	if w := verify(first); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for the replaced token, got %d", w.Code)
+	}
+	w := verify(second)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"email_verified":true`) {
+		t.Errorf("Expected the user marked verified, got %s", w.Body.String())
+	}
+
+	// Now there is nothing left to resend
+	w = httptest.NewRecorder()
+	apiCfg.ResendVerificationHandler(w, withClaims(httptest.NewRequest("POST", "/v1/resend-verification", nil), user.ID))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 once verified, got %d", w.Code)
+	}
+}
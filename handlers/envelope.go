@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/froggu-tantei/ToT/models"
+)
+
+// EnvelopeHeader lets a client turn off the {success, data} envelope
+const EnvelopeHeader = "X-Response-Envelope"
+
+// Pagination headers sent in place of the pagination object when a paginated
+// response goes out without its envelope
+const (
+	TotalCountHeader = "X-Total-Count"
+	PageHeader       = "X-Page"
+	PerPageHeader    = "X-Per-Page"
+	LastPageHeader   = "X-Last-Page"
+)
+
+// Summary headers sent in place of a list page's summary object, likewise
+const (
+	SignupsLast24hHeader  = "X-Signups-Last-24h"
+	TotalLastPlacesHeader = "X-Total-Last-Places"
+)
+
+// bareWriter marks a response whose success payload RespondWithJSON sends
+// without its envelope
+type bareWriter struct {
+	http.ResponseWriter
+}
+
+// Flush lets streaming handlers flush through the wrapper
+func (b bareWriter) Flush() {
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (b bareWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// Envelope lets GET requests opt out of the response envelope with an
+// X-Response-Envelope: false header or ?envelope=false. The envelope stays the
+// default, and errors always keep it so clients can still tell them apart.
+func Envelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && !wantsEnvelope(r) {
+			w = bareWriter{w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wantsEnvelope reports whether a request kept the envelope, the header
+// taking precedence over the query parameter
+func wantsEnvelope(r *http.Request) bool {
+	value := r.Header.Get(EnvelopeHeader)
+	if value == "" {
+		value = r.URL.Query().Get("envelope")
+	}
+	enveloped, err := strconv.ParseBool(value)
+	return err != nil || enveloped
+}
+
+// isBare reports whether w, or a writer it wraps, is a bareWriter
+func isBare(w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(bareWriter); ok {
+			return true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// unwrapEnvelope returns the payload to send: the bare data of a success
+// response when the request opted out of the envelope, the payload otherwise.
+// A paginated response's pagination, and summary when asked for, move into
+// headers.
+func unwrapEnvelope(w http.ResponseWriter, payload any) any {
+	if !isBare(w) {
+		return payload
+	}
+	switch resp := payload.(type) {
+	case models.SuccessResponse:
+		return resp.Data
+	case models.PaginatedResponse:
+		w.Header().Set(TotalCountHeader, strconv.FormatInt(resp.Pagination.Total, 10))
+		w.Header().Set(PageHeader, strconv.Itoa(resp.Pagination.CurrentPage))
+		w.Header().Set(PerPageHeader, strconv.Itoa(resp.Pagination.PerPage))
+		w.Header().Set(LastPageHeader, strconv.FormatInt(resp.Pagination.LastPage, 10))
+		if resp.Summary != nil {
+			w.Header().Set(SignupsLast24hHeader, strconv.FormatInt(resp.Summary.SignupsLast24h, 10))
+			w.Header().Set(TotalLastPlacesHeader, strconv.FormatInt(resp.Summary.TotalLastPlaces, 10))
+		}
+		return resp.Data
+	}
+	return payload
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestEnvelopeStats(t *testing.T) {
+	queries := 0
+	apiCfg := NewAPIConfig(statsDB(&queries), newMockStorage())
+	handler := Envelope(http.HandlerFunc(apiCfg.GetStatsHandler))
+
+	tests := []struct {
+		name      string
+		target    string
+		header    string
+		enveloped bool
+	}{
+		{name: "default", target: "/v1/stats", enveloped: true},
+		{name: "header_false", target: "/v1/stats", header: "false", enveloped: false},
+		{name: "query_false", target: "/v1/stats?envelope=false", enveloped: false},
+		{name: "header_overrides_query", target: "/v1/stats?envelope=false", header: "true", enveloped: true},
+		{name: "unparseable", target: "/v1/stats?envelope=nope", enveloped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set(EnvelopeHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			var stats models.Stats
+			if tt.enveloped {
+				var resp struct {
+					Success bool         `json:"success"`
+					Data    models.Stats `json:"data"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				if !resp.Success {
+					t.Error("Expected success in the envelope")
+				}
+				stats = resp.Data
+			} else {
+				var raw map[string]any
+				if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				if _, ok := raw["success"]; ok {
+					t.Errorf("Expected a bare object, got %s", w.Body.String())
+				}
+				json.Unmarshal(w.Body.Bytes(), &stats)
+			}
+			if stats.TotalUsers != 42 || stats.TotalGames != 17 {
+				t.Errorf("Unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestEnvelopePaginatedMovesToHeaders(t *testing.T) {
+	db := leaderboardDB(25)
+	db.countLeaderBoard = func(ctx context.Context, minGames int32) (int64, error) {
+		return 25, nil
+	}
+	db.getLeaderBoardModified = func(ctx context.Context) (pgtype.Timestamp, error) {
+		return pgtype.Timestamp{Time: time.Now(), Valid: true}, nil
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+
+	w := httptest.NewRecorder()
+	Envelope(http.HandlerFunc(apiCfg.GetLeaderboardHandler)).ServeHTTP(w, httptest.NewRequest("GET", "/v1/leaderboard?page=2&envelope=false", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var entries []models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Expected a bare array, got %s", w.Body.String())
+	}
+	if len(entries) != 10 {
+		t.Errorf("Expected 10 entries, got %d", len(entries))
+	}
+	expected := map[string]string{TotalCountHeader: "25", PageHeader: "2", PerPageHeader: "10", LastPageHeader: "3"}
+	for header, value := range expected {
+		if got := w.Header().Get(header); got != value {
+			t.Errorf("Expected %s %q, got %q", header, value, got)
+		}
+	}
+}
+
+func TestEnvelopeSummaryMovesToHeaders(t *testing.T) {
+	response := models.NewPaginatedResponse([]models.User{}, 0, 10, 1)
+
+	w := httptest.NewRecorder()
+	RespondWithJSON(bareWriter{w}, http.StatusOK, response)
+	for _, header := range []string{SignupsLast24hHeader, TotalLastPlacesHeader} {
+		if got := w.Header().Get(header); got != "" {
+			t.Errorf("Expected no %s without a summary, got %q", header, got)
+		}
+	}
+
+	response.Summary = &models.Summary{SignupsLast24h: 3, TotalLastPlaces: 42}
+	w = httptest.NewRecorder()
+	RespondWithJSON(bareWriter{w}, http.StatusOK, response)
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("Expected a bare array, got %s", body)
+	}
+	expected := map[string]string{SignupsLast24hHeader: "3", TotalLastPlacesHeader: "42"}
+	for header, value := range expected {
+		if got := w.Header().Get(header); got != value {
+			t.Errorf("Expected %s %q, got %q", header, value, got)
+		}
+	}
+}
+
+func TestEnvelopeKeptForErrorsAndWrites(t *testing.T) {
+	handler := Envelope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
+			return
+		}
+		RespondWithJSON(w, http.StatusCreated, models.NewSuccessResponse(map[string]string{"id": "1"}))
+	}))
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req := httptest.NewRequest(method, "/?envelope=false", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var raw map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", method, err)
+		}
+		if _, ok := raw["success"]; !ok {
+			t.Errorf("%s: expected the envelope to stay, got %s", method, w.Body.String())
+		}
+	}
+}
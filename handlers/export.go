@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/google/uuid"
+)
+
+// MaxLeaderboardExport caps how many entries one export returns
+const MaxLeaderboardExport = 10000
+
+// leaderboardExportBatch is how many rows are fetched per query while streaming
+const leaderboardExportBatch = 500
+
+// Supported leaderboard export formats
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatJSON = "json"
+)
+
+// leaderboardWriter writes export entries in one format
+type leaderboardWriter interface {
+	write(entry models.LeaderboardEntry) error
+	close() error
+}
+
+// ExportLeaderboardHandler streams the whole leaderboard, up to
+// MaxLeaderboardExport entries, as CSV (the default) or JSON
+func (cfg *APIConfig) ExportLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+	if format != ExportFormatCSV && format != ExportFormatJSON {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid format, must be csv or json"))
+		return
+	}
+
+	// Fetch the first batch before writing anything, so a failing query still gets a proper error
+	minGames := cfg.leaderboardMinGames(r)
+	rows, err := cfg.DB.GetLeaderBoard(r.Context(), database.GetLeaderBoardParams{
+		MinGames: minGames,
+		Limit:    leaderboardExportBatch,
+		Offset:   0,
+	})
+	if err != nil {
+		respondWithDBError(w, err, "Error fetching leaderboard")
+		return
+	}
+
+	var out leaderboardWriter
+	if format == ExportFormatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="leaderboard.csv"`)
+		out = newCSVLeaderboardWriter(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="leaderboard.json"`)
+		out = newJSONLeaderboardWriter(w)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	rank := 0
+	for {
+		for _, row := range rows {
+			if rank == MaxLeaderboardExport {
+				break
+			}
+			rank++
+			if err := out.write(models.LeaderboardEntry{
+				Rank:           rank,
+				Username:       row.Username,
+				LastPlaceCount: int(row.LastPlaceCount),
+			}); err != nil {
+				log.Printf("Leaderboard export aborted: %v", err)
+				return
+			}
+		}
+		if len(rows) < leaderboardExportBatch || rank == MaxLeaderboardExport {
+			break
+		}
+
+		// Push what we have to the client before the next query
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		rows, err = cfg.DB.GetLeaderBoard(r.Context(), database.GetLeaderBoardParams{
+			MinGames: minGames,
+			Limit:    leaderboardExportBatch,
+			Offset:   int32(rank),
+		})
+		if err != nil {
+			// The status is already sent, all we can do is stop early
+			log.Printf("Leaderboard export truncated at %d entries: %v", rank, err)
+			return
+		}
+	}
+
+	if err := out.close(); err != nil {
+		log.Printf("Leaderboard export aborted: %v", err)
+	}
+}
+
+// csvLeaderboardWriter writes entries as CSV rows after a header row
+type csvLeaderboardWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVLeaderboardWriter(w http.ResponseWriter) *csvLeaderboardWriter {
+	return &csvLeaderboardWriter{w: csv.NewWriter(w)}
+}
+
+// writeHeader writes the header row once
+func (c *csvLeaderboardWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write([]string{"rank", "username", "last_place_count"})
+}
+
+func (c *csvLeaderboardWriter) write(entry models.LeaderboardEntry) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.w.Write([]string{
+		strconv.Itoa(entry.Rank),
+		entry.Username,
+		strconv.Itoa(entry.LastPlaceCount),
+	})
+}
+
+func (c *csvLeaderboardWriter) close() error {
+	// An empty leaderboard still gets its header row
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonLeaderboardWriter writes entries as the data array of a success
+// response, one element at a time
+type jsonLeaderboardWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func newJSONLeaderboardWriter(w http.ResponseWriter) *jsonLeaderboardWriter {
+	return &jsonLeaderboardWriter{w: w}
+}
+
+func (j *jsonLeaderboardWriter) write(entry models.LeaderboardEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	prefix := ","
+	if !j.started {
+		j.started = true
+		prefix = `{"success":true,"data":[`
+	}
+	_, err = j.w.Write(append([]byte(prefix), data...))
+	return err
+}
+
+func (j *jsonLeaderboardWriter) close() error {
+	if !j.started {
+		j.started = true
+		_, err := j.w.Write([]byte(`{"success":true,"data":[]}`))
+		return err
+	}
+	_, err := j.w.Write([]byte("]}"))
+	return err
+}
+
+// userExportBatch is how many users are fetched per query while streaming
+const userExportBatch = 500
+
+// ExportFormatNDJSON is one JSON object per line, the user export's default
+const ExportFormatNDJSON = "ndjson"
+
+// userWriter writes user export rows in one format
+type userWriter interface {
+	write(user models.UserExport) error
+	flush() error
+}
+
+// ExportUsersHandler streams every user, soft-deleted ones included, as
+// NDJSON (the default) or CSV. Users are read in id order one batch at a
+// time and flushed after each, so the whole table is never held in memory.
+// A client that goes away cancels the export.
+func (cfg *APIConfig) ExportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportFormatNDJSON
+	}
+	if format != ExportFormatNDJSON && format != ExportFormatCSV {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid format, must be ndjson or csv"))
+		return
+	}
+
+	// Fetch the first batch before writing anything, so a failing query still gets a proper error
+	rows, err := cfg.DB.ListUsersForExport(r.Context(), database.ListUsersForExportParams{
+		AfterID: uuid.Nil,
+		Limit:   userExportBatch,
+	})
+	if err != nil {
+		respondWithDBError(w, err, "Error fetching users")
+		return
+	}
+
+	var out userWriter
+	if format == ExportFormatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		out = newCSVUserWriter(w)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.ndjson"`)
+		out = newNDJSONUserWriter(w)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	exported := 0
+	for {
+		for _, row := range rows {
+			if err := out.write(exportedUser(row)); err != nil {
+				log.Printf("User export aborted: %v", err)
+				return
+			}
+		}
+		exported += len(rows)
+		if len(rows) < userExportBatch {
+			break
+		}
+
+		// Push what we have to the client before the next query
+		if err := out.flush(); err != nil {
+			log.Printf("User export aborted: %v", err)
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		rows, err = cfg.DB.ListUsersForExport(r.Context(), database.ListUsersForExportParams{
+			AfterID: rows[len(rows)-1].ID,
+			Limit:   userExportBatch,
+		})
+		if err != nil {
+			// The status is already sent, all we can do is stop early
+			log.Printf("User export truncated at %d users: %v", exported, err)
+			return
+		}
+	}
+
+	if err := out.flush(); err != nil {
+		log.Printf("User export aborted: %v", err)
+	}
+}
+
+// exportedUser converts an export row to its API model
+func exportedUser(row database.ListUsersForExportRow) models.UserExport {
+	user := models.UserExport{
+		ID:             row.ID,
+		Username:       row.Username,
+		Email:          row.Email,
+		Role:           row.Role,
+		LastPlaceCount: int(row.LastPlaceCount),
+		ProfilePicture: row.ProfilePicture.String,
+		Bio:            row.Bio.String,
+		CreatedAt:      row.CreatedAt.Time,
+		UpdatedAt:      row.UpdatedAt.Time,
+	}
+	if row.DeletedAt.Valid {
+		user.DeletedAt = &row.DeletedAt.Time
+	}
+	return user
+}
+
+// csvUserWriter writes users as CSV rows under a header row
+type csvUserWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVUserWriter(w http.ResponseWriter) *csvUserWriter {
+	return &csvUserWriter{w: csv.NewWriter(w)}
+}
+
+// writeHeader writes the header row once
+func (c *csvUserWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write([]string{"id", "username", "email", "role", "last_place_count", "profile_picture", "bio", "created_at", "updated_at", "deleted_at"})
+}
+
+func (c *csvUserWriter) write(user models.UserExport) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	deletedAt := ""
+	if user.DeletedAt != nil {
+		deletedAt = user.DeletedAt.Format(time.RFC3339)
+	}
+	return c.w.Write([]string{
+		user.ID.String(),
+		user.Username,
+		user.Email,
+		user.Role,
+		strconv.Itoa(user.LastPlaceCount),
+		user.ProfilePicture,
+		user.Bio,
+		user.CreatedAt.Format(time.RFC3339),
+		user.UpdatedAt.Format(time.RFC3339),
+		deletedAt,
+	})
+}
+
+func (c *csvUserWriter) flush() error {
+	// An empty export still gets its header row
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonUserWriter writes one JSON object per line
+type ndjsonUserWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONUserWriter(w http.ResponseWriter) *ndjsonUserWriter {
+	return &ndjsonUserWriter{enc: json.NewEncoder(w)}
+}
+
+func (n *ndjsonUserWriter) write(user models.UserExport) error {
+	return n.enc.Encode(user) // Encode ends each object with a newline
+}
+
+// flush has nothing to do, the encoder writes straight through
+func (n *ndjsonUserWriter) flush() error {
+	return nil
+}
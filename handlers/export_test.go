@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// leaderboardDB serves n leaderboard rows with descending counts, paged like the real query
+func leaderboardDB(n int) *mockDB {
+	return &mockDB{
+		getLeaderBoard: func(ctx context.Context, arg database.GetLeaderBoardParams) ([]database.GetLeaderBoardRow, error) {
+			rows := []database.GetLeaderBoardRow{}
+			for i := int(arg.Offset); i < n && i < int(arg.Offset+arg.Limit); i++ {
+				rows = append(rows, database.GetLeaderBoardRow{
+					ID:             uuid.New(),
+					Username:       fmt.Sprintf("player%d", i+1),
+					LastPlaceCount: int32(n - i),
+				})
+			}
+			return rows, nil
+		},
+	}
+}
+
+func TestExportLeaderboardHandlerCSV(t *testing.T) {
+	cfg := &APIConfig{DB: leaderboardDB(leaderboardExportBatch + 2)}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/leaderboard/export?format=csv", nil)
+	w := httptest.NewRecorder()
+	cfg.ExportLeaderboardHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Expected CSV content type, got %q", ct)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(records) != leaderboardExportBatch+3 {
+		t.Fatalf("Expected header plus %d rows, got %d records", leaderboardExportBatch+2, len(records))
+	}
+
+	expected := map[int][]string{
+		0:                          {"rank", "username", "last_place_count"},
+		1:                          {"1", "player1", fmt.Sprint(leaderboardExportBatch + 2)},
+		leaderboardExportBatch + 2: {fmt.Sprint(leaderboardExportBatch + 2), fmt.Sprintf("player%d", leaderboardExportBatch+2), "1"},
+	}
+	for i, want := range expected {
+		if fmt.Sprint(records[i]) != fmt.Sprint(want) {
+			t.Errorf("Record %d: expected %v, got %v", i, want, records[i])
+		}
+	}
+}
+
+func TestExportLeaderboardHandlerJSON(t *testing.T) {
+	cfg := &APIConfig{DB: leaderboardDB(2)}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/leaderboard/export?format=json", nil)
+	w := httptest.NewRecorder()
+	cfg.ExportLeaderboardHandler(w, req)
+
+	var resp struct {
+		Success bool                      `json:"success"`
+		Data    []models.LeaderboardEntry `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.Success || len(resp.Data) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", resp)
+	}
+	if resp.Data[1] != (models.LeaderboardEntry{Rank: 2, Username: "player2", LastPlaceCount: 1}) {
+		t.Errorf("Unexpected second entry %+v", resp.Data[1])
+	}
+}
+
+func TestExportLeaderboardHandlerEmpty(t *testing.T) {
+	cfg := &APIConfig{DB: leaderboardDB(0)}
+
+	for format, expected := range map[string]string{
+		"csv":  "rank,username,last_place_count\n",
+		"json": `{"success":true,"data":[]}`,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/leaderboard/export?format="+format, nil)
+		w := httptest.NewRecorder()
+		cfg.ExportLeaderboardHandler(w, req)
+
+		if w.Body.String() != expected {
+			t.Errorf("%s: expected %q, got %q", format, expected, w.Body.String())
+		}
+	}
+}
+
+func TestExportLeaderboardHandlerInvalidFormat(t *testing.T) {
+	cfg := &APIConfig{DB: leaderboardDB(1)}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/leaderboard/export?format=xml", nil)
+	w := httptest.NewRecorder()
+	cfg.ExportLeaderboardHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// exportDB seeds n users with password hashes and serves them like
+// ListUsersForExport: after the cursor, in id order, without the hash
+func exportDB(n int) (*mockDB, *[]database.ListUsersForExportParams) {
+	users := make([]database.User, n)
+	for i := range users {
+		users[i] = database.User{
+			ID:           uuid.New(),
+			Username:     fmt.Sprintf("user%d", i+1),
+			Email:        fmt.Sprintf("user%d@example.com", i+1),
+			PasswordHash: "$2a$10$secret-hash-" + strconv.Itoa(i),
+			Role:         models.RoleUser,
+		}
+	}
+	users[0].DeletedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	slices.SortFunc(users, func(a, b database.User) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+
+	var calls []database.ListUsersForExportParams
+	return &mockDB{
+		listUsersForExport: func(ctx context.Context, arg database.ListUsersForExportParams) ([]database.ListUsersForExportRow, error) {
+			calls = append(calls, arg)
+			rows := []database.ListUsersForExportRow{}
+			for _, u := range users {
+				if bytes.Compare(u.ID[:], arg.AfterID[:]) <= 0 || len(rows) == int(arg.Limit) {
+					continue
+				}
+				rows = append(rows, database.ListUsersForExportRow{
+					ID:             u.ID,
+					Email:          u.Email,
+					Username:       u.Username,
+					Role:           u.Role,
+					LastPlaceCount: u.LastPlaceCount,
+					DeletedAt:      u.DeletedAt,
+				})
+			}
+			return rows, nil
+		},
+	}, &calls
+}
+
+func TestExportUsersHandlerNDJSON(t *testing.T) {
+	const total = userExportBatch + 3
+	db, calls := exportDB(total)
+	cfg := &APIConfig{DB: db}
+
+	w := httptest.NewRecorder()
+	cfg.ExportUsersHandler(w, httptest.NewRequest(http.MethodGet, "/v1/admin/users/export", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON content type, got %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "users.ndjson") {
+		t.Errorf("Expected an attachment named users.ndjson, got %q", cd)
+	}
+	if strings.Contains(w.Body.String(), "secret-hash") || strings.Contains(w.Body.String(), "password") {
+		t.Fatal("Password hash leaked into the export")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) != total {
+		t.Fatalf("Expected %d lines, got %d", total, len(lines))
+	}
+	seen := make(map[uuid.UUID]bool)
+	deleted := 0
+	for _, line := range lines {
+		var user models.UserExport
+		if err := json.Unmarshal([]byte(line), &user); err != nil {
+			t.Fatalf("Invalid NDJSON line %q: %v", line, err)
+		}
+		seen[user.ID] = true
+		if user.DeletedAt != nil {
+			deleted++
+		}
+	}
+	if len(seen) != total {
+		t.Errorf("Expected %d distinct users, got %d", total, len(seen))
+	}
+	if deleted != 1 {
+		t.Errorf("Expected the soft-deleted user to be exported with deleted_at, got %d", deleted)
+	}
+
+	// Two batches, the second continuing after the last id of the first
+	if len(*calls) != 2 || (*calls)[0].AfterID != uuid.Nil {
+		t.Fatalf("Expected 2 queries starting from the nil UUID, got %+v", *calls)
+	}
+}
+
+func TestExportUsersHandlerCSV(t *testing.T) {
+	const total = 3
+	db, _ := exportDB(total)
+	cfg := &APIConfig{DB: db}
+
+	w := httptest.NewRecorder()
+	cfg.ExportUsersHandler(w, httptest.NewRequest(http.MethodGet, "/v1/admin/users/export?format=csv", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "secret-hash") {
+		t.Fatal("Password hash leaked into the export")
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	if len(records) != total+1 {
+		t.Fatalf("Expected a header and %d rows, got %d records", total, len(records))
+	}
+	if slices.Contains(records[0], "password_hash") || records[0][0] != "id" {
+		t.Errorf("Unexpected header %v", records[0])
+	}
+}
+
+func TestExportUsersHandlerInvalidFormat(t *testing.T) {
+	db, calls := exportDB(1)
+	cfg := &APIConfig{DB: db}
+
+	w := httptest.NewRecorder()
+	cfg.ExportUsersHandler(w, httptest.NewRequest(http.MethodGet, "/v1/admin/users/export?format=xml", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("Expected no queries for an invalid format, got %d", len(*calls))
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/froggu-tantei/ToT/models"
+)
+
+// userFields are the models.User JSON fields clients may pick with ?fields=
+var userFields = map[string]bool{
+	"id":               true,
+	"username":         true,
+	"email":            true,
+	"created_at":       true,
+	"updated_at":       true,
+	"last_place_count": true,
+	"profile_picture":  true,
+	"bio":              true,
+	"email_verified":   true,
+}
+
+// parseUserFields reads the comma-separated fields query parameter. Nil means
+// the client didn't ask for a subset and gets every field.
+func parseUserFields(r *http.Request) ([]string, error) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !userFields[field] {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields selected")
+	}
+	return fields, nil
+}
+
+// selectUserFields serializes a user and keeps only the requested fields.
+// Omitted optional fields stay omitted rather than showing up as null.
+func selectUserFields(user models.User, fields []string) (any, error) {
+	if fields == nil {
+		return user, nil
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected, nil
+}
+
+// selectUsersFields applies selectUserFields to every user
+func selectUsersFields(users []models.User, fields []string) (any, error) {
+	if fields == nil {
+		return users, nil
+	}
+
+	selected := make([]any, len(users))
+	for i, user := range users {
+		var err error
+		if selected[i], err = selectUserFields(user, fields); err != nil {
+			return nil, err
+		}
+	}
+	return selected, nil
+}
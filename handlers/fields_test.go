@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/google/uuid"
+)
+
+func TestGetUserByIDFieldSelection(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedFields []string
+	}{
+		{name: "all_fields_by_default", query: "", expectedStatus: http.StatusOK, expectedFields: []string{"created_at", "email", "email_verified", "id", "last_place_count", "updated_at", "username"}},
+		{name: "subset", query: "?fields=id,username,last_place_count", expectedStatus: http.StatusOK, expectedFields: []string{"id", "last_place_count", "username"}},
+		{name: "omitted_field_stays_omitted", query: "?fields=username,bio", expectedStatus: http.StatusOK, expectedFields: []string{"username"}},
+		{name: "unknown_field", query: "?fields=id,password_hash", expectedStatus: http.StatusBadRequest},
+		{name: "no_fields", query: "?fields=,", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := uuid.New()
+			db := &mockDB{
+				getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+					return database.User{ID: id, Username: "tester", Email: "tester@example.com", LastPlaceCount: 3}, nil
+				},
+			}
+			apiCfg := NewAPIConfig(db, newMockStorage())
+
+			req := withURLParams(httptest.NewRequest("GET", "/v1/users/"+userID.String()+tt.query, nil), map[string]string{"id": userID.String()})
+			w := httptest.NewRecorder()
+			apiCfg.GetUserByIDHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Data map[string]any `json:"data"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			var fields []string
+			for field := range resp.Data {
+				fields = append(fields, field)
+			}
+			slices.Sort(fields)
+			if !slices.Equal(fields, tt.expectedFields) {
+				t.Errorf("Expected fields %v, got %v", tt.expectedFields, fields)
+			}
+		})
+	}
+}
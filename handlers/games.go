@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	MaxGameParticipants = 16 // Caps the roster size of a single recorded game
+
+	foreignKeyViolation = "23503" // Postgres error code for a missing referenced row
+)
+
+// Policies for TIE_LAST_PLACE_POLICY, deciding whose last_place_count goes up
+// when several players share the final placement. A sole last place is always counted.
+const (
+	TieLastPlaceAll    = "all"    // Every tied player (default)
+	TieLastPlaceNone   = "none"   // Nobody, a shared last place isn't a loss
+	TieLastPlaceRandom = "random" // One of the tied players, picked at random
+)
+
+// ParseTieLastPlacePolicy converts a TIE_LAST_PLACE_POLICY value into a policy
+func ParseTieLastPlacePolicy(policy string) (string, error) {
+	switch p := strings.ToLower(strings.TrimSpace(policy)); p {
+	case "":
+		return TieLastPlaceAll, nil
+	case TieLastPlaceAll, TieLastPlaceNone, TieLastPlaceRandom:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown tie last place policy %q", policy)
+	}
+}
+
+// tieLastPlacePolicy returns the configured tie policy, defaulting to all
+func (cfg *APIConfig) tieLastPlacePolicy() string {
+	if cfg.TieLastPlacePolicy == "" {
+		return TieLastPlaceAll
+	}
+	return cfg.TieLastPlacePolicy
+}
+
+// gamePlacements returns the explicit placements when they cover every
+// participant, otherwise the default 1, 2, 3... ordering
+func gamePlacements(placements []int, count int) []int32 {
+	result := make([]int32, count)
+	for i := range result {
+		if len(placements) == count {
+			result[i] = int32(placements[i])
+		} else {
+			result[i] = int32(i + 1)
+		}
+	}
+	return result
+}
+
+// lastPlaceGroup returns the participants sharing the final placement
+func lastPlaceGroup(participants []uuid.UUID, placements []int32) []uuid.UUID {
+	if len(participants) == 0 {
+		return nil
+	}
+	last := placements[len(placements)-1]
+	i := len(placements) - 1
+	for i > 0 && placements[i-1] == last {
+		i--
+	}
+	return participants[i:]
+}
+
+// lastPlaceLosers applies the tie policy to the last place group
+func lastPlaceLosers(group []uuid.UUID, policy string) []uuid.UUID {
+	if len(group) <= 1 {
+		return group
+	}
+	switch policy {
+	case TieLastPlaceNone:
+		return nil
+	case TieLastPlaceRandom:
+		return []uuid.UUID{group[rand.IntN(len(group))]}
+	default:
+		return group
+	}
+}
+
+// validateGameRequest checks the ordered participant list, returning the parsed
+// IDs (first place to last place) or the field-level errors found.
+func validateGameRequest(req models.RecordGameRequest) ([]uuid.UUID, []models.FieldError) {
+	var fieldErrors []models.FieldError
+
+	if len(req.ParticipantIDs) == 0 {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "participant_ids", Message: "At least one participant is required"})
+	} else if len(req.ParticipantIDs) > MaxGameParticipants {
+		fieldErrors = append(fieldErrors, models.FieldError{
+			Field:   "participant_ids",
+			Message: fmt.Sprintf("A game cannot have more than %d participants", MaxGameParticipants),
+		})
+	}
+
+	// Parse participants, rejecting malformed and duplicate IDs
+	participants := make([]uuid.UUID, 0, len(req.ParticipantIDs))
+	seen := make(map[uuid.UUID]bool, len(req.ParticipantIDs))
+	for i, idStr := range req.ParticipantIDs {
+		field := fmt.Sprintf("participant_ids[%d]", i)
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: field, Message: "Invalid user ID format"})
+			continue
+		}
+		if seen[id] {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: field, Message: "Duplicate participant"})
+			continue
+		}
+		seen[id] = true
+		participants = append(participants, id)
+	}
+
+	// Explicit placements allow ties but must follow the participant order
+	if req.Placements != nil {
+		if len(req.Placements) != len(req.ParticipantIDs) {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "placements", Message: "Placements must have one entry per participant"})
+		} else {
+			for i, placement := range req.Placements {
+				field := fmt.Sprintf("placements[%d]", i)
+				if placement < 1 {
+					fieldErrors = append(fieldErrors, models.FieldError{Field: field, Message: "Placement must be at least 1"})
+				} else if i > 0 && placement < req.Placements[i-1] {
+					fieldErrors = append(fieldErrors, models.FieldError{Field: field, Message: "Placements must not decrease"})
+				}
+			}
+		}
+	}
+
+	// Last place is derived from the ordering; an explicit ID must agree with it
+	if req.LastPlaceID != "" {
+		if id, err := uuid.Parse(req.LastPlaceID); err != nil {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "last_place_id", Message: "Invalid user ID format"})
+		} else if !slices.Contains(lastPlaceGroup(participants, gamePlacements(req.Placements, len(participants))), id) {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "last_place_id", Message: "Last place must be a final participant"})
+		}
+	}
+
+	return participants, fieldErrors
+}
+
+// RecordGameHandler records the placements of a finished game. The caller
+// must be one of the participants.
+func (cfg *APIConfig) RecordGameHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req models.RecordGameRequest
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	// Validate before touching the database
+	participants, fieldErrors := validateGameRequest(req)
+	if len(fieldErrors) > 0 {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewValidationErrorResponse("Invalid game result", fieldErrors))
+		return
+	}
+
+	// Players record their own games, otherwise anyone could pin last places on others
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Unauthorized"))
+		return
+	}
+	if !slices.Contains(participants, claims.UserID) {
+		RespondWithJSON(w, http.StatusForbidden, models.NewErrorResponse("You can only record games you played in"))
+		return
+	}
+
+	// Ties for last are settled by the configured policy before anything is stored
+	positions := gamePlacements(req.Placements, len(participants))
+	losers := lastPlaceLosers(lastPlaceGroup(participants, positions), cfg.tieLastPlacePolicy())
+
+	// Store the game, every placement and the last place increments atomically
+	var (
+		game       database.Game
+		placements []database.GameParticipant
+		lastPlaces []database.User
+	)
+	err := cfg.DB.ExecTx(r.Context(), func(q database.Querier) error {
+		var err error
+		game, err = q.CreateGame(r.Context())
+		if err != nil {
+			return err
+		}
+
+		placements = make([]database.GameParticipant, len(participants))
+		for i, userID := range participants {
+			placements[i], err = q.CreateGameParticipant(r.Context(), database.CreateGameParticipantParams{
+				GameID:    game.ID,
+				UserID:    userID,
+				Placement: positions[i],
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, userID := range losers {
+			user, err := q.IncrementLastPlaceCount(r.Context(), userID)
+			if err != nil {
+				return err
+			}
+			lastPlaces = append(lastPlaces, user)
+		}
+
+		// Other instances drop their cached leaderboard once this commits
+		return q.NotifyLeaderboardChanged(r.Context())
+	})
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation) {
+		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("One or more participants not found"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Error recording game")
+		return
+	}
+	cfg.InvalidateLeaderboard()
+
+	// Return the recorded game and the updated last place users. last_place is
+	// the first of them, or null when a tie wasn't counted.
+	response := map[string]any{
+		"game":        models.DatabaseGameToGame(game, placements),
+		"last_place":  nil,
+		"last_places": models.DatabaseUsersToUsers(lastPlaces),
+	}
+	if len(lastPlaces) > 0 {
+		response["last_place"] = models.DatabaseUserToUser(lastPlaces[0])
+	}
+	RespondWithJSON(w, http.StatusCreated, models.NewSuccessResponse(response))
+}
+
+// tallyHeadToHead counts who finished ahead in each shared game (lower placement wins)
+func tallyHeadToHead(userID, otherUserID uuid.UUID, rows []database.ListHeadToHeadPlacementsRow) models.HeadToHead {
+	result := models.HeadToHead{
+		UserID:      userID,
+		OtherUserID: otherUserID,
+		SharedGames: len(rows),
+	}
+	for _, row := range rows {
+		switch {
+		case row.UserPlacement < row.OtherPlacement:
+			result.UserAhead++
+		case row.UserPlacement > row.OtherPlacement:
+			result.OtherAhead++
+		default:
+			result.Ties++
+		}
+	}
+	return result
+}
+
+// GetHeadToHeadHandler returns the head-to-head record between two users
+func (cfg *APIConfig) GetHeadToHeadHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract and parse both IDs from path
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid user ID format"))
+		return
+	}
+	otherUserID, err := uuid.Parse(chi.URLParam(r, "otherId"))
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid other user ID format"))
+		return
+	}
+
+	if !requireDifferentUser(w, userID, otherUserID, "Cannot compare a user with themselves") {
+		return
+	}
+
+	// Make sure both users exist so a typo isn't reported as "no shared games"
+	for _, id := range []uuid.UUID{userID, otherUserID} {
+		_, err := cfg.DB.GetUserByID(r.Context(), id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
+			return
+		} else if err != nil {
+			respondWithDBError(w, err, "Database error")
+			return
+		}
+	}
+
+	// Get both users' placements in every game they shared
+	rows, err := cfg.DB.ListHeadToHeadPlacements(r.Context(), database.ListHeadToHeadPlacementsParams{
+		UserID:      userID,
+		OtherUserID: otherUserID,
+	})
+	if err != nil {
+		respondWithDBError(w, err, "Error fetching head-to-head record")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(tallyHeadToHead(userID, otherUserID, rows)))
+}
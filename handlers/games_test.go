@@ -0,0 +1,649 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestValidateGameRequest(t *testing.T) {
+	alice := uuid.New().String()
+	bob := uuid.New().String()
+	carol := uuid.New().String()
+
+	oversized := make([]string, MaxGameParticipants+1)
+	for i := range oversized {
+		oversized[i] = uuid.New().String()
+	}
+
+	tests := []struct {
+		name           string
+		request        models.RecordGameRequest
+		expectedFields []string
+	}{
+		{
+			name: "valid_game",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, bob, carol},
+				LastPlaceID:    carol,
+			},
+			expectedFields: nil,
+		},
+		{
+			name: "valid_game_without_explicit_last_place",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, bob, carol},
+			},
+			expectedFields: nil,
+		},
+		{
+			name: "empty_roster",
+			request: models.RecordGameRequest{
+				LastPlaceID: alice,
+			},
+			expectedFields: []string{"participant_ids", "last_place_id"},
+		},
+		{
+			name: "duplicate_participant",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, bob, alice},
+				LastPlaceID:    bob,
+			},
+			expectedFields: []string{"participant_ids[2]"},
+		},
+		{
+			name: "invalid_participant_uuid",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, "not-a-uuid"},
+				LastPlaceID:    alice,
+			},
+			expectedFields: []string{"participant_ids[1]"},
+		},
+		{
+			name: "last_place_not_a_participant",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, bob},
+				LastPlaceID:    carol,
+			},
+			expectedFields: []string{"last_place_id"},
+		},
+		{
+			name: "last_place_not_final_participant",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, bob},
+				LastPlaceID:    alice,
+			},
+			expectedFields: []string{"last_place_id"},
+		},
+		{
+			name: "valid_tie_for_last",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, bob, carol},
+				Placements:     []int{1, 2, 2},
+				LastPlaceID:    bob,
+			},
+			expectedFields: nil,
+		},
+		{
+			name: "placements_length_mismatch",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, bob, carol},
+				Placements:     []int{1, 2},
+			},
+			expectedFields: []string{"placements"},
+		},
+		{
+			name: "placements_decreasing",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, bob, carol},
+				Placements:     []int{1, 3, 2},
+			},
+			expectedFields: []string{"placements[2]"},
+		},
+		{
+			name: "placement_below_one",
+			request: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, bob},
+				Placements:     []int{0, 1},
+			},
+			expectedFields: []string{"placements[0]"},
+		},
+		{
+			name: "oversized_roster",
+			request: models.RecordGameRequest{
+				ParticipantIDs: oversized,
+			},
+			expectedFields: []string{"participant_ids"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, fieldErrors := validateGameRequest(tt.request)
+
+			if len(fieldErrors) != len(tt.expectedFields) {
+				t.Fatalf("Expected %d field errors, got %d: %+v", len(tt.expectedFields), len(fieldErrors), fieldErrors)
+			}
+			for i, field := range tt.expectedFields {
+				if fieldErrors[i].Field != field {
+					t.Errorf("Expected error on field %q, got %q", field, fieldErrors[i].Field)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordGameHandlerValidation(t *testing.T) {
+	// DB is nil: invalid requests must be rejected before any query runs
+	apiCfg := &APIConfig{DB: nil}
+
+	alice := uuid.New().String()
+	bob := uuid.New().String()
+
+	tests := []struct {
+		name           string
+		requestBody    any
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "invalid_json",
+			requestBody:    "invalid json string",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid request format",
+		},
+		{
+			name: "duplicate_participants",
+			requestBody: models.RecordGameRequest{
+				ParticipantIDs: []string{alice, alice},
+				LastPlaceID:    alice,
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid game result",
+		},
+		{
+			name: "last_place_outside_roster",
+			requestBody: models.RecordGameRequest{
+				ParticipantIDs: []string{alice},
+				LastPlaceID:    bob,
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid game result",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body *bytes.Buffer
+			if str, ok := tt.requestBody.(string); ok {
+				body = bytes.NewBufferString(str)
+			} else {
+				jsonBody, _ := json.Marshal(tt.requestBody)
+				body = bytes.NewBuffer(jsonBody)
+			}
+
+			req := httptest.NewRequest("POST", "/v1/games", body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			apiCfg.RecordGameHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			var response models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+
+			if response.Error != tt.expectedError {
+				t.Errorf("Expected error %q, got %q", tt.expectedError, response.Error)
+			}
+		})
+	}
+}
+
+func TestRecordGameHandlerBodyTooLarge(t *testing.T) {
+	apiCfg := &APIConfig{DB: &mockDB{}}
+
+	ids := make([]string, MaxGameParticipants)
+	for i := range ids {
+		ids[i] = uuid.New().String()
+	}
+	jsonBody, _ := json.Marshal(models.RecordGameRequest{ParticipantIDs: ids})
+
+	// The global body limit leaves the handler a reader that fails partway through
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/games", nil)
+	req.Body = http.MaxBytesReader(w, io.NopCloser(bytes.NewReader(jsonBody)), 64)
+	apiCfg.RecordGameHandler(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d", w.Code)
+	}
+	var resp models.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Error != "Request body too large (max 64 bytes)" {
+		t.Errorf("Unexpected error %q", resp.Error)
+	}
+}
+
+func TestRecordGameHandlerLogsBody(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	apiCfg := &APIConfig{DB: &mockDB{}, LogRequestBodies: true}
+	req := httptest.NewRequest("POST", "/v1/games", strings.NewReader(`{"participant_ids":["not-a-uuid"]}`))
+	apiCfg.RecordGameHandler(httptest.NewRecorder(), req)
+
+	if !strings.Contains(logs.String(), "not-a-uuid") {
+		t.Errorf("Expected the request body to be logged, got %q", logs.String())
+	}
+}
+
+func TestRecordGameHandlerPersistsPlacements(t *testing.T) {
+	gameID := uuid.New()
+	participants := []uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+
+	var stored []database.CreateGameParticipantParams
+	var incremented []uuid.UUID
+	apiCfg := &APIConfig{
+		DB: &mockDB{
+			createGame: func(ctx context.Context) (database.Game, error) {
+				return database.Game{ID: gameID}, nil
+			},
+			createGameParticipant: func(ctx context.Context, arg database.CreateGameParticipantParams) (database.GameParticipant, error) {
+				stored = append(stored, arg)
+				return database.GameParticipant(arg), nil
+			},
+			incrementLastPlaceCount: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+				incremented = append(incremented, id)
+				return database.User{ID: id, LastPlaceCount: 1}, nil
+			},
+		},
+	}
+
+	ids := make([]string, len(participants))
+	for i, id := range participants {
+		ids[i] = id.String()
+	}
+	jsonBody, _ := json.Marshal(models.RecordGameRequest{ParticipantIDs: ids})
+
+	req := withClaims(httptest.NewRequest("POST", "/v1/games", bytes.NewBuffer(jsonBody)), participants[0])
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	apiCfg.RecordGameHandler(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	// Every participant is stored with its finishing position
+	if len(stored) != len(participants) {
+		t.Fatalf("Expected %d stored placements, got %d", len(participants), len(stored))
+	}
+	for i, p := range stored {
+		if p.GameID != gameID {
+			t.Errorf("Placement %d stored against game %s, expected %s", i, p.GameID, gameID)
+		}
+		if p.UserID != participants[i] || p.Placement != int32(i+1) {
+			t.Errorf("Expected %s at placement %d, got %s at %d", participants[i], i+1, p.UserID, p.Placement)
+		}
+	}
+
+	// Only the final entry gets the last place increment
+	if len(incremented) != 1 || incremented[0] != participants[len(participants)-1] {
+		t.Errorf("Expected last place increment for %s, got %v", participants[len(participants)-1], incremented)
+	}
+
+	var response struct {
+		Data struct {
+			Game      models.Game `json:"game"`
+			LastPlace models.User `json:"last_place"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if len(response.Data.Game.Participants) != len(participants) {
+		t.Errorf("Expected %d participants in response, got %d", len(participants), len(response.Data.Game.Participants))
+	}
+	if response.Data.LastPlace.ID != participants[len(participants)-1] {
+		t.Errorf("Expected last place %s in response, got %s", participants[len(participants)-1], response.Data.LastPlace.ID)
+	}
+}
+
+func TestRecordGameHandlerRequiresParticipant(t *testing.T) {
+	participants := []string{uuid.New().String(), uuid.New().String()}
+	jsonBody, _ := json.Marshal(models.RecordGameRequest{ParticipantIDs: participants})
+	// No stubs: a rejected request must not reach the database
+	apiCfg := &APIConfig{DB: &mockDB{}}
+
+	w := httptest.NewRecorder()
+	apiCfg.RecordGameHandler(w, withClaims(httptest.NewRequest("POST", "/v1/games", bytes.NewBuffer(jsonBody)), uuid.New()))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a caller outside the game, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	apiCfg.RecordGameHandler(w, httptest.NewRequest("POST", "/v1/games", bytes.NewBuffer(jsonBody)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a caller, got %d", w.Code)
+	}
+}
+
+func TestRecordGameHandlerDeletedParticipant(t *testing.T) {
+	player, deleted := uuid.New(), uuid.New()
+	jsonBody, _ := json.Marshal(models.RecordGameRequest{ParticipantIDs: []string{player.String(), deleted.String()}})
+	db := &mockDB{
+		createGame: func(ctx context.Context) (database.Game, error) {
+			return database.Game{ID: uuid.New()}, nil
+		},
+		// The insert filters out deleted accounts, so the row never comes back
+		createGameParticipant: func(ctx context.Context, arg database.CreateGameParticipantParams) (database.GameParticipant, error) {
+			if arg.UserID == deleted {
+				return database.GameParticipant{}, pgx.ErrNoRows
+			}
+			return database.GameParticipant(arg), nil
+		},
+	}
+	apiCfg := &APIConfig{DB: db}
+
+	w := httptest.NewRecorder()
+	apiCfg.RecordGameHandler(w, withClaims(httptest.NewRequest("POST", "/v1/games", bytes.NewBuffer(jsonBody)), player))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a deleted participant, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRecordGameHandlerInvalidatesLeaderboard(t *testing.T) {
+	queries, notified := 0, 0
+	db := leaderboardDB(2)
+	db.countLeaderBoard = func(ctx context.Context, minGames int32) (int64, error) {
+		queries++
+		return 2, nil
+	}
+	db.getLeaderBoardModified = func(ctx context.Context) (pgtype.Timestamp, error) {
+		return pgtype.Timestamp{Time: time.Now(), Valid: true}, nil
+	}
+	db.createGame = func(ctx context.Context) (database.Game, error) {
+		return database.Game{ID: uuid.New()}, nil
+	}
+	db.createGameParticipant = func(ctx context.Context, arg database.CreateGameParticipantParams) (database.GameParticipant, error) {
+		return database.GameParticipant(arg), nil
+	}
+	db.incrementLastPlaceCount = func(ctx context.Context, id uuid.UUID) (database.User, error) {
+		return database.User{ID: id, LastPlaceCount: 1}, nil
+	}
+	db.notifyLeaderboard = func(ctx context.Context) error {
+		notified++
+		return nil
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+	apiCfg.CacheTTL = time.Hour
+
+	getLeaderboard := func() {
+		w := httptest.NewRecorder()
+		apiCfg.GetLeaderboardHandler(w, httptest.NewRequest("GET", "/v1/leaderboard", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+	}
+
+	getLeaderboard()
+	getLeaderboard()
+	if queries != 1 {
+		t.Fatalf("Expected the cached page to be reused, got %d queries", queries)
+	}
+
+	player := uuid.New()
+	jsonBody, _ := json.Marshal(models.RecordGameRequest{ParticipantIDs: []string{player.String(), uuid.New().String()}})
+	w := httptest.NewRecorder()
+	apiCfg.RecordGameHandler(w, withClaims(httptest.NewRequest("POST", "/v1/games", bytes.NewBuffer(jsonBody)), player))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if notified != 1 {
+		t.Errorf("Expected other instances to be notified once, got %d", notified)
+	}
+
+	getLeaderboard()
+	if queries != 2 {
+		t.Errorf("Expected the leaderboard to be queried again after a game, got %d queries", queries)
+	}
+}
+
+func TestRecordGameHandlerTieLastPlacePolicy(t *testing.T) {
+	participants := []uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+	tied := participants[2:]
+
+	tests := []struct {
+		policy        string
+		expectedCount int
+	}{
+		{policy: "", expectedCount: 2}, // Defaults to all
+		{policy: TieLastPlaceAll, expectedCount: 2},
+		{policy: TieLastPlaceNone, expectedCount: 0},
+		{policy: TieLastPlaceRandom, expectedCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run("policy_"+tt.policy, func(t *testing.T) {
+			var stored []database.CreateGameParticipantParams
+			var incremented []uuid.UUID
+			apiCfg := &APIConfig{
+				TieLastPlacePolicy: tt.policy,
+				DB: &mockDB{
+					createGame: func(ctx context.Context) (database.Game, error) {
+						return database.Game{ID: uuid.New()}, nil
+					},
+					createGameParticipant: func(ctx context.Context, arg database.CreateGameParticipantParams) (database.GameParticipant, error) {
+						stored = append(stored, arg)
+						return database.GameParticipant(arg), nil
+					},
+					incrementLastPlaceCount: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+						incremented = append(incremented, id)
+						return database.User{ID: id, LastPlaceCount: 1}, nil
+					},
+				},
+			}
+
+			ids := make([]string, len(participants))
+			for i, id := range participants {
+				ids[i] = id.String()
+			}
+			// Two-way tie for last
+			jsonBody, _ := json.Marshal(models.RecordGameRequest{ParticipantIDs: ids, Placements: []int{1, 2, 3, 3}})
+
+			w := httptest.NewRecorder()
+			apiCfg.RecordGameHandler(w, withClaims(httptest.NewRequest("POST", "/v1/games", bytes.NewBuffer(jsonBody)), participants[0]))
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+			}
+			if stored[2].Placement != 3 || stored[3].Placement != 3 {
+				t.Errorf("Expected both tied players stored at placement 3, got %d and %d", stored[2].Placement, stored[3].Placement)
+			}
+
+			if len(incremented) != tt.expectedCount {
+				t.Fatalf("Expected %d last place increments, got %v", tt.expectedCount, incremented)
+			}
+			for _, id := range incremented {
+				if !slices.Contains(tied, id) {
+					t.Errorf("Incremented %s, who didn't tie for last", id)
+				}
+			}
+
+			var response struct {
+				Data struct {
+					LastPlace  *models.User  `json:"last_place"`
+					LastPlaces []models.User `json:"last_places"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+			if len(response.Data.LastPlaces) != tt.expectedCount {
+				t.Errorf("Expected %d last places in response, got %d", tt.expectedCount, len(response.Data.LastPlaces))
+			}
+			if (response.Data.LastPlace != nil) != (tt.expectedCount > 0) {
+				t.Errorf("Expected last_place set %v, got %+v", tt.expectedCount > 0, response.Data.LastPlace)
+			}
+		})
+	}
+}
+
+func TestParseTieLastPlacePolicy(t *testing.T) {
+	for input, expected := range map[string]string{"": TieLastPlaceAll, "ALL": TieLastPlaceAll, "none": TieLastPlaceNone, " random ": TieLastPlaceRandom} {
+		if policy, err := ParseTieLastPlacePolicy(input); err != nil || policy != expected {
+			t.Errorf("ParseTieLastPlacePolicy(%q) = %q, %v; expected %q", input, policy, err, expected)
+		}
+	}
+	if _, err := ParseTieLastPlacePolicy("loser-picks"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+func TestTallyHeadToHead(t *testing.T) {
+	alice := uuid.New()
+	bob := uuid.New()
+
+	// Alice beat Bob twice, lost once and tied once
+	rows := []database.ListHeadToHeadPlacementsRow{
+		{GameID: uuid.New(), UserPlacement: 1, OtherPlacement: 3},
+		{GameID: uuid.New(), UserPlacement: 2, OtherPlacement: 4},
+		{GameID: uuid.New(), UserPlacement: 4, OtherPlacement: 1},
+		{GameID: uuid.New(), UserPlacement: 3, OtherPlacement: 3},
+	}
+
+	result := tallyHeadToHead(alice, bob, rows)
+
+	if result.UserAhead != 2 {
+		t.Errorf("Expected user ahead 2, got %d", result.UserAhead)
+	}
+	if result.OtherAhead != 1 {
+		t.Errorf("Expected other ahead 1, got %d", result.OtherAhead)
+	}
+	if result.Ties != 1 {
+		t.Errorf("Expected ties 1, got %d", result.Ties)
+	}
+	if result.SharedGames != 4 {
+		t.Errorf("Expected shared games 4, got %d", result.SharedGames)
+	}
+}
+
+func TestGetHeadToHeadHandler(t *testing.T) {
+	alice := uuid.New()
+	bob := uuid.New()
+
+	tests := []struct {
+		name           string
+		userID         string
+		otherID        string
+		rows           []database.ListHeadToHeadPlacementsRow
+		expectedStatus int
+		expectedError  string
+		expected       models.HeadToHead
+	}{
+		{
+			name:    "shared_games",
+			userID:  alice.String(),
+			otherID: bob.String(),
+			rows: []database.ListHeadToHeadPlacementsRow{
+				{GameID: uuid.New(), UserPlacement: 1, OtherPlacement: 2},
+				{GameID: uuid.New(), UserPlacement: 3, OtherPlacement: 2},
+				{GameID: uuid.New(), UserPlacement: 1, OtherPlacement: 4},
+			},
+			expectedStatus: http.StatusOK,
+			expected:       models.HeadToHead{UserID: alice, OtherUserID: bob, UserAhead: 2, OtherAhead: 1, SharedGames: 3},
+		},
+		{
+			name:           "no_shared_games",
+			userID:         alice.String(),
+			otherID:        bob.String(),
+			rows:           []database.ListHeadToHeadPlacementsRow{},
+			expectedStatus: http.StatusOK,
+			expected:       models.HeadToHead{UserID: alice, OtherUserID: bob},
+		},
+		{
+			name:           "same_user",
+			userID:         alice.String(),
+			otherID:        alice.String(),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Cannot compare a user with themselves",
+		},
+		{
+			name:           "same_user_different_case",
+			userID:         alice.String(),
+			otherID:        strings.ToUpper(alice.String()),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Cannot compare a user with themselves",
+		},
+		{
+			name:           "invalid_other_id",
+			userID:         alice.String(),
+			otherID:        "not-a-uuid",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiCfg := &APIConfig{
+				DB: &mockDB{
+					getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+						return database.User{ID: id}, nil
+					},
+					listHeadToHead: func(ctx context.Context, arg database.ListHeadToHeadPlacementsParams) ([]database.ListHeadToHeadPlacementsRow, error) {
+						return tt.rows, nil
+					},
+				},
+			}
+
+			req := httptest.NewRequest("GET", "/v1/users/"+tt.userID+"/head-to-head/"+tt.otherID, nil)
+			req = withURLParams(req, map[string]string{"id": tt.userID, "otherId": tt.otherID})
+			w := httptest.NewRecorder()
+
+			apiCfg.GetHeadToHeadHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedError != "" && !strings.Contains(w.Body.String(), tt.expectedError) {
+				t.Errorf("Expected error %q, got %s", tt.expectedError, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data models.HeadToHead `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+			if response.Data != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, response.Data)
+			}
+		})
+	}
+}
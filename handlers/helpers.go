@@ -1,12 +1,23 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/mail"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/froggu-tantei/ToT/middleware"
 	"github.com/froggu-tantei/ToT/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/sync/singleflight"
 )
 
 // isValidEmail validates email format using Go's standard library
@@ -19,9 +30,141 @@ func isValidEmail(email string) bool {
 	return addr.Address == email
 }
 
-// RespondWithJSON sends a JSON response
+// strongPasswordLength is the length below which an accepted password is
+// reported as weak
+const strongPasswordLength = 10
+
+// passwordWarning returns a warning for a password that is long enough to
+// be accepted but easy to guess: short, or all one kind of character. An
+// empty string means no warning.
+func passwordWarning(password string) string {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	kinds := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			kinds++
+		}
+	}
+	if utf8.RuneCountInString(password) < strongPasswordLength || kinds < 2 {
+		return "Password is weak, use at least 10 characters mixing letters, numbers or symbols"
+	}
+	return ""
+}
+
+// queryCanceled is the Postgres error code for a statement cancelled mid-run
+const queryCanceled = "57014"
+
+// isQueryTimeout reports whether a query failed because it ran out of time
+func isQueryTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &pgErr) && pgErr.Code == queryCanceled)
+}
+
+// respondWithDBError answers a failed query with 504 when it timed out, so
+// clients can tell a struggling database from a bug, and a 500 otherwise.
+// A queryError's own message replaces msg.
+func respondWithDBError(w http.ResponseWriter, err error, msg string) {
+	if isQueryTimeout(err) {
+		RespondWithJSON(w, http.StatusGatewayTimeout, models.NewErrorResponse("Database timed out"))
+		return
+	}
+	var qErr *queryError
+	if errors.As(err, &qErr) {
+		msg = qErr.msg
+	}
+	RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse(msg))
+}
+
+// queryError is a failed query with the message to answer it with, for
+// queries that run apart from the handler responding, like a coalesced one
+type queryError struct {
+	msg string
+	err error
+}
+
+func (e *queryError) Error() string { return e.msg + ": " + e.err.Error() }
+func (e *queryError) Unwrap() error { return e.err }
+
+// coalesce runs fn once for every concurrent call with the same key and
+// hands each caller the result, so a burst of identical requests costs the
+// database one set of queries. fn doesn't inherit the caller's cancellation,
+// as the other callers still want the result if the first one goes away; the
+// Store's query timeout still bounds it.
+func coalesce[V any](ctx context.Context, group *singleflight.Group, key string, fn func(ctx context.Context) (V, error)) (V, error) {
+	v, err, _ := group.Do(key, func() (any, error) {
+		return fn(context.WithoutCancel(ctx))
+	})
+	value, _ := v.(V)
+	return value, err
+}
+
+// decodeJSON decodes the request body into v, logging it with its secrets
+// masked when LogRequestBodies is on
+func (cfg *APIConfig) decodeJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return err
+	}
+	if cfg.LogRequestBodies {
+		log.Printf("Request body %s %s: %s", r.Method, r.URL.Path, middleware.Redact(v))
+	}
+	return nil
+}
+
+// respondWithDecodeError answers a request body that couldn't be decoded: 413
+// when it ran past the body size limit, 400 for anything else
+func respondWithDecodeError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		RespondWithJSON(w, http.StatusRequestEntityTooLarge, models.NewErrorResponse(fmt.Sprintf("Request body too large (max %d bytes)", maxBytesErr.Limit)))
+		return
+	}
+	RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid request format"))
+}
+
+// requireDifferentUser rejects operations aimed at the same account twice,
+// like comparing or blocking yourself, with msg saying what can't be done. It
+// reports whether the request may continue, having already sent a 400 when it
+// may not.
+func requireDifferentUser(w http.ResponseWriter, callerID, targetID uuid.UUID, msg string) bool {
+	if callerID == targetID {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse(msg))
+		return false
+	}
+	return true
+}
+
+// notModified sets Last-Modified and reports whether the client's
+// If-Modified-Since copy is still current, in which case it already sent a 304
+func notModified(w http.ResponseWriter, r *http.Request, lastModified time.Time) bool {
+	// HTTP dates have second precision, so compare at that precision too
+	lastModified = lastModified.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// RespondWithJSON sends a JSON response. The body is marshaled up front, so
+// unlike a streamed response it carries a Content-Length. Success responses
+// lose their envelope when the request opted out through Envelope.
 func RespondWithJSON(w http.ResponseWriter, code int, payload any) {
-	data, err := json.Marshal(payload)
+	data, err := json.Marshal(unwrapEnvelope(w, payload))
 	if err != nil {
 		log.Printf("Failed to marshal JSON response: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -31,6 +174,7 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload any) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 	w.WriteHeader(code)
 	w.Write(data)
 }
@@ -59,6 +203,7 @@ func RespondWithError(w http.ResponseWriter, code int, msg string) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 	w.WriteHeader(code)
 	w.Write(data)
 }
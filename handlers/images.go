@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register GIF for image.DecodeConfig
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// DefaultJPEGQuality is the quality compressed pictures are re-encoded at
+const DefaultJPEGQuality = 85
+
+// MaxImagePixels caps the size of a picture that is decoded to re-encode it.
+// A small file can declare a huge canvas, and decoding allocates all of it, so
+// anything past about a 6000x4000 photo (~100MB decoded) is refused.
+const MaxImagePixels = 24_000_000
+
+// ErrImageTooLarge is returned for pictures over MaxImagePixels
+var ErrImageTooLarge = errors.New("image too large to decode")
+
+// ImageConstraints bounds the dimensions of uploaded pictures. Zero values
+// mean no limit, so the zero ImageConstraints accepts any image.
+type ImageConstraints struct {
+	MinWidth       int
+	MinHeight      int
+	MaxWidth       int
+	MaxHeight      int
+	MinAspectRatio float64 // Width divided by height, e.g. 0.5 allows images up to twice as tall as wide
+	MaxAspectRatio float64 // Width divided by height, e.g. 2 allows images up to twice as wide as tall
+}
+
+// Check returns a client-facing message describing why an image of the given
+// size isn't allowed, or "" if it is
+func (c ImageConstraints) Check(width, height int) string {
+	if width <= 0 || height <= 0 {
+		return "Image has no size"
+	}
+	if (c.MinWidth > 0 && width < c.MinWidth) || (c.MinHeight > 0 && height < c.MinHeight) {
+		return fmt.Sprintf("Image is too small (%dx%d), minimum is %dx%d", width, height, c.MinWidth, c.MinHeight)
+	}
+	if (c.MaxWidth > 0 && width > c.MaxWidth) || (c.MaxHeight > 0 && height > c.MaxHeight) {
+		return fmt.Sprintf("Image is too large (%dx%d), maximum is %dx%d", width, height, c.MaxWidth, c.MaxHeight)
+	}
+
+	ratio := float64(width) / float64(height)
+	if c.MinAspectRatio > 0 && ratio < c.MinAspectRatio {
+		return fmt.Sprintf("Image is too tall (aspect ratio %.2f, minimum is %.2f)", ratio, c.MinAspectRatio)
+	}
+	if c.MaxAspectRatio > 0 && ratio > c.MaxAspectRatio {
+		return fmt.Sprintf("Image is too wide (aspect ratio %.2f, maximum is %.2f)", ratio, c.MaxAspectRatio)
+	}
+	return ""
+}
+
+// imageDimensions reads the width and height from an image header without
+// decoding the pixels, then rewinds the file for storing
+func imageDimensions(file io.ReadSeeker) (width, height int, err error) {
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// ImageCompression re-encodes uploaded pictures before they are stored to
+// save storage and egress. Pictures with transparency stay PNG, opaque ones
+// become JPEG (baseline, image/jpeg can't write progressive). GIFs are kept
+// as uploaded so animations survive.
+type ImageCompression struct {
+	Enabled     bool
+	JPEGQuality int // 1-100, zero uses DefaultJPEGQuality
+}
+
+// compress returns the re-encoded picture and its extension, or nil when
+// compression is off or wouldn't make the file smaller. The file is rewound
+// either way so the original can still be stored.
+func (c ImageCompression) compress(file io.ReadSeeker, extension string) (io.Reader, string, error) {
+	if !c.Enabled || extension == ".gif" {
+		return nil, "", nil
+	}
+
+	original, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	// Encoding a JPEG again at the quality it was written with only loses
+	// detail, and reprocessing would do that on every run
+	quality := c.JPEGQuality
+	if quality <= 0 {
+		quality = DefaultJPEGQuality
+	}
+	quality = min(quality, 100)
+	if extension == ".jpg" && jpegEncodedAt(original, quality) {
+		return nil, "", nil
+	}
+
+	// Check the declared size before decoding allocates it
+	config, _, err := image.DecodeConfig(bytes.NewReader(original))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, "", fmt.Errorf("%w: %dx%d", ErrImageTooLarge, config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	if hasAlpha(img) {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buf, img)
+		extension = ".png"
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+		extension = ".jpg"
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Already well-compressed uploads are kept as they are
+	if buf.Len() >= len(original) {
+		return nil, "", nil
+	}
+	return &buf, extension, nil
+}
+
+// jpegLuminanceQuant is the standard luminance quantization table, in the
+// zig-zag order a JPEG stores it, that image/jpeg scales by quality
+var jpegLuminanceQuant = [64]byte{
+	16, 11, 12, 14, 12, 10, 16, 14, 13, 14, 18, 17, 16, 19, 24, 40,
+	26, 24, 22, 22, 24, 49, 35, 37, 29, 40, 58, 51, 61, 60, 57, 51,
+	56, 55, 64, 72, 92, 78, 64, 68, 87, 69, 55, 56, 80, 109, 81, 87,
+	95, 98, 103, 104, 103, 62, 77, 113, 121, 112, 100, 120, 92, 101, 103, 99,
+}
+
+// jpegEncodedAt reports whether a JPEG's luminance quantization table is the
+// one image/jpeg writes at the given quality (1-100), i.e. the picture was
+// already compressed with these settings
+func jpegEncodedAt(data []byte, quality int) bool {
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var want [64]byte
+	for i, q := range jpegLuminanceQuant {
+		want[i] = byte(max(1, min((int(q)*scale+50)/100, 255)))
+	}
+
+	// Walk the marker segments after SOI, the tables all come before the scan
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return false
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker, length := data[i+1], int(data[i+2])<<8|int(data[i+3])
+		if marker == 0xda || length < 2 || i+2+length > len(data) {
+			return false
+		}
+		if marker == 0xdb {
+			// A DQT segment holds one or more 8-bit tables of 65 bytes each
+			for table := data[i+4 : i+2+length]; len(table) >= 65 && table[0]>>4 == 0; table = table[65:] {
+				if table[0]&0x0f == 0 {
+					return [64]byte(table[1:65]) == want
+				}
+			}
+		}
+		i += 2 + length
+	}
+	return false
+}
+
+// hasAlpha reports whether any pixel of the image is not fully opaque
+func hasAlpha(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/google/uuid"
+)
+
+func TestImageConstraintsCheck(t *testing.T) {
+	constraints := ImageConstraints{
+		MinWidth:       32,
+		MinHeight:      32,
+		MaxWidth:       2048,
+		MaxHeight:      2048,
+		MinAspectRatio: 0.5,
+		MaxAspectRatio: 2,
+	}
+
+	tests := []struct {
+		name          string
+		width, height int
+		allowed       bool
+	}{
+		{name: "square", width: 256, height: 256, allowed: true},
+		{name: "at_max_ratio", width: 400, height: 200, allowed: true},
+		{name: "too_wide", width: 1000, height: 100},
+		{name: "too_tall", width: 100, height: 1000},
+		{name: "too_small", width: 16, height: 16},
+		{name: "too_large", width: 4096, height: 4096},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := constraints.Check(tt.width, tt.height)
+			if (msg == "") != tt.allowed {
+				t.Errorf("Expected allowed %v, got message %q", tt.allowed, msg)
+			}
+		})
+	}
+
+	// The zero value is permissive
+	if msg := (ImageConstraints{}).Check(10000, 1); msg != "" {
+		t.Errorf("Expected zero constraints to allow any size, got %q", msg)
+	}
+}
+
+func TestUploadProfilePictureImageConstraints(t *testing.T) {
+	tests := []struct {
+		name           string
+		width, height  int
+		expectedStatus int
+	}{
+		{name: "square_passes", width: 64, height: 64, expectedStatus: http.StatusOK},
+		{name: "outside_ratio_rejected", width: 120, height: 20, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileStorage := newMockStorage()
+			db := &mockDB{
+				updateProfilePicture: func(ctx context.Context, arg database.UpdateProfilePictureParams) (database.UpdateProfilePictureRow, error) {
+					return database.UpdateProfilePictureRow{User: database.User{ID: arg.ID, ProfilePicture: arg.ProfilePicture}}, nil
+				},
+			}
+			apiCfg := NewAPIConfig(db, fileStorage)
+			apiCfg.ImageConstraints = ImageConstraints{MinAspectRatio: 0.5, MaxAspectRatio: 2}
+
+			w := httptest.NewRecorder()
+			apiCfg.UploadProfilePictureHandler(w, newUploadRequest(t, uuid.New(), "avatar.png", testPNG(t, tt.width, tt.height)))
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if stored := len(fileStorage.files) > 0; stored != (tt.expectedStatus == http.StatusOK) {
+				t.Errorf("Expected file stored %v, got %v", tt.expectedStatus == http.StatusOK, stored)
+			}
+		})
+	}
+}
+
+// bombPNG returns a tiny PNG whose header declares a width x height canvas,
+// the way a decompression bomb claims far more pixels than it has bytes
+func bombPNG(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	data := testPNG(t, 1, 1)
+	// The IHDR chunk follows the 8-byte signature: length, type, width, height,
+	// five more bytes of fields, then a CRC over the type and fields
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestUploadProfilePictureDecompressionBomb(t *testing.T) {
+	fileStorage := newMockStorage()
+	apiCfg := NewAPIConfig(&mockDB{}, fileStorage)
+	apiCfg.ImageCompression = ImageCompression{Enabled: true}
+
+	w := httptest.NewRecorder()
+	apiCfg.UploadProfilePictureHandler(w, newUploadRequest(t, uuid.New(), "avatar.png", bombPNG(t, 50000, 50000)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "megapixels") {
+		t.Errorf("Expected the pixel limit in the message, got %s", w.Body.String())
+	}
+	if len(fileStorage.files) != 0 {
+		t.Errorf("Expected nothing stored, got %d files", len(fileStorage.files))
+	}
+}
+
+// photoPNG encodes an opaque, photo-like image: a gradient with some noise,
+// which PNG compresses poorly and JPEG well
+func photoPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			noise := uint8(rng.IntN(24))
+			img.Set(x, y, color.RGBA{R: uint8(x) + noise, G: uint8(y) + noise, B: uint8(x+y) / 2, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode test PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// photoJPEG encodes photoPNG's image as a JPEG at the given quality
+func photoJPEG(t *testing.T, width, height, quality int) []byte {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(photoPNG(t, width, height)))
+	if err != nil {
+		t.Fatalf("Failed to decode test PNG: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("Failed to encode test JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestJPEGEncodedAt(t *testing.T) {
+	for _, quality := range []int{1, 30, 50, DefaultJPEGQuality, 100} {
+		data := photoJPEG(t, 16, 16, quality)
+		if !jpegEncodedAt(data, quality) {
+			t.Errorf("Expected a JPEG written at %d to match it", quality)
+		}
+		if jpegEncodedAt(data, quality%100+1) {
+			t.Errorf("Expected a JPEG written at %d not to match %d", quality, quality%100+1)
+		}
+	}
+	for _, data := range [][]byte{testPNG(t, 16, 16), {0xff, 0xd8}, {0xff, 0xd8, 0xff, 0xdb, 0xff, 0xff}, nil} {
+		if jpegEncodedAt(data, DefaultJPEGQuality) {
+			t.Errorf("Expected no match for %d bytes that aren't a valid JPEG", len(data))
+		}
+	}
+}
+
+func TestImageCompressionCompress(t *testing.T) {
+	enabled := ImageCompression{Enabled: true}
+
+	t.Run("large_png_shrinks_to_jpeg", func(t *testing.T) {
+		original := photoPNG(t, 400, 400)
+		compressed, extension, err := enabled.compress(bytes.NewReader(original), ".png")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if compressed == nil {
+			t.Fatal("Expected the picture to be compressed")
+		}
+		data, _ := io.ReadAll(compressed)
+		if extension != ".jpg" || http.DetectContentType(data) != "image/jpeg" {
+			t.Errorf("Expected a JPEG, got %s (%s)", extension, http.DetectContentType(data))
+		}
+		if len(data) >= len(original) {
+			t.Errorf("Expected fewer than %d bytes, got %d", len(original), len(data))
+		}
+	})
+
+	t.Run("transparent_png_stays_png", func(t *testing.T) {
+		original := testPNG(t, 400, 400)
+		img := image.NewNRGBA(image.Rect(0, 0, 400, 400))
+		for i := range img.Pix {
+			img.Pix[i] = uint8(i % 251) // Varying color and alpha
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("Failed to encode test PNG: %v", err)
+		}
+
+		for _, original := range [][]byte{original, buf.Bytes()} {
+			compressed, extension, err := enabled.compress(bytes.NewReader(original), ".png")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if compressed == nil {
+				continue // Kept as uploaded, still a PNG
+			}
+			data, _ := io.ReadAll(compressed)
+			if extension != ".png" || http.DetectContentType(data) != "image/png" {
+				t.Errorf("Expected a transparent picture to stay PNG, got %s", extension)
+			}
+		}
+	})
+
+	t.Run("jpeg_at_target_quality_kept", func(t *testing.T) {
+		original := photoJPEG(t, 400, 400, DefaultJPEGQuality)
+		compressed, _, err := enabled.compress(bytes.NewReader(original), ".jpg")
+		if err != nil || compressed != nil {
+			t.Errorf("Expected a JPEG already at the target quality to be kept, got %v, %v", compressed, err)
+		}
+
+		// A lower target still re-encodes it
+		lower := ImageCompression{Enabled: true, JPEGQuality: 40}
+		if compressed, _, err := lower.compress(bytes.NewReader(original), ".jpg"); err != nil || compressed == nil {
+			t.Errorf("Expected a lower quality to re-encode, got %v, %v", compressed, err)
+		}
+	})
+
+	t.Run("too_many_pixels", func(t *testing.T) {
+		_, _, err := enabled.compress(bytes.NewReader(bombPNG(t, 6000, 4001)), ".png")
+		if !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("Expected ErrImageTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		compressed, _, err := ImageCompression{}.compress(bytes.NewReader(photoPNG(t, 64, 64)), ".png")
+		if err != nil || compressed != nil {
+			t.Errorf("Expected no compression when disabled, got %v, %v", compressed, err)
+		}
+	})
+
+	t.Run("rewinds_file", func(t *testing.T) {
+		original := testPNG(t, 16, 16)
+		file := bytes.NewReader(original)
+		if _, _, err := enabled.compress(file, ".png"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if data, _ := io.ReadAll(file); !bytes.Equal(data, original) {
+			t.Error("Expected the original to be readable after compressing")
+		}
+	})
+}
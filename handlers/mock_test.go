@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// mockDB stubs only the queries a test needs. Any other method falls through
+// to the nil embedded Store and panics, which makes unexpected DB calls obvious.
+type mockDB struct {
+	database.Store
+
+	getUserByID              func(ctx context.Context, id uuid.UUID) (database.User, error)
+	getUserByEmail           func(ctx context.Context, email string) (database.User, error)
+	createUser               func(ctx context.Context, arg database.CreateUserParams) (database.User, error)
+	updateUser               func(ctx context.Context, arg database.UpdateUserParams) (database.User, error)
+	updateProfilePicture     func(ctx context.Context, arg database.UpdateProfilePictureParams) (database.UpdateProfilePictureRow, error)
+	createGame               func(ctx context.Context) (database.Game, error)
+	createGameParticipant    func(ctx context.Context, arg database.CreateGameParticipantParams) (database.GameParticipant, error)
+	incrementLastPlaceCount  func(ctx context.Context, id uuid.UUID) (database.User, error)
+	listHeadToHead           func(ctx context.Context, arg database.ListHeadToHeadPlacementsParams) ([]database.ListHeadToHeadPlacementsRow, error)
+	listProfilePictures      func(ctx context.Context) ([]pgtype.Text, error)
+	softDeleteUsers          func(ctx context.Context, ids []uuid.UUID) ([]database.SoftDeleteUsersRow, error)
+	listProfilePicturesAfter func(ctx context.Context, arg database.ListProfilePicturesAfterParams) ([]database.ListProfilePicturesAfterRow, error)
+	replaceProfilePicture    func(ctx context.Context, arg database.ReplaceProfilePictureParams) (int64, error)
+	listUsersForExport       func(ctx context.Context, arg database.ListUsersForExportParams) ([]database.ListUsersForExportRow, error)
+	getLeaderBoard           func(ctx context.Context, arg database.GetLeaderBoardParams) ([]database.GetLeaderBoardRow, error)
+	getLeaderBoardModified   func(ctx context.Context) (pgtype.Timestamp, error)
+	countUsers               func(ctx context.Context) (int64, error)
+	upsertEmailChange        func(ctx context.Context, arg database.UpsertEmailChangeParams) (database.EmailChange, error)
+	confirmEmailChange       func(ctx context.Context, tokenHash string) (database.User, error)
+	upsertEmailVerification  func(ctx context.Context, arg database.UpsertEmailVerificationParams) (database.EmailVerification, error)
+	verifyEmail              func(ctx context.Context, tokenHash string) (database.User, error)
+	countSearchUsers         func(ctx context.Context, arg database.CountSearchUsersParams) (int64, error)
+	searchUsers              func(ctx context.Context, arg database.SearchUsersParams) ([]database.SearchUsersRow, error)
+	searchUsersSorted        func(ctx context.Context, arg database.SearchUsersParams, sortKey, order string) ([]database.SearchUsersRow, error)
+	countLeaderBoard         func(ctx context.Context, minGames int32) (int64, error)
+	listUsers                func(ctx context.Context, arg database.ListUsersParams) ([]database.User, error)
+	countGames               func(ctx context.Context) (int64, error)
+	countRecentUsers         func(ctx context.Context) (int64, error)
+	sumLastPlaceCounts       func(ctx context.Context) (int64, error)
+	notifyLeaderboard        func(ctx context.Context) error
+	countVisibleUsers        func(ctx context.Context, viewerID uuid.UUID) (int64, error)
+	createBlock              func(ctx context.Context, arg database.CreateBlockParams) error
+	deleteBlock              func(ctx context.Context, arg database.DeleteBlockParams) error
+	getServerVersion         func(ctx context.Context) (string, error)
+}
+
+// ExecTx runs fn directly against the mock; there is no real transaction to roll back
+func (m *mockDB) ExecTx(ctx context.Context, fn func(database.Querier) error) error {
+	return fn(m)
+}
+
+func (m *mockDB) GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	return m.getUserByID(ctx, id)
+}
+
+func (m *mockDB) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	return m.getUserByEmail(ctx, email)
+}
+
+func (m *mockDB) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	return m.createUser(ctx, arg)
+}
+
+func (m *mockDB) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	return m.updateUser(ctx, arg)
+}
+
+func (m *mockDB) UpdateProfilePicture(ctx context.Context, arg database.UpdateProfilePictureParams) (database.UpdateProfilePictureRow, error) {
+	return m.updateProfilePicture(ctx, arg)
+}
+
+func (m *mockDB) CreateGame(ctx context.Context) (database.Game, error) {
+	return m.createGame(ctx)
+}
+
+func (m *mockDB) CreateGameParticipant(ctx context.Context, arg database.CreateGameParticipantParams) (database.GameParticipant, error) {
+	return m.createGameParticipant(ctx, arg)
+}
+
+func (m *mockDB) IncrementLastPlaceCount(ctx context.Context, id uuid.UUID) (database.User, error) {
+	return m.incrementLastPlaceCount(ctx, id)
+}
+
+func (m *mockDB) ListHeadToHeadPlacements(ctx context.Context, arg database.ListHeadToHeadPlacementsParams) ([]database.ListHeadToHeadPlacementsRow, error) {
+	return m.listHeadToHead(ctx, arg)
+}
+
+func (m *mockDB) ListUsersForExport(ctx context.Context, arg database.ListUsersForExportParams) ([]database.ListUsersForExportRow, error) {
+	return m.listUsersForExport(ctx, arg)
+}
+
+func (m *mockDB) ListProfilePicturesAfter(ctx context.Context, arg database.ListProfilePicturesAfterParams) ([]database.ListProfilePicturesAfterRow, error) {
+	return m.listProfilePicturesAfter(ctx, arg)
+}
+
+func (m *mockDB) ReplaceProfilePicture(ctx context.Context, arg database.ReplaceProfilePictureParams) (int64, error) {
+	return m.replaceProfilePicture(ctx, arg)
+}
+
+func (m *mockDB) ListProfilePictures(ctx context.Context) ([]pgtype.Text, error) {
+	return m.listProfilePictures(ctx)
+}
+
+func (m *mockDB) GetLeaderBoard(ctx context.Context, arg database.GetLeaderBoardParams) ([]database.GetLeaderBoardRow, error) {
+	return m.getLeaderBoard(ctx, arg)
+}
+
+func (m *mockDB) GetLeaderBoardLastModified(ctx context.Context) (pgtype.Timestamp, error) {
+	return m.getLeaderBoardModified(ctx)
+}
+
+func (m *mockDB) UpsertEmailChange(ctx context.Context, arg database.UpsertEmailChangeParams) (database.EmailChange, error) {
+	return m.upsertEmailChange(ctx, arg)
+}
+
+func (m *mockDB) ConfirmEmailChange(ctx context.Context, tokenHash string) (database.User, error) {
+	return m.confirmEmailChange(ctx, tokenHash)
+}
+
+func (m *mockDB) UpsertEmailVerification(ctx context.Context, arg database.UpsertEmailVerificationParams) (database.EmailVerification, error) {
+	return m.upsertEmailVerification(ctx, arg)
+}
+
+func (m *mockDB) VerifyEmail(ctx context.Context, tokenHash string) (database.User, error) {
+	return m.verifyEmail(ctx, tokenHash)
+}
+
+func (m *mockDB) CountSearchUsers(ctx context.Context, arg database.CountSearchUsersParams) (int64, error) {
+	return m.countSearchUsers(ctx, arg)
+}
+
+func (m *mockDB) SearchUsers(ctx context.Context, arg database.SearchUsersParams) ([]database.SearchUsersRow, error) {
+	return m.searchUsers(ctx, arg)
+}
+
+func (m *mockDB) SearchUsersSorted(ctx context.Context, arg database.SearchUsersParams, sortKey, order string) ([]database.SearchUsersRow, error) {
+	return m.searchUsersSorted(ctx, arg, sortKey, order)
+}
+
+func (m *mockDB) CountUsers(ctx context.Context) (int64, error) {
+	return m.countUsers(ctx)
+}
+
+func (m *mockDB) CountLeaderBoard(ctx context.Context, minGames int32) (int64, error) {
+	return m.countLeaderBoard(ctx, minGames)
+}
+
+func (m *mockDB) CountVisibleUsers(ctx context.Context, viewerID uuid.UUID) (int64, error) {
+	return m.countVisibleUsers(ctx, viewerID)
+}
+
+func (m *mockDB) CreateBlock(ctx context.Context, arg database.CreateBlockParams) error {
+	return m.createBlock(ctx, arg)
+}
+
+func (m *mockDB) DeleteBlock(ctx context.Context, arg database.DeleteBlockParams) error {
+	return m.deleteBlock(ctx, arg)
+}
+
+func (m *mockDB) GetServerVersion(ctx context.Context) (string, error) {
+	return m.getServerVersion(ctx)
+}
+
+func (m *mockDB) ListUsers(ctx context.Context, arg database.ListUsersParams) ([]database.User, error) {
+	return m.listUsers(ctx, arg)
+}
+
+func (m *mockDB) CountGames(ctx context.Context) (int64, error) {
+	return m.countGames(ctx)
+}
+
+func (m *mockDB) CountRecentUsers(ctx context.Context) (int64, error) {
+	return m.countRecentUsers(ctx)
+}
+
+func (m *mockDB) SumLastPlaceCounts(ctx context.Context) (int64, error) {
+	return m.sumLastPlaceCounts(ctx)
+}
+
+// NotifyLeaderboardChanged is optional, since most game tests don't care about other instances
+func (m *mockDB) NotifyLeaderboardChanged(ctx context.Context) error {
+	if m.notifyLeaderboard == nil {
+		return nil
+	}
+	return m.notifyLeaderboard(ctx)
+}
+
+func (m *mockDB) SoftDeleteUsers(ctx context.Context, ids []uuid.UUID) ([]database.SoftDeleteUsersRow, error) {
+	return m.softDeleteUsers(ctx, ids)
+}
+
+// mockStorage keeps stored files in memory, keyed by the path Store returns
+type mockStorage struct {
+	files map[string][]byte
+}
+
+func newMockStorage(paths ...string) *mockStorage {
+	m := &mockStorage{files: make(map[string][]byte)}
+	for _, path := range paths {
+		m.files[path] = nil
+	}
+	return m
+}
+
+func (m *mockStorage) Store(file io.Reader, filename string) (string, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	path := "/" + filename
+	m.files[path] = data
+	return path, nil
+}
+
+func (m *mockStorage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	data, ok := m.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *mockStorage) Exists(ctx context.Context, filename string) (bool, error) {
+	_, ok := m.files["/"+filename]
+	return ok, nil
+}
+
+func (m *mockStorage) Delete(path string) error {
+	delete(m.files, path)
+	return nil
+}
+
+func (m *mockStorage) GetPublicURL(path string) string {
+	return path
+}
+
+func (m *mockStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	paths := make([]string, 0, len(m.files))
+	for path := range m.files {
+		if strings.HasPrefix(path, "/"+prefix) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// withURLParams attaches chi URL params to the request
+func withURLParams(req *http.Request, params map[string]string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for key, value := range params {
+		rctx.URLParams.Add(key, value)
+	}
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+// withClaims attaches authenticated user claims and a chi "id" URL param to the request
+// configureAuth installs a test JWT secret for the rest of the test
+func configureAuth(t *testing.T, accessExpiry time.Duration) {
+	t.Helper()
+	err := auth.Configure(auth.Settings{Secret: "test_secret_key", AccessExpiry: accessExpiry, RefreshExpiry: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to configure auth: %v", err)
+	}
+	t.Cleanup(auth.Reset)
+}
+
+func withClaims(req *http.Request, userID uuid.UUID) *http.Request {
+	ctx := context.WithValue(req.Context(), middleware.UserContextKey, &auth.Claims{UserID: userID})
+	return withURLParams(req.WithContext(ctx), map[string]string{"id": userID.String()})
+}
+
+// testPNG returns a small encoded PNG image
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("Failed to encode test PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// newUploadRequest builds a multipart profile-picture upload request
+func newUploadRequest(t *testing.T, userID uuid.UUID, filename string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("profile_picture", filename)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatalf("Failed to write form file: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close multipart writer: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/users/"+userID.String()+"/profile-picture", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return withClaims(req, userID)
+}
+
+// multipartTempFiles lists the temp files mime/multipart left behind in dir
+func multipartTempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "multipart-*"))
+	if err != nil {
+		t.Fatalf("Failed to list temp dir: %v", err)
+	}
+	return matches
+}
+
+// blockingQuery returns wait, for a query stub to count its call and block
+// in, and release, which unblocks every call once the first one has started
+// and the other callers have had time to queue up behind it
+func blockingQuery(calls *atomic.Int32) (wait func(), release func()) {
+	ch := make(chan struct{})
+	wait = func() {
+		calls.Add(1)
+		<-ch
+	}
+	release = func() {
+		for calls.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(ch)
+	}
+	return wait, release
+}
+
+// concurrently runs n copies of fn at once, calling whileRunning before
+// waiting for them to finish
+func concurrently(n int, fn func(), whileRunning ...func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for range n {
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	for _, f := range whileRunning {
+		f()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultUploadsMaxAge is how long clients may reuse an uploaded file. Uploads
+// get a fresh name every time, so a file never changes under the same URL.
+const DefaultUploadsMaxAge = 24 * time.Hour
+
+// staticContentTypes pins the types of the image extensions uploads are stored
+// with, rather than trusting the host's MIME tables
+var staticContentTypes = map[string]string{
+	".gif":  "image/gif",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+}
+
+// staticCSP stops a served file from running scripts or loading anything even
+// if a browser ends up rendering it as a page
+const staticCSP = "default-src 'none'; sandbox"
+
+// StaticFiles serves files from dir like http.FileServer, adding a
+// Cache-Control max-age, an ETag for revalidation and fixed image types.
+// A zero maxAge makes clients revalidate every time. Directories are never
+// listed, only files fetched by name are served. Anything that isn't one of
+// the image types is sent as an opaque download, so markup that slips into
+// the directory can't be opened as a page on the API's origin.
+func StaticFiles(dir string, maxAge time.Duration) http.Handler {
+	fileServer := http.FileServer(http.Dir(dir))
+	cacheControl := "no-cache"
+	if maxAge > 0 {
+		cacheControl = "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || info.IsDir() {
+			// Directory listings would let anyone enumerate every upload
+			http.NotFound(w, r)
+			return
+		}
+
+		// Size and modification time change whenever the file does
+		w.Header().Set("ETag", fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+		w.Header().Set("Cache-Control", cacheControl)
+		if contentType, ok := staticContentTypes[strings.ToLower(path.Ext(name))]; ok {
+			w.Header().Set("Content-Type", contentType)
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", "attachment")
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", staticCSP)
+		fileServer.ServeHTTP(w, r)
+	})
+}
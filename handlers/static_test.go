@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticFilesCacheHeaders(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "avatar.JPG"), []byte("not really a jpeg"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	handler := http.StripPrefix("/uploads/", StaticFiles(dir, time.Hour))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/uploads/avatar.JPG", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "public, max-age=3600" {
+		t.Errorf("Expected Cache-Control public, max-age=3600, got %q", cacheControl)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "image/jpeg" {
+		t.Errorf("Expected Content-Type image/jpeg, got %q", contentType)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+
+	// Revalidating with the ETag doesn't resend the file
+	req := httptest.NewRequest("GET", "/uploads/avatar.JPG", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 for a matching ETag, got %d", w.Code)
+	}
+
+	// Missing files aren't marked cacheable
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/uploads/missing.png", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "" {
+		t.Errorf("Expected no Cache-Control on a 404, got %q", cacheControl)
+	}
+}
+
+func TestStaticFilesNoMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "avatar.png"), []byte("png"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	StaticFiles(dir, 0).ServeHTTP(w, httptest.NewRequest("GET", "/avatar.png", nil))
+
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "no-cache" {
+		t.Errorf("Expected Cache-Control no-cache, got %q", cacheControl)
+	}
+}
+
+func TestStaticFilesNoDirectoryListing(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "avatar.png"), []byte("png"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested"), 0750); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	handler := http.StripPrefix("/uploads/", StaticFiles(dir, time.Hour))
+
+	for path, expected := range map[string]int{
+		"/uploads/":            http.StatusNotFound,
+		"/uploads/nested/":     http.StatusNotFound,
+		"/uploads/nested":      http.StatusNotFound,
+		"/uploads/avatar.png":  http.StatusOK,
+		"/uploads/../uploads/": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != expected {
+			t.Errorf("Expected status %d for %s, got %d", expected, path, w.Code)
+		}
+		if expected == http.StatusNotFound && strings.Contains(w.Body.String(), "avatar.png") {
+			t.Errorf("Expected no listing for %s, got %q", path, w.Body.String())
+		}
+	}
+}
+
+func TestStaticFilesXSSHeaders(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"avatar.png": "<html><script>alert(1)</script></html>",
+		"evil.html":  "<html><script>alert(1)</script></html>",
+		"evil.svg":   `<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	handler := http.StripPrefix("/uploads/", StaticFiles(dir, time.Hour))
+
+	tests := []struct {
+		name                string
+		path                string
+		expectedType        string
+		expectedDisposition string
+	}{
+		{name: "image_shown_inline", path: "/uploads/avatar.png", expectedType: "image/png"},
+		{name: "html_downloaded", path: "/uploads/evil.html", expectedType: "application/octet-stream", expectedDisposition: "attachment"},
+		{name: "svg_downloaded", path: "/uploads/evil.svg", expectedType: "application/octet-stream", expectedDisposition: "attachment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("Expected nosniff, got %q", got)
+			}
+			if got := w.Header().Get("Content-Security-Policy"); !strings.HasPrefix(got, "default-src 'none'") {
+				t.Errorf("Expected a default-src 'none' policy, got %q", got)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.expectedType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.expectedType, got)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.expectedDisposition {
+				t.Errorf("Expected Content-Disposition %q, got %q", tt.expectedDisposition, got)
+			}
+		})
+	}
+}
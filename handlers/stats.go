@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/froggu-tantei/ToT/models"
+	"golang.org/x/sync/errgroup"
+)
+
+// statsCacheKey is the only key in the stats cache, the endpoint takes no parameters
+const statsCacheKey = "stats"
+
+// GetStatsHandler returns global totals for the public dashboard. The numbers
+// are cached briefly, a dashboard doesn't need them to the second, and
+// requests arriving together while they're stale share one set of queries.
+func (cfg *APIConfig) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if stats, ok := cfg.statsCache.Get(statsCacheKey); ok {
+		RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(stats))
+		return
+	}
+
+	stats, err := coalesce(r.Context(), &cfg.inflight, statsCacheKey, cfg.loadStats)
+	if err != nil {
+		respondWithDBError(w, err, "Error fetching stats")
+		return
+	}
+
+	cfg.statsCache.Set(statsCacheKey, stats, cfg.CacheTTL)
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(stats))
+}
+
+// loadStats queries the totals GetStatsHandler returns
+func (cfg *APIConfig) loadStats(ctx context.Context) (models.Stats, error) {
+	var stats models.Stats
+	var err error
+	if stats.TotalUsers, err = cfg.DB.CountUsers(ctx); err != nil {
+		return stats, &queryError{"Error counting users", err}
+	}
+	if stats.TotalGames, err = cfg.DB.CountGames(ctx); err != nil {
+		return stats, &queryError{"Error counting games", err}
+	}
+	if stats.TotalLastPlaces, err = cfg.DB.SumLastPlaceCounts(ctx); err != nil {
+		return stats, &queryError{"Error counting last places", err}
+	}
+	if stats.SignupsLast24h, err = cfg.DB.CountRecentUsers(ctx); err != nil {
+		return stats, &queryError{"Error counting signups", err}
+	}
+	return stats, nil
+}
+
+// wantsSummary reports whether the client opted in with ?summary=true to the
+// aggregates in models.Summary next to a list page
+func wantsSummary(r *http.Request) bool {
+	wants, _ := strconv.ParseBool(r.URL.Query().Get("summary"))
+	return wants
+}
+
+// loadSummary runs the aggregate queries for models.Summary side by side
+func (cfg *APIConfig) loadSummary(ctx context.Context) (*models.Summary, error) {
+	var summary models.Summary
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		summary.SignupsLast24h, err = cfg.DB.CountRecentUsers(ctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		summary.TotalLastPlaces, err = cfg.DB.SumLastPlaceCounts(ctx)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statsDB returns fixed totals and counts how often the users were counted
+func statsDB(queries *int) *mockDB {
+	return &mockDB{
+		countUsers: func(ctx context.Context) (int64, error) {
+			*queries++
+			return 42, nil
+		},
+		countGames: func(ctx context.Context) (int64, error) {
+			return 17, nil
+		},
+		sumLastPlaceCounts: func(ctx context.Context) (int64, error) {
+			return 15, nil
+		},
+		countRecentUsers: func(ctx context.Context) (int64, error) {
+			return 3, nil
+		},
+	}
+}
+
+func TestGetStatsHandler(t *testing.T) {
+	queries := 0
+	apiCfg := NewAPIConfig(statsDB(&queries), newMockStorage())
+
+	w := httptest.NewRecorder()
+	apiCfg.GetStatsHandler(w, httptest.NewRequest("GET", "/v1/stats", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var resp struct {
+		Success bool             `json:"success"`
+		Data    map[string]int64 `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := map[string]int64{
+		"total_users":       42,
+		"total_games":       17,
+		"total_last_places": 15,
+		"signups_last_24h":  3,
+	}
+	if !resp.Success || len(resp.Data) != len(expected) {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+	for key, value := range expected {
+		if resp.Data[key] != value {
+			t.Errorf("Expected %s=%d, got %d", key, value, resp.Data[key])
+		}
+	}
+}
+
+func TestGetStatsHandlerCaches(t *testing.T) {
+	queries := 0
+	apiCfg := NewAPIConfig(statsDB(&queries), newMockStorage())
+	apiCfg.CacheTTL = time.Hour
+
+	for range 3 {
+		w := httptest.NewRecorder()
+		apiCfg.GetStatsHandler(w, httptest.NewRequest("GET", "/v1/stats", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("Expected the stats to be queried once, got %d", queries)
+	}
+
+	// Once dropped from the cache the next request queries again
+	apiCfg.statsCache.Delete(statsCacheKey)
+	apiCfg.GetStatsHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/stats", nil))
+	if queries != 2 {
+		t.Errorf("Expected a fresh query after expiry, got %d queries", queries)
+	}
+}
+
+func TestGetStatsHandlerErrorNotCached(t *testing.T) {
+	queries := 0
+	db := statsDB(&queries)
+	db.countGames = func(ctx context.Context) (int64, error) {
+		return 0, errors.New("connection reset")
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+	apiCfg.CacheTTL = time.Hour
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		apiCfg.GetStatsHandler(w, httptest.NewRequest("GET", "/v1/stats", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500, got %d", w.Code)
+		}
+	}
+	if queries != 2 {
+		t.Errorf("Expected a failed result not to be cached, got %d queries", queries)
+	}
+}
+
+func TestGetStatsHandlerCoalescesQueries(t *testing.T) {
+	const requests = 10
+	var queries atomic.Int32
+	wait, release := blockingQuery(&queries)
+	db := statsDB(new(int))
+	db.countUsers = func(ctx context.Context) (int64, error) {
+		wait()
+		return 42, nil
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+
+	var failed atomic.Int32
+	concurrently(requests, func() {
+		w := httptest.NewRecorder()
+		apiCfg.GetStatsHandler(w, httptest.NewRequest("GET", "/v1/stats", nil))
+		if w.Code != http.StatusOK {
+			failed.Add(1)
+		}
+	}, release)
+
+	if n := failed.Load(); n != 0 {
+		t.Errorf("Expected every request to succeed, %d didn't", n)
+	}
+	if n := queries.Load(); n != 1 {
+		t.Errorf("Expected one query for %d identical requests, got %d", requests, n)
+	}
+}
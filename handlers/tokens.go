@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// setTokenCookie also delivers the access token as an httpOnly cookie when
+// TokenCookie is enabled, keeping it out of reach of injected scripts. A
+// script-readable CSRF token is issued with it for the double-submit check.
+func (cfg *APIConfig) setTokenCookie(w http.ResponseWriter, token string) error {
+	if !cfg.TokenCookie {
+		return nil
+	}
+
+	csrfToken, err := middleware.NewCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	// The token was just signed, so auth is configured and this only fails if that changed since
+	maxAge := 0
+	if access, _, err := auth.TokenExpiries(); err == nil {
+		maxAge = int(access.Seconds())
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.AccessTokenCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.CSRFCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// RefreshTokenHandler exchanges a valid refresh token for a new access and refresh token pair
+func (cfg *APIConfig) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if req.RefreshToken == "" {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Refresh token is required"))
+		return
+	}
+
+	// Validate refresh token
+	claims, err := auth.ValidateRefreshToken(req.RefreshToken)
+	if errors.Is(err, auth.ErrAuthNotConfigured) {
+		RespondWithJSON(w, http.StatusServiceUnavailable, models.NewErrorResponse("Authentication not configured"))
+		return
+	}
+	if err != nil {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Invalid or expired refresh token"))
+		return
+	}
+
+	// Make sure the user still exists and pick up current claims data
+	user, err := cfg.DB.GetUserByID(r.Context(), claims.UserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Invalid or expired refresh token"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Database error")
+		return
+	}
+
+	// Issue a fresh token pair
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
+		return
+	}
+	refreshToken, err := auth.GenerateRefreshToken(user)
+	if err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
+		return
+	}
+
+	if err := cfg.setTokenCookie(w, token); err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(map[string]any{
+		"token":         token,
+		"refresh_token": refreshToken,
+	}))
+}
+
+// RefreshClaimsHandler issues the caller a new access token from their
+// current account, without logging in again or rotating the refresh token.
+// Like RefreshTokenHandler it takes the refresh token, so an access token
+// alone can't keep minting successors past JWT_REFRESH_EXPIRY. Tokens carry
+// nothing beyond the user ID, so a role change already applies on the next
+// request; this re-confirms the account is still active and returns it with
+// the new token, so a client can pick up the change at once.
+func (cfg *APIConfig) RefreshClaimsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user from context (set by AuthMiddleware)
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Unauthorized"))
+		return
+	}
+
+	// Parse request
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Refresh token is required"))
+		return
+	}
+
+	// The refresh token bounds the session and must belong to the caller
+	refreshClaims, err := auth.ValidateRefreshToken(req.RefreshToken)
+	if errors.Is(err, auth.ErrAuthNotConfigured) {
+		RespondWithJSON(w, http.StatusServiceUnavailable, models.NewErrorResponse("Authentication not configured"))
+		return
+	}
+	if err != nil || refreshClaims.UserID != claims.UserID {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Invalid or expired refresh token"))
+		return
+	}
+
+	// Deleted users aren't found, so they can't keep extending a token
+	user, err := cfg.DB.GetUserByID(r.Context(), claims.UserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Account is no longer active"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Database error")
+		return
+	}
+
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
+		return
+	}
+	if err := cfg.setTokenCookie(w, token); err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(map[string]any{
+		"user":  cfg.userModel(r, user),
+		"token": token,
+	}))
+}
+
+// TokenIntrospection describes the decoded claims of the caller's access
+// token. Tokens don't carry the username or email, /v1/me has the current ones.
+type TokenIntrospection struct {
+	UserID              uuid.UUID `json:"user_id"`
+	TokenType           string    `json:"token_type"`
+	IssuedAt            time.Time `json:"issued_at"`
+	ExpiresAt           time.Time `json:"expires_at"`
+	RemainingTTLSeconds int64     `json:"remaining_ttl_seconds"`
+}
+
+// IntrospectTokenHandler returns the claims the server decoded from the caller's token
+func (cfg *APIConfig) IntrospectTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user from context (set by AuthMiddleware)
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Unauthorized"))
+		return
+	}
+
+	introspection := TokenIntrospection{
+		UserID:    claims.UserID,
+		TokenType: claims.TokenType,
+	}
+	if introspection.TokenType == "" {
+		introspection.TokenType = auth.TokenTypeAccess // Tokens issued before typed tokens
+	}
+	if claims.IssuedAt != nil {
+		introspection.IssuedAt = claims.IssuedAt.Time.UTC()
+	}
+	if claims.ExpiresAt != nil {
+		introspection.ExpiresAt = claims.ExpiresAt.Time.UTC()
+		introspection.RemainingTTLSeconds = max(0, int64(time.Until(claims.ExpiresAt.Time).Seconds()))
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(introspection))
+}
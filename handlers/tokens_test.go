@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestIntrospectTokenHandler(t *testing.T) {
+	configureAuth(t, 30*time.Minute)
+
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "test@example.com"}
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+
+	apiCfg := &APIConfig{}
+	req := httptest.NewRequest("GET", "/v1/token/introspect", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, claims))
+	w := httptest.NewRecorder()
+
+	apiCfg.IntrospectTokenHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		Data TokenIntrospection `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	if response.Data.UserID != user.ID {
+		t.Errorf("Expected user ID %s, got %s", user.ID, response.Data.UserID)
+	}
+	if !response.Data.ExpiresAt.Equal(claims.ExpiresAt.Time) {
+		t.Errorf("Expected expires_at %v, got %v", claims.ExpiresAt.Time, response.Data.ExpiresAt)
+	}
+	if ttl := time.Duration(response.Data.RemainingTTLSeconds) * time.Second; ttl <= 29*time.Minute || ttl > 30*time.Minute {
+		t.Errorf("Expected remaining TTL close to 30m, got %v", ttl)
+	}
+	if response.Data.TokenType != auth.TokenTypeAccess {
+		t.Errorf("Expected token type %q, got %q", auth.TokenTypeAccess, response.Data.TokenType)
+	}
+}
+
+func TestIntrospectTokenHandlerUnauthorized(t *testing.T) {
+	apiCfg := &APIConfig{}
+	req := httptest.NewRequest("GET", "/v1/token/introspect", nil)
+	w := httptest.NewRecorder()
+
+	apiCfg.IntrospectTokenHandler(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestRefreshClaimsHandler(t *testing.T) {
+	configureAuth(t, time.Hour)
+
+	user := database.User{ID: uuid.New(), Username: "testuser", Role: models.RoleUser}
+	deleted := false
+	apiCfg := &APIConfig{DB: &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			if id != user.ID || deleted {
+				return database.User{}, pgx.ErrNoRows
+			}
+			return user, nil
+		},
+	}}
+	admin := middleware.AuthMiddleware(apiCfg.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	refreshToken, err := auth.GenerateRefreshToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+	refreshClaims := func(refreshToken string) *httptest.ResponseRecorder {
+		body := strings.NewReader(`{"refresh_token":"` + refreshToken + `"}`)
+		w := httptest.NewRecorder()
+		apiCfg.RefreshClaimsHandler(w, withClaims(httptest.NewRequest("POST", "/v1/token/refresh-claims", body), user.ID))
+		return w
+	}
+
+	// The user is promoted after their token was issued
+	user.Role = models.RoleAdmin
+	w := refreshClaims(refreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if _, ok := response.Data["refresh_token"]; ok {
+		t.Error("Expected the refresh token to be left alone")
+	}
+	var token string
+	if err := json.Unmarshal(response.Data["token"], &token); err != nil || token == "" {
+		t.Fatalf("Expected a token, got %s", response.Data["token"])
+	}
+
+	// The new token carries the promotion
+	req := httptest.NewRequest("GET", "/v1/admin/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the new token to have admin access, got status %d", w.Code)
+	}
+
+	// An access token alone, or another user's refresh token, can't mint
+	// new tokens, so a leaked access token still runs out
+	otherRefreshToken, err := auth.GenerateRefreshToken(database.User{ID: uuid.New()})
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+	for name, tt := range map[string]struct {
+		refreshToken string
+		status       int
+	}{
+		"missing":       {refreshToken: "", status: http.StatusBadRequest},
+		"access_token":  {refreshToken: token, status: http.StatusUnauthorized},
+		"another_users": {refreshToken: otherRefreshToken, status: http.StatusUnauthorized},
+	} {
+		if w := refreshClaims(tt.refreshToken); w.Code != tt.status {
+			t.Errorf("%s refresh token: expected status %d, got %d", name, tt.status, w.Code)
+		}
+	}
+
+	// A deleted account can't mint itself new tokens
+	deleted = true
+	w = refreshClaims(refreshToken)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for a deleted user, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if strings.Contains(w.Body.String(), `"token"`) {
+		t.Errorf("Expected no token for a deleted user, got %s", w.Body.String())
+	}
+}
@@ -1,8 +1,11 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -16,16 +19,41 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// Unique indexes on the users table. They only cover active accounts, so a
+// soft-deleted user's email and username can be registered again.
+const (
+	uniqueViolation  = "23505" // Postgres error code for a duplicate key
+	usersEmailKey    = "users_email_key"
+	usersUsernameKey = "users_username_key"
+)
+
+// duplicateUserMessage returns the conflict message for the field whose unique
+// constraint err violated, or "" if err isn't a unique violation
+func duplicateUserMessage(err error) string {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+		return ""
+	}
+	switch pgErr.ConstraintName {
+	case usersEmailKey:
+		return "Email already registered"
+	case usersUsernameKey:
+		return "Username already taken"
+	default:
+		return "User already exists"
+	}
+}
+
 // SignupHandler registers a new user
 func (cfg *APIConfig) SignupHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var req models.CreateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid request format"))
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
 		return
 	}
 
@@ -37,46 +65,30 @@ func (cfg *APIConfig) SignupHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Add email format validation
 	if !isValidEmail(req.Email) {
-		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid email format"))
+		RespondWithJSON(w, http.StatusUnprocessableEntity, models.NewErrorResponse("Invalid email format"))
 		return
 	}
 
 	// Add password length validation
 	if len(req.Password) < 6 {
-		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Password must be at least 6 characters"))
+		RespondWithJSON(w, http.StatusUnprocessableEntity, models.NewErrorResponse("Password must be at least 6 characters"))
 		return
 	}
 
 	// Validate bio length
 	if len(req.Bio) > 200 {
-		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Bio cannot exceed 200 characters"))
+		RespondWithJSON(w, http.StatusUnprocessableEntity, models.NewErrorResponse("Bio cannot exceed 200 characters"))
 		return
 	}
 
-	// Check if email already exists
-	_, err := cfg.DB.GetUserByEmail(r.Context(), req.Email)
-	if err == nil {
-		RespondWithJSON(w, http.StatusConflict, models.NewErrorResponse("Email already registered"))
-		return
-	} else if !errors.Is(err, pgx.ErrNoRows) {
-		// Other database error
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
-		return
-	}
-
-	// Check if username already exists
-	_, err = cfg.DB.GetUserByUsername(r.Context(), req.Username)
-	if err == nil {
-		RespondWithJSON(w, http.StatusConflict, models.NewErrorResponse("Username already taken"))
-		return
-	} else if !errors.Is(err, pgx.ErrNoRows) {
-		// Other database error
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
-		return
+	// A weak password is still accepted, the client is only told about it
+	var warnings []string
+	if msg := passwordWarning(req.Password); msg != "" {
+		warnings = append(warnings, msg)
 	}
 
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := cfg.hasher().Hash(req.Password)
 	if err != nil {
 		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error processing password"))
 		return
@@ -85,31 +97,47 @@ func (cfg *APIConfig) SignupHandler(w http.ResponseWriter, r *http.Request) {
 	// Create user in database
 	user, err := cfg.DB.CreateUser(r.Context(), database.CreateUserParams{
 		Email:          req.Email,
-		PasswordHash:   string(hashedPassword),
+		PasswordHash:   hashedPassword,
 		Username:       req.Username,
 		Bio:            pgtype.Text{String: req.Bio, Valid: req.Bio != ""},
 		ProfilePicture: pgtype.Text{String: "", Valid: false},
 	})
-	if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error creating user"))
+	// The unique constraints catch duplicates without a racy lookup first
+	if msg := duplicateUserMessage(err); msg != "" {
+		RespondWithJSON(w, http.StatusConflict, models.NewErrorResponse(msg))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Error creating user")
 		return
 	}
 
-	// Generate JWT token
+	// Generate JWT access and refresh tokens
 	token, err := auth.GenerateToken(user)
 	if err != nil {
 		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
 		return
 	}
+	refreshToken, err := auth.GenerateRefreshToken(user)
+	if err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
+		return
+	}
 
 	// Convert to API model
 	userModel := models.DatabaseUserToUser(user)
+	if err := cfg.setTokenCookie(w, token); err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
+		return
+	}
 
 	// Return the user and token
-	RespondWithJSON(w, http.StatusCreated, models.NewSuccessResponse(map[string]any{
-		"user":  userModel,
-		"token": token,
-	}))
+	response := models.NewSuccessResponse(map[string]any{
+		"user":          userModel,
+		"token":         token,
+		"refresh_token": refreshToken,
+	})
+	response.Warnings = warnings
+	RespondWithJSON(w, http.StatusCreated, response)
 }
 
 // LoginHandler handles user authentication
@@ -119,8 +147,8 @@ func (cfg *APIConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid request format"))
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
 		return
 	}
 
@@ -136,34 +164,73 @@ func (cfg *APIConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Invalid email or password"))
 		return
 	} else if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
+		respondWithDBError(w, err, "Database error")
 		return
 	}
 
 	// Verify password
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
-	if err != nil {
+	hasher := cfg.hasher()
+	if err := hasher.Compare(user.PasswordHash, req.Password); err != nil {
 		RespondWithJSON(w, http.StatusUnauthorized, models.NewErrorResponse("Invalid email or password"))
 		return
 	}
 
-	// Generate JWT token
+	// Upgrade hashes from an older algorithm or cost while we have the plaintext
+	if hasher.NeedsRehash(user.PasswordHash) {
+		user = cfg.rehashPassword(r.Context(), user, req.Password)
+	}
+
+	// Generate JWT access and refresh tokens
 	token, err := auth.GenerateToken(user)
 	if err != nil {
 		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
 		return
 	}
+	refreshToken, err := auth.GenerateRefreshToken(user)
+	if err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
+		return
+	}
 
 	// Convert to API model
 	userModel := models.DatabaseUserToUser(user)
+	if err := cfg.setTokenCookie(w, token); err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error generating authentication token"))
+		return
+	}
 
 	// Return user and token
 	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(map[string]any{
-		"user":  userModel,
-		"token": token,
+		"user":          userModel,
+		"token":         token,
+		"refresh_token": refreshToken,
 	}))
 }
 
+// rehashPassword stores the password under the configured hasher. Failure only
+// delays the upgrade to the next login, so it is logged rather than returned.
+func (cfg *APIConfig) rehashPassword(ctx context.Context, user database.User, password string) database.User {
+	hashedPassword, err := cfg.hasher().Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %s: %v", user.ID, err)
+		return user
+	}
+
+	updated, err := cfg.DB.UpdateUser(ctx, database.UpdateUserParams{
+		ID:             user.ID,
+		Email:          user.Email,
+		PasswordHash:   hashedPassword,
+		Username:       user.Username,
+		Bio:            user.Bio,
+		ProfilePicture: user.ProfilePicture,
+	})
+	if err != nil {
+		log.Printf("Failed to store rehashed password for user %s: %v", user.ID, err)
+		return user
+	}
+	return updated
+}
+
 // GetMeHandler returns the authenticated user's profile
 func (cfg *APIConfig) GetMeHandler(w http.ResponseWriter, r *http.Request) {
 	// Get user from context (set by AuthMiddleware)
@@ -179,12 +246,12 @@ func (cfg *APIConfig) GetMeHandler(w http.ResponseWriter, r *http.Request) {
 		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
 		return
 	} else if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
+		respondWithDBError(w, err, "Database error")
 		return
 	}
 
 	// Return user data
-	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(models.DatabaseUserToUser(user)))
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(cfg.userModel(r, user)))
 }
 
 // GetUserByIDHandler returns a user by ID
@@ -203,18 +270,30 @@ func (cfg *APIConfig) GetUserByIDHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Validate the field selection before touching the database
+	fields, err := parseUserFields(r)
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid fields parameter: "+err.Error()))
+		return
+	}
+
 	// Get user from database
 	user, err := cfg.DB.GetUserByID(r.Context(), id)
 	if errors.Is(err, pgx.ErrNoRows) {
 		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
 		return
 	} else if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
+		respondWithDBError(w, err, "Database error")
 		return
 	}
 
 	// Return user data
-	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(models.DatabaseUserToUser(user)))
+	data, err := selectUserFields(cfg.userModel(r, user), fields)
+	if err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error encoding user"))
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(data))
 }
 
 // GetUserByUsernameHandler returns a user by username
@@ -226,18 +305,30 @@ func (cfg *APIConfig) GetUserByUsernameHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// Validate the field selection before touching the database
+	fields, err := parseUserFields(r)
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid fields parameter: "+err.Error()))
+		return
+	}
+
 	// Get user from database
 	user, err := cfg.DB.GetUserByUsername(r.Context(), username)
 	if errors.Is(err, pgx.ErrNoRows) {
 		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
 		return
 	} else if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
+		respondWithDBError(w, err, "Database error")
 		return
 	}
 
 	// Return user data
-	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(models.DatabaseUserToUser(user)))
+	data, err := selectUserFields(cfg.userModel(r, user), fields)
+	if err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error encoding user"))
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(data))
 }
 
 // UpdateUserHandler updates user information
@@ -271,8 +362,8 @@ func (cfg *APIConfig) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 
 	// Parse request
 	var req models.UpdateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid request format"))
+	if err := cfg.decodeJSON(r, &req); err != nil {
+		respondWithDecodeError(w, err)
 		return
 	}
 
@@ -282,7 +373,7 @@ func (cfg *APIConfig) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
 		return
 	} else if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
+		respondWithDBError(w, err, "Database error")
 		return
 	}
 
@@ -298,22 +389,9 @@ func (cfg *APIConfig) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 
 	// Update fields if provided - ADD VALIDATION HERE
 	if req.Email != "" && req.Email != currentUser.Email {
-		// Validate email format
-		if !isValidEmail(req.Email) {
-			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid email format"))
-			return
-		}
-
-		// Check if new email is already taken
-		_, err := cfg.DB.GetUserByEmail(r.Context(), req.Email)
-		if err == nil {
-			RespondWithJSON(w, http.StatusConflict, models.NewErrorResponse("Email already in use"))
-			return
-		} else if !errors.Is(err, pgx.ErrNoRows) {
-			RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
-			return
-		}
-		updateParams.Email = req.Email
+		// The new address has to confirm it, see RequestEmailChangeHandler
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Email changes must be confirmed, use POST /v1/users/{id}/email-change"))
+		return
 	}
 
 	if req.Username != "" && req.Username != currentUser.Username {
@@ -323,46 +401,76 @@ func (cfg *APIConfig) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 			RespondWithJSON(w, http.StatusConflict, models.NewErrorResponse("Username already in use"))
 			return
 		} else if !errors.Is(err, pgx.ErrNoRows) {
-			RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
+			respondWithDBError(w, err, "Database error")
 			return
 		}
 		updateParams.Username = req.Username
 	}
 
+	// Advice about accepted input, returned alongside the updated user
+	var warnings []string
+
 	if req.Password != "" {
 		// ADD: Validate password length
 		if len(req.Password) < 6 {
-			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Password must be at least 6 characters"))
+			RespondWithJSON(w, http.StatusUnprocessableEntity, models.NewErrorResponse("Password must be at least 6 characters"))
 			return
 		}
+		if msg := passwordWarning(req.Password); msg != "" {
+			warnings = append(warnings, msg)
+		}
 
 		// Hash new password
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		hashedPassword, err := cfg.hasher().Hash(req.Password)
 		if err != nil {
 			RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error processing password"))
 			return
 		}
-		updateParams.PasswordHash = string(hashedPassword)
+		updateParams.PasswordHash = hashedPassword
 	}
 
 	if req.Bio != "" && req.Bio != currentUser.Bio.String {
 		// Validate bio length
 		if len(req.Bio) > 200 {
-			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Bio cannot exceed 200 characters"))
+			RespondWithJSON(w, http.StatusUnprocessableEntity, models.NewErrorResponse("Bio cannot exceed 200 characters"))
 			return
 		}
 		updateParams.Bio = pgtype.Text{String: req.Bio, Valid: true}
 	}
 
+	if req.AvatarURL != "" {
+		if len(cfg.AvatarURLHosts) == 0 {
+			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("External avatars are not enabled, upload a profile picture instead"))
+			return
+		}
+		avatarURL, msg := validateAvatarURL(req.AvatarURL, cfg.AvatarURLHosts)
+		if msg != "" {
+			RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse(msg))
+			return
+		}
+		updateParams.ProfilePicture = pgtype.Text{String: avatarURL, Valid: true}
+	}
+
 	// Update user in database
 	updatedUser, err := cfg.DB.UpdateUser(r.Context(), updateParams)
-	if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error updating user"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Deleted since it was read above
+		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
+		return
+	} else if err != nil {
+		respondWithDBError(w, err, "Error updating user")
 		return
 	}
 
+	// An uploaded picture replaced by an external one is no longer referenced
+	if old := currentUser.ProfilePicture.String; req.AvatarURL != "" && old != "" && old != updatedUser.ProfilePicture.String && !isExternalAvatar(old) {
+		_ = cfg.FileStorage.Delete(old) // Errors are already logged in the implementation
+	}
+
 	// Return updated user
-	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(models.DatabaseUserToUser(updatedUser)))
+	response := models.NewSuccessResponse(models.DatabaseUserToUser(updatedUser))
+	response.Warnings = warnings
+	RespondWithJSON(w, http.StatusOK, response)
 }
 
 // DeleteUserHandler deletes a user account
@@ -394,12 +502,24 @@ func (cfg *APIConfig) DeleteUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Delete user from database
-	err = cfg.DB.DeleteUser(r.Context(), id)
+	// Soft-delete like the admin bulk delete does: the row's updated_at moves,
+	// so the leaderboard's Last-Modified does too and clients don't get a 304
+	// for a board that still lists the user
+	deleted, err := cfg.DB.SoftDeleteUsers(r.Context(), []uuid.UUID{id})
 	if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error deleting user"))
+		respondWithDBError(w, err, "Error deleting user")
+		return
+	}
+	if len(deleted) == 0 {
+		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
 		return
 	}
+	if picture := deleted[0].ProfilePicture; picture.Valid && picture.String != "" && !isExternalAvatar(picture.String) {
+		if err := cfg.FileStorage.Delete(picture.String); err != nil {
+			log.Printf("Failed to delete profile picture of deleted user %s: %v", id, err)
+		}
+	}
+	cfg.InvalidateLeaderboard()
 
 	// Return success message
 	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(map[string]string{
@@ -429,43 +549,69 @@ func (cfg *APIConfig) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Validate the field selection before touching the database
+	fields, err := parseUserFields(r)
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid fields parameter: "+err.Error()))
+		return
+	}
+
+	// Hide users the caller has blocked; without claims the nil ID hides nobody
+	var viewerID uuid.UUID
+	if claims, ok := middleware.GetUserFromContext(r.Context()); ok {
+		viewerID = claims.UserID
+	}
+
+	// Get total count first, so a page past the end can be clamped before querying
+	totalCount, err := cfg.DB.CountVisibleUsers(r.Context(), viewerID)
+	if err != nil {
+		respondWithDBError(w, err, "Error counting users")
+		return
+	}
+	page = models.ClampPage(page, perPage, totalCount)
+
 	// Calculate offset
 	offset := (page - 1) * perPage
 
 	// Get users with pagination
 	users, err := cfg.DB.ListUsers(r.Context(), database.ListUsersParams{
-		Limit:  int32(perPage),
-		Offset: int32(offset),
+		ViewerID: viewerID,
+		Limit:    int32(perPage),
+		Offset:   int32(offset),
 	})
 	if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error fetching users"))
+		respondWithDBError(w, err, "Error fetching users")
 		return
 	}
 
-	// Get total count for pagination
-	totalCount, err := cfg.DB.CountUsers(r.Context())
+	// Convert database users to API models
+	userModels, err := selectUsersFields(cfg.userModels(r, users), fields)
 	if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error counting users"))
+		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error encoding users"))
 		return
 	}
 
-	// Convert database users to API models
-	userModels := models.DatabaseUsersToUsers(users)
-
 	// Return paginated response
 	response := models.NewPaginatedResponse(
 		userModels,
-		int(totalCount),
+		totalCount,
 		perPage,
 		page,
 	)
+	if wantsSummary(r) {
+		if response.Summary, err = cfg.loadSummary(r.Context()); err != nil {
+			respondWithDBError(w, err, "Error summarizing users")
+			return
+		}
+	}
 
 	RespondWithJSON(w, http.StatusOK, response)
 }
 
 const (
-	MaxUploadSize = 5 * 1024 * 1024 // 5MB
-	UploadsDir    = "uploads"
+	MaxUploadSize          = 5 * 1024 * 1024 // 5MB
+	DefaultMultipartMemory = 1 * 1024 * 1024 // 1MB kept in memory, the rest spills to disk
+	UploadsDir             = "uploads"
 )
 
 var allowedFileTypes = map[string]string{
@@ -474,6 +620,24 @@ var allowedFileTypes = map[string]string{
 	"image/gif":  ".gif",
 }
 
+// multipartMemory returns the in-memory threshold for multipart parsing
+func (cfg *APIConfig) multipartMemory() int64 {
+	if cfg.MultipartMemory <= 0 {
+		return DefaultMultipartMemory
+	}
+	return cfg.MultipartMemory
+}
+
+// cleanupMultipartForm removes temp files spilled by ParseMultipartForm, if any
+func cleanupMultipartForm(r *http.Request) {
+	if r.MultipartForm == nil {
+		return
+	}
+	if err := r.MultipartForm.RemoveAll(); err != nil {
+		log.Printf("Failed to remove multipart temp files: %v", err)
+	}
+}
+
 // UploadProfilePictureHandler handles user profile picture uploads
 func (cfg *APIConfig) UploadProfilePictureHandler(w http.ResponseWriter, r *http.Request) {
 	// Get authenticated user
@@ -503,19 +667,10 @@ func (cfg *APIConfig) UploadProfilePictureHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	// Get current user data
-	currentUser, err := cfg.DB.GetUserByID(r.Context(), id)
-	if errors.Is(err, pgx.ErrNoRows) {
-		RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
-		return
-	} else if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Database error"))
-		return
-	}
-
 	// Limit request size
 	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
-	if err := r.ParseMultipartForm(MaxUploadSize); err != nil {
+	defer cleanupMultipartForm(r)
+	if err := r.ParseMultipartForm(cfg.multipartMemory()); err != nil {
 		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("File too large (max 5MB)"))
 		return
 	}
@@ -533,6 +688,10 @@ func (cfg *APIConfig) UploadProfilePictureHandler(w http.ResponseWriter, r *http
 		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("File too large (max 5MB)"))
 		return
 	}
+	if header.Size == 0 {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("File is empty"))
+		return
+	}
 
 	// Validate filename extension as additional check
 	fileName := header.Filename
@@ -544,10 +703,16 @@ func (cfg *APIConfig) UploadProfilePictureHandler(w http.ResponseWriter, r *http
 
 	// Check file type
 	buff := make([]byte, 512) // 512 bytes for MIME detection
-	if _, err := file.Read(buff); err != nil {
+	n, err := io.ReadFull(file, buff)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
 		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error reading file"))
 		return
 	}
+	if n == 0 {
+		// The header size is client-supplied, so trust what was actually read
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("File is empty"))
+		return
+	}
 
 	// Reset file pointer to beginning
 	if _, err := file.Seek(0, 0); err != nil {
@@ -555,49 +720,104 @@ func (cfg *APIConfig) UploadProfilePictureHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	// Detect MIME type
-	fileType := http.DetectContentType(buff)
+	// Detect MIME type from only the bytes read, small files don't fill the buffer
+	fileType := http.DetectContentType(buff[:n])
 	extension, valid := allowedFileTypes[fileType]
 	if !valid {
 		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("File type not allowed. Please upload JPG, PNG or GIF"))
 		return
 	}
 
+	// Reject images the client can't render sensibly before storing anything
+	width, height, err := imageDimensions(file)
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid or corrupt image"))
+		return
+	}
+	if msg := cfg.ImageConstraints.Check(width, height); msg != "" {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse(msg))
+		return
+	}
+
+	// Re-encode to save storage when enabled, keeping the upload if that doesn't help
+	var upload io.Reader = file
+	compressed, compressedExtension, err := cfg.ImageCompression.compress(file, extension)
+	if errors.Is(err, ErrImageTooLarge) {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse(fmt.Sprintf("Image is too large, maximum is %d megapixels", MaxImagePixels/1_000_000)))
+		return
+	} else if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, models.NewErrorResponse("Invalid or corrupt image"))
+		return
+	}
+	if compressed != nil {
+		upload, extension = compressed, compressedExtension
+	}
+
 	// Generate unique filename
-	uniqueFileName := id.String() + "_" + strconv.FormatInt(time.Now().UnixNano(), 10) + extension
+	uniqueFileName := id.String() + "_" + cfg.newUUID() + extension
 
 	// Store file using storage interface
-	filePath, err := cfg.FileStorage.Store(file, uniqueFileName)
+	filePath, err := cfg.FileStorage.Store(upload, uniqueFileName)
 	if err != nil {
 		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error saving file"))
 		return
 	}
 
-	// Delete old profile picture if exists
-	if currentUser.ProfilePicture.Valid && currentUser.ProfilePicture.String != "" {
-		oldFilePath := currentUser.ProfilePicture.String
-		_ = cfg.FileStorage.Delete(oldFilePath) // Errors are already logged in the implementation
-	}
-
-	// Update user profile with new image path
-	updateParams := database.UpdateUserParams{
+	// Swap the picture in one query, which also tells us the previous one
+	updated, err := cfg.DB.UpdateProfilePicture(r.Context(), database.UpdateProfilePictureParams{
 		ID:             id,
-		Email:          currentUser.Email,
-		PasswordHash:   currentUser.PasswordHash,
-		Username:       currentUser.Username,
-		Bio:            currentUser.Bio,
 		ProfilePicture: pgtype.Text{String: filePath, Valid: true},
-	}
-
-	// Update user in database
-	updatedUser, err := cfg.DB.UpdateUser(r.Context(), updateParams)
+	})
 	if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error updating profile picture"))
+		// The new file is unreferenced, don't leave it behind
+		if deleteErr := cfg.FileStorage.Delete(filePath); deleteErr != nil {
+			log.Printf("Failed to delete unused upload %s: %v", filePath, deleteErr)
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			RespondWithJSON(w, http.StatusNotFound, models.NewErrorResponse("User not found"))
+			return
+		}
+		respondWithDBError(w, err, "Error updating profile picture")
 		return
 	}
 
+	// Delete the old profile picture only once nothing references it
+	if updated.OldProfilePicture.Valid && updated.OldProfilePicture.String != "" && !isExternalAvatar(updated.OldProfilePicture.String) {
+		_ = cfg.FileStorage.Delete(updated.OldProfilePicture.String) // Errors are already logged in the implementation
+	}
+
 	// Return updated user
-	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(models.DatabaseUserToUser(updatedUser)))
+	RespondWithJSON(w, http.StatusOK, models.NewSuccessResponse(models.DatabaseUserToUser(updated.User)))
+}
+
+// leaderboardPage is a cached first page of the leaderboard
+type leaderboardPage struct {
+	response     models.PaginatedResponse
+	lastModified time.Time
+}
+
+// leaderboardCachePrefix starts every leaderboard cache key
+const leaderboardCachePrefix = "leaderboard:"
+
+// leaderboardCacheKey identifies a cached first page by the parameters that change its body
+func leaderboardCacheKey(perPage, minGames int, defaultAvatar bool) string {
+	return fmt.Sprintf("%sper_page=%d:min_games=%d:default_avatar=%t", leaderboardCachePrefix, perPage, minGames, defaultAvatar)
+}
+
+// leaderboardMinGames returns the minimum games to be ranked: the request's
+// ?min_games when it's a valid count, otherwise LeaderboardMinGames
+func (cfg *APIConfig) leaderboardMinGames(r *http.Request) int32 {
+	if parsed, err := strconv.ParseInt(r.URL.Query().Get("min_games"), 10, 32); err == nil && parsed >= 0 {
+		return int32(parsed)
+	}
+	return int32(cfg.LeaderboardMinGames)
+}
+
+// InvalidateLeaderboard drops the cached leaderboard pages and stats, so the
+// next request sees a newly recorded game instead of waiting out CacheTTL.
+func (cfg *APIConfig) InvalidateLeaderboard() {
+	cfg.leaderboardCache.DeletePrefix(leaderboardCachePrefix)
+	cfg.statsCache.Delete(statsCacheKey)
 }
 
 // GetLeaderboardHandler returns a paginated leaderboard based on last_place_count
@@ -622,26 +842,72 @@ func (cfg *APIConfig) GetLeaderboardHandler(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	// Calculate offset
-	offset := (page - 1) * perPage
+	// The first page is what everyone polls, so it's served from the cache while
+	// fresh. Only the configured minimum is cached: ?min_games takes any count,
+	// and caching each one would let clients grow the cache without bound.
+	defaultAvatar := cfg.wantsDefaultAvatar(r)
+	minGames := cfg.leaderboardMinGames(r)
+	cacheKey := leaderboardCacheKey(perPage, int(minGames), defaultAvatar)
+	cacheable := page == 1 && minGames == int32(cfg.LeaderboardMinGames)
+	if cacheable {
+		if cached, ok := cfg.leaderboardCache.Get(cacheKey); ok {
+			if !notModified(w, r, cached.lastModified) {
+				RespondWithJSON(w, http.StatusOK, cached.response)
+			}
+			return
+		}
+	}
 
-	// Get leaderboard with pagination
-	leaderboardRows, err := cfg.DB.GetLeaderBoard(r.Context(), database.GetLeaderBoardParams{
-		Limit:  int32(perPage),
-		Offset: int32(offset),
-	})
+	// The board only changes when a user row does, so polling clients can get
+	// a 304. Identical requests arriving together share each query below.
+	lastModified, err := coalesce(r.Context(), &cfg.inflight, leaderboardCachePrefix+"last_modified", cfg.DB.GetLeaderBoardLastModified)
 	if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error fetching leaderboard"))
+		respondWithDBError(w, err, "Error fetching leaderboard")
+		return
+	}
+	if notModified(w, r, lastModified.Time) {
 		return
 	}
 
-	// Get total count for pagination
-	totalCount, err := cfg.DB.CountUsers(r.Context())
+	flightKey := fmt.Sprintf("%s:page=%d", cacheKey, page)
+	response, err := coalesce(r.Context(), &cfg.inflight, flightKey, func(ctx context.Context) (models.PaginatedResponse, error) {
+		return cfg.loadLeaderboard(ctx, page, perPage, minGames, defaultAvatar)
+	})
 	if err != nil {
-		RespondWithJSON(w, http.StatusInternalServerError, models.NewErrorResponse("Error counting users"))
+		respondWithDBError(w, err, "Error fetching leaderboard")
 		return
 	}
 
+	if cacheable {
+		cfg.leaderboardCache.Set(cacheKey, leaderboardPage{response: response, lastModified: lastModified.Time}, cfg.CacheTTL)
+	}
+
+	RespondWithJSON(w, http.StatusOK, response)
+}
+
+// loadLeaderboard queries a page of the leaderboard, clamping a page past
+// the end to the last one
+func (cfg *APIConfig) loadLeaderboard(ctx context.Context, page, perPage int, minGames int32, defaultAvatar bool) (models.PaginatedResponse, error) {
+	// Get total count first, so a page past the end can be clamped before querying
+	totalCount, err := cfg.DB.CountLeaderBoard(ctx, minGames)
+	if err != nil {
+		return models.PaginatedResponse{}, &queryError{"Error counting users", err}
+	}
+	page = models.ClampPage(page, perPage, totalCount)
+
+	// Calculate offset
+	offset := (page - 1) * perPage
+
+	// Get leaderboard with pagination
+	leaderboardRows, err := cfg.DB.GetLeaderBoard(ctx, database.GetLeaderBoardParams{
+		MinGames: minGames,
+		Limit:    int32(perPage),
+		Offset:   int32(offset),
+	})
+	if err != nil {
+		return models.PaginatedResponse{}, &queryError{"Error fetching leaderboard", err}
+	}
+
 	// Convert leaderboard rows to API models
 	leaderboardEntries := make([]models.User, len(leaderboardRows))
 	for i, row := range leaderboardRows {
@@ -652,15 +918,16 @@ func (cfg *APIConfig) GetLeaderboardHandler(w http.ResponseWriter, r *http.Reque
 			ProfilePicture: row.ProfilePicture.String,
 			Bio:            row.Bio.String,
 		}
+		if defaultAvatar && leaderboardEntries[i].ProfilePicture == "" {
+			leaderboardEntries[i].ProfilePicture = cfg.DefaultAvatarURL
+		}
 	}
 
 	// Return paginated response
-	response := models.NewPaginatedResponse(
+	return models.NewPaginatedResponse(
 		leaderboardEntries,
-		int(totalCount),
+		totalCount,
 		perPage,
 		page,
-	)
-
-	RespondWithJSON(w, http.StatusOK, response)
+	), nil
 }
@@ -2,14 +2,32 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/middleware"
 	"github.com/froggu-tantei/ToT/models"
 	"github.com/froggu-tantei/ToT/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Simple tests that don't require database
@@ -61,9 +79,29 @@ func TestSignupHandlerValidation(t *testing.T) {
 				"password": "testpass123",
 				"bio":      strings.Repeat("a", 201), // 201 characters
 			},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 			expectedError:  "Bio cannot exceed 200 characters",
 		},
+		{
+			name: "invalid_email",
+			requestBody: map[string]string{
+				"username": "testuser",
+				"email":    "not-an-email",
+				"password": "testpass123",
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "Invalid email format",
+		},
+		{
+			name: "password_too_short",
+			requestBody: map[string]string{
+				"username": "testuser",
+				"email":    "test@example.com",
+				"password": "short",
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "Password must be at least 6 characters",
+		},
 		{
 			name:           "invalid_json",
 			requestBody:    "invalid json string",
@@ -105,6 +143,176 @@ func TestSignupHandlerValidation(t *testing.T) {
 	}
 }
 
+func TestDuplicateUserMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "email", err: &pgconn.PgError{Code: uniqueViolation, ConstraintName: usersEmailKey}, expected: "Email already registered"},
+		{name: "username", err: &pgconn.PgError{Code: uniqueViolation, ConstraintName: usersUsernameKey}, expected: "Username already taken"},
+		{name: "wrapped", err: fmt.Errorf("insert: %w", &pgconn.PgError{Code: uniqueViolation, ConstraintName: usersEmailKey}), expected: "Email already registered"},
+		{name: "other_constraint", err: &pgconn.PgError{Code: uniqueViolation, ConstraintName: "users_pkey"}, expected: "User already exists"},
+		{name: "other_pg_error", err: &pgconn.PgError{Code: "23503", ConstraintName: usersEmailKey}, expected: ""},
+		{name: "not_pg_error", err: errors.New("connection refused"), expected: ""},
+		{name: "nil", err: nil, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg := duplicateUserMessage(tt.err); msg != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, msg)
+			}
+		})
+	}
+}
+
+func TestSignupHandlerDuplicate(t *testing.T) {
+	tests := []struct {
+		name          string
+		constraint    string
+		expectedError string
+	}{
+		{name: "email_taken", constraint: usersEmailKey, expectedError: "Email already registered"},
+		{name: "username_taken", constraint: usersUsernameKey, expectedError: "Username already taken"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only CreateUser is stubbed, so a pre-check lookup would panic
+			db := &mockDB{
+				createUser: func(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+					return database.User{}, &pgconn.PgError{Code: uniqueViolation, ConstraintName: tt.constraint}
+				},
+			}
+			apiCfg := NewAPIConfig(db, newMockStorage())
+			apiCfg.Hasher = &auth.BcryptHasher{Cost: bcrypt.MinCost}
+
+			body := `{"email": "test@example.com", "password": "testpass123", "username": "testuser"}`
+			w := httptest.NewRecorder()
+			apiCfg.SignupHandler(w, httptest.NewRequest("POST", "/v1/users", strings.NewReader(body)))
+
+			if w.Code != http.StatusConflict {
+				t.Fatalf("Expected status 409, got %d", w.Code)
+			}
+			var response models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+			if response.Error != tt.expectedError {
+				t.Errorf("Expected error %q, got %q", tt.expectedError, response.Error)
+			}
+		})
+	}
+}
+
+func TestSignupHandlerSoftDeletedEmailReusable(t *testing.T) {
+	// Mimics the partial unique indexes: only active rows conflict
+	type row struct {
+		user    database.User
+		deleted bool
+	}
+	rows := []row{{user: database.User{ID: uuid.New(), Email: "test@example.com", Username: "testuser"}, deleted: true}}
+	db := &mockDB{
+		createUser: func(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+			for _, r := range rows {
+				if r.deleted {
+					continue
+				}
+				if r.user.Email == arg.Email {
+					return database.User{}, &pgconn.PgError{Code: uniqueViolation, ConstraintName: usersEmailKey}
+				}
+				if r.user.Username == arg.Username {
+					return database.User{}, &pgconn.PgError{Code: uniqueViolation, ConstraintName: usersUsernameKey}
+				}
+			}
+			user := database.User{ID: uuid.New(), Email: arg.Email, Username: arg.Username}
+			rows = append(rows, row{user: user})
+			return user, nil
+		},
+	}
+	configureAuth(t, time.Hour)
+	apiCfg := NewAPIConfig(db, newMockStorage())
+	apiCfg.Hasher = &auth.BcryptHasher{Cost: bcrypt.MinCost}
+
+	body := `{"email": "test@example.com", "password": "testpass123", "username": "testuser"}`
+
+	// The soft-deleted account doesn't block signing up again
+	w := httptest.NewRecorder()
+	apiCfg.SignupHandler(w, httptest.NewRequest("POST", "/v1/users", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201 re-registering a soft-deleted email, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// But the new active account does
+	w = httptest.NewRecorder()
+	apiCfg.SignupHandler(w, httptest.NewRequest("POST", "/v1/users", strings.NewReader(body)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for an active duplicate, got %d", w.Code)
+	}
+}
+
+func TestSignupHandlerPasswordWarning(t *testing.T) {
+	db := &mockDB{
+		createUser: func(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+			return database.User{ID: uuid.New(), Email: arg.Email, Username: arg.Username}, nil
+		},
+	}
+	configureAuth(t, time.Hour)
+	apiCfg := NewAPIConfig(db, newMockStorage())
+	apiCfg.Hasher = &auth.BcryptHasher{Cost: bcrypt.MinCost}
+
+	tests := []struct {
+		name     string
+		password string
+		warnings []string
+	}{
+		{name: "weak_password", password: "abcdef", warnings: []string{"Password is weak, use at least 10 characters mixing letters, numbers or symbols"}},
+		{name: "strong_password", password: "correct-horse-42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"email": "test@example.com", "password": "` + tt.password + `", "username": "testuser"}`
+			w := httptest.NewRecorder()
+			apiCfg.SignupHandler(w, httptest.NewRequest("POST", "/v1/users", strings.NewReader(body)))
+
+			// A warning never fails the signup
+			if w.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+			}
+			var response models.SuccessResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+			if !slices.Equal(response.Warnings, tt.warnings) {
+				t.Errorf("Expected warnings %q, got %q", tt.warnings, response.Warnings)
+			}
+		})
+	}
+}
+
+func TestPasswordWarning(t *testing.T) {
+	tests := []struct {
+		password string
+		weak     bool
+	}{
+		{password: "abcdef", weak: true},
+		{password: "abc123", weak: true},
+		{password: "abcdefghijkl", weak: true},
+		{password: "123456789012", weak: true},
+		{password: "ábcdéf1234", weak: false},
+		{password: "Correcthorse", weak: false},
+		{password: "correct-horse-42", weak: false},
+	}
+
+	for _, tt := range tests {
+		if weak := passwordWarning(tt.password) != ""; weak != tt.weak {
+			t.Errorf("passwordWarning(%q): expected weak=%v, got %v", tt.password, tt.weak, weak)
+		}
+	}
+}
+
 func TestLoginHandlerValidation(t *testing.T) {
 	fileStorage := storage.NewLocalStorage("test_uploads", "")
 	apiCfg := &APIConfig{
@@ -183,3 +391,1169 @@ func TestLoginHandlerValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginHandlerLogsRedactedBody(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	db := &mockDB{getUserByEmail: func(ctx context.Context, email string) (database.User, error) {
+		return database.User{}, pgx.ErrNoRows
+	}}
+	apiCfg := &APIConfig{DB: db, LogRequestBodies: true}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"froggu@example.com","password":"hunter2"}`))
+	apiCfg.LoginHandler(httptest.NewRecorder(), req)
+
+	out := logs.String()
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("Expected the password to be masked, got %q", out)
+	}
+	if !strings.Contains(out, `"email":"froggu@example.com"`) || !strings.Contains(out, `"password":"[REDACTED]"`) {
+		t.Errorf("Expected the body with only the password masked, got %q", out)
+	}
+}
+
+func TestLoginHandlerTokenCookie(t *testing.T) {
+	configureAuth(t, 15*time.Minute)
+
+	hash, err := (&auth.BcryptHasher{Cost: bcrypt.MinCost}).Hash("password123")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	db := &mockDB{
+		getUserByEmail: func(ctx context.Context, email string) (database.User, error) {
+			return database.User{ID: uuid.New(), Email: email, Username: "tester", PasswordHash: hash}, nil
+		},
+	}
+
+	for _, enabled := range []bool{false, true} {
+		apiCfg := NewAPIConfig(db, newMockStorage())
+		apiCfg.Hasher = &auth.BcryptHasher{Cost: bcrypt.MinCost}
+		apiCfg.TokenCookie = enabled
+
+		body, _ := json.Marshal(map[string]string{"email": "test@example.com", "password": "password123"})
+		w := httptest.NewRecorder()
+		apiCfg.LoginHandler(w, httptest.NewRequest("POST", "/login", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		var resp struct {
+			Data struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Data.Token == "" {
+			t.Error("Expected the token in the body either way")
+		}
+
+		cookies := w.Result().Cookies()
+		if !enabled {
+			if len(cookies) != 0 {
+				t.Errorf("Expected no cookie by default, got %v", cookies)
+			}
+			continue
+		}
+		byName := map[string]*http.Cookie{}
+		for _, cookie := range cookies {
+			byName[cookie.Name] = cookie
+		}
+		cookie, ok := byName[middleware.AccessTokenCookie]
+		if !ok || cookie.Value != resp.Data.Token {
+			t.Fatalf("Expected the access token in %s, got %v", middleware.AccessTokenCookie, cookies)
+		}
+		if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+			t.Errorf("Expected a Secure, HttpOnly, SameSite=Lax cookie, got %+v", cookie)
+		}
+		if cookie.MaxAge != 15*60 {
+			t.Errorf("Expected the cookie to last as long as the token, got %d seconds", cookie.MaxAge)
+		}
+
+		// The frontend has to read the CSRF token to echo it back
+		csrf, ok := byName[middleware.CSRFCookie]
+		if !ok || csrf.Value == "" || csrf.HttpOnly {
+			t.Errorf("Expected a script-readable CSRF cookie, got %v", cookies)
+		}
+	}
+}
+
+func TestLoginHandlerRehashesPassword(t *testing.T) {
+	configureAuth(t, time.Hour)
+
+	bcryptHash, err := (&auth.BcryptHasher{Cost: bcrypt.MinCost}).Hash("password123")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	argonHasher := &auth.Argon2idHasher{Time: 1, Memory: 64, Threads: 1, KeyLen: 32, SaltLen: 16}
+	argonHash, err := argonHasher.Hash("password123")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		storedHash   string
+		password     string
+		expectStatus int
+		expectRehash bool
+	}{
+		{name: "bcrypt_upgraded_to_argon2id", storedHash: bcryptHash, password: "password123", expectStatus: http.StatusOK, expectRehash: true},
+		{name: "current_hash_left_alone", storedHash: argonHash, password: "password123", expectStatus: http.StatusOK, expectRehash: false},
+		{name: "wrong_password_not_rehashed", storedHash: bcryptHash, password: "wrong", expectStatus: http.StatusUnauthorized, expectRehash: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := database.User{ID: uuid.New(), Email: "test@example.com", Username: "tester", PasswordHash: tt.storedHash}
+			var savedHash string
+			db := &mockDB{
+				getUserByEmail: func(ctx context.Context, email string) (database.User, error) {
+					return user, nil
+				},
+				updateUser: func(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+					savedHash = arg.PasswordHash
+					user.PasswordHash = arg.PasswordHash
+					return user, nil
+				},
+			}
+			apiCfg := NewAPIConfig(db, newMockStorage())
+			apiCfg.Hasher = argonHasher
+
+			body, _ := json.Marshal(map[string]string{"email": "test@example.com", "password": tt.password})
+			w := httptest.NewRecorder()
+			apiCfg.LoginHandler(w, httptest.NewRequest("POST", "/login", bytes.NewReader(body)))
+
+			if w.Code != tt.expectStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectStatus, w.Code)
+			}
+			if rehashed := savedHash != ""; rehashed != tt.expectRehash {
+				t.Fatalf("Expected rehash %v, got %v", tt.expectRehash, rehashed)
+			}
+			if tt.expectRehash {
+				if !strings.HasPrefix(savedHash, "$argon2id$") {
+					t.Errorf("Expected argon2id hash to be stored, got %q", savedHash)
+				}
+				if err := argonHasher.Compare(savedHash, tt.password); err != nil {
+					t.Errorf("Rehashed password does not verify: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMeHandlerMinimalToken(t *testing.T) {
+	configureAuth(t, time.Hour)
+	user := database.User{ID: uuid.New(), Username: "renamed", Email: "new@example.com"}
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	// The token only identifies the user, everything else comes from the database
+	apiCfg := &APIConfig{DB: &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			if id != user.ID {
+				return database.User{}, pgx.ErrNoRows
+			}
+			return user, nil
+		},
+	}}
+	req := httptest.NewRequest("GET", "/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(http.HandlerFunc(apiCfg.GetMeHandler)).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Data models.User `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if response.Data.ID != user.ID || response.Data.Username != user.Username || response.Data.Email != user.Email {
+		t.Errorf("Expected the full profile from the database, got %+v", response.Data)
+	}
+}
+
+func TestUpdateUserHandlerValidation(t *testing.T) {
+	userID := uuid.New()
+	apiCfg := &APIConfig{
+		DB: &mockDB{
+			getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+				return database.User{ID: id, Email: "test@example.com", Username: "testuser"}, nil
+			},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "invalid_json",
+			body:           "{",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid request format",
+		},
+		{
+			name:           "email_change",
+			body:           `{"email":"new@example.com"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Email changes must be confirmed, use POST /v1/users/{id}/email-change",
+		},
+		{
+			name:           "password_too_short",
+			body:           `{"password":"short"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "Password must be at least 6 characters",
+		},
+		{
+			name:           "bio_too_long",
+			body:           `{"bio":"` + strings.Repeat("a", 201) + `"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "Bio cannot exceed 200 characters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := withClaims(httptest.NewRequest("PUT", "/v1/users/"+userID.String(), strings.NewReader(tt.body)), userID)
+			w := httptest.NewRecorder()
+			apiCfg.UpdateUserHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			var response models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+			if response.Error != tt.expectedError {
+				t.Errorf("Expected error %q, got %q", tt.expectedError, response.Error)
+			}
+		})
+	}
+}
+
+func TestUpdateUserHandlerAvatarURL(t *testing.T) {
+	userID := uuid.New()
+	var stored pgtype.Text
+	db := &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			return database.User{ID: id, Email: "test@example.com", Username: "testuser"}, nil
+		},
+		updateUser: func(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+			stored = arg.ProfilePicture
+			return database.User{ID: arg.ID, Email: arg.Email, Username: arg.Username, ProfilePicture: arg.ProfilePicture}, nil
+		},
+	}
+
+	tests := []struct {
+		name           string
+		hosts          []string
+		avatarURL      string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "allowed_host",
+			hosts:          []string{"avatars.example.com"},
+			avatarURL:      "https://avatars.example.com/u/1.png",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "disallowed_host",
+			hosts:          []string{"avatars.example.com"},
+			avatarURL:      "https://evil.example.com/u/1.png",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Avatar URL host is not allowed",
+		},
+		{
+			name:           "plain_http",
+			hosts:          []string{"avatars.example.com"},
+			avatarURL:      "http://avatars.example.com/u/1.png",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Avatar URL must use https",
+		},
+		{
+			name:           "disabled",
+			avatarURL:      "https://avatars.example.com/u/1.png",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "External avatars are not enabled, upload a profile picture instead",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored = pgtype.Text{}
+			apiCfg := &APIConfig{DB: db, AvatarURLHosts: tt.hosts}
+
+			body := `{"avatar_url":"` + tt.avatarURL + `"}`
+			req := withClaims(httptest.NewRequest("PUT", "/v1/users/"+userID.String(), strings.NewReader(body)), userID)
+			w := httptest.NewRecorder()
+			apiCfg.UpdateUserHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				var response models.ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatalf("Failed to parse JSON response: %v", err)
+				}
+				if response.Error != tt.expectedError {
+					t.Errorf("Expected error %q, got %q", tt.expectedError, response.Error)
+				}
+				if stored.Valid {
+					t.Errorf("Expected no update, got profile picture %q", stored.String)
+				}
+				return
+			}
+			if stored.String != tt.avatarURL {
+				t.Errorf("Expected profile picture %q to be stored, got %q", tt.avatarURL, stored.String)
+			}
+			if !strings.Contains(w.Body.String(), tt.avatarURL) {
+				t.Errorf("Expected the avatar URL in the response, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestUpdateUserHandlerDeletedUser(t *testing.T) {
+	userID := uuid.New()
+	db := &mockDB{
+		getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+			return database.User{ID: id, Email: "test@example.com", Username: "testuser"}, nil
+		},
+		// UpdateUser skips deleted users, so one deleted after the read matches no row
+		updateUser: func(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+			return database.User{}, pgx.ErrNoRows
+		},
+	}
+	apiCfg := &APIConfig{DB: db}
+
+	req := withClaims(httptest.NewRequest("PUT", "/v1/users/"+userID.String(), strings.NewReader(`{"bio":"still here"}`)), userID)
+	w := httptest.NewRecorder()
+	apiCfg.UpdateUserHandler(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUploadProfilePictureMultipartMemory(t *testing.T) {
+	// Point multipart temp files at a directory we can inspect
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	userID := uuid.New()
+	db := &mockDB{
+		updateProfilePicture: func(ctx context.Context, arg database.UpdateProfilePictureParams) (database.UpdateProfilePictureRow, error) {
+			return database.UpdateProfilePictureRow{User: database.User{ID: arg.ID, Username: "testuser", Email: "test@example.com", ProfilePicture: arg.ProfilePicture}}, nil
+		},
+	}
+
+	tests := []struct {
+		name            string
+		multipartMemory int64
+	}{
+		{
+			name:            "small_file_in_memory",
+			multipartMemory: DefaultMultipartMemory,
+		},
+		{
+			name:            "file_spills_to_disk",
+			multipartMemory: 1, // Anything over a byte goes to a temp file
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiCfg := &APIConfig{
+				DB:              db,
+				FileStorage:     storage.NewLocalStorage(t.TempDir(), ""),
+				MultipartMemory: tt.multipartMemory,
+			}
+
+			req := newUploadRequest(t, userID, "avatar.png", testPNG(t, 8, 8))
+			w := httptest.NewRecorder()
+
+			apiCfg.UploadProfilePictureHandler(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			if leftover := multipartTempFiles(t, tempDir); len(leftover) != 0 {
+				t.Errorf("Expected multipart temp files to be removed, found %v", leftover)
+			}
+		})
+	}
+}
+
+func TestUploadProfilePictureCleansUpTempFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	userID := uuid.New()
+	apiCfg := &APIConfig{
+		DB: &mockDB{
+			updateProfilePicture: func(ctx context.Context, arg database.UpdateProfilePictureParams) (database.UpdateProfilePictureRow, error) {
+				return database.UpdateProfilePictureRow{User: database.User{ID: arg.ID, ProfilePicture: arg.ProfilePicture}}, nil
+			},
+		},
+		FileStorage:     storage.NewLocalStorage(t.TempDir(), ""),
+		MultipartMemory: 1, // Force every file part to spill to disk
+	}
+
+	tests := []struct {
+		name           string
+		filename       string
+		content        []byte
+		expectedStatus int
+	}{
+		{
+			name:           "valid_upload",
+			filename:       "avatar.png",
+			content:        testPNG(t, 4, 4),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "rejected_extension",
+			filename:       "avatar.txt",
+			content:        testPNG(t, 4, 4),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "rejected_content_type",
+			filename:       "avatar.png",
+			content:        []byte("<html><body>not an image</body></html>"),
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := multipartTempFiles(t, tempDir)
+
+			req := newUploadRequest(t, userID, tt.filename, tt.content)
+			w := httptest.NewRecorder()
+			apiCfg.UploadProfilePictureHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+
+			after := multipartTempFiles(t, tempDir)
+			if len(after) != len(before) {
+				t.Errorf("Expected %d multipart temp files after the handler, found %v", len(before), after)
+			}
+		})
+	}
+}
+
+func TestUploadProfilePictureSingleQuery(t *testing.T) {
+	userID := uuid.New()
+	fileStorage := newMockStorage("/old.png")
+
+	// Only UpdateProfilePicture is stubbed; any other query panics through the nil Store
+	queries := 0
+	db := &mockDB{
+		updateProfilePicture: func(ctx context.Context, arg database.UpdateProfilePictureParams) (database.UpdateProfilePictureRow, error) {
+			queries++
+			return database.UpdateProfilePictureRow{
+				User: database.User{
+					ID:              arg.ID,
+					ProfilePicture:  arg.ProfilePicture,
+					EmailVerifiedAt: pgtype.Timestamp{Time: time.Now(), Valid: true},
+				},
+				OldProfilePicture: pgtype.Text{String: "/old.png", Valid: true},
+			}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, fileStorage)
+
+	w := httptest.NewRecorder()
+	apiCfg.UploadProfilePictureHandler(w, newUploadRequest(t, userID, "avatar.png", testPNG(t, 4, 4)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if queries != 1 {
+		t.Errorf("Expected 1 query, got %d", queries)
+	}
+	var response struct {
+		Data models.User `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if !response.Data.EmailVerified {
+		t.Error("Expected the response to keep the user's verified email")
+	}
+	if _, ok := fileStorage.files["/old.png"]; ok {
+		t.Error("Expected the previous picture to be deleted")
+	}
+	if len(fileStorage.files) != 1 {
+		t.Errorf("Expected only the new picture stored, got %v", fileStorage.files)
+	}
+}
+
+func TestUploadProfilePictureFilename(t *testing.T) {
+	userID := uuid.New()
+	fileStorage := newMockStorage()
+	db := &mockDB{
+		updateProfilePicture: func(ctx context.Context, arg database.UpdateProfilePictureParams) (database.UpdateProfilePictureRow, error) {
+			return database.UpdateProfilePictureRow{User: database.User{ID: arg.ID, ProfilePicture: arg.ProfilePicture}}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, fileStorage)
+	ids := []string{"first", "second"}
+	apiCfg.UUIDGen = func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+
+	for _, expected := range []string{"/" + userID.String() + "_first.png", "/" + userID.String() + "_second.png"} {
+		w := httptest.NewRecorder()
+		apiCfg.UploadProfilePictureHandler(w, newUploadRequest(t, userID, "avatar.png", testPNG(t, 4, 4)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if _, ok := fileStorage.files[expected]; !ok {
+			t.Errorf("Expected the picture stored as %s, got %v", expected, fileStorage.files)
+		}
+	}
+}
+
+func TestUploadProfilePictureUserGone(t *testing.T) {
+	fileStorage := newMockStorage()
+	db := &mockDB{
+		updateProfilePicture: func(ctx context.Context, arg database.UpdateProfilePictureParams) (database.UpdateProfilePictureRow, error) {
+			return database.UpdateProfilePictureRow{}, pgx.ErrNoRows
+		},
+	}
+	apiCfg := NewAPIConfig(db, fileStorage)
+
+	w := httptest.NewRecorder()
+	apiCfg.UploadProfilePictureHandler(w, newUploadRequest(t, uuid.New(), "avatar.png", testPNG(t, 4, 4)))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404, got %d", w.Code)
+	}
+	if len(fileStorage.files) != 0 {
+		t.Errorf("Expected the orphaned upload to be removed, got %v", fileStorage.files)
+	}
+}
+
+func TestDeleteUserHandler(t *testing.T) {
+	userID := uuid.New()
+	var deletedIDs []uuid.UUID
+	fileStorage := newMockStorage("/uploads/avatar.png")
+	db := &mockDB{
+		softDeleteUsers: func(ctx context.Context, ids []uuid.UUID) ([]database.SoftDeleteUsersRow, error) {
+			if slices.Contains(deletedIDs, ids[0]) {
+				return []database.SoftDeleteUsersRow{}, nil
+			}
+			deletedIDs = append(deletedIDs, ids...)
+			return []database.SoftDeleteUsersRow{{ID: ids[0], ProfilePicture: pgtype.Text{String: "/uploads/avatar.png", Valid: true}}}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, fileStorage)
+	apiCfg.CacheTTL = time.Hour
+	cacheKey := leaderboardCacheKey(10, 0, false)
+	apiCfg.leaderboardCache.Set(cacheKey, leaderboardPage{}, apiCfg.CacheTTL)
+
+	del := func(id uuid.UUID) int {
+		req := withClaims(httptest.NewRequest("DELETE", "/v1/users/"+id.String(), nil), userID)
+		req = withURLParams(req, map[string]string{"id": id.String()})
+		w := httptest.NewRecorder()
+		apiCfg.DeleteUserHandler(w, req)
+		return w.Code
+	}
+
+	if code := del(uuid.New()); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for another account, got %d", code)
+	}
+	if code := del(userID); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if !slices.Equal(deletedIDs, []uuid.UUID{userID}) {
+		t.Errorf("Expected only the user to be soft-deleted, got %v", deletedIDs)
+	}
+	if _, ok := fileStorage.files["/uploads/avatar.png"]; ok {
+		t.Error("Expected the profile picture to be removed")
+	}
+	if _, ok := apiCfg.leaderboardCache.Get(cacheKey); ok {
+		t.Error("Expected the cached leaderboard to be dropped")
+	}
+
+	if code := del(userID); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 once already deleted, got %d", code)
+	}
+}
+
+func TestGetLeaderboardHandlerNotModified(t *testing.T) {
+	modified := time.Date(2025, 3, 1, 12, 0, 0, 500, time.UTC)
+	queries := 0
+	db := leaderboardDB(3)
+	db.getLeaderBoardModified = func(ctx context.Context) (pgtype.Timestamp, error) {
+		return pgtype.Timestamp{Time: modified, Valid: true}, nil
+	}
+	db.countLeaderBoard = func(ctx context.Context, minGames int32) (int64, error) {
+		queries++
+		return 3, nil
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+
+	get := func(ifModifiedSince string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/leaderboard", nil)
+		if ifModifiedSince != "" {
+			req.Header.Set("If-Modified-Since", ifModifiedSince)
+		}
+		w := httptest.NewRecorder()
+		apiCfg.GetLeaderboardHandler(w, req)
+		return w
+	}
+
+	first := get("")
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", first.Code)
+	}
+	lastModified := first.Header().Get("Last-Modified")
+	if lastModified != "Sat, 01 Mar 2025 12:00:00 GMT" {
+		t.Fatalf("Unexpected Last-Modified %q", lastModified)
+	}
+
+	second := get(lastModified)
+	if second.Code != http.StatusNotModified {
+		t.Fatalf("Expected status 304, got %d", second.Code)
+	}
+	if second.Body.Len() != 0 {
+		t.Errorf("Expected an empty 304 body, got %q", second.Body.String())
+	}
+	if queries != 1 {
+		t.Errorf("Expected the 304 to skip the leaderboard queries, got %d count queries", queries)
+	}
+
+	// A game recorded since the client's copy makes the board fresh again
+	modified = modified.Add(time.Minute)
+	if third := get(lastModified); third.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after an update, got %d", third.Code)
+	}
+}
+
+func TestGetLeaderboardHandlerCachesFirstPage(t *testing.T) {
+	queries := 0
+	db := leaderboardDB(30)
+	db.countLeaderBoard = func(ctx context.Context, minGames int32) (int64, error) {
+		queries++
+		return 30, nil
+	}
+	db.getLeaderBoardModified = func(ctx context.Context) (pgtype.Timestamp, error) {
+		return pgtype.Timestamp{Time: time.Now(), Valid: true}, nil
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+	apiCfg.CacheTTL = time.Hour
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		apiCfg.GetLeaderboardHandler(w, httptest.NewRequest("GET", "/v1/leaderboard"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %q, got %d", query, w.Code)
+		}
+		return w
+	}
+
+	first := get("")
+	if second := get("?page=1"); second.Body.String() != first.Body.String() {
+		t.Error("Expected the cached page to match the original response")
+	}
+	if queries != 1 {
+		t.Errorf("Expected the first page to be queried once, got %d", queries)
+	}
+
+	// Other parameters are cached separately and later pages aren't cached at all
+	get("?per_page=20")
+	get("?page=2")
+	get("?page=2")
+	if queries != 4 {
+		t.Errorf("Expected 4 queries, got %d", queries)
+	}
+}
+
+func TestGetLeaderboardHandlerCoalescesQueries(t *testing.T) {
+	const requests = 10
+	var modifiedQueries, countQueries, pageQueries atomic.Int32
+	waitModified, releaseModified := blockingQuery(&modifiedQueries)
+	waitCount, releaseCount := blockingQuery(&countQueries)
+
+	db := leaderboardDB(3)
+	getLeaderBoard := db.getLeaderBoard
+	db.getLeaderBoardModified = func(ctx context.Context) (pgtype.Timestamp, error) {
+		waitModified()
+		return pgtype.Timestamp{Time: time.Now(), Valid: true}, nil
+	}
+	db.countLeaderBoard = func(ctx context.Context, minGames int32) (int64, error) {
+		waitCount()
+		return 3, nil
+	}
+	db.getLeaderBoard = func(ctx context.Context, arg database.GetLeaderBoardParams) ([]database.GetLeaderBoardRow, error) {
+		pageQueries.Add(1)
+		return getLeaderBoard(ctx, arg)
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage()) // No CacheTTL, so only coalescing saves queries
+
+	var failed atomic.Int32
+	concurrently(requests, func() {
+		w := httptest.NewRecorder()
+		apiCfg.GetLeaderboardHandler(w, httptest.NewRequest("GET", "/v1/leaderboard?per_page=2", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "player1") {
+			failed.Add(1)
+		}
+	}, releaseModified, releaseCount)
+
+	if n := failed.Load(); n != 0 {
+		t.Errorf("Expected every request to get the leaderboard, %d didn't", n)
+	}
+	for name, calls := range map[string]*atomic.Int32{"last modified": &modifiedQueries, "count": &countQueries, "page": &pageQueries} {
+		if n := calls.Load(); n != 1 {
+			t.Errorf("Expected one %s query for %d identical requests, got %d", name, requests, n)
+		}
+	}
+
+	// Different parameters are a different query
+	apiCfg.GetLeaderboardHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/leaderboard?per_page=3", nil))
+	if n := pageQueries.Load(); n != 2 {
+		t.Errorf("Expected another page query for a different per_page, got %d queries", n)
+	}
+}
+
+func TestGetLeaderboardHandlerMinGames(t *testing.T) {
+	// Rookie tops the board on last places but has only played once
+	players := []struct {
+		name      string
+		lastPlace int32
+		games     int32
+	}{
+		{name: "rookie", lastPlace: 1, games: 1},
+		{name: "veteran", lastPlace: 0, games: 5},
+	}
+	ranked := func(minGames int32) []database.GetLeaderBoardRow {
+		rows := []database.GetLeaderBoardRow{}
+		for _, p := range players {
+			if p.games >= minGames {
+				rows = append(rows, database.GetLeaderBoardRow{ID: uuid.New(), Username: p.name, LastPlaceCount: p.lastPlace})
+			}
+		}
+		return rows
+	}
+	db := &mockDB{
+		getLeaderBoard: func(ctx context.Context, arg database.GetLeaderBoardParams) ([]database.GetLeaderBoardRow, error) {
+			return ranked(arg.MinGames), nil
+		},
+		countLeaderBoard: func(ctx context.Context, minGames int32) (int64, error) {
+			return int64(len(ranked(minGames))), nil
+		},
+		getLeaderBoardModified: func(ctx context.Context) (pgtype.Timestamp, error) {
+			return pgtype.Timestamp{Time: time.Now(), Valid: true}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+	apiCfg.CacheTTL = time.Hour
+
+	get := func(query string) []string {
+		w := httptest.NewRecorder()
+		apiCfg.GetLeaderboardHandler(w, httptest.NewRequest("GET", "/v1/leaderboard"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %q, got %d", query, w.Code)
+		}
+		var resp struct {
+			Data       []models.User     `json:"data"`
+			Pagination models.Pagination `json:"pagination"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Pagination.Total != int64(len(resp.Data)) {
+			t.Errorf("Expected total %d to count only ranked users, got %d", len(resp.Data), resp.Pagination.Total)
+		}
+		names := []string{}
+		for _, user := range resp.Data {
+			names = append(names, user.Username)
+		}
+		return names
+	}
+
+	if names := get(""); !slices.Equal(names, []string{"rookie", "veteran"}) {
+		t.Errorf("Expected everyone ranked by default, got %v", names)
+	}
+	if names := get("?min_games=3"); !slices.Equal(names, []string{"veteran"}) {
+		t.Errorf("Expected the rookie excluded with min_games=3, got %v", names)
+	}
+
+	// The configured minimum applies unless the request sets its own
+	apiCfg.LeaderboardMinGames = 3
+	if names := get(""); !slices.Equal(names, []string{"veteran"}) {
+		t.Errorf("Expected the configured minimum to exclude the rookie, got %v", names)
+	}
+	if names := get("?min_games=0"); !slices.Equal(names, []string{"rookie", "veteran"}) {
+		t.Errorf("Expected min_games=0 to rank everyone, got %v", names)
+	}
+	if names := get("?min_games=-1"); !slices.Equal(names, []string{"veteran"}) {
+		t.Errorf("Expected an invalid min_games to fall back to the configured one, got %v", names)
+	}
+
+	// Only the configured minimum is cached, other counts always hit the database
+	if _, ok := apiCfg.leaderboardCache.Get(leaderboardCacheKey(10, 3, false)); !ok {
+		t.Error("Expected the configured minimum's first page to be cached")
+	}
+	for _, minGames := range []int{5, 1 << 30} {
+		get(fmt.Sprintf("?min_games=%d", minGames))
+		if _, ok := apiCfg.leaderboardCache.Get(leaderboardCacheKey(10, minGames, false)); ok {
+			t.Errorf("Expected min_games=%d not to be cached", minGames)
+		}
+	}
+}
+
+func TestPaginationPastTheEnd(t *testing.T) {
+	const total = 25
+	var offsets []int32
+	db := leaderboardDB(total)
+	getLeaderBoard := db.getLeaderBoard
+	db.getLeaderBoard = func(ctx context.Context, arg database.GetLeaderBoardParams) ([]database.GetLeaderBoardRow, error) {
+		offsets = append(offsets, arg.Offset)
+		return getLeaderBoard(ctx, arg)
+	}
+	db.getLeaderBoardModified = func(ctx context.Context) (pgtype.Timestamp, error) {
+		return pgtype.Timestamp{Time: time.Now(), Valid: true}, nil
+	}
+	db.countLeaderBoard = func(ctx context.Context, minGames int32) (int64, error) {
+		return total, nil
+	}
+	db.countVisibleUsers = func(ctx context.Context, viewerID uuid.UUID) (int64, error) {
+		return total, nil
+	}
+	db.listUsers = func(ctx context.Context, arg database.ListUsersParams) ([]database.User, error) {
+		offsets = append(offsets, arg.Offset)
+		users := []database.User{}
+		for i := int(arg.Offset); i < total && i < int(arg.Offset+arg.Limit); i++ {
+			users = append(users, database.User{ID: uuid.New(), Username: fmt.Sprintf("user%d", i+1)})
+		}
+		return users, nil
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		query   string
+		page    int
+		items   int
+	}{
+		{name: "users_past_the_end", handler: apiCfg.ListUsersHandler, query: "?page=9999", page: 3, items: 5},
+		{name: "leaderboard_past_the_end", handler: apiCfg.GetLeaderboardHandler, query: "?page=9999", page: 3, items: 5},
+		{name: "leaderboard_last_page", handler: apiCfg.GetLeaderboardHandler, query: "?page=2&per_page=20", page: 2, items: 5},
+		{name: "leaderboard_in_range", handler: apiCfg.GetLeaderboardHandler, query: "?page=2", page: 2, items: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offsets = nil
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest("GET", "/"+tt.query, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			var resp struct {
+				Data       []map[string]any  `json:"data"`
+				Pagination models.Pagination `json:"pagination"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			if resp.Pagination.CurrentPage != tt.page {
+				t.Errorf("Expected current_page %d, got %d", tt.page, resp.Pagination.CurrentPage)
+			}
+			if int64(resp.Pagination.CurrentPage) > resp.Pagination.LastPage {
+				t.Errorf("current_page %d is past last_page %d", resp.Pagination.CurrentPage, resp.Pagination.LastPage)
+			}
+			if len(resp.Data) != tt.items {
+				t.Errorf("Expected %d items, got %d", tt.items, len(resp.Data))
+			}
+			for _, offset := range offsets {
+				if offset >= total {
+					t.Errorf("Expected no query past the end, got offset %d", offset)
+				}
+			}
+		})
+	}
+}
+
+func TestPaginationTotalBeyondInt32(t *testing.T) {
+	const total = int64(5_000_000_000) // COUNT(*) is a bigint
+	db := leaderboardDB(20)
+	db.getLeaderBoardModified = func(ctx context.Context) (pgtype.Timestamp, error) {
+		return pgtype.Timestamp{Time: time.Now(), Valid: true}, nil
+	}
+	db.countLeaderBoard = func(ctx context.Context, minGames int32) (int64, error) {
+		return total, nil
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+
+	w := httptest.NewRecorder()
+	apiCfg.GetLeaderboardHandler(w, httptest.NewRequest("GET", "/?page=2", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var resp models.PaginatedResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expected := models.Pagination{
+		Total:       total,
+		PerPage:     10,
+		CurrentPage: 2,
+		LastPage:    500_000_000,
+		From:        11,
+		To:          20,
+	}
+	if resp.Pagination != expected {
+		t.Errorf("Expected pagination %+v, got %+v", expected, resp.Pagination)
+	}
+}
+
+func TestListUsersHandlerSummary(t *testing.T) {
+	users := []database.User{
+		{ID: uuid.New(), Username: "alice", LastPlaceCount: 4},
+		{ID: uuid.New(), Username: "bob", LastPlaceCount: 7},
+	}
+	var aggregates atomic.Int32
+	db := &mockDB{
+		countVisibleUsers: func(ctx context.Context, viewerID uuid.UUID) (int64, error) {
+			return int64(len(users)), nil
+		},
+		listUsers: func(ctx context.Context, arg database.ListUsersParams) ([]database.User, error) {
+			return users, nil
+		},
+		countRecentUsers: func(ctx context.Context) (int64, error) {
+			aggregates.Add(1)
+			return 1, nil
+		},
+		sumLastPlaceCounts: func(ctx context.Context) (int64, error) {
+			aggregates.Add(1)
+			return 11, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+
+	tests := []struct {
+		name     string
+		query    string
+		expected *models.Summary
+	}{
+		{name: "not_requested", query: ""},
+		{name: "disabled", query: "?summary=false"},
+		{name: "requested", query: "?summary=true", expected: &models.Summary{SignupsLast24h: 1, TotalLastPlaces: 11}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aggregates.Store(0)
+			w := httptest.NewRecorder()
+			apiCfg.ListUsersHandler(w, httptest.NewRequest("GET", "/v1/users"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+
+			var resp struct {
+				Data    []models.User   `json:"data"`
+				Summary *models.Summary `json:"summary"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(resp.Data) != len(users) {
+				t.Errorf("Expected %d users, got %d", len(users), len(resp.Data))
+			}
+			if tt.expected == nil {
+				if resp.Summary != nil || aggregates.Load() != 0 {
+					t.Errorf("Expected no summary and no aggregate queries, got %+v after %d queries", resp.Summary, aggregates.Load())
+				}
+				return
+			}
+			if resp.Summary == nil || *resp.Summary != *tt.expected {
+				t.Errorf("Expected summary %+v, got %+v", tt.expected, resp.Summary)
+			}
+		})
+	}
+}
+
+func TestListUsersHandlerSummaryError(t *testing.T) {
+	db := &mockDB{
+		countVisibleUsers: func(ctx context.Context, viewerID uuid.UUID) (int64, error) {
+			return 0, nil
+		},
+		listUsers: func(ctx context.Context, arg database.ListUsersParams) ([]database.User, error) {
+			return []database.User{}, nil
+		},
+		countRecentUsers: func(ctx context.Context) (int64, error) {
+			return 0, errors.New("connection reset")
+		},
+		sumLastPlaceCounts: func(ctx context.Context) (int64, error) {
+			return 0, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+
+	w := httptest.NewRecorder()
+	apiCfg.ListUsersHandler(w, httptest.NewRequest("GET", "/v1/users?summary=true", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", w.Code)
+	}
+}
+
+func TestPaginationEmpty(t *testing.T) {
+	db := &mockDB{
+		countVisibleUsers: func(ctx context.Context, viewerID uuid.UUID) (int64, error) {
+			return 0, nil
+		},
+		listUsers: func(ctx context.Context, arg database.ListUsersParams) ([]database.User, error) {
+			if arg.Offset != 0 {
+				t.Errorf("Expected offset 0 on an empty list, got %d", arg.Offset)
+			}
+			return []database.User{}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, newMockStorage())
+
+	w := httptest.NewRecorder()
+	apiCfg.ListUsersHandler(w, httptest.NewRequest("GET", "/?page=5", nil))
+
+	var resp models.PaginatedResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Pagination.CurrentPage != 1 {
+		t.Errorf("Expected current_page 1, got %d", resp.Pagination.CurrentPage)
+	}
+}
+
+func TestGetUserByIDQueryTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          func(ctx context.Context) error
+		expectedStatus int
+	}{
+		{
+			name: "deadline_exceeded",
+			query: func(ctx context.Context) error {
+				// Block like a hung query until the deadline fires
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			expectedStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name: "statement_cancelled",
+			query: func(ctx context.Context) error {
+				return &pgconn.PgError{Code: queryCanceled}
+			},
+			expectedStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name: "other_error",
+			query: func(ctx context.Context) error {
+				return errors.New("connection reset")
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDB{
+				getUserByID: func(ctx context.Context, id uuid.UUID) (database.User, error) {
+					return database.User{}, tt.query(ctx)
+				},
+			}
+			apiCfg := NewAPIConfig(db, newMockStorage())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			userID := uuid.New()
+			req := withURLParams(httptest.NewRequest("GET", "/v1/users/"+userID.String(), nil).WithContext(ctx), map[string]string{"id": userID.String()})
+			w := httptest.NewRecorder()
+			apiCfg.GetUserByIDHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestUploadProfilePictureSmallGIF(t *testing.T) {
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9[:2]), nil); err != nil {
+		t.Fatalf("Failed to encode GIF: %v", err)
+	}
+	if gifData.Len() >= 512 {
+		t.Fatalf("Expected a GIF under 512 bytes, got %d", gifData.Len())
+	}
+
+	fileStorage := newMockStorage()
+	db := &mockDB{
+		updateProfilePicture: func(ctx context.Context, arg database.UpdateProfilePictureParams) (database.UpdateProfilePictureRow, error) {
+			return database.UpdateProfilePictureRow{User: database.User{ID: arg.ID, ProfilePicture: arg.ProfilePicture}}, nil
+		},
+	}
+	apiCfg := NewAPIConfig(db, fileStorage)
+
+	w := httptest.NewRecorder()
+	apiCfg.UploadProfilePictureHandler(w, newUploadRequest(t, uuid.New(), "tiny.gif", gifData.Bytes()))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(fileStorage.files) != 1 {
+		t.Fatalf("Expected one stored picture, got %d", len(fileStorage.files))
+	}
+	for path, content := range fileStorage.files {
+		if !strings.HasSuffix(path, ".gif") {
+			t.Errorf("Expected the picture stored as a GIF, got %s", path)
+		}
+		if !bytes.Equal(content, gifData.Bytes()) {
+			t.Error("Expected the stored picture to match the upload")
+		}
+	}
+}
+
+func TestUploadProfilePictureEmptyFile(t *testing.T) {
+	fileStorage := newMockStorage()
+	apiCfg := NewAPIConfig(&mockDB{}, fileStorage)
+
+	w := httptest.NewRecorder()
+	apiCfg.UploadProfilePictureHandler(w, newUploadRequest(t, uuid.New(), "empty.png", []byte{}))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "File is empty") {
+		t.Errorf("Expected an empty file error, got %s", w.Body.String())
+	}
+	if len(fileStorage.files) != 0 {
+		t.Errorf("Expected nothing stored, got %v", fileStorage.files)
+	}
+}
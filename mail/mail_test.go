@@ -0,0 +1,57 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestBuildMessage(t *testing.T) {
+	message := string(buildMessage("noreply@tot.example", Message{
+		To:      "player@example.com",
+		Subject: "Confirm your email",
+		Body:    "Hello\nClick the link",
+	}))
+
+	for _, expected := range []string{
+		"From: noreply@tot.example\r\n",
+		"To: player@example.com\r\n",
+		"Subject: Confirm your email\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\nHello\r\nClick the link",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected %q in the message, got %q", expected, message)
+		}
+	}
+}
+
+func TestSendRejectsHeaderInjection(t *testing.T) {
+	var buf bytes.Buffer
+	sender := LogSender{Logger: log.New(&buf, "", 0)}
+
+	for _, msg := range []Message{
+		{To: "", Subject: "Hi"},
+		{To: "player@example.com\r\nBcc: everyone@example.com", Subject: "Hi"},
+		{To: "player@example.com", Subject: "Hi\nBcc: everyone@example.com"},
+	} {
+		if err := sender.Send(context.Background(), msg); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader for %+v, got %v", msg, err)
+		}
+		if err := (SMTPSender{Addr: "localhost:1"}).Send(context.Background(), msg); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected the SMTP sender to refuse %+v before connecting, got %v", msg, err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing logged, got %q", buf.String())
+	}
+
+	if err := sender.Send(context.Background(), Message{To: "player@example.com", Subject: "Hi", Body: "Body"}); err != nil {
+		t.Fatalf("Expected a valid message to be sent, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "player@example.com") {
+		t.Errorf("Expected the message in the log, got %q", buf.String())
+	}
+}
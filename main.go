@@ -6,18 +6,28 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"runtime/debug"
 	"time"
 
+	"github.com/froggu-tantei/ToT/auth"        // Import auth
+	"github.com/froggu-tantei/ToT/avatar"      // Import avatar generation
+	"github.com/froggu-tantei/ToT/config"      // Import configuration loading
 	"github.com/froggu-tantei/ToT/db/database" // Import generated db code
 	"github.com/froggu-tantei/ToT/handlers"    // Import handlers
+	"github.com/froggu-tantei/ToT/mail"        // Import email delivery
 	"github.com/froggu-tantei/ToT/middleware"  // Import middleware
 	"github.com/froggu-tantei/ToT/routes"      // Import routes
-	"github.com/froggu-tantei/ToT/storage"     // Import storage
 	"github.com/jackc/pgx/v5/pgxpool"          // Import pgx driver
 	"github.com/joho/godotenv"                 // Import godotenv for loading environment variables
 )
 
+// Set at build time, e.g. -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD)".
+// The commit falls back to the VCS revision Go stamps into the binary.
+var (
+	version = "dev"
+	commit  = ""
+)
+
 func main() {
 
 	err := godotenv.Load(".env")
@@ -25,61 +35,65 @@ func main() {
 		log.Printf("Error loading .env file: %v", err)
 	}
 
-	portString := os.Getenv("PORT")
-	if portString == "" {
-		log.Fatal("$PORT must be set")
+	// One-off maintenance commands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate-storage" {
+		runMigrateStorage(os.Args[2:])
+		return
 	}
 
-	dbURL := os.Getenv("DB_URL")
-	if dbURL == "" {
-		log.Fatal("$DB_URL must be set")
+	// Every setting is read and checked up front, so a bad deployment fails here listing all its problems
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if err := auth.Configure(cfg.JWT); err != nil {
+		log.Fatal("Invalid JWT configuration: ", err)
 	}
 
-	conn, err := pgxpool.New(context.Background(), dbURL)
+	dbConfig, err := pgxpool.ParseConfig(cfg.Database.URL)
+	if err != nil {
+		log.Fatal("Invalid DB_URL: ", err)
+	}
+
+	// Slow and failed queries are logged with the request ID so they can be traced back, debug logs every query
+	dbConfig.ConnConfig.Tracer = middleware.NewQueryLogger(middleware.QueryLogConfig{
+		Level:         cfg.Database.LogLevel,
+		SlowThreshold: cfg.Database.SlowQuery,
+	})
+
+	conn, err := pgxpool.NewWithConfig(context.Background(), dbConfig)
 	if err != nil {
 		log.Fatal("Can't connect to the database: ", err)
 	}
 
-	// Ping the database to verify connection
-	if err := conn.Ping(context.Background()); err != nil {
+	// Ping the database to verify connection, waiting for it to come up when
+	// the container started first
+	if err := waitForDatabase(context.Background(), conn, cfg.Database.ConnectAttempts, cfg.Database.ConnectDelay); err != nil {
 		log.Fatal("Failed to ping database: ", err)
 	}
 
-	db := database.New(conn)
-
-	// Rate limiting configuration with fallbacks
-	authLimit := getEnvAsInt("AUTH_RATE_LIMIT", 3)          // Default: 3 requests
-	authWindow := getEnvAsInt("AUTH_RATE_WINDOW", 60)       // Default: 60 seconds
-	genericLimit := getEnvAsInt("GENERIC_RATE_LIMIT", 30)   // Default: 30 requests
-	genericWindow := getEnvAsInt("GENERIC_RATE_WINDOW", 60) // Default: 60 seconds
-
-	// Convert to rate (requests per second) and create configs
-	authRate := float64(authLimit) / float64(authWindow)
-	genericRate := float64(genericLimit) / float64(genericWindow)
+	// Slow queries fail on their own deadline rather than holding a connection for the whole request
+	db := database.NewStore(conn, cfg.Database.QueryTimeout)
 
 	// Create rate limiter configs
-	authConfig := middleware.RateLimiterConfig{
-		Rate:            authRate,
-		Capacity:        authLimit,
-		MaxBuckets:      10000,
-		CleanupInterval: 5 * time.Minute,
-		BucketTTL:       10 * time.Minute,
-		MaxRetryAfter:   5 * time.Minute,
-	}
+	limits := cfg.RateLimit
+	authConfig, genericConfig, mailConfig := rateLimiterConfigs(limits)
 
-	genericConfig := middleware.RateLimiterConfig{
-		Rate:            genericRate,
-		Capacity:        genericLimit,
-		MaxBuckets:      10000,
-		CleanupInterval: 5 * time.Minute,
-		BucketTTL:       10 * time.Minute,
-		MaxRetryAfter:   5 * time.Minute,
+	// Create rate limiters with proper configs, or let everything through when disabled
+	var authLimiter, genericLimiter, mailLimiter middleware.Limiter = middleware.NoopLimiter{}, middleware.NoopLimiter{}, middleware.NoopLimiter{}
+	if limits.Enabled {
+		for name, limiterConfig := range map[string]middleware.RateLimiterConfig{"auth": authConfig, "generic": genericConfig, "mail": mailConfig} {
+			if err := limiterConfig.Validate(); err != nil {
+				log.Fatalf("Invalid %s rate limit configuration: %v", name, err)
+			}
+		}
+		authLimiter = middleware.NewRateLimiter(authConfig)
+		genericLimiter = middleware.NewRateLimiter(genericConfig)
+		mailLimiter = middleware.NewRateLimiter(mailConfig)
+	} else {
+		log.Println("Rate limiting is disabled")
 	}
 
-	// Create rate limiters with proper configs
-	authLimiter := middleware.NewRateLimiter(authConfig)
-	genericLimiter := middleware.NewRateLimiter(genericConfig)
-
 	// Ensure proper cleanup on shutdown
 	defer func() {
 		if err := authLimiter.Close(); err != nil {
@@ -88,43 +102,137 @@ func main() {
 		if err := genericLimiter.Close(); err != nil {
 			log.Printf("Error closing generic limiter: %v", err)
 		}
+		if err := mailLimiter.Close(); err != nil {
+			log.Printf("Error closing mail limiter: %v", err)
+		}
 	}()
 
-	fileStorage := storage.NewLocalStorage("uploads", "")
-	// Change fileStorage into this whenever I want to use S3 storage:
-	// fileStorage, err := storage.NewS3Storage(
-	// "your-bucket-name",
-	// "your-region",  // e.g., "eu-west-1"
-	// ""  // Optional CDN URL if I have one
-	// )
-	// if err != nil {
-	// log.Fatal("Failed to initialize S3 storage:", err)
-	//}
+	fileStorage, err := newFileStorage(cfg.Storage.Backend, cfg.Storage)
+	if err != nil {
+		log.Fatal("Failed to initialize storage: ", err)
+	}
 
 	// Instantiate the APIConfig from handlers package
 	apiCfg := handlers.NewAPIConfig(db, fileStorage)
+	apiCfg.MultipartMemory = cfg.Server.MultipartMemory
+	apiCfg.ImageConstraints = cfg.Images.Constraints
+	apiCfg.ImageCompression = cfg.Images.Compression
+	apiCfg.CacheTTL = cfg.CacheTTL
+	apiCfg.TokenCookie = cfg.TokenCookie
+	apiCfg.LeaderboardMinGames = cfg.LeaderboardMin
+	apiCfg.TieLastPlacePolicy = cfg.TieLastPlace
+	apiCfg.LogRequestBodies = cfg.LogRequestBodies
+	apiCfg.Version = version
+	apiCfg.Commit = buildCommit()
+	apiCfg.StorageBackend = cfg.Storage.Backend
+
+	// Avatars for users without a picture: an optional placeholder URL, also
+	// returned as profile_picture with ?default_avatar=true, otherwise a
+	// generated image unless AVATAR_STYLE is "none"
+	apiCfg.DefaultAvatarURL = cfg.Images.DefaultAvatarURL
+	apiCfg.AvatarURLHosts = cfg.Images.AvatarURLHosts
+	if cfg.Images.AvatarStyle != "" {
+		apiCfg.Avatars = avatar.NewGenerator(cfg.Images.AvatarStyle)
+	}
+
+	// Password hashing, existing hashes of other algorithms are upgraded on login
+	hasher, err := auth.NewHasher(cfg.PasswordHasher)
+	if err != nil {
+		log.Fatal("Invalid PASSWORD_HASHER: ", err)
+	}
+	apiCfg.Hasher = hasher
+
+	// Email changes are confirmed through a link mailed to the new address.
+	// Without SMTP_ADDR they are disabled, unless MAIL_LOG_ONLY logs the
+	// emails instead for local development.
+	if cfg.Mail.SMTPAddr != "" {
+		apiCfg.Mailer = mail.SMTPSender{
+			Addr:     cfg.Mail.SMTPAddr,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		}
+	} else if cfg.Mail.LogOnly {
+		apiCfg.Mailer = mail.LogSender{}
+	}
+	apiCfg.EmailChangeURL = cfg.Mail.EmailChangeURL
+	apiCfg.EmailChangeTTL = cfg.Mail.EmailChangeTTL
+	apiCfg.VerifyEmailURL = cfg.Mail.VerifyEmailURL
+	apiCfg.EmailVerificationTTL = cfg.Mail.EmailVerificationTTL
+
+	if cfg.Maintenance.Enabled {
+		log.Println("Maintenance mode is enabled")
+	}
+
+	// Latency histograms served on /metrics to admins and INTERNAL_NETWORKS scrapers
+	var metrics *middleware.HTTPMetrics
+	if cfg.Metrics {
+		metrics = middleware.NewHTTPMetrics(nil)
+	}
+
+	// Internal services on these networks may call protected routes without a token
+	var authenticate func(http.Handler) http.Handler
+	if len(cfg.InternalNetworks) > 0 {
+		authenticate = middleware.AllowInternal(cfg.InternalNetworks, genericConfig)
+	}
+
+	// The public read endpoints can be opened to more origins than the rest of the API
+	corsConfig := middleware.CORSConfig{AllowedOrigins: cfg.CORS.AllowedOrigins}
+	publicCORSConfig := corsConfig
+	if len(cfg.CORS.PublicOrigins) > 0 {
+		publicCORSConfig = middleware.CORSConfig{AllowedOrigins: cfg.CORS.PublicOrigins}
+	}
 
 	// Create Chi router (this handles all middleware internally)
-	router := routes.RegisterRoutes(apiCfg, authLimiter, genericLimiter)
+	router := routes.RegisterRoutes(apiCfg, authLimiter, genericLimiter, routes.Config{
+		Logging:     cfg.Logging,
+		Maintenance: middleware.NewMaintenance(cfg.Maintenance),
+		Metrics:     metrics,
+		Auth:        authenticate,
+		MaxBodySize: cfg.Server.MaxBodySize,
+		CORS:        corsConfig,
+		PublicCORS:  &publicCORSConfig,
+		MailLimiter: mailLimiter,
+		Security:    &cfg.Security,
+		Pprof:       cfg.Server.Pprof,
+		MaxInFlight: cfg.Server.MaxInFlight,
+	})
+
+	// Log what we're actually running with, minus secrets
+	poolConfig := conn.Config()
+	startup, err := newStartupConfig(cfg.Server.Addr, cfg.Database.URL, poolConfig.MaxConns, poolConfig.MinConns, cfg.Database.QueryTimeout, cfg.Storage.Backend,
+		limits.Enabled, authConfig, genericConfig, corsConfig, publicCORSConfig, metrics != nil, cfg.Maintenance.Enabled)
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	log.Printf("Effective configuration: %s", startup)
 
 	// Serve static files using Chi.
-	router.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads"))))
+	router.Handle("/uploads/*", http.StripPrefix("/uploads/", handlers.StaticFiles("uploads", cfg.Server.UploadsMaxAge)))
 
 	srv := &http.Server{
-		Addr:         ":" + portString,
+		Addr:         cfg.Server.Addr,
 		Handler:      router,
 		IdleTimeout:  60 * time.Second,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
+		Protocols:    serverProtocols(cfg.Server.H2C),
 	}
 
 	go func() {
-		log.Println("Starting server on port " + portString)
+		log.Println("Starting server on " + cfg.Server.Addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("ListenAndServe(): %v", err)
 		}
 	}()
 
+	// Games recorded on other instances clear this one's cached leaderboard
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	defer stopListening()
+	if apiCfg.CacheTTL > 0 {
+		go listenForLeaderboardChanges(listenCtx, db, apiCfg)
+	}
+
 	defer conn.Close()
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
@@ -138,13 +246,85 @@ func main() {
 	log.Println("Server exiting")
 }
 
-// Helper function to get environment variable as int with fallback
-func getEnvAsInt(key string, fallback int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// listenForLeaderboardChanges invalidates the leaderboard cache on every
+// notification, reconnecting when the listener drops. Anything missed while
+// disconnected is covered by the cache TTL and the invalidation on reconnect.
+func listenForLeaderboardChanges(ctx context.Context, db *database.SQLStore, apiCfg *handlers.APIConfig) {
+	for {
+		err := db.Listen(ctx, database.LeaderboardChangedChannel, apiCfg.InvalidateLeaderboard)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Leaderboard listener stopped: %v, reconnecting in 5s", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		apiCfg.InvalidateLeaderboard()
+	}
+}
+
+// rateLimiterConfigs derives the auth, generic and mail limiter configs from
+// the rate limit settings
+func rateLimiterConfigs(limits config.RateLimitConfig) (authConfig, genericConfig, mailConfig middleware.RateLimiterConfig) {
+	authConfig = middleware.RateLimiterConfig{
+		Rate:             limits.Auth.Rate(),
+		Capacity:         limits.Auth.Requests,
+		MaxBuckets:       10000,
+		CleanupInterval:  5 * time.Minute,
+		BucketTTL:        10 * time.Minute,
+		MaxRetryAfter:    5 * time.Minute,
+		RetryAfterFormat: limits.RetryAfterFormat,
+		TrustedProxyHops: limits.TrustedProxyHops,
+		IPv6PrefixLen:    limits.IPv6PrefixLen,
+		ClientIPHeaders:  limits.ClientIPHeaders,
+		TrustedProxies:   limits.TrustedProxies,
+		NoLegacyHeaders:  !limits.LegacyHeaders,
+		ExceededMessage:  limits.Message,
+		DetailedBody:     limits.DetailedBody,
+	}
+
+	// Method weights only apply to the generic limit: a weighted POST would
+	// otherwise eat several of the few login or email attempts allowed
+	genericConfig = authConfig
+	genericConfig.Rate = limits.Generic.Rate()
+	genericConfig.Capacity = limits.Generic.Requests
+	genericConfig.MethodWeights = limits.MethodWeights
+
+	// Endpoints that send email are limited per recipient on top of the auth
+	// limit, so nobody can flood an inbox by rotating IPs
+	mailConfig = authConfig
+	mailConfig.Rate = limits.Mail.Rate()
+	mailConfig.Capacity = limits.Mail.Requests
+	mailConfig.BucketTTL = limits.Mail.Window
+	mailConfig.MaxRetryAfter = limits.Mail.Window
+	mailConfig.ClientIDFunc = middleware.EmailClientID
+	return authConfig, genericConfig, mailConfig
+}
+
+// serverProtocols is HTTP/1.1, plus cleartext HTTP/2 with prior knowledge
+// (h2c) when enabled for internal clients that don't go through a TLS proxy
+func serverProtocols(h2c bool) *http.Protocols {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(h2c)
+	return &protocols
+}
+
+// buildCommit is the commit set through -ldflags, or the VCS revision go build
+// recorded when it wasn't set
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
 		}
-		log.Printf("Invalid value for %s: %s, using fallback: %d", key, value, fallback)
 	}
-	return fallback
+	return "unknown"
 }
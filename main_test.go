@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/config"
+	"github.com/froggu-tantei/ToT/handlers"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/froggu-tantei/ToT/routes"
+)
+
+func TestServerProtocolsH2C(t *testing.T) {
+	limiterConfig := middleware.DefaultConfig()
+	limiterConfig.Rate = 0.001
+	limiterConfig.Capacity = 2
+	limiter := middleware.NewRateLimiter(limiterConfig)
+	defer limiter.Close()
+
+	router := routes.RegisterRoutes(handlers.NewAPIConfig(nil, nil), middleware.NoopLimiter{}, limiter, routes.Config{})
+	server := httptest.NewUnstartedServer(router)
+	server.Config.Protocols = serverProtocols(true)
+	server.Start()
+	defer server.Close()
+
+	// A prior-knowledge client speaks HTTP/2 from the first byte, no upgrade
+	var clientProtocols http.Protocols
+	clientProtocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &clientProtocols}}
+
+	get := func() *http.Response {
+		t.Helper()
+		resp, err := client.Get(server.URL + "/")
+		if err != nil {
+			t.Fatalf("h2c request failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	for range limiterConfig.Capacity {
+		resp := get()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("Expected HTTP/2, got %s", resp.Proto)
+		}
+		if resp.Header.Get("X-Request-ID") == "" {
+			t.Error("Expected the middleware to set X-Request-ID over h2c")
+		}
+	}
+
+	// Streams share a connection, the limiter still sees one client
+	if resp := get(); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 once the limit is spent, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerProtocolsHTTP1Only(t *testing.T) {
+	protocols := serverProtocols(false)
+	if !protocols.HTTP1() || protocols.UnencryptedHTTP2() {
+		t.Errorf("Expected only HTTP/1.1 without ENABLE_H2C, got %v", protocols)
+	}
+}
+
+func TestRateLimiterConfigsMethodWeights(t *testing.T) {
+	limits := config.RateLimitConfig{
+		Auth:          config.Limit{Requests: 5, Window: time.Minute},
+		Generic:       config.Limit{Requests: 100, Window: time.Minute},
+		Mail:          config.Limit{Requests: 3, Window: time.Hour},
+		MethodWeights: map[string]int{http.MethodPost: 5},
+	}
+
+	authConfig, genericConfig, mailConfig := rateLimiterConfigs(limits)
+	if genericConfig.MethodWeights[http.MethodPost] != 5 {
+		t.Errorf("Expected the generic limiter to weigh POSTs, got %v", genericConfig.MethodWeights)
+	}
+	if authConfig.MethodWeights != nil || mailConfig.MethodWeights != nil {
+		t.Errorf("Expected no weights on the auth and mail limiters, got %v and %v", authConfig.MethodWeights, mailConfig.MethodWeights)
+	}
+}
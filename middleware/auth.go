@@ -3,9 +3,12 @@ package middleware
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/froggu-tantei/ToT/auth"
 	"github.com/froggu-tantei/ToT/models"
@@ -16,35 +19,86 @@ type contextKey string
 
 const UserContextKey contextKey = "user"
 
+// AccessTokenCookie is the cookie the access token is delivered in when
+// cookie delivery is enabled. AuthMiddleware reads it when there is no
+// Authorization header.
+const AccessTokenCookie = "access_token"
+
+// TokenExpiredAtHeader tells a client when its rejected token expired, in RFC
+// 3339, so it can notice its clock is off rather than refreshing in a loop
+const TokenExpiredAtHeader = "X-Token-Expired-At"
+
+// tokenCookie returns the access token cookie's value, empty when there is none
+func tokenCookie(r *http.Request) string {
+	cookie, err := r.Cookie(AccessTokenCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// Error codes of a rejected token. TOKEN_EXPIRED means a silent refresh will
+// do, TOKEN_INVALID that the user has to log in again.
+const (
+	TokenExpiredCode = "TOKEN_EXPIRED"
+	TokenInvalidCode = "TOKEN_INVALID"
+)
+
+// respondWithTokenError sends a 401 for a rejected token with its error code,
+// plus the RFC 6750 WWW-Authenticate challenge for clients that read headers
+func respondWithTokenError(w http.ResponseWriter, code, message string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, message))
+	resp := models.NewErrorResponse(message)
+	resp.Code = code
+	writeJSONError(w, http.StatusUnauthorized, resp)
+}
+
 // AuthMiddleware authenticates requests using JWT
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			// No Authorization header
+		// Get token from Authorization header, falling back to the cookie
+		var token string
+		ctx := r.Context()
+		if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+			// Check Bearer format
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				respondWithError(w, http.StatusUnauthorized, "Invalid authorization format")
+				return
+			}
+			token = parts[1]
+		} else if token = tokenCookie(r); token == "" {
+			// Neither an Authorization header nor a cookie
 			respondWithError(w, http.StatusUnauthorized, "Missing authorization header")
 			return
+		} else {
+			// Cookies are sent by the browser on its own, so CSRF checks apply
+			ctx = withCookieAuth(ctx)
 		}
 
-		// Check Bearer format
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			respondWithError(w, http.StatusUnauthorized, "Invalid authorization format")
-			return
-		}
-
-		token := parts[1]
-
 		// Validate JWT token
 		claims, err := auth.ValidateToken(token)
+		if errors.Is(err, auth.ErrAuthNotConfigured) {
+			log.Printf("Rejecting authenticated request: %v", err)
+			respondWithError(w, http.StatusServiceUnavailable, "Authentication not configured")
+			return
+		}
+		if errors.Is(err, auth.ErrTokenExpired) {
+			var expired *auth.ExpiredError
+			if errors.As(err, &expired) {
+				w.Header().Set(TokenExpiredAtHeader, expired.ExpiresAt.UTC().Format(time.RFC3339))
+			}
+			respondWithTokenError(w, TokenExpiredCode, "Token expired")
+			return
+		}
 		if err != nil {
-			respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+			respondWithTokenError(w, TokenInvalidCode, "Invalid token")
 			return
 		}
 
 		// Add claims to request context
-		ctx := context.WithValue(r.Context(), UserContextKey, claims)
+		setLogUserID(ctx, claims.UserID.String())
+		ctx = context.WithValue(ctx, UserContextKey, claims)
 
 		// Call the next handler with the updated context
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -59,10 +113,14 @@ func GetUserFromContext(ctx context.Context) (*auth.Claims, bool) {
 
 // Helper function to respond with error
 func respondWithError(w http.ResponseWriter, statusCode int, message string) {
+	// Use the models.ErrorResponse for consistent error formatting
+	writeJSONError(w, statusCode, models.NewErrorResponse(message))
+}
+
+// writeJSONError sends an error response body as JSON
+func writeJSONError(w http.ResponseWriter, statusCode int, resp models.ErrorResponse) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	// Use the models.ErrorResponse for consistent error formatting
-	resp := models.NewErrorResponse(message)
 	data, err := json.Marshal(resp)
 	if err != nil {
 		log.Printf("Error marshaling error response: %v", err)
@@ -2,20 +2,22 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/froggu-tantei/ToT/auth"
 	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 func TestAuthMiddleware(t *testing.T) {
-	// Setup test environment
-	os.Setenv("JWT_SECRET", "test_secret_key")
-	defer os.Unsetenv("JWT_SECRET")
+	configureAuth(t)
 
 	// Create test user and generate valid token
 	testUser := database.User{
@@ -36,12 +38,13 @@ func TestAuthMiddleware(t *testing.T) {
 			return
 		}
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(claims.Username))
+		w.Write([]byte(claims.UserID.String()))
 	})
 
 	tests := []struct {
 		name           string
 		authHeader     string
+		cookie         string
 		expectedStatus int
 		expectedBody   string
 		checkBody      bool
@@ -50,7 +53,7 @@ func TestAuthMiddleware(t *testing.T) {
 			name:           "valid_bearer_token",
 			authHeader:     "Bearer " + validToken,
 			expectedStatus: http.StatusOK,
-			expectedBody:   "testuser",
+			expectedBody:   testUser.ID.String(),
 			checkBody:      true,
 		},
 		{
@@ -95,6 +98,26 @@ func TestAuthMiddleware(t *testing.T) {
 			expectedStatus: http.StatusUnauthorized,
 			checkBody:      false,
 		},
+		{
+			name:           "valid_cookie",
+			cookie:         validToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   testUser.ID.String(),
+			checkBody:      true,
+		},
+		{
+			name:           "invalid_cookie",
+			cookie:         "invalid.jwt.token",
+			expectedStatus: http.StatusUnauthorized,
+			checkBody:      false,
+		},
+		{
+			name:           "header_takes_precedence_over_cookie",
+			authHeader:     "Bearer invalid.jwt.token",
+			cookie:         validToken,
+			expectedStatus: http.StatusUnauthorized,
+			checkBody:      false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -104,6 +127,9 @@ func TestAuthMiddleware(t *testing.T) {
 			if tt.authHeader != "" {
 				req.Header.Set("Authorization", tt.authHeader)
 			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: AccessTokenCookie, Value: tt.cookie})
+			}
 
 			w := httptest.NewRecorder()
 
@@ -131,6 +157,86 @@ func TestAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestAuthMiddlewareTokenErrorCodes(t *testing.T) {
+	configureAuth(t)
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		UserID:           uuid.New(),
+		TokenType:        auth.TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour))},
+	}).SignedString([]byte("test_secret_key"))
+	if err != nil {
+		t.Fatalf("Failed to generate expired token: %v", err)
+	}
+
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called with a rejected token")
+	}))
+
+	expiresAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantCode   string
+		wantExpiry bool
+	}{
+		{name: "expired", token: expired, wantCode: TokenExpiredCode, wantExpiry: true},
+		{name: "garbage", token: "garbage", wantCode: TokenInvalidCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+			}
+			var body models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode body: %v", err)
+			}
+			if body.Code != tt.wantCode {
+				t.Errorf("Expected code %q, got %q", tt.wantCode, body.Code)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); !strings.Contains(challenge, `error="invalid_token"`) {
+				t.Errorf("Expected an invalid_token challenge, got %q", challenge)
+			}
+
+			// Only an expired token reports when it expired
+			header := w.Header().Get(TokenExpiredAtHeader)
+			if !tt.wantExpiry {
+				if header != "" {
+					t.Errorf("Expected no %s header, got %q", TokenExpiredAtHeader, header)
+				}
+				return
+			}
+			expiry, err := time.Parse(time.RFC3339, header)
+			if err != nil || expiry.Sub(expiresAt).Abs() > 2*time.Second {
+				t.Errorf("Expected %s close to %v, got %q", TokenExpiredAtHeader, expiresAt, header)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareNotConfigured(t *testing.T) {
+	auth.Reset()
+
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called without a JWT secret")
+	}))
+
+	req := httptest.NewRequest("GET", "/protected", nil)
+	req.Header.Set("Authorization", "Bearer some.token.value")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
 func TestGetUserFromContext(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -143,7 +249,6 @@ func TestGetUserFromContext(t *testing.T) {
 			contextValue: &auth.Claims{
 				UserID:   uuid.New(),
 				Username: "testuser",
-				Email:    "test@example.com",
 			},
 			expectedOK: true,
 		},
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decodeJSON answers like the API handlers: 413 when the body limit was hit
+var decodeJSON = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+})
+
+// jsonBody is a JSON object of roughly size bytes
+func jsonBody(size int) string {
+	return `{"bio":"` + strings.Repeat("x", size) + `"}`
+}
+
+func TestMaxBodySizeRejectsDeclaredLength(t *testing.T) {
+	called := false
+	handler := MaxBodySize(64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("POST", "/v1/games", strings.NewReader(jsonBody(128)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d", w.Code)
+	}
+	if called {
+		t.Error("Expected the handler not to run")
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected a JSON response, got %q", ct)
+	}
+	var resp struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Success || resp.Error != "Request body too large (max 64 bytes)" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+}
+
+func TestMaxBodySizeLimitsUndeclaredLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		expected int
+	}{
+		{name: "within_limit", size: 16, expected: http.StatusOK},
+		{name: "over_limit", size: 128, expected: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A chunked body has no Content-Length, so only reading it finds the size
+			req := httptest.NewRequest("POST", "/v1/games", io.NopCloser(strings.NewReader(jsonBody(tt.size))))
+			req.ContentLength = -1
+			w := httptest.NewRecorder()
+			MaxBodySize(64)(decodeJSON).ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestMaxBodySizeRouteOverride(t *testing.T) {
+	// The route limit replaces the global one rather than nesting inside it
+	handler := MaxBodySize(64)(MaxBodySize(1024)(decodeJSON))
+
+	req := httptest.NewRequest("POST", "/v1/users/me/profile-picture", io.NopCloser(strings.NewReader(jsonBody(512))))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the larger route limit to apply, got status %d", w.Code)
+	}
+}
+
+func TestMaxBodySizeDefault(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/games", strings.NewReader(jsonBody(DefaultMaxBodySize)))
+	w := httptest.NewRecorder()
+	MaxBodySize(0)(decodeJSON).ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected a zero limit to use the default, got status %d", w.Code)
+	}
+}
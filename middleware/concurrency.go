@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ConcurrencyRetryAfter is sent as Retry-After when ConcurrencyLimit turns a
+// request away. Heavy endpoints take seconds, not minutes, to free a slot.
+const ConcurrencyRetryAfter = 5 * time.Second
+
+// ConcurrencyLimit lets at most n requests run the wrapped handler at once
+// and rejects the rest with 503 rather than queueing them, independently of
+// rate limiting, so a few callers can't tie up the database or storage with
+// expensive work. Each call has its own slots: call it once per route to
+// limit endpoints separately. n of zero or less disables the limit.
+func ConcurrencyLimit(n int) func(http.Handler) http.Handler {
+	if n <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	slots := make(chan struct{}, n)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				w.Header().Set("Retry-After", strconv.Itoa(int(ConcurrencyRetryAfter.Seconds())))
+				respondWithError(w, http.StatusServiceUnavailable, "Too many requests in progress, please try again later")
+				return
+			}
+			defer func() { <-slots }() // Freed even if the handler panics
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
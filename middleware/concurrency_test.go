@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	const limit = 3
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := ConcurrencyLimit(limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Fill every slot with a request that blocks until released
+	var wg sync.WaitGroup
+	codes := make([]int, limit)
+	for i := range limit {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/export", nil))
+			codes[i] = w.Code
+		}()
+		<-started
+	}
+
+	// One more is turned away while they run
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/export", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d past the limit, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if got, want := w.Header().Get("Retry-After"), strconv.Itoa(int(ConcurrencyRetryAfter.Seconds())); got != want {
+		t.Errorf("Expected Retry-After %q, got %q", want, got)
+	}
+
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Expected request %d within the limit to succeed, got %d", i, code)
+		}
+	}
+
+	// Finished requests free their slots
+	go func() { <-started }()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/export", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d once slots are free, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestConcurrencyLimitReleasesOnPanic(t *testing.T) {
+	handler := ConcurrencyLimit(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	for range 2 {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected the handler's panic, the slot was not free")
+				}
+			}()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/export", nil))
+		}()
+	}
+}
+
+func TestConcurrencyLimitDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := ConcurrencyLimit(0)(next)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/export", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected a disabled limit to pass requests through, got %d", w.Code)
+	}
+}
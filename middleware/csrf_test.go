@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/google/uuid"
+)
+
+func TestCSRF(t *testing.T) {
+	configureAuth(t)
+	token, err := auth.GenerateToken(database.User{ID: uuid.New(), Username: "testuser"})
+	if err != nil {
+		t.Fatalf("Failed to generate test token: %v", err)
+	}
+	csrfToken, err := NewCSRFToken()
+	if err != nil {
+		t.Fatalf("Failed to generate CSRF token: %v", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := AuthMiddleware(CSRF(ok))
+
+	tests := []struct {
+		name           string
+		method         string
+		bearer         bool
+		csrfCookie     string
+		csrfHeader     string
+		expectedStatus int
+	}{
+		{name: "cookie_auth_without_header", method: "POST", csrfCookie: csrfToken, expectedStatus: http.StatusForbidden},
+		{name: "cookie_auth_with_matching_header", method: "POST", csrfCookie: csrfToken, csrfHeader: csrfToken, expectedStatus: http.StatusOK},
+		{name: "cookie_auth_with_wrong_header", method: "DELETE", csrfCookie: csrfToken, csrfHeader: "guess", expectedStatus: http.StatusForbidden},
+		{name: "cookie_auth_without_csrf_cookie", method: "PUT", expectedStatus: http.StatusForbidden},
+		{name: "cookie_auth_safe_method", method: "GET", expectedStatus: http.StatusOK},
+		{name: "bearer_auth_exempt", method: "POST", bearer: true, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/games", nil)
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+token)
+			} else {
+				req.AddCookie(&http.Cookie{Name: AccessTokenCookie, Value: token})
+			}
+			if tt.csrfCookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				req.Header.Set(CSRFHeader, tt.csrfHeader)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusForbidden && w.Header().Get("Content-Type") != "application/json" {
+				t.Error("Expected a JSON error")
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MaxDrainBytes bounds how much unread body DrainBody will discard. Anything
+// larger isn't worth reading just to keep the connection, so it gets closed.
+// net/http already discards up to 256KB itself, so DrainBody only matters for
+// bodies between that and this, which is the default MaxBodySize.
+const MaxDrainBytes = DefaultMaxBodySize
+
+// DrainBody discards whatever the handler left unread in the request body, so
+// an early return (like a validation error) doesn't cost the keep-alive
+// connection. Multipart uploads are skipped: they are size-limited by their
+// handler and can be large enough that closing the connection is cheaper.
+func DrainBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if r.Body == nil || r.Body == http.NoBody {
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			return
+		}
+		io.CopyN(io.Discard, r.Body, MaxDrainBytes)
+	})
+}
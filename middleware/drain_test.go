@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+)
+
+// drainTrackingBody records how much of the body was read
+type drainTrackingBody struct {
+	io.Reader
+	read int
+}
+
+func (b *drainTrackingBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *drainTrackingBody) Close() error { return nil }
+
+// rejectEarly answers 400 without touching the body, like a failed validation
+var rejectEarly = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusBadRequest)
+})
+
+func TestDrainBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		bodySize    int
+		expectRead  int
+	}{
+		{name: "json_body_drained", contentType: "application/json", bodySize: 1024, expectRead: 1024},
+		{name: "multipart_left_alone", contentType: "multipart/form-data; boundary=x", bodySize: 1024, expectRead: 0},
+		{name: "oversized_body_bounded", contentType: "application/json", bodySize: MaxDrainBytes + 1024, expectRead: MaxDrainBytes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &drainTrackingBody{Reader: strings.NewReader(strings.Repeat("x", tt.bodySize))}
+			req := httptest.NewRequest("POST", "/v1/games", nil)
+			req.Body = body
+			req.Header.Set("Content-Type", tt.contentType)
+
+			DrainBody(rejectEarly).ServeHTTP(httptest.NewRecorder(), req)
+
+			if body.read != tt.expectRead {
+				t.Errorf("Expected %d bytes drained, got %d", tt.expectRead, body.read)
+			}
+		})
+	}
+}
+
+// postTwice sends two requests with the given body size over one client and
+// reports whether the second reused the first's connection
+func postTwice(t *testing.T, handler http.Handler, bodySize int) bool {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := server.Client()
+	var reused []bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = append(reused, info.Reused) },
+	}
+
+	for range 2 {
+		req, err := http.NewRequest("POST", server.URL, strings.NewReader(strings.Repeat("x", bodySize)))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d", resp.StatusCode)
+		}
+	}
+
+	if len(reused) != 2 {
+		t.Fatalf("Expected 2 connections, got %v", reused)
+	}
+	return reused[1]
+}
+
+func TestDrainBodyConnectionReused(t *testing.T) {
+	// A body past net/http's own 256KB drain but within MaxDrainBytes
+	const bodySize = 512 << 10
+
+	if postTwice(t, rejectEarly, bodySize) {
+		t.Fatal("Expected net/http alone to close the connection, the test would prove nothing")
+	}
+	if !postTwice(t, DrainBody(rejectEarly), bodySize) {
+		t.Error("Expected the second request to reuse the connection")
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/froggu-tantei/ToT/auth"
+)
+
+// ServiceUsername is the username of the synthetic identity given to
+// requests from internal networks
+const ServiceUsername = "internal-service"
+
+// ParseInternalNetworks parses an INTERNAL_NETWORKS value such as "10.0.0.0/8,192.168.1.5"
+func ParseInternalNetworks(value string) ([]*net.IPNet, error) {
+	return parseNetworks(value, "internal network")
+}
+
+// AllowInternal returns an authentication middleware that lets requests from
+// the internal networks through as the service identity and sends everything
+// else through AuthMiddleware. The client IP is resolved like the rate
+// limiter's, except client IP headers are only believed from explicitly
+// trusted proxies, since a spoofed header here would skip authentication.
+//
+// Without trusted proxies the connecting address decides. That is only safe
+// when clients connect directly: behind a reverse proxy or load balancer on
+// one of the networks, every request arrives from the proxy and skips
+// authentication, pprof included. A warning is logged for that setup.
+func AllowInternal(networks []*net.IPNet, ipConfig RateLimiterConfig) func(http.Handler) http.Handler {
+	if len(networks) > 0 && len(ipConfig.TrustedProxies) == 0 {
+		log.Println("WARNING: INTERNAL_NETWORKS is set without TRUSTED_PROXIES, so the connecting address decides who skips authentication. " +
+			"If a proxy on one of those networks forwards public traffic, every request is treated as internal; set TRUSTED_PROXIES to the proxy's address.")
+	}
+
+	return func(next http.Handler) http.Handler {
+		authenticated := AuthMiddleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isInternal(networks, ipConfig, r) {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserContextKey, &auth.Claims{Username: ServiceUsername})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// isInternal reports whether the request's client IP is in one of the networks
+func isInternal(networks []*net.IPNet, ipConfig RateLimiterConfig, r *http.Request) bool {
+	var ip net.IP
+	if len(ipConfig.TrustedProxies) == 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip = net.ParseIP(host)
+	} else {
+		ip = net.ParseIP(ipConfig.realIP(r))
+	}
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/google/uuid"
+)
+
+func TestAllowInternal(t *testing.T) {
+	configureAuth(t)
+
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "test@example.com"}
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate test token: %v", err)
+	}
+
+	internal, err := ParseInternalNetworks("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Failed to parse networks: %v", err)
+	}
+	proxies, err := ParseTrustedProxies("192.168.0.1")
+	if err != nil {
+		t.Fatalf("Failed to parse proxies: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   string
+		proxies        []*net.IPNet
+		authHeader     string
+		expectedStatus int
+		expectedUser   string
+	}{
+		{name: "internal_without_token", remoteAddr: "10.1.2.3:1234", expectedStatus: http.StatusOK, expectedUser: ServiceUsername},
+		{name: "external_without_token", remoteAddr: "203.0.113.5:1234", expectedStatus: http.StatusUnauthorized},
+		{name: "external_with_token", remoteAddr: "203.0.113.5:1234", authHeader: "Bearer " + token, expectedStatus: http.StatusOK, expectedUser: user.ID.String()},
+		{name: "spoofed_header_without_trusted_proxies", remoteAddr: "203.0.113.5:1234", forwardedFor: "10.1.2.3", expectedStatus: http.StatusUnauthorized},
+		{name: "internal_behind_trusted_proxy", remoteAddr: "192.168.0.1:1234", forwardedFor: "10.1.2.3", proxies: proxies, expectedStatus: http.StatusOK, expectedUser: ServiceUsername},
+		{name: "external_behind_trusted_proxy", remoteAddr: "192.168.0.1:1234", forwardedFor: "203.0.113.5", proxies: proxies, expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := AllowInternal(internal, RateLimiterConfig{TrustedProxies: tt.proxies})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Tokens only carry the user ID, the service identity only a username
+				claims, _ := GetUserFromContext(r.Context())
+				if claims.Username == "" {
+					w.Write([]byte(claims.UserID.String()))
+					return
+				}
+				w.Write([]byte(claims.Username))
+			}))
+
+			req := httptest.NewRequest("GET", "/v1/users", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedUser != "" && w.Body.String() != tt.expectedUser {
+				t.Errorf("Expected user %q, got %q", tt.expectedUser, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAllowInternalWarnsWithoutTrustedProxies(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	internal, err := ParseInternalNetworks("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Failed to parse networks: %v", err)
+	}
+	proxies, err := ParseTrustedProxies("10.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to parse proxies: %v", err)
+	}
+
+	AllowInternal(internal, RateLimiterConfig{TrustedProxies: proxies})
+	if logs.Len() != 0 {
+		t.Errorf("Expected no warning with trusted proxies, got %q", logs.String())
+	}
+
+	AllowInternal(internal, RateLimiterConfig{})
+	if !strings.Contains(logs.String(), "TRUSTED_PROXIES") {
+		t.Errorf("Expected a warning about TRUSTED_PROXIES, got %q", logs.String())
+	}
+}
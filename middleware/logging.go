@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LogLevel controls how much LoggingMiddleware writes
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota // Log request start and completion
+	LogLevelInfo                  // Log every completed request
+	LogLevelWarn                  // Log only 4xx and 5xx responses
+	LogLevelError                 // Log only 5xx responses
+)
+
+// ParseLogLevel converts a LOG_LEVEL value into a LogLevel
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "", "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	default:
+		return LogLevelInfo, fmt.Errorf("unknown log level %q", level)
+	}
+}
+
+// LoggingConfig holds all configuration for the logging middleware
+type LoggingConfig struct {
+	Level       LogLevel       // Minimum severity to log
+	SkipPaths   []string       // Paths whose successful requests are never logged
+	Non2xxOnly  bool           // Only log responses outside the 2xx range
+	SampleRates map[string]int // Log 1 in N successful requests per path; errors are always logged
+	Logger      *log.Logger    // Defaults to the standard logger
+}
+
+// ParseSampleRates parses a LOG_SAMPLE_RATES value such as "/v1/leaderboard:10,/v1/me:5"
+func ParseSampleRates(value string) (map[string]int, error) {
+	rates := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		idx := strings.LastIndex(entry, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid sample rate %q, expected path:N", entry)
+		}
+		rate, err := strconv.Atoi(entry[idx+1:])
+		if err != nil || rate < 1 {
+			return nil, fmt.Errorf("invalid sample rate %q, N must be a positive integer", entry)
+		}
+		rates[entry[:idx]] = rate
+	}
+	return rates, nil
+}
+
+// logSampler keeps a request counter per sampled path
+type logSampler struct {
+	rates    map[string]int
+	counters map[string]*atomic.Uint64
+}
+
+func newLogSampler(rates map[string]int) *logSampler {
+	// Counters are created up front so the map is read-only while serving
+	counters := make(map[string]*atomic.Uint64, len(rates))
+	for path := range rates {
+		counters[path] = new(atomic.Uint64)
+	}
+	return &logSampler{rates: rates, counters: counters}
+}
+
+// keep reports whether this request is the 1 in N that gets logged
+func (s *logSampler) keep(path string) bool {
+	rate := s.rates[path]
+	if rate <= 1 {
+		return true
+	}
+	return (s.counters[path].Add(1)-1)%uint64(rate) == 0
+}
+
+// DefaultLoggingConfig returns sensible defaults
+func DefaultLoggingConfig() LoggingConfig {
+	return LoggingConfig{
+		Level:     LogLevelInfo,
+		SkipPaths: []string{"/v1/healthz", "/v1/readiness"},
+	}
+}
+
+// shouldLog decides whether a completed request is worth a log line
+func (c LoggingConfig) shouldLog(path string, status int) bool {
+	// Server errors are always logged
+	if status >= 500 {
+		return true
+	}
+	if status < 400 && slices.Contains(c.SkipPaths, path) {
+		return false
+	}
+	if c.Non2xxOnly && status >= 200 && status < 300 {
+		return false
+	}
+	switch c.Level {
+	case LogLevelError:
+		return false
+	case LogLevelWarn:
+		return status >= 400
+	default:
+		return true
+	}
+}
+
+// responseRecorder captures the status code written by the next handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// WriteHeader records the status code before passing it on
+func (rr *responseRecorder) WriteHeader(code int) {
+	if !rr.wroteHeader {
+		rr.status = code
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+// Write marks the header as written with an implicit 200
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	return rr.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// logInfoContextKey holds the requestLogInfo of the request being logged
+const logInfoContextKey contextKey = "log_info"
+
+// requestLogInfo collects what later middleware learns about a request, since
+// the context they derive never makes it back to the logger
+type requestLogInfo struct {
+	userID string
+}
+
+// setLogUserID records the authenticated user for the completion log line.
+// Only the ID is logged, never the email.
+func setLogUserID(ctx context.Context, userID string) {
+	if info, ok := ctx.Value(logInfoContextKey).(*requestLogInfo); ok {
+		info.userID = userID
+	}
+}
+
+// NewLoggingMiddleware creates request logging middleware with custom config
+func NewLoggingMiddleware(config LoggingConfig) func(http.Handler) http.Handler {
+	logger := config.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	sampler := newLogSampler(config.SampleRates)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			requestID := GetRequestID(r.Context())
+			if config.Level == LogLevelDebug {
+				logger.Printf("Started %s %s%s", r.Method, r.URL.Path, requestIDSuffix(requestID))
+			}
+
+			info := &requestLogInfo{}
+			rec := newResponseRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), logInfoContextKey, info)))
+
+			if !config.shouldLog(r.URL.Path, rec.status) {
+				return
+			}
+			// Errors bypass sampling so they're never lost
+			if rec.status < 400 && !sampler.keep(r.URL.Path) {
+				return
+			}
+			logger.Printf("Completed %s %s %d in %v%s%s", r.Method, r.URL.Path, rec.status, time.Since(start), requestIDSuffix(requestID), userIDSuffix(info.userID))
+		})
+	}
+}
+
+// requestIDSuffix formats the request ID for a log line, if there is one
+func requestIDSuffix(requestID string) string {
+	if requestID == "" {
+		return ""
+	}
+	return " request_id=" + requestID
+}
+
+// userIDSuffix formats the authenticated user for a log line, if there is one
+func userIDSuffix(userID string) string {
+	if userID == "" {
+		return ""
+	}
+	return " user_id=" + userID
+}
+
+// LoggingMiddleware logs incoming requests with the default config.
+func LoggingMiddleware(next http.Handler) http.Handler {
+	return NewLoggingMiddleware(DefaultLoggingConfig())(next)
+}
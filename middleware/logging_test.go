@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/google/uuid"
+)
+
+// newTestLogger returns a logger writing into a buffer the test can inspect
+func newTestLogger() (*log.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return log.New(&buf, "", 0), &buf
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    LogLevel
+		expectError bool
+	}{
+		{input: "debug", expected: LogLevelDebug},
+		{input: "", expected: LogLevelInfo},
+		{input: "INFO", expected: LogLevelInfo},
+		{input: "warn", expected: LogLevelWarn},
+		{input: "error", expected: LogLevelError},
+		{input: "verbose", expected: LogLevelInfo, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			level, err := ParseLogLevel(tt.input)
+			if tt.expectError != (err != nil) {
+				t.Errorf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if level != tt.expected {
+				t.Errorf("Expected level %d, got %d", tt.expected, level)
+			}
+		})
+	}
+}
+
+func TestLoggingMiddlewareFiltering(t *testing.T) {
+	// Handler that returns the status requested in the path
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/err":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/v1/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/v1/redirect":
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.Write([]byte("ok")) // Implicit 200
+		}
+	})
+
+	tests := []struct {
+		name          string
+		config        LoggingConfig
+		path          string
+		expectLogLine bool
+	}{
+		{
+			name:          "health_check_skipped",
+			config:        DefaultLoggingConfig(),
+			path:          "/v1/healthz",
+			expectLogLine: false,
+		},
+		{
+			name:          "error_logged",
+			config:        DefaultLoggingConfig(),
+			path:          "/v1/err",
+			expectLogLine: true,
+		},
+		{
+			name:          "normal_request_logged_at_info",
+			config:        DefaultLoggingConfig(),
+			path:          "/v1/leaderboard",
+			expectLogLine: true,
+		},
+		{
+			name:          "success_suppressed_at_warn",
+			config:        LoggingConfig{Level: LogLevelWarn},
+			path:          "/v1/leaderboard",
+			expectLogLine: false,
+		},
+		{
+			name:          "client_error_logged_at_warn",
+			config:        LoggingConfig{Level: LogLevelWarn},
+			path:          "/v1/missing",
+			expectLogLine: true,
+		},
+		{
+			name:          "client_error_suppressed_at_error",
+			config:        LoggingConfig{Level: LogLevelError},
+			path:          "/v1/missing",
+			expectLogLine: false,
+		},
+		{
+			name:          "success_suppressed_when_non_2xx_only",
+			config:        LoggingConfig{Level: LogLevelInfo, Non2xxOnly: true},
+			path:          "/v1/leaderboard",
+			expectLogLine: false,
+		},
+		{
+			name:          "redirect_logged_when_non_2xx_only",
+			config:        LoggingConfig{Level: LogLevelInfo, Non2xxOnly: true},
+			path:          "/v1/redirect",
+			expectLogLine: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, buf := newTestLogger()
+			tt.config.Logger = logger
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			NewLoggingMiddleware(tt.config)(handler).ServeHTTP(w, req)
+
+			logged := strings.Contains(buf.String(), "Completed GET "+tt.path)
+			if logged != tt.expectLogLine {
+				t.Errorf("Expected log line %v, got log output %q", tt.expectLogLine, buf.String())
+			}
+		})
+	}
+}
+
+func TestLoggingMiddlewareDebugLogsStart(t *testing.T) {
+	logger, buf := newTestLogger()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest("POST", "/v1/games", nil)
+	w := httptest.NewRecorder()
+	NewLoggingMiddleware(LoggingConfig{Level: LogLevelDebug, Logger: logger})(handler).ServeHTTP(w, req)
+
+	output := buf.String()
+	if !strings.Contains(output, "Started POST /v1/games") {
+		t.Errorf("Expected start line at debug level, got %q", output)
+	}
+	if !strings.Contains(output, "Completed POST /v1/games 201") {
+		t.Errorf("Expected completion line with status, got %q", output)
+	}
+}
+
+func TestParseSampleRates(t *testing.T) {
+	rates, err := ParseSampleRates("/v1/leaderboard:10, /v1/me:5")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rates["/v1/leaderboard"] != 10 || rates["/v1/me"] != 5 {
+		t.Errorf("Unexpected rates: %v", rates)
+	}
+
+	for _, invalid := range []string{"/v1/me", "/v1/me:0", "/v1/me:abc", ":5"} {
+		if _, err := ParseSampleRates(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestLoggingMiddlewareSampling(t *testing.T) {
+	logger, buf := newTestLogger()
+	config := LoggingConfig{
+		Level:       LogLevelInfo,
+		SampleRates: map[string]int{"/v1/leaderboard": 10, "/v1/err": 10},
+		Logger:      logger,
+	}
+	handler := NewLoggingMiddleware(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/err" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	for range 100 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/leaderboard", nil))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/err", nil))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/users", nil))
+	}
+
+	output := buf.String()
+	if got := strings.Count(output, "Completed GET /v1/leaderboard "); got != 10 {
+		t.Errorf("Expected 10 of 100 sampled requests logged, got %d", got)
+	}
+	if got := strings.Count(output, "Completed GET /v1/err "); got != 100 {
+		t.Errorf("Expected every error logged despite sampling, got %d", got)
+	}
+	if got := strings.Count(output, "Completed GET /v1/users "); got != 100 {
+		t.Errorf("Expected unsampled path logged every time, got %d", got)
+	}
+}
+
+func TestLoggingMiddlewareIncludesRequestID(t *testing.T) {
+	logger, buf := newTestLogger()
+	handler := RequestIDMiddleware(NewLoggingMiddleware(LoggingConfig{Logger: logger})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	))
+
+	req := httptest.NewRequest("GET", "/v1/me", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("Expected request ID to be echoed, got %q", got)
+	}
+	if !strings.Contains(buf.String(), "request_id=abc-123") {
+		t.Errorf("Expected request ID in log line, got %q", buf.String())
+	}
+
+	// Without an incoming ID one is generated
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/me", nil))
+	if w.Header().Get(RequestIDHeader) == "" {
+		t.Error("Expected a generated request ID")
+	}
+}
+
+func TestLoggingMiddlewareIncludesUserID(t *testing.T) {
+	configureAuth(t)
+
+	user := database.User{ID: uuid.New(), Username: "testuser", Email: "private@example.com"}
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate test token: %v", err)
+	}
+
+	logger, buf := newTestLogger()
+	handler := NewLoggingMiddleware(LoggingConfig{Logger: logger})(AuthMiddleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	))
+
+	req := httptest.NewRequest("GET", "/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.Contains(buf.String(), "user_id="+user.ID.String()) {
+		t.Errorf("Expected user ID in log line, got %q", buf.String())
+	}
+	if strings.Contains(buf.String(), user.Email) {
+		t.Errorf("Expected no email in log line, got %q", buf.String())
+	}
+
+	// Anonymous requests have no user ID
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/me", nil))
+	if strings.Contains(buf.String(), "user_id=") {
+		t.Errorf("Expected no user ID for an anonymous request, got %q", buf.String())
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// MaintenanceConfig holds all configuration for maintenance mode
+type MaintenanceConfig struct {
+	Enabled     bool          // Initial state, can be toggled at runtime with SetEnabled
+	BlockReads  bool          // Also reject GET/HEAD instead of only writes
+	RetryAfter  time.Duration // Sent as Retry-After on rejected requests, omitted when zero
+	BypassPaths []string      // Paths that are always served, e.g. health checks
+}
+
+// DefaultMaintenanceConfig returns sensible defaults
+func DefaultMaintenanceConfig() MaintenanceConfig {
+	return MaintenanceConfig{
+		RetryAfter:  5 * time.Minute,
+		BypassPaths: []string{"/v1/healthz", "/v1/readiness"},
+	}
+}
+
+// Maintenance rejects requests with 503 while maintenance mode is on
+type Maintenance struct {
+	config  MaintenanceConfig
+	enabled atomic.Bool
+}
+
+// NewMaintenance creates a maintenance mode switch with the given config
+func NewMaintenance(config MaintenanceConfig) *Maintenance {
+	m := &Maintenance{config: config}
+	m.enabled.Store(config.Enabled)
+	return m
+}
+
+// Enabled reports whether maintenance mode is currently on
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// SetEnabled turns maintenance mode on or off without a restart
+func (m *Maintenance) SetEnabled(enabled bool) {
+	m.enabled.Store(enabled)
+}
+
+// blocks reports whether a request is rejected while maintenance mode is on
+func (m *Maintenance) blocks(r *http.Request) bool {
+	if slices.Contains(m.config.BypassPaths, r.URL.Path) {
+		return false
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		// Let CORS preflights through so browsers see the real 503
+		return false
+	case http.MethodGet, http.MethodHead:
+		return m.config.BlockReads
+	default:
+		return true
+	}
+}
+
+// Middleware returns 503 with a JSON body for requests blocked by maintenance mode
+func (m *Maintenance) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.Enabled() || !m.blocks(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.config.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(m.config.RetryAfter.Seconds())))
+		}
+		respondWithError(w, http.StatusServiceUnavailable, "Service is undergoing maintenance. Please try again later.")
+	})
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		enabled        bool
+		blockReads     bool
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{name: "disabled_allows_writes", enabled: false, method: "POST", path: "/v1/games", expectedStatus: http.StatusOK},
+		{name: "post_blocked", enabled: true, method: "POST", path: "/v1/games", expectedStatus: http.StatusServiceUnavailable},
+		{name: "put_blocked", enabled: true, method: "PUT", path: "/v1/users/1", expectedStatus: http.StatusServiceUnavailable},
+		{name: "delete_blocked", enabled: true, method: "DELETE", path: "/v1/users/1", expectedStatus: http.StatusServiceUnavailable},
+		{name: "get_allowed", enabled: true, method: "GET", path: "/v1/leaderboard", expectedStatus: http.StatusOK},
+		{name: "head_allowed", enabled: true, method: "HEAD", path: "/v1/leaderboard", expectedStatus: http.StatusOK},
+		{name: "get_blocked_when_reads_blocked", enabled: true, blockReads: true, method: "GET", path: "/v1/leaderboard", expectedStatus: http.StatusServiceUnavailable},
+		{name: "healthz_bypasses", enabled: true, blockReads: true, method: "GET", path: "/v1/healthz", expectedStatus: http.StatusOK},
+		{name: "readiness_bypasses", enabled: true, blockReads: true, method: "GET", path: "/v1/readiness", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultMaintenanceConfig()
+			config.Enabled = tt.enabled
+			config.BlockReads = tt.blockReads
+			m := NewMaintenance(config)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			m.Middleware(okHandler).ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus == http.StatusServiceUnavailable {
+				if got := w.Header().Get("Retry-After"); got != "300" {
+					t.Errorf("Expected Retry-After 300, got %q", got)
+				}
+				if got := w.Header().Get("Content-Type"); got != "application/json" {
+					t.Errorf("Expected JSON body, got content type %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestMaintenanceToggle(t *testing.T) {
+	m := NewMaintenance(MaintenanceConfig{RetryAfter: time.Minute})
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/games", nil))
+		return w.Code
+	}
+
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("Expected writes allowed before enabling, got %d", code)
+	}
+	m.SetEnabled(true)
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected writes blocked after enabling, got %d", code)
+	}
+	m.SetEnabled(false)
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("Expected writes allowed after disabling, got %d", code)
+	}
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DefaultLatencyBuckets are the histogram upper bounds in seconds, from 5ms to 2s
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2}
+
+// unmatchedRoute labels requests that didn't match any route, so random
+// paths from scanners can't blow up the number of series
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels requests with a non-standard method, which clients can
+// make up as freely as paths
+const otherMethod = "other"
+
+// metricsMethod returns the method label for a request method
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return otherMethod
+}
+
+// metricsKey identifies one histogram series
+type metricsKey struct {
+	method string
+	route  string
+	status int
+}
+
+// latencyHistogram counts observations per bucket (not cumulative) plus the total
+type latencyHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// HTTPMetrics records request latencies per chi route pattern and serves them
+// in the Prometheus text format
+type HTTPMetrics struct {
+	bounds []float64
+
+	mu     sync.Mutex
+	series map[metricsKey]*latencyHistogram
+}
+
+// NewHTTPMetrics creates a metrics collector with the given bucket bounds in
+// seconds, or DefaultLatencyBuckets when none are given
+func NewHTTPMetrics(bounds []float64) *HTTPMetrics {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBuckets
+	}
+	bounds = slices.Clone(bounds)
+	slices.Sort(bounds)
+
+	return &HTTPMetrics{
+		bounds: bounds,
+		series: make(map[metricsKey]*latencyHistogram),
+	}
+}
+
+// Observe records one request duration
+func (m *HTTPMetrics) Observe(method, route string, status int, duration time.Duration) {
+	seconds := duration.Seconds()
+	key := metricsKey{method: method, route: route, status: status}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.series[key]
+	if !ok {
+		h = &latencyHistogram{buckets: make([]uint64, len(m.bounds))}
+		m.series[key] = h
+	}
+	// Observations above the last bound only show up in +Inf, i.e. the count
+	if i, _ := slices.BinarySearch(m.bounds, seconds); i < len(m.bounds) {
+		h.buckets[i]++
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Middleware times every request and records it under its route pattern
+func (m *HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		// chi fills in the pattern while routing, so it's only complete after the handler ran
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+		m.Observe(metricsMethod(r.Method), route, rec.status, time.Since(start))
+	})
+}
+
+// Handler serves the collected histograms in the Prometheus text exposition format
+func (m *HTTPMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(m.render()))
+	})
+}
+
+// render formats every series, sorted so the output is stable between scrapes
+func (m *HTTPMetrics) render() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]metricsKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b metricsKey) int {
+		if c := strings.Compare(a.route, b.route); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.method, b.method); c != 0 {
+			return c
+		}
+		return a.status - b.status
+	})
+
+	const name = "http_request_duration_seconds"
+	var sb strings.Builder
+	sb.WriteString("# HELP " + name + " HTTP request latency by route.\n")
+	sb.WriteString("# TYPE " + name + " histogram\n")
+
+	for _, key := range keys {
+		h := m.series[key]
+		labels := fmt.Sprintf(`method="%s",route="%s",status="%d"`, key.method, escapeLabel(key.route), key.status)
+
+		var cumulative uint64
+		for i, bound := range m.bounds {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(&sb, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&sb, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(&sb, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&sb, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+	return sb.String()
+}
+
+// escapeLabel escapes a label value for the text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
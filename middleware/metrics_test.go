@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestHTTPMetricsLatencyBucket(t *testing.T) {
+	metrics := NewHTTPMetrics(nil)
+
+	r := chi.NewRouter()
+	r.Use(metrics.Middleware)
+	r.Get("/v1/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	r.Get("/metrics", metrics.Handler().ServeHTTP)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/users/123", nil))
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+
+	labels := `method="GET",route="/v1/users/{id}",status="200"`
+	expected := []string{
+		// 30ms is above the 25ms bound and below the 50ms one
+		`http_request_duration_seconds_bucket{` + labels + `,le="0.025"} 0`,
+		`http_request_duration_seconds_bucket{` + labels + `,le="+Inf"} 1`,
+		`http_request_duration_seconds_count{` + labels + `} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+	// Bounds are cumulative, so every bucket from 2s down to the first one above 30ms has the request
+	if !strings.Contains(body, `http_request_duration_seconds_bucket{`+labels+`,le="2"} 1`) {
+		t.Errorf("Expected the 2s bucket to contain the request, got:\n%s", body)
+	}
+	if strings.Contains(body, "/v1/users/123") {
+		t.Errorf("Expected the route pattern instead of the raw path, got:\n%s", body)
+	}
+}
+
+func TestHTTPMetricsObserveBuckets(t *testing.T) {
+	metrics := NewHTTPMetrics([]float64{0.1, 0.01})
+
+	metrics.Observe("GET", "/", 200, 5*time.Millisecond)
+	metrics.Observe("GET", "/", 200, 50*time.Millisecond)
+	metrics.Observe("GET", "/", 200, 3*time.Second)
+
+	body := metrics.render()
+	expected := []string{
+		`http_request_duration_seconds_bucket{method="GET",route="/",status="200",le="0.01"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/",status="200",le="0.1"} 2`,
+		`http_request_duration_seconds_bucket{method="GET",route="/",status="200",le="+Inf"} 3`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestHTTPMetricsUnmatchedRoute(t *testing.T) {
+	metrics := NewHTTPMetrics(nil)
+
+	r := chi.NewRouter()
+	r.Use(metrics.Middleware)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/wp-admin/setup.php", nil))
+
+	if body := metrics.render(); !strings.Contains(body, `route="unmatched",status="404"`) {
+		t.Errorf("Expected unmatched request to be grouped, got:\n%s", body)
+	}
+}
+
+func TestHTTPMetricsNonStandardMethod(t *testing.T) {
+	metrics := NewHTTPMetrics(nil)
+
+	r := chi.NewRouter()
+	r.Use(metrics.Middleware)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, method := range []string{"FOO", "BAR", "get"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+	}
+
+	body := metrics.render()
+	if !strings.Contains(body, `http_request_duration_seconds_count{method="other",route="unmatched",status="405"} 3`) {
+		t.Errorf("Expected made-up methods to share one series, got:\n%s", body)
+	}
+	if strings.Contains(body, "FOO") || strings.Contains(body, `method="get"`) {
+		t.Errorf("Expected no series per made-up method, got:\n%s", body)
+	}
+}
@@ -1,32 +1,48 @@
 package middleware
 
 import (
-	"log"
 	"net/http"
-	"time"
+	"slices"
 
 	"github.com/rs/cors"
 )
 
-// LoggingMiddleware logs incoming requests.
-func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		log.Printf("Started %s %s", r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
-		log.Printf("Completed %s %s in %v", r.Method, r.URL.Path, time.Since(start))
-	})
+// corsAllowedOrigins are the origins CorsMiddleware accepts
+var corsAllowedOrigins = []string{"*"} // TODO: Replace * with frontend domain later
+// var corsAllowedOrigins = []string{"http://localhost:3000", "https://your-frontend-domain.com"} // Example
+
+// CORSAllowedOrigins returns the origins CorsMiddleware accepts
+func CORSAllowedOrigins() []string {
+	return slices.Clone(corsAllowedOrigins)
 }
 
-// CorsMiddleware sets up and returns a CORS handler.
-func CorsMiddleware(next http.Handler) http.Handler {
-	// Configure CORS
+// CORSConfig configures one CORS policy, so route groups can allow different origins
+type CORSConfig struct {
+	AllowedOrigins []string // Empty uses the default origins
+}
+
+// Origins returns the origins the policy accepts
+func (c CORSConfig) Origins() []string {
+	if len(c.AllowedOrigins) == 0 {
+		return CORSAllowedOrigins()
+	}
+	return slices.Clone(c.AllowedOrigins)
+}
+
+// NewCORS returns a CORS middleware for the given policy. Preflights are
+// answered by the middleware itself, so a route group using it also needs an
+// OPTIONS route (or a MethodNotAllowed handler) for the middleware to run.
+func NewCORS(config CORSConfig) func(http.Handler) http.Handler {
 	return cors.New(cors.Options{
-		AllowedOrigins: []string{"*"}, // TODO: Replace * with frontend domain later
-		// AllowedOrigins: []string{"http://localhost:3000", "https://your-frontend-domain.com"}, // Example
+		AllowedOrigins: config.Origins(),
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
-		ExposedHeaders: []string{"Link"},
+		ExposedHeaders: []string{"Link", "X-Request-ID", "RateLimit", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "X-Total-Count", "X-Page", "X-Per-Page", "X-Last-Page", "X-Signups-Last-24h", "X-Total-Last-Places", TokenExpiredAtHeader},
 		MaxAge:         300,
-	}).Handler(next) // Wrap the next handler with CORS middleware
+	}).Handler
+}
+
+// CorsMiddleware applies the default CORS policy.
+func CorsMiddleware(next http.Handler) http.Handler {
+	return NewCORS(CORSConfig{})(next)
 }
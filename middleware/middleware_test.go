@@ -3,9 +3,12 @@ package middleware
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,6 +20,16 @@ import (
 	"github.com/google/uuid"
 )
 
+// configureAuth installs a test JWT secret for the rest of the test
+func configureAuth(t *testing.T) {
+	t.Helper()
+	err := auth.Configure(auth.Settings{Secret: "test_secret_key", AccessExpiry: time.Hour, RefreshExpiry: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to configure auth: %v", err)
+	}
+	t.Cleanup(auth.Reset)
+}
+
 // Helper function to create test rate limiter
 func createTestRateLimiter(rate float64, capacity int) *RateLimiter {
 	config := RateLimiterConfig{
@@ -87,9 +100,7 @@ func TestRateLimiterBasic(t *testing.T) {
 }
 
 func TestRateLimiterWithAuth(t *testing.T) {
-	// Setup JWT environment for testing
-	os.Setenv("JWT_SECRET", "test_secret_key")
-	defer os.Unsetenv("JWT_SECRET")
+	configureAuth(t)
 
 	limiter := createTestRateLimiter(1.0, 2)
 	defer limiter.Close()
@@ -172,6 +183,420 @@ func TestRateLimitMiddleware(t *testing.T) {
 	}
 }
 
+func TestRateLimitMiddlewareMethodWeights(t *testing.T) {
+	config := DefaultConfig()
+	config.Rate = 0.001 // No meaningful refill during the test
+	config.Capacity = 6
+	config.MethodWeights = map[string]int{http.MethodPost: 3}
+	limiter := NewRateLimiter(config)
+	defer limiter.Close()
+
+	handler := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// A GET costs one token, leaving 5
+	if w := send(http.MethodGet); w.Code != http.StatusOK {
+		t.Fatalf("GET: expected 200, got %d", w.Code)
+	}
+	if remaining := parseRateLimitHeader(t, send(http.MethodGet).Header().Get("RateLimit"))["remaining"]; remaining != 4 {
+		t.Errorf("Expected 4 tokens left after two GETs, got %d", remaining)
+	}
+
+	// A POST costs three, leaving 1
+	w := send(http.MethodPost)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST: expected 200, got %d", w.Code)
+	}
+	if remaining := parseRateLimitHeader(t, w.Header().Get("RateLimit"))["remaining"]; remaining != 1 {
+		t.Errorf("Expected 1 token left after a POST, got %d", remaining)
+	}
+
+	// One token is too few for another POST but enough for a GET
+	if w := send(http.MethodPost); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Second POST: expected 429, got %d", w.Code)
+	}
+	if w := send(http.MethodGet); w.Code != http.StatusOK {
+		t.Errorf("GET after denied POST: expected 200, got %d", w.Code)
+	}
+}
+
+func TestRateLimiterWeightAboveCapacity(t *testing.T) {
+	config := DefaultConfig()
+	config.Rate = 0.001
+	config.Capacity = 2
+	limiter := NewRateLimiter(config)
+	defer limiter.Close()
+
+	// Charged as the whole bucket rather than denied forever
+	if allowed, _ := limiter.AllowN("client", 5); !allowed {
+		t.Error("Expected a cost above capacity to pass on a full bucket")
+	}
+	if allowed, _ := limiter.AllowN("client", 1); allowed {
+		t.Error("Expected the bucket to be empty afterwards")
+	}
+}
+
+func TestParseMethodWeights(t *testing.T) {
+	weights, err := ParseMethodWeights("post=3, DELETE=2,")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if weights["POST"] != 3 || weights["DELETE"] != 2 || len(weights) != 2 {
+		t.Errorf("Unexpected weights %v", weights)
+	}
+
+	for _, value := range []string{"POST", "POST=0", "POST=many"} {
+		if _, err := ParseMethodWeights(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+// parseRateLimitHeader parses "limit=30, remaining=27, reset=12" into its fields
+func parseRateLimitHeader(t *testing.T, header string) map[string]int {
+	t.Helper()
+	fields := make(map[string]int)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			t.Fatalf("Malformed RateLimit header %q", header)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			t.Fatalf("Non-numeric %s in RateLimit header %q", key, header)
+		}
+		fields[key] = n
+	}
+	return fields
+}
+
+func TestRateLimitMiddlewareQuotaHeaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		noLegacy     bool
+		expectLegacy bool
+	}{
+		{name: "with_legacy", expectLegacy: true},
+		{name: "standard_only", noLegacy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := createTestRateLimiter(1.0, 5)
+			defer limiter.Close()
+			limiter.config.NoLegacyHeaders = tt.noLegacy
+
+			handler := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+
+			quota := parseRateLimitHeader(t, w.Header().Get("RateLimit"))
+			if quota["limit"] != 5 {
+				t.Errorf("Expected limit 5, got %d", quota["limit"])
+			}
+			if quota["remaining"] != 3 {
+				t.Errorf("Expected remaining 3 after two requests, got %d", quota["remaining"])
+			}
+			// Two tokens used at one token per second
+			if quota["reset"] < 1 || quota["reset"] > 2 {
+				t.Errorf("Expected reset between 1 and 2, got %d", quota["reset"])
+			}
+
+			if legacy := w.Header().Get("X-RateLimit-Remaining"); (legacy != "") != tt.expectLegacy {
+				t.Errorf("Expected legacy headers %v, got X-RateLimit-Remaining %q", tt.expectLegacy, legacy)
+			} else if tt.expectLegacy && legacy != "3" {
+				t.Errorf("Expected X-RateLimit-Remaining 3, got %q", legacy)
+			}
+			// Both headers report the bucket capacity, not the refill rate
+			if legacy := w.Header().Get("X-RateLimit-Limit"); tt.expectLegacy && legacy != strconv.Itoa(quota["limit"]) {
+				t.Errorf("Expected X-RateLimit-Limit to match RateLimit limit=%d, got %q", quota["limit"], legacy)
+			}
+		})
+	}
+}
+
+func TestRateLimiterClientLimitOverride(t *testing.T) {
+	limiter := createTestRateLimiter(0.01, 2)
+	defer limiter.Close()
+
+	partner := UserClientID(uuid.NewString())
+	limiter.SetClientLimit(partner, 0.01, 5)
+
+	allowed := 0
+	for range 10 {
+		if limiter.Allow(partner) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Expected overridden client to get 5 requests, got %d", allowed)
+	}
+
+	allowed = 0
+	for range 10 {
+		if limiter.Allow("ip:203.0.113.1") {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("Expected other clients to keep the configured capacity of 2, got %d", allowed)
+	}
+}
+
+func TestRateLimiterClientLimitAppliesToLiveBucket(t *testing.T) {
+	limiter := createTestRateLimiter(0.01, 1)
+	defer limiter.Close()
+
+	clientID := "ip:203.0.113.1"
+	if !limiter.Allow(clientID) || limiter.Allow(clientID) {
+		t.Fatal("Expected the configured capacity of 1 before the override")
+	}
+
+	// Raising the rate lets the existing bucket refill quickly
+	limiter.SetClientLimit(clientID, 1000, 3)
+	time.Sleep(10 * time.Millisecond)
+	if !limiter.Allow(clientID) {
+		t.Error("Expected the override to apply to the existing bucket")
+	}
+
+	limiter.RemoveClientLimit(clientID)
+	if _, capacity := limiter.clientLimits(clientID); capacity != 1 {
+		t.Errorf("Expected capacity 1 after removing the override, got %d", capacity)
+	}
+}
+
+func TestUserClientIDMatchesTokenKeying(t *testing.T) {
+	configureAuth(t)
+
+	limiter := createTestRateLimiter(1.0, 2)
+	defer limiter.Close()
+
+	user := database.User{ID: uuid.New(), Username: "partner", Email: "partner@example.com"}
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if got, expected := limiter.getClientID(req), UserClientID(user.ID.String()); got != expected {
+		t.Errorf("Expected client ID %s, got %s", expected, got)
+	}
+}
+
+func TestRateLimiterClientIDFunc(t *testing.T) {
+	config := DefaultConfig()
+	config.Rate = 0.001
+	config.Capacity = 1
+	config.ClientIDFunc = func(r *http.Request) string {
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			return "key:" + key
+		}
+		return ""
+	}
+	limiter := NewRateLimiter(config)
+	defer limiter.Close()
+
+	handler := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(apiKey, remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// The key picks the bucket whatever address the request comes from
+	if code := send("alpha", "192.168.1.1:1234"); code != http.StatusOK {
+		t.Fatalf("First alpha request: expected 200, got %d", code)
+	}
+	if code := send("alpha", "192.168.1.2:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Second alpha request from another IP: expected 429, got %d", code)
+	}
+	if code := send("beta", "192.168.1.1:1234"); code != http.StatusOK {
+		t.Errorf("beta request from alpha's IP: expected 200, got %d", code)
+	}
+
+	// Without a key the default IP keying applies
+	if code := send("", "192.168.1.1:1234"); code != http.StatusOK {
+		t.Errorf("Keyless request: expected 200, got %d", code)
+	}
+	if code := send("", "192.168.1.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Second keyless request: expected 429, got %d", code)
+	}
+}
+
+func TestNoopLimiterAllowsBurst(t *testing.T) {
+	var limiter Limiter = NoopLimiter{}
+	defer limiter.Close()
+
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Far beyond the capacity of any configured limiter
+	for i := range 100 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i+1, w.Code)
+		}
+	}
+
+	for name, value := range limiter.GetMetrics() {
+		if value != 0 {
+			t.Errorf("Expected metric %s to be 0, got %d", name, value)
+		}
+	}
+}
+
+func TestRateLimitMiddlewareBody(t *testing.T) {
+	tests := []struct {
+		name            string
+		message         string
+		detailed        bool
+		expectedMessage string
+	}{
+		{name: "default", expectedMessage: DefaultRateLimitMessage},
+		{name: "custom_message", message: "Slow down!", expectedMessage: "Slow down!"},
+		{name: "detailed", detailed: true, expectedMessage: DefaultRateLimitMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Rate = 0.5 // One token every 2 seconds
+			config.Capacity = 1
+			config.ExceededMessage = tt.message
+			config.DetailedBody = tt.detailed
+			limiter := NewRateLimiter(config)
+			defer limiter.Close()
+
+			handler := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			before := time.Now().UTC().Truncate(time.Second)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != http.StatusTooManyRequests {
+				t.Fatalf("Expected status 429, got %d", w.Code)
+			}
+
+			var body struct {
+				Success    bool    `json:"success"`
+				Error      string  `json:"error"`
+				Code       string  `json:"code"`
+				Limit      *int    `json:"limit"`
+				RetryAfter *int    `json:"retry_after"`
+				ResetAt    *string `json:"reset_at"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode 429 body: %v", err)
+			}
+			if body.Success || body.Error != tt.expectedMessage || body.Code != RateLimitedCode {
+				t.Errorf("Unexpected 429 body %+v", body)
+			}
+
+			if !tt.detailed {
+				if body.Limit != nil || body.RetryAfter != nil || body.ResetAt != nil {
+					t.Error("Expected no quota fields unless enabled")
+				}
+				return
+			}
+			if body.Limit == nil || *body.Limit != 1 {
+				t.Errorf("Expected limit 1, got %v", body.Limit)
+			}
+			if body.RetryAfter == nil || *body.RetryAfter != 2 {
+				t.Errorf("Expected retry_after 2, got %v", body.RetryAfter)
+			}
+			if body.ResetAt == nil {
+				t.Fatal("Expected reset_at")
+			}
+			resetAt, err := time.Parse(time.RFC3339, *body.ResetAt)
+			if err != nil {
+				t.Fatalf("Expected an RFC 3339 reset_at, got %q", *body.ResetAt)
+			}
+			if want := before.Add(2 * time.Second); resetAt.Before(want) || resetAt.After(want.Add(time.Second)) {
+				t.Errorf("Expected reset_at about %s, got %s", want, resetAt)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddlewareRetryAfterFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{name: "default", format: ""},
+		{name: "seconds", format: RetryAfterSeconds},
+		{name: "http-date", format: RetryAfterHTTPDate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Rate = 0.5 // One token every 2 seconds
+			config.Capacity = 1
+			config.RetryAfterFormat = tt.format
+			limiter := NewRateLimiter(config)
+			defer limiter.Close()
+
+			handler := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			before := time.Now().UTC().Truncate(time.Second)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != http.StatusTooManyRequests {
+				t.Fatalf("Expected status 429, got %d", w.Code)
+			}
+
+			// Reset stays in seconds whatever the Retry-After format
+			if got := w.Header().Get("X-RateLimit-Reset"); got != "2" {
+				t.Errorf("Expected X-RateLimit-Reset 2, got %q", got)
+			}
+
+			retryAfter := w.Header().Get("Retry-After")
+			if tt.format != RetryAfterHTTPDate {
+				if retryAfter != "2" {
+					t.Errorf("Expected Retry-After 2, got %q", retryAfter)
+				}
+				return
+			}
+
+			retryAt, err := http.ParseTime(retryAfter)
+			if err != nil {
+				t.Fatalf("Expected Retry-After as HTTP date, got %q: %v", retryAfter, err)
+			}
+			if !strings.HasSuffix(retryAfter, "GMT") {
+				t.Errorf("Expected Retry-After in GMT, got %q", retryAfter)
+			}
+			if delay := retryAt.Sub(before); delay < 2*time.Second || delay > 3*time.Second {
+				t.Errorf("Expected Retry-After about 2 seconds out, got %v", delay)
+			}
+		})
+	}
+}
+
 // Keep your existing TestAuthMiddleware and TestGetUserFromContext functions
 // Remove any duplicate declarations
 
@@ -186,6 +611,7 @@ func TestRateLimiterIPExtraction(t *testing.T) {
 		remoteAddr    string
 		xForwardedFor string
 		xRealIP       string
+		hops          int
 		expectedIP    string
 	}{
 		{
@@ -237,10 +663,54 @@ func TestRateLimiterIPExtraction(t *testing.T) {
 			remoteAddr: "192.168.1.100",
 			expectedIP: "192.168.1.100",
 		},
+		{
+			name:          "One hop selects entry before the proxy",
+			remoteAddr:    "10.0.0.2:12345",
+			xForwardedFor: "203.0.113.1, 198.51.100.1",
+			hops:          1,
+			expectedIP:    "203.0.113.1",
+		},
+		{
+			name:          "One hop ignores spoofed left-most entry",
+			remoteAddr:    "10.0.0.2:12345",
+			xForwardedFor: "1.2.3.4, 203.0.113.1, 198.51.100.1",
+			hops:          1,
+			expectedIP:    "203.0.113.1",
+		},
+		{
+			name:          "CDN and ingress chain with two hops",
+			remoteAddr:    "10.0.0.3:12345",
+			xForwardedFor: "1.2.3.4, 203.0.113.1, 198.51.100.1, 10.0.0.2",
+			hops:          2,
+			expectedIP:    "203.0.113.1",
+		},
+		{
+			name:          "Chain shorter than hops falls back to RemoteAddr",
+			remoteAddr:    "10.0.0.2:12345",
+			xForwardedFor: "203.0.113.1",
+			hops:          2,
+			expectedIP:    "10.0.0.2",
+		},
+		{
+			name:          "Invalid entry at hop position falls back to X-Real-IP",
+			remoteAddr:    "10.0.0.2:12345",
+			xForwardedFor: "203.0.113.1, not-an-ip, 198.51.100.1",
+			xRealIP:       "203.0.113.2",
+			hops:          1,
+			expectedIP:    "203.0.113.2",
+		},
+		{
+			name:          "IPv6 entry with hops",
+			remoteAddr:    "10.0.0.2:12345",
+			xForwardedFor: "2001:db8::1, 198.51.100.1",
+			hops:          1,
+			expectedIP:    "2001:db8::1",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			limiter.config.TrustedProxyHops = tt.hops
 			req := httptest.NewRequest("GET", "/test", nil)
 			req.RemoteAddr = tt.remoteAddr
 			if tt.xForwardedFor != "" {
@@ -265,6 +735,44 @@ func TestRateLimiterIPExtraction(t *testing.T) {
 	}
 }
 
+func TestRateLimiterIPv6PrefixGrouping(t *testing.T) {
+	config := DefaultConfig()
+	limiter := NewRateLimiter(config)
+	defer limiter.Close()
+
+	clientID := func(remoteAddr string) string {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = remoteAddr
+		return limiter.getClientID(req)
+	}
+
+	// Two addresses in the same /64 share a bucket
+	first := clientID("[2001:db8:1:2:aaaa::1]:12345")
+	second := clientID("[2001:db8:1:2:bbbb:cccc:dddd:eeee]:54321")
+	if first != second {
+		t.Errorf("Expected same client ID within a /64, got %s and %s", first, second)
+	}
+	if first != "ip:2001:db8:1:2::/64" {
+		t.Errorf("Expected prefix client ID, got %s", first)
+	}
+
+	// A different /64 gets its own bucket
+	if other := clientID("[2001:db8:1:3::1]:12345"); other == first {
+		t.Errorf("Expected different client ID for a different /64, got %s", other)
+	}
+
+	// IPv4 stays per-address
+	if got := clientID("192.168.1.100:12345"); got != "ip:192.168.1.100" {
+		t.Errorf("Expected per-address IPv4 client ID, got %s", got)
+	}
+
+	// Disabling grouping keeps full IPv6 addresses
+	limiter.config.IPv6PrefixLen = 0
+	if got := clientID("[2001:db8:1:2:aaaa::1]:12345"); got != "ip:2001:db8:1:2:aaaa::1" {
+		t.Errorf("Expected full IPv6 client ID with grouping disabled, got %s", got)
+	}
+}
+
 func TestRateLimiterAllowWithRetryInfo(t *testing.T) {
 	limiter := createTestRateLimiter(1.0, 2) // 1 token/second, capacity 2
 	defer limiter.Close()
@@ -296,6 +804,54 @@ func TestRateLimiterAllowWithRetryInfo(t *testing.T) {
 	}
 }
 
+func TestRateLimiterAllowN(t *testing.T) {
+	limiter := createTestRateLimiter(1.0, 5) // 1 token/second, capacity 5
+	defer limiter.Close()
+
+	clientID := "bulk-client"
+
+	// Three tokens at once leaves 2
+	allowed, retryAfter := limiter.AllowN(clientID, 3)
+	if !allowed || retryAfter != 0 {
+		t.Fatalf("Expected first AllowN(3) to pass, got allowed=%v retryAfter=%d", allowed, retryAfter)
+	}
+
+	// Two left is one short of another 3, so wait for the missing token
+	allowed, retryAfter = limiter.AllowN(clientID, 3)
+	if allowed {
+		t.Fatal("Expected second AllowN(3) to be denied")
+	}
+	if retryAfter != 1 {
+		t.Errorf("Expected retry after 1 second for 1 missing token, got %d", retryAfter)
+	}
+
+	// The denial didn't consume anything, so 2 single requests still fit
+	if !limiter.Allow(clientID) || !limiter.Allow(clientID) {
+		t.Error("Expected the 2 remaining tokens to be usable")
+	}
+
+	// Empty now, so 3 tokens take 3 seconds
+	if _, retryAfter = limiter.AllowN(clientID, 3); retryAfter != 3 {
+		t.Errorf("Expected retry after 3 seconds for 3 missing tokens, got %d", retryAfter)
+	}
+
+	// A zero or negative cost still takes a token rather than slipping through
+	for _, n := range []int{0, -5} {
+		if allowed, retryAfter = limiter.AllowN(clientID, n); allowed || retryAfter != 1 {
+			t.Errorf("Expected AllowN(%d) on an empty bucket to wait for 1 token, got allowed=%v retryAfter=%d", n, allowed, retryAfter)
+		}
+	}
+	if allowed, _ := limiter.AllowN("fresh-client", 0); !allowed {
+		t.Fatal("Expected the first AllowN(0) of a new client to pass")
+	}
+	for range 4 {
+		limiter.Allow("fresh-client")
+	}
+	if limiter.Allow("fresh-client") {
+		t.Error("Expected the first AllowN(0) to have charged a token")
+	}
+}
+
 func TestRateLimiterMaxBuckets(t *testing.T) {
 	// Create limiter with very low bucket limit
 	config := RateLimiterConfig{
@@ -565,6 +1121,85 @@ func TestRateLimiterAuthEdgeCases(t *testing.T) {
 	}
 }
 
+func TestRateLimiterClientIPHeaders(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.5")
+	if err != nil {
+		t.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		headers    []string
+		proxies    []*net.IPNet
+		remoteAddr string
+		expectedIP string
+	}{
+		{
+			name:       "CF-Connecting-IP honored when configured",
+			headers:    []string{"CF-Connecting-IP", "X-Forwarded-For"},
+			remoteAddr: "10.0.0.1:12345",
+			expectedIP: "203.0.113.7",
+		},
+		{
+			name:       "CF-Connecting-IP ignored by default",
+			remoteAddr: "10.0.0.1:12345",
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "Headers honored from trusted proxy",
+			headers:    []string{"CF-Connecting-IP"},
+			proxies:    trusted,
+			remoteAddr: "192.168.1.5:12345",
+			expectedIP: "203.0.113.7",
+		},
+		{
+			name:       "Headers ignored from untrusted source",
+			headers:    []string{"CF-Connecting-IP"},
+			proxies:    trusted,
+			remoteAddr: "192.0.2.10:12345",
+			expectedIP: "192.0.2.10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := createTestRateLimiter(1.0, 2)
+			defer limiter.Close()
+			limiter.config.ClientIPHeaders = tt.headers
+			limiter.config.TrustedProxies = tt.proxies
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("CF-Connecting-IP", "203.0.113.7")
+			req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+			if ip := limiter.getRealIP(req); ip != tt.expectedIP {
+				t.Errorf("Expected IP %s, got %s", tt.expectedIP, ip)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	networks, err := ParseTrustedProxies("10.0.0.0/8, 2001:db8::1,")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(networks) != 2 {
+		t.Fatalf("Expected 2 networks, got %d", len(networks))
+	}
+	if !networks[1].Contains(net.ParseIP("2001:db8::1")) || networks[1].Contains(net.ParseIP("2001:db8::2")) {
+		t.Errorf("Expected bare address to match only itself, got %s", networks[1])
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+	if _, err := ParseTrustedProxies("proxy.local"); err == nil {
+		t.Error("Expected error for hostname")
+	}
+}
+
 func TestRateLimiterIPExtractionEdgeCases(t *testing.T) {
 	limiter := createTestRateLimiter(1.0, 2)
 	defer limiter.Close()
@@ -616,15 +1251,97 @@ func TestRateLimiterCalculateRetryAfterEdgeCases(t *testing.T) {
 	}
 
 	// Should hit max retry limit
-	retryAfter := limiter.calculateRetryAfter(bucket, time.Now())
+	retryAfter := limiter.calculateRetryAfter(bucket, 1, time.Now())
 	if retryAfter != 10 { // MaxRetryAfter is 10 seconds
 		t.Errorf("Expected max retry after 10, got %d", retryAfter)
 	}
 
 	// Test with fractional tokens
 	bucket.tokens = 0.7
-	retryAfter = limiter.calculateRetryAfter(bucket, time.Now())
+	retryAfter = limiter.calculateRetryAfter(bucket, 1, time.Now())
 	if retryAfter < 1 {
 		t.Errorf("Expected at least 1 second retry, got %d", retryAfter)
 	}
 }
+
+func TestRateLimiterConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*RateLimiterConfig)
+		valid  bool
+	}{
+		{name: "defaults", modify: func(c *RateLimiterConfig) {}, valid: true},
+		{name: "zero_rate", modify: func(c *RateLimiterConfig) { c.Rate = 0 }},
+		{name: "negative_rate", modify: func(c *RateLimiterConfig) { c.Rate = -1 }},
+		{name: "nan_rate", modify: func(c *RateLimiterConfig) { c.Rate = math.NaN() }},
+		{name: "infinite_rate", modify: func(c *RateLimiterConfig) { c.Rate = math.Inf(1) }},
+		{name: "zero_capacity", modify: func(c *RateLimiterConfig) { c.Capacity = 0 }},
+		{name: "negative_capacity", modify: func(c *RateLimiterConfig) { c.Capacity = -3 }},
+		{name: "zero_cleanup_interval", modify: func(c *RateLimiterConfig) { c.CleanupInterval = 0 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.modify(&config)
+			if err := config.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Expected valid=%v, got %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestNewRateLimiterInvalidConfig(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "capacity") {
+			t.Errorf("Expected a panic naming the capacity, got %v", r)
+		}
+	}()
+	config := DefaultConfig()
+	config.Capacity = -1
+	NewRateLimiter(config).Close()
+}
+
+func TestRateLimiterEmailClientID(t *testing.T) {
+	config := DefaultConfig()
+	config.Rate = 0.001
+	config.Capacity = 2
+	config.ClientIDFunc = EmailClientID
+	limiter := NewRateLimiter(config)
+	defer limiter.Close()
+
+	handler := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler still gets the whole body
+		body, err := io.ReadAll(r.Body)
+		if err != nil || !strings.Contains(string(body), `"email"`) {
+			t.Errorf("Expected the body to reach the handler, got %q (%v)", body, err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(email, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/password-reset/request", strings.NewReader(`{"email":"`+email+`"}`))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Repeated requests for one address are throttled whatever IP they come from
+	for i, addr := range []string{"192.168.1.1:1234", "192.168.1.2:1234"} {
+		if w := send("victim@example.com", addr); w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200, got %d", i+1, w.Code)
+		}
+	}
+	w := send(" Victim@Example.com ", "192.168.1.3:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Third request for the same email: expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header on the 429")
+	}
+
+	// Another address has its own bucket
+	if w := send("other@example.com", "192.168.1.3:1234"); w.Code != http.StatusOK {
+		t.Errorf("Request for another email: expected 200, got %d", w.Code)
+	}
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultSlowQueryThreshold is how long a query runs before QueryLogger calls it slow
+const DefaultSlowQueryThreshold = 200 * time.Millisecond
+
+// maxLoggedQueryLength caps the SQL written for queries that aren't named by sqlc
+const maxLoggedQueryLength = 100
+
+// QueryLogConfig holds the configuration for QueryLogger
+type QueryLogConfig struct {
+	Level         LogLevel      // Debug logs every query, Info and Warn slow or failed ones, Error only failed ones
+	SlowThreshold time.Duration // Zero uses DefaultSlowQueryThreshold
+	Logger        *log.Logger   // Defaults to the standard logger
+}
+
+// QueryLogger is a pgx.QueryTracer that logs queries with the ID of the
+// request that ran them, so a slow query can be tied back to its request
+type QueryLogger struct {
+	config QueryLogConfig
+	logger *log.Logger
+	now    func() time.Time // Swappable for tests
+}
+
+// NewQueryLogger creates a QueryLogger, set it as the pool's ConnConfig.Tracer
+func NewQueryLogger(config QueryLogConfig) *QueryLogger {
+	if config.SlowThreshold <= 0 {
+		config.SlowThreshold = DefaultSlowQueryThreshold
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &QueryLogger{config: config, logger: logger, now: time.Now}
+}
+
+// queryTraceKey holds the running query in its context between the trace hooks
+type queryTraceKey struct{}
+
+type queryTrace struct {
+	sql   string
+	start time.Time
+}
+
+// TraceQueryStart records when a query started. Arguments are never kept, so
+// passwords and personal data can't end up in the logs.
+func (q *QueryLogger) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryTraceKey{}, queryTrace{sql: data.SQL, start: q.now()})
+}
+
+// TraceQueryEnd logs the query if the configured level asks for it
+func (q *QueryLogger) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	trace, ok := ctx.Value(queryTraceKey{}).(queryTrace)
+	if !ok {
+		return
+	}
+	elapsed := q.now().Sub(trace.start)
+	slow := elapsed >= q.config.SlowThreshold
+
+	switch {
+	case data.Err != nil:
+		q.logger.Printf("Query %s failed after %v: %v%s", queryName(trace.sql), elapsed, data.Err, requestIDSuffix(GetRequestID(ctx)))
+	case slow && q.config.Level <= LogLevelWarn:
+		q.logger.Printf("Slow query %s took %v%s", queryName(trace.sql), elapsed, requestIDSuffix(GetRequestID(ctx)))
+	case q.config.Level == LogLevelDebug:
+		q.logger.Printf("Query %s took %v%s", queryName(trace.sql), elapsed, requestIDSuffix(GetRequestID(ctx)))
+	}
+}
+
+// queryName returns the sqlc name of a query, e.g. "GetUser", or its
+// whitespace-collapsed SQL for queries written by hand
+func queryName(sql string) string {
+	if rest, ok := strings.CutPrefix(sql, "-- name: "); ok {
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			return fields[0]
+		}
+	}
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) > maxLoggedQueryLength {
+		sql = sql[:maxLoggedQueryLength] + "..."
+	}
+	return sql
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// traceQuery runs a query taking elapsed on a fake clock through the tracer hooks the way pgx calls them
+func traceQuery(ctx context.Context, tracer *QueryLogger, sql string, elapsed time.Duration, err error) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracer.now = func() time.Time { return now }
+	ctx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: sql, Args: []any{"hunter2"}})
+	now = now.Add(elapsed)
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: err})
+}
+
+func TestQueryLoggerRequestID(t *testing.T) {
+	logger, buf := newTestLogger()
+	tracer := NewQueryLogger(QueryLogConfig{Level: LogLevelInfo, Logger: logger})
+
+	ctx := context.WithValue(context.Background(), RequestIDContextKey, "req-123")
+	traceQuery(ctx, tracer, "-- name: GetUser :one\nSELECT id FROM users WHERE id = $1", time.Second, nil)
+
+	line := buf.String()
+	if !strings.Contains(line, "request_id=req-123") {
+		t.Errorf("Expected the request ID in the log line, got %q", line)
+	}
+	if !strings.Contains(line, "Slow query GetUser took 1s") {
+		t.Errorf("Expected the sqlc query name and duration in the log line, got %q", line)
+	}
+	if strings.Contains(line, "hunter2") || strings.Contains(line, "SELECT") {
+		t.Errorf("Expected no SQL or arguments in the log line, got %q", line)
+	}
+}
+
+func TestQueryLoggerLevels(t *testing.T) {
+	tests := []struct {
+		name     string
+		level    LogLevel
+		elapsed  time.Duration
+		err      error
+		expected string // Empty means nothing is logged
+	}{
+		{name: "debug_fast", level: LogLevelDebug, elapsed: 5 * time.Millisecond, expected: "Query GetUser took 5ms"},
+		{name: "info_fast", level: LogLevelInfo, elapsed: 99 * time.Millisecond},
+		{name: "info_at_threshold", level: LogLevelInfo, elapsed: 100 * time.Millisecond, expected: "Slow query GetUser took 100ms"},
+		{name: "warn_slow", level: LogLevelWarn, elapsed: time.Second, expected: "Slow query GetUser took 1s"},
+		{name: "error_slow", level: LogLevelError, elapsed: time.Second},
+		{name: "error_failed", level: LogLevelError, elapsed: time.Millisecond, err: errors.New("boom"), expected: "Query GetUser failed after 1ms: boom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, buf := newTestLogger()
+			tracer := NewQueryLogger(QueryLogConfig{Level: tt.level, SlowThreshold: 100 * time.Millisecond, Logger: logger})
+
+			traceQuery(context.Background(), tracer, "-- name: GetUser :one\nSELECT 1", tt.elapsed, tt.err)
+
+			if tt.expected == "" && buf.Len() != 0 {
+				t.Errorf("Expected nothing logged, got %q", buf.String())
+			}
+			if tt.expected != "" && !strings.Contains(buf.String(), tt.expected) {
+				t.Errorf("Expected %q in the log, got %q", tt.expected, buf.String())
+			}
+			if strings.Contains(buf.String(), "request_id=") {
+				t.Errorf("Expected no request ID outside a request, got %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestQueryName(t *testing.T) {
+	if name := queryName("-- name: ListUsers :many\nSELECT *"); name != "ListUsers" {
+		t.Errorf("Expected ListUsers, got %q", name)
+	}
+	if name := queryName("LISTEN\n  \"leaderboard_changed\""); name != `LISTEN "leaderboard_changed"` {
+		t.Errorf("Expected collapsed SQL, got %q", name)
+	}
+	if name := queryName(strings.Repeat("x", 150)); len(name) != maxLoggedQueryLength+len("...") {
+		t.Errorf("Expected long SQL to be truncated, got %d bytes", len(name))
+	}
+}
@@ -1,13 +1,16 @@
 package middleware
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,23 +22,117 @@ import (
 
 // RateLimiterConfig holds all configuration for the rate limiter
 type RateLimiterConfig struct {
-	Rate            float64       // Tokens per second
-	Capacity        int           // Bucket capacity
-	MaxBuckets      int           // Maximum concurrent buckets
-	CleanupInterval time.Duration // How often to cleanup old buckets
-	BucketTTL       time.Duration // How long before a bucket expires
-	MaxRetryAfter   time.Duration // Maximum retry-after time
+	Rate             float64                    // Tokens per second
+	Capacity         int                        // Bucket capacity
+	MaxBuckets       int                        // Maximum concurrent buckets
+	CleanupInterval  time.Duration              // How often to cleanup old buckets
+	BucketTTL        time.Duration              // How long before a bucket expires
+	MaxRetryAfter    time.Duration              // Maximum retry-after time
+	RetryAfterFormat string                     // RetryAfterSeconds (default) or RetryAfterHTTPDate
+	TrustedProxyHops int                        // Proxies in front of us that append to X-Forwarded-For, 0 takes the left-most entry
+	IPv6PrefixLen    int                        // IPv6 clients in the same prefix share a bucket, 0 buckets per address
+	ClientIPHeaders  []string                   // Headers carrying the client IP in priority order, defaults to X-Forwarded-For then X-Real-IP
+	TrustedProxies   []*net.IPNet               // Only read ClientIPHeaders on requests from these networks, empty trusts any source
+	NoLegacyHeaders  bool                       // Only send the standard RateLimit header, not X-RateLimit-*
+	ExceededMessage  string                     // 429 error message, defaults to DefaultRateLimitMessage
+	DetailedBody     bool                       // Add limit, retry_after and reset_at to the 429 body
+	MethodWeights    map[string]int             // Tokens a request costs by HTTP method, unlisted methods cost 1
+	ClientIDFunc     func(*http.Request) string // Custom bucket key, e.g. an API key or tenant; an empty result falls back to the default
 }
 
+// DefaultRateLimitMessage is the 429 error message when ExceededMessage is unset
+const DefaultRateLimitMessage = "Rate limit exceeded. Please try again later."
+
+// RateLimitedCode is the error code of every 429 body
+const RateLimitedCode = "RATE_LIMITED"
+
+// rateLimitResponse is the 429 body. The quota fields are only set with DetailedBody.
+type rateLimitResponse struct {
+	models.ErrorResponse
+	Limit      int    `json:"limit,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"` // Seconds, whatever the Retry-After header format
+	ResetAt    string `json:"reset_at,omitempty"`    // RFC 3339 time the next request is allowed
+}
+
+// defaultClientIPHeaders is used when ClientIPHeaders is empty
+var defaultClientIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// ParseTrustedProxies parses a TRUSTED_PROXIES value such as "10.0.0.0/8,192.168.1.5".
+// Bare addresses are treated as single-host networks.
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	return parseNetworks(value, "trusted proxy")
+}
+
+// parseNetworks parses a comma-separated list of CIDRs and bare addresses,
+// naming what the list is for in errors
+func parseNetworks(value, kind string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s %q", kind, entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", kind, entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ParseMethodWeights parses a RATE_LIMIT_METHOD_WEIGHTS value such as
+// "POST=3,PUT=3,DELETE=3". Methods are upper-cased.
+func ParseMethodWeights(value string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		method, weight, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid method weight %q", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid method weight %q", entry)
+		}
+		weights[strings.ToUpper(strings.TrimSpace(method))] = n
+	}
+	return weights, nil
+}
+
+// Retry-After header formats
+const (
+	RetryAfterSeconds  = "seconds"
+	RetryAfterHTTPDate = "http-date"
+)
+
 // DefaultConfig returns sensible defaults
 func DefaultConfig() RateLimiterConfig {
 	return RateLimiterConfig{
-		Rate:            10.0,             // 10 requests per second
-		Capacity:        20,               // Burst of 20
-		MaxBuckets:      10000,            // 10k concurrent clients
-		CleanupInterval: 5 * time.Minute,  // Cleanup every 5 minutes
-		BucketTTL:       10 * time.Minute, // Expire buckets after 10 minutes
-		MaxRetryAfter:   5 * time.Minute,  // Max 5 minute retry
+		Rate:             10.0,              // 10 requests per second
+		Capacity:         20,                // Burst of 20
+		MaxBuckets:       10000,             // 10k concurrent clients
+		CleanupInterval:  5 * time.Minute,   // Cleanup every 5 minutes
+		BucketTTL:        10 * time.Minute,  // Expire buckets after 10 minutes
+		MaxRetryAfter:    5 * time.Minute,   // Max 5 minute retry
+		RetryAfterFormat: RetryAfterSeconds, // Plain seconds for compatibility
+		IPv6PrefixLen:    64,                // Group IPv6 clients by /64
+		ClientIPHeaders:  defaultClientIPHeaders,
 	}
 }
 
@@ -81,13 +178,33 @@ type RateLimiter struct {
 	config  RateLimiterConfig
 	buckets sync.Map // Use sync.Map for better concurrent access
 	metrics Metrics
+	limits  sync.Map // Per-client overrides, clientID -> clientLimit
 	ctx     context.Context
 	cancel  context.CancelFunc
 	done    chan struct{}
 }
 
-// NewRateLimiter creates a new rate limiter with custom config
+// Validate reports settings that would make the limiter reject every request
+// or never refill, so a bad configuration fails at startup rather than in traffic
+func (c RateLimiterConfig) Validate() error {
+	if !(c.Rate > 0) || math.IsInf(c.Rate, 0) {
+		return fmt.Errorf("rate limiter rate must be a positive number of tokens per second, got %v", c.Rate)
+	}
+	if c.Capacity <= 0 {
+		return fmt.Errorf("rate limiter capacity must be positive, got %d", c.Capacity)
+	}
+	if c.CleanupInterval <= 0 {
+		return fmt.Errorf("rate limiter cleanup interval must be positive, got %v", c.CleanupInterval)
+	}
+	return nil
+}
+
+// NewRateLimiter creates a new rate limiter with custom config. It panics if
+// the config doesn't pass Validate, as a limiter built from it can't work.
 func NewRateLimiter(config RateLimiterConfig) *RateLimiter {
+	if err := config.Validate(); err != nil {
+		panic("middleware: " + err.Error())
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	rl := &RateLimiter{
 		config: config,
@@ -187,26 +304,149 @@ func (tb *TokenBucket) getRemainingTokens(now time.Time) float64 {
 
 // getClientID generates a client identifier with configurable privacy
 func (rl *RateLimiter) getClientID(r *http.Request) string {
+	if rl.config.ClientIDFunc != nil {
+		if clientID := rl.config.ClientIDFunc(r); clientID != "" {
+			return clientID
+		}
+	}
+
 	// Try JWT-based identification first
 	if userID := rl.extractUserID(r); userID != "" {
-		// Use first 16 bytes of hash for memory efficiency while maintaining security
-		hash := sha256.Sum256([]byte(userID))
-		return fmt.Sprintf("user:%x", hash[:16]) // 128-bit hash is plenty
+		return UserClientID(userID)
 	}
 
 	// Fallback to IP-based identification
 	ip := rl.getRealIP(r)
-	return fmt.Sprintf("ip:%s", ip)
+	return fmt.Sprintf("ip:%s", rl.ipBucketKey(ip))
+}
+
+// UserClientID returns the client ID that requests authenticated as the user
+// are limited under, so SetClientLimit can target a specific user
+func UserClientID(userID string) string {
+	// Use first 16 bytes of hash for memory efficiency while maintaining security
+	hash := sha256.Sum256([]byte(userID))
+	return fmt.Sprintf("user:%x", hash[:16]) // 128-bit hash is plenty
+}
+
+// maxEmailKeyBody is how much of a request body EmailClientID reads looking for the email
+const maxEmailKeyBody = 64 * 1024
+
+// EmailClientID is a ClientIDFunc keying requests on the "email" field of
+// their JSON body, so endpoints that send mail can be limited per recipient
+// whichever address or account the requests come from. The body is left
+// intact for the handler. Requests without an email fall back to the default
+// keying.
+func EmailClientID(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+	prefix, err := io.ReadAll(io.LimitReader(r.Body, maxEmailKeyBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(prefix, &body) != nil {
+		return ""
+	}
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	if email == "" {
+		return ""
+	}
+	// Hashed like user IDs, so addresses don't sit in memory or metrics
+	hash := sha256.Sum256([]byte(email))
+	return fmt.Sprintf("email:%x", hash[:16])
+}
+
+// clientLimit overrides the configured rate and capacity for one client
+type clientLimit struct {
+	rate     float64
+	capacity int
+}
+
+// SetClientLimit gives one client its own rate and capacity, e.g. a trusted
+// partner. Overrides are sticky: they outlive bucket expiry until removed.
+func (rl *RateLimiter) SetClientLimit(clientID string, rate float64, capacity int) {
+	rl.limits.Store(clientID, clientLimit{rate: rate, capacity: capacity})
+	if value, ok := rl.buckets.Load(clientID); ok {
+		value.(*bucketInfo).bucket.setLimits(rate, capacity, time.Now())
+	}
+}
+
+// RemoveClientLimit puts a client back on the configured rate and capacity
+func (rl *RateLimiter) RemoveClientLimit(clientID string) {
+	rl.limits.Delete(clientID)
+	if value, ok := rl.buckets.Load(clientID); ok {
+		value.(*bucketInfo).bucket.setLimits(rl.config.Rate, rl.config.Capacity, time.Now())
+	}
+}
+
+// clientLimits returns the rate and capacity that apply to a client
+func (rl *RateLimiter) clientLimits(clientID string) (float64, int) {
+	if value, ok := rl.limits.Load(clientID); ok {
+		limit := value.(clientLimit)
+		return limit.rate, limit.capacity
+	}
+	return rl.config.Rate, rl.config.Capacity
+}
+
+// setLimits changes a bucket's rate and capacity, settling the refill owed at
+// the old rate first
+func (tb *TokenBucket) setLimits(rate float64, capacity int, now time.Time) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	elapsed := now.Sub(tb.lastRefill).Seconds()
+	tb.tokens = min(tb.tokens+elapsed*tb.rate, float64(capacity))
+	tb.lastRefill = now
+	tb.rate = rate
+	tb.capacity = capacity
+}
+
+// limits returns the bucket's current rate and capacity
+func (tb *TokenBucket) limits() (float64, int) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return tb.rate, tb.capacity
 }
 
-// extractUserID extracts user ID from JWT token
+// ipBucketKey collapses an IPv6 address to its configured prefix, since privacy
+// extensions rotate addresses within a /64. IPv4 addresses are left as-is.
+func (rl *RateLimiter) ipBucketKey(ip string) string {
+	prefixLen := rl.config.IPv6PrefixLen
+	if prefixLen <= 0 || prefixLen >= 128 {
+		return ip
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return ip
+	}
+
+	mask := net.CIDRMask(prefixLen, 128)
+	return fmt.Sprintf("%s/%d", parsed.Mask(mask), prefixLen)
+}
+
+// extractUserID extracts the user ID from a valid JWT token. It is the only
+// user field tokens carry, so a rename never splits a user across buckets.
 func (rl *RateLimiter) extractUserID(r *http.Request) string {
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
+	token := tokenCookie(r)
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			return ""
+		}
+		token = strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	if token == "" {
 		return ""
 	}
 
-	token := strings.TrimPrefix(authHeader, "Bearer ")
 	claims, err := auth.ValidateToken(token)
 	if err != nil {
 		return ""
@@ -217,46 +457,120 @@ func (rl *RateLimiter) extractUserID(r *http.Request) string {
 
 // getRealIP extracts the real client IP with validation
 func (rl *RateLimiter) getRealIP(r *http.Request) string {
-	// Check X-Forwarded-For header first
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			ip := strings.TrimSpace(ips[0])
-			if net.ParseIP(ip) != nil {
-				return ip
-			}
-		}
+	return rl.config.realIP(r)
+}
+
+// realIP resolves the client IP. The configured headers are tried in order,
+// but only when the request comes from a trusted proxy; otherwise anyone could
+// pick their own bucket by setting them.
+func (c RateLimiterConfig) realIP(r *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	if !c.isTrustedProxy(remoteIP) {
+		return remoteIP
 	}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		if net.ParseIP(xri) != nil {
-			return xri
+	for _, header := range c.clientIPHeaders() {
+		value := r.Header.Get(header)
+		if value == "" {
+			continue
+		}
+
+		// X-Forwarded-For is a chain, the other headers hold a single address
+		var ip string
+		if http.CanonicalHeaderKey(header) == "X-Forwarded-For" {
+			ip = c.forwardedClientIP(strings.Split(value, ","))
+		} else if value = strings.TrimSpace(value); net.ParseIP(value) != nil {
+			ip = value
+		}
+		if ip != "" {
+			return ip
 		}
 	}
 
 	// Fall back to RemoteAddr
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	return remoteIP
+}
+
+// methodWeight returns the tokens a request with this method costs
+func (c RateLimiterConfig) methodWeight(method string) int {
+	if weight, ok := c.MethodWeights[method]; ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// clientIPHeaders returns the configured client IP headers or the defaults
+func (c RateLimiterConfig) clientIPHeaders() []string {
+	if len(c.ClientIPHeaders) == 0 {
+		return defaultClientIPHeaders
+	}
+	return c.ClientIPHeaders
+}
+
+// isTrustedProxy reports whether client IP headers from this address can be believed
+func (c RateLimiterConfig) isTrustedProxy(remoteIP string) bool {
+	if len(c.TrustedProxies) == 0 {
+		return true
+	}
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClientIP picks the client entry from X-Forwarded-For. Each trusted
+// proxy appends the address it saw, so with N hops the client is N entries
+// from the right; anything further left could have been spoofed by the client.
+func (c RateLimiterConfig) forwardedClientIP(ips []string) string {
+	idx := 0
+	if hops := c.TrustedProxyHops; hops > 0 {
+		idx = len(ips) - 1 - hops
+		if idx < 0 {
+			// Shorter chain than configured, so the header didn't come through our proxies
+			return ""
+		}
 	}
-	return host
+
+	ip := strings.TrimSpace(ips[idx])
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return ip
 }
 
 func (rl *RateLimiter) AllowWithRetryInfo(clientID string) (allowed bool, retryAfterSeconds int) {
+	return rl.AllowN(clientID, 1)
+}
+
+// AllowN charges a request that costs n tokens, e.g. a batch lookup, and on
+// denial reports how long until n tokens are available. A cost above the
+// bucket's capacity is charged as the full capacity, otherwise it could never
+// pass, and a cost below 1 as 1 so no request is free.
+func (rl *RateLimiter) AllowN(clientID string, n int) (allowed bool, retryAfterSeconds int) {
 	now := time.Now() // Single source of truth for this request
 
 	if value, exists := rl.buckets.Load(clientID); exists {
 		info := value.(*bucketInfo)
 		atomic.StoreInt64(&info.lastSeen, now.Unix())
 
-		if info.bucket.consume(1, now) {
+		_, capacity := info.bucket.limits()
+		n = max(1, min(n, capacity))
+		if info.bucket.consume(n, now) {
 			atomic.AddInt64(&rl.metrics.RequestsAllowed, 1)
 			return true, 0
 		}
 
 		// Pass the SAME timestamp to ensure consistency
-		retryAfter := rl.calculateRetryAfter(info.bucket, now)
+		retryAfter := rl.calculateRetryAfter(info.bucket, n, now)
 		atomic.AddInt64(&rl.metrics.RequestsDenied, 1)
 		return false, retryAfter
 	}
@@ -268,11 +582,12 @@ func (rl *RateLimiter) AllowWithRetryInfo(clientID string) (allowed bool, retryA
 		return false, int(rl.config.MaxRetryAfter.Seconds())
 	}
 
-	// Create new bucket
+	// Create new bucket, honoring any per-client override
+	rate, capacity := rl.clientLimits(clientID)
 	bucket := &TokenBucket{
-		tokens:     float64(rl.config.Capacity),
-		capacity:   rl.config.Capacity,
-		rate:       rl.config.Rate,
+		tokens:     float64(capacity),
+		capacity:   capacity,
+		rate:       rate,
 		lastRefill: now,
 	}
 
@@ -289,29 +604,30 @@ func (rl *RateLimiter) AllowWithRetryInfo(clientID string) (allowed bool, retryA
 	atomic.AddInt64(&rl.metrics.ActiveBuckets, 1)
 
 	// Allow the first request
-	bucket.consume(1, now)
+	bucket.consume(max(1, min(n, capacity)), now)
 	atomic.AddInt64(&rl.metrics.RequestsAllowed, 1)
 	return true, 0
 }
 
 // Allow is a simple wrapper for backward compatibility
 func (rl *RateLimiter) Allow(clientID string) bool {
-	allowed, _ := rl.AllowWithRetryInfo(clientID)
+	allowed, _ := rl.AllowN(clientID, 1)
 	return allowed
 }
 
-func (rl *RateLimiter) calculateRetryAfter(bucket *TokenBucket, now time.Time) int {
+func (rl *RateLimiter) calculateRetryAfter(bucket *TokenBucket, tokens int, now time.Time) int {
 	// Use the passed timestamp, don't call getRemainingTokens with a new time
 	currentTokens := bucket.getRemainingTokensAtTime(now)
 
 	// This check can now be removed or turned into a defensive assertion
-	if currentTokens >= 1.0 {
+	if currentTokens >= float64(tokens) {
 		// This really shouldn't happen now, but if it does, something's wrong
 		return 1 // Or log an error
 	}
 
-	tokensNeeded := 1.0 - currentTokens
-	secondsNeeded := tokensNeeded / rl.config.Rate
+	rate, _ := bucket.limits()
+	tokensNeeded := float64(tokens) - currentTokens
+	secondsNeeded := tokensNeeded / rate
 	retryAfter := int(math.Ceil(secondsNeeded))
 
 	return max(1, min(retryAfter, int(rl.config.MaxRetryAfter.Seconds())))
@@ -331,32 +647,120 @@ func (rl *RateLimiter) GetMetrics() map[string]int64 {
 	return rl.metrics.GetMetrics()
 }
 
+// formatRetryAfter renders the Retry-After value in the configured format
+func (rl *RateLimiter) formatRetryAfter(retryAfter int, now time.Time) string {
+	if rl.config.RetryAfterFormat == RetryAfterHTTPDate {
+		return now.Add(time.Duration(retryAfter) * time.Second).UTC().Format(http.TimeFormat)
+	}
+	return fmt.Sprintf("%d", retryAfter)
+}
+
+// Limiter is a rate limiter that can be mounted on routes. RateLimiter is the
+// real one; NoopLimiter lets everything through when limiting is disabled.
+type Limiter interface {
+	Middleware(next http.Handler) http.Handler
+	GetMetrics() map[string]int64
+	Close() error
+}
+
+// Middleware rate limits requests with this limiter
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return RateLimitMiddleware(rl)(next)
+}
+
+// NoopLimiter allows every request, for local development and tests
+type NoopLimiter struct{}
+
+// Middleware returns next unchanged
+func (NoopLimiter) Middleware(next http.Handler) http.Handler {
+	return next
+}
+
+// GetMetrics reports zeroed metrics so dashboards keep working
+func (NoopLimiter) GetMetrics() map[string]int64 {
+	var metrics Metrics
+	return metrics.GetMetrics()
+}
+
+// Close does nothing, there's no cleanup goroutine to stop
+func (NoopLimiter) Close() error {
+	return nil
+}
+
+// quota reports a client's capacity, the whole tokens it has left and the
+// seconds until its bucket is full again
+func (rl *RateLimiter) quota(clientID string, now time.Time) (limit, remaining, reset int) {
+	value, ok := rl.buckets.Load(clientID)
+	if !ok {
+		_, capacity := rl.clientLimits(clientID)
+		return capacity, capacity, 0
+	}
+
+	bucket := value.(*bucketInfo).bucket
+	rate, capacity := bucket.limits()
+	tokens := bucket.getRemainingTokensAtTime(now)
+	if missing := float64(capacity) - tokens; missing > 0 && rate > 0 {
+		reset = int(math.Ceil(missing / rate))
+	}
+	return capacity, int(tokens), reset
+}
+
+// setQuotaHeaders sends the IETF draft RateLimit header and, unless disabled,
+// the legacy X-RateLimit-* headers
+func (rl *RateLimiter) setQuotaHeaders(w http.ResponseWriter, limit, remaining, reset int) {
+	w.Header().Set("RateLimit", fmt.Sprintf("limit=%d, remaining=%d, reset=%d", limit, remaining, reset))
+	if rl.config.NoLegacyHeaders {
+		return
+	}
+	w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
+	w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+	w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", reset)) // Always seconds
+}
+
+// writeExceeded sends the 429 response for a client that must wait retryAfter seconds
+func (rl *RateLimiter) writeExceeded(w http.ResponseWriter, clientID string, retryAfter int, now time.Time) {
+	w.Header().Set("Retry-After", rl.formatRetryAfter(retryAfter, now))
+	limit, _, _ := rl.quota(clientID, now)
+	rl.setQuotaHeaders(w, limit, 0, retryAfter)
+
+	message := rl.config.ExceededMessage
+	if message == "" {
+		message = DefaultRateLimitMessage
+	}
+	resp := rateLimitResponse{ErrorResponse: models.NewErrorResponse(message)}
+	resp.Code = RateLimitedCode
+	if rl.config.DetailedBody {
+		resp.Limit = limit
+		resp.RetryAfter = retryAfter
+		resp.ResetAt = now.Add(time.Duration(retryAfter) * time.Second).UTC().Format(time.RFC3339)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Rate limit exceeded, please try again later."))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(data)
+}
+
 // RateLimitMiddleware creates HTTP middleware for rate limiting
 func RateLimitMiddleware(limiter *RateLimiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			clientID := limiter.getClientID(r)
-			allowed, retryAfter := limiter.AllowWithRetryInfo(clientID)
+			allowed, retryAfter := limiter.AllowN(clientID, limiter.config.methodWeight(r.Method))
 
 			if !allowed {
-				w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
-				w.Header().Set("Content-Type", "application/json")
-				w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%.0f", limiter.config.Rate))
-				w.Header().Set("X-RateLimit-Remaining", "0")
-				w.WriteHeader(http.StatusTooManyRequests)
-
-				resp := models.NewErrorResponse("Rate limit exceeded. Please try again later.")
-				data, err := json.Marshal(resp)
-				if err != nil {
-					w.Header().Set("Content-Type", "text/plain")
-					w.Write([]byte("Rate limit exceeded, please try again later."))
-					return
-				}
-				w.Header().Set("Content-Type", "application/json")
-				w.Write(data)
+				limiter.writeExceeded(w, clientID, retryAfter, time.Now())
 				return
 			}
 
+			limit, remaining, reset := limiter.quota(clientID, time.Now())
+			limiter.setQuotaHeaders(w, limit, remaining, reset)
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// RedactedValue replaces sensitive values in logged payloads
+const RedactedValue = "[REDACTED]"
+
+// sensitiveKeys are matched case-insensitively anywhere in a field name, so
+// new_password and refresh_token are caught along with password and token
+var sensitiveKeys = []string{"password", "token", "secret", "authorization"}
+
+// IsSensitiveKey reports whether a field with this name must never be logged
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns v as JSON for logging, with the value of every sensitive
+// field masked at any depth. Structs are read through their JSON names.
+// Anything that can't be encoded is masked whole rather than risk a leak.
+func Redact(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return RedactedValue
+	}
+	return RedactJSON(data)
+}
+
+// RedactJSON masks the sensitive fields of a raw JSON document, masking the
+// whole document if it doesn't parse
+func RedactJSON(data []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // Keep numbers exactly as sent
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return RedactedValue
+	}
+	redacted, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return RedactedValue
+	}
+	return string(redacted)
+}
+
+func redactValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, item := range value {
+			if IsSensitiveKey(key) {
+				value[key] = RedactedValue
+			} else {
+				value[key] = redactValue(item)
+			}
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redactValue(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	type login struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	tests := []struct {
+		name     string
+		payload  any
+		expected string
+	}{
+		{name: "struct", payload: login{Username: "froggu", Password: "hunter2"}, expected: `{"password":"[REDACTED]","username":"froggu"}`},
+		{
+			name:     "nested_map",
+			payload:  map[string]any{"user": map[string]any{"email": "a@b.c", "New_Password": "hunter2"}, "refresh_token": "abc", "count": 3},
+			expected: `{"count":3,"refresh_token":"[REDACTED]","user":{"New_Password":"[REDACTED]","email":"a@b.c"}}`,
+		},
+		{name: "list", payload: []map[string]string{{"Authorization": "Bearer abc", "id": "1"}}, expected: `[{"Authorization":"[REDACTED]","id":"1"}]`},
+		{name: "unencodable", payload: map[string]any{"password": func() {}}, expected: RedactedValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.payload); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	got := RedactJSON([]byte(`{"username":"froggu","password":"hunter2","client_secret":"s3cret","score":12345678901234567890}`))
+	for _, secret := range []string{"hunter2", "s3cret"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("Expected %q to be masked, got %s", secret, got)
+		}
+	}
+	if !strings.Contains(got, `"username":"froggu"`) || !strings.Contains(got, "12345678901234567890") {
+		t.Errorf("Expected the other fields unchanged, got %s", got)
+	}
+
+	// A body that isn't JSON could hold anything
+	if got := RedactJSON([]byte("password=hunter2")); got != RedactedValue {
+		t.Errorf("Expected an invalid body to be masked whole, got %s", got)
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	hsts := DefaultSecurityHeadersConfig()
+	hsts.HSTSMaxAge = 365 * 24 * time.Hour
+	hsts.HSTSSubdomains = true
+
+	tests := []struct {
+		name     string
+		config   SecurityHeadersConfig
+		expected map[string]string
+	}{
+		{
+			name:   "defaults",
+			config: DefaultSecurityHeadersConfig(),
+			expected: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Referrer-Policy":           "no-referrer",
+				"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name:   "hsts",
+			config: hsts,
+			expected: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+			},
+		},
+		{
+			name:   "all_off",
+			config: SecurityHeadersConfig{},
+			expected: map[string]string{
+				"X-Content-Type-Options":    "",
+				"X-Frame-Options":           "",
+				"Referrer-Policy":           "",
+				"Content-Security-Policy":   "",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name:   "custom_values",
+			config: SecurityHeadersConfig{FrameOptions: "SAMEORIGIN", ReferrerPolicy: "strict-origin-when-cross-origin"},
+			expected: map[string]string{
+				"X-Content-Type-Options": "",
+				"X-Frame-Options":        "SAMEORIGIN",
+				"Referrer-Policy":        "strict-origin-when-cross-origin",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			SecurityHeaders(tt.config)(okHandler).ServeHTTP(w, httptest.NewRequest("GET", "/v1/leaderboard", nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			for name, value := range tt.expected {
+				if got := w.Header().Get(name); got != value {
+					t.Errorf("Expected %s %q, got %q", name, value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersHandlerOverride(t *testing.T) {
+	handler := SecurityHeaders(DefaultSecurityHeadersConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("Expected the handler's X-Frame-Options, got %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/google/uuid"
+)
+
+// RecordGameRequest represents the payload for recording a finished game.
+// ParticipantIDs are ordered by finishing position, first place to last place.
+type RecordGameRequest struct {
+	ParticipantIDs []string `json:"participant_ids"`
+	Placements     []int    `json:"placements,omitempty"`    // Optional, parallel to ParticipantIDs; equal values are ties. Defaults to 1, 2, 3...
+	LastPlaceID    string   `json:"last_place_id,omitempty"` // Optional, must finish in the final placement
+}
+
+// Game represents the API-friendly game model
+type Game struct {
+	ID           uuid.UUID         `json:"id"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Participants []GameParticipant `json:"participants"`
+}
+
+// GameParticipant represents a user's finishing position in a game
+type GameParticipant struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Placement int       `json:"placement"`
+}
+
+// DatabaseGameToGame converts a database game and its participants to an API game
+func DatabaseGameToGame(dbGame database.Game, dbParticipants []database.GameParticipant) Game {
+	participants := make([]GameParticipant, len(dbParticipants))
+	for i, p := range dbParticipants {
+		participants[i] = GameParticipant{
+			UserID:    p.UserID,
+			Placement: int(p.Placement),
+		}
+	}
+	return Game{
+		ID:           dbGame.ID,
+		CreatedAt:    dbGame.CreatedAt.Time,
+		Participants: participants,
+	}
+}
+
+// HeadToHead summarizes how two users placed against each other in shared games
+type HeadToHead struct {
+	UserID      uuid.UUID `json:"user_id"`
+	OtherUserID uuid.UUID `json:"other_user_id"`
+	UserAhead   int       `json:"user_ahead"`   // Games where user finished ahead of other user
+	OtherAhead  int       `json:"other_ahead"`  // Games where other user finished ahead of user
+	Ties        int       `json:"ties"`         // Games where both shared a placement
+	SharedGames int       `json:"shared_games"` // Total games both played in
+}
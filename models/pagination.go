@@ -5,26 +5,41 @@ type PaginatedResponse struct {
 	Success    bool       `json:"success"`
 	Data       any        `json:"data"`
 	Pagination Pagination `json:"pagination"`
+	Summary    *Summary   `json:"summary,omitempty"` // Only on request, see Summary
 }
 
 // Pagination holds pagination metadata
 type Pagination struct {
-	Total       int `json:"total"`
-	PerPage     int `json:"per_page"`
-	CurrentPage int `json:"current_page"`
-	LastPage    int `json:"last_page"`
-	From        int `json:"from"`
-	To          int `json:"to"`
+	Total       int64 `json:"total"` // COUNT(*) is a bigint
+	PerPage     int   `json:"per_page"`
+	CurrentPage int   `json:"current_page"`
+	LastPage    int64 `json:"last_page"`
+	From        int64 `json:"from"`
+	To          int64 `json:"to"`
+}
+
+// Summary holds aggregates a dashboard shows next to a list page. They cost
+// extra queries, so a list only includes them when asked with ?summary=true.
+type Summary struct {
+	SignupsLast24h  int64 `json:"signups_last_24h"`
+	TotalLastPlaces int64 `json:"total_last_places"`
+}
+
+// ClampPage limits a requested page to the last page of total items, so a
+// past-the-end request gets the final page instead of a huge empty offset
+func ClampPage(page, perPage int, total int64) int {
+	lastPage := max((total+int64(perPage)-1)/int64(perPage), 1)
+	return int(min(int64(max(page, 1)), lastPage))
 }
 
 // NewPaginatedResponse creates a standard paginated response
-func NewPaginatedResponse(data any, total, perPage, currentPage int) PaginatedResponse {
+func NewPaginatedResponse(data any, total int64, perPage, currentPage int) PaginatedResponse {
 	// Calculate last page (ceiling division)
-	lastPage := (total + perPage - 1) / perPage
+	lastPage := (total + int64(perPage) - 1) / int64(perPage)
 
 	// Calculate from/to indexes
-	from := (currentPage-1)*perPage + 1
-	to := from + perPage - 1
+	from := int64(currentPage-1)*int64(perPage) + 1
+	to := from + int64(perPage) - 1
 
 	if total == 0 {
 		from = 0
@@ -3,14 +3,23 @@ package models
 
 // SuccessResponse wraps successful responses with metadata
 type SuccessResponse struct {
-	Success bool `json:"success"`
-	Data    any  `json:"data"`
+	Success  bool     `json:"success"`
+	Data     any      `json:"data"`
+	Warnings []string `json:"warnings,omitempty"` // Advice about accepted input, never a reason the request failed
 }
 
 // ErrorResponse provides consistent error format
 type ErrorResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error"`
+	Success bool         `json:"success"`
+	Error   string       `json:"error"`
+	Code    string       `json:"code,omitempty"` // Machine-readable reason, for errors clients branch on
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// FieldError describes a validation failure for a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 // NewSuccessResponse creates a standard success response
@@ -28,3 +37,12 @@ func NewErrorResponse(message string) ErrorResponse {
 		Error:   message,
 	}
 }
+
+// NewValidationErrorResponse creates an error response carrying field-level details
+func NewValidationErrorResponse(message string, details []FieldError) ErrorResponse {
+	return ErrorResponse{
+		Success: false,
+		Error:   message,
+		Details: details,
+	}
+}
@@ -7,6 +7,12 @@ import (
 	"github.com/google/uuid"
 )
 
+// User roles stored in users.role
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User represents the API-friendly user model
 type User struct {
 	ID             uuid.UUID `json:"id"`
@@ -17,6 +23,37 @@ type User struct {
 	LastPlaceCount int       `json:"last_place_count"`
 	ProfilePicture string    `json:"profile_picture,omitempty"`
 	Bio            string    `json:"bio,omitempty"`
+	EmailVerified  bool      `json:"email_verified"`
+}
+
+// LeaderboardEntry is one row of the leaderboard export
+type LeaderboardEntry struct {
+	Rank           int    `json:"rank"`
+	Username       string `json:"username"`
+	LastPlaceCount int    `json:"last_place_count"`
+}
+
+// UserExport is one row of the admin user export. It carries what an admin
+// needs for analysis, including role and deletion, but never the password hash.
+type UserExport struct {
+	ID             uuid.UUID  `json:"id"`
+	Username       string     `json:"username"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	LastPlaceCount int        `json:"last_place_count"`
+	ProfilePicture string     `json:"profile_picture,omitempty"`
+	Bio            string     `json:"bio,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Stats holds the global numbers shown on the public dashboard
+type Stats struct {
+	TotalUsers      int64 `json:"total_users"`
+	TotalGames      int64 `json:"total_games"`
+	TotalLastPlaces int64 `json:"total_last_places"`
+	SignupsLast24h  int64 `json:"signups_last_24h"`
 }
 
 // UserRequest represents the request payload for user-related operations
@@ -33,6 +70,42 @@ type UpdateUserRequest struct {
 	Password string `json:"password" validate:"omitempty,min=6"`
 	Username string `json:"username" validate:"omitempty,min=2"`
 	Bio      string `json:"bio" validate:"omitempty,max=200"`
+
+	// AvatarURL points the profile picture at an image on an allowed external
+	// host instead of an upload
+	AvatarURL string `json:"avatar_url" validate:"omitempty,url"`
+}
+
+// EmailChangeRequest represents the request payload for starting an email change
+type EmailChangeRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ConfirmEmailChangeRequest represents the request payload for confirming an email change
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// VerifyEmailRequest represents the request payload for verifying an email
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// PendingEmailVerification describes a verification link waiting to be opened
+type PendingEmailVerification struct {
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PendingEmailChange describes an email change waiting for the new address to confirm it
+type PendingEmailChange struct {
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// BulkDeleteUsersRequest represents the request payload for deleting several users at once
+type BulkDeleteUsersRequest struct {
+	UserIDs []string `json:"user_ids" validate:"required,min=1"`
 }
 
 // DatabaseUserToUser converts a database user to an API user
@@ -46,6 +119,7 @@ func DatabaseUserToUser(dbUser database.User) User {
 		LastPlaceCount: int(dbUser.LastPlaceCount),
 		ProfilePicture: dbUser.ProfilePicture.String,
 		Bio:            dbUser.Bio.String,
+		EmailVerified:  dbUser.EmailVerifiedAt.Valid,
 	}
 }
 
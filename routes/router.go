@@ -1,48 +1,235 @@
 package routes
 
 import (
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+
 	"github.com/froggu-tantei/ToT/handlers" // Import handlers to access APIConfig and handler methods
 	"github.com/froggu-tantei/ToT/middleware"
 	"github.com/go-chi/chi/v5" // Import chi for routing
 )
 
+// Config holds the middleware settings applied by RegisterRoutes.
+type Config struct {
+	Logging     middleware.LoggingConfig
+	Maintenance *middleware.Maintenance           // Optional, nil disables maintenance mode entirely
+	Metrics     *middleware.HTTPMetrics           // Optional, nil disables latency metrics and /metrics
+	Auth        func(http.Handler) http.Handler   // Optional, nil uses middleware.AuthMiddleware
+	MaxBodySize int64                             // Request body limit outside of uploads, 0 uses middleware.DefaultMaxBodySize
+	CORS        middleware.CORSConfig             // Policy for everything but the public read endpoints
+	PublicCORS  *middleware.CORSConfig            // Optional policy for the leaderboard, stats and avatars, nil uses CORS
+	MailLimiter middleware.Limiter                // Optional per-recipient limit on endpoints that send email, nil leaves only the auth limit
+	Security    *middleware.SecurityHeadersConfig // Optional, nil uses middleware.DefaultSecurityHeadersConfig
+	Pprof       bool                              // Mount net/http/pprof under /debug/pprof/ for admins and internal networks
+	MaxInFlight int                               // Requests each heavy endpoint, such as exports and bulk operations, runs at once, 0 disables the limit
+}
+
+// publicPaths are the /v1 routes served under Config.PublicCORS
+var publicPaths = []string{"/leaderboard", "/leaderboard/export", "/stats", "/users/{id}/avatar"}
+
+// methodNotAllowed mirrors chi's default 405, which is replaced so preflights
+// for routes without their own OPTIONS route still pass through a CORS policy
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// preflight answers OPTIONS requests the group's CORS middleware lets through
+func preflight(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mountPprof adds the net/http/pprof handlers. pprof.Index also serves the
+// named profiles such as heap and goroutine. CPU profiles and traces can't
+// run longer than the server's WriteTimeout, pprof answers 400 for a longer
+// ?seconds.
+func mountPprof(r chi.Router) {
+	r.Get("/", pprof.Index)
+	r.Get("/cmdline", pprof.Cmdline)
+	r.With(profileSeconds).Get("/profile", pprof.Profile)
+	r.Get("/symbol", pprof.Symbol)
+	r.Post("/symbol", pprof.Symbol)
+	r.Get("/trace", pprof.Trace)
+	r.Get("/{profile}", pprof.Index)
+}
+
+// profileSeconds gives CPU profiles requested without ?seconds half the
+// server's WriteTimeout. pprof's own default of 30s is past the 10s timeout,
+// so a bare /debug/pprof/profile would otherwise always be refused.
+func profileSeconds(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server)
+		if ok && srv.WriteTimeout > 0 && r.URL.Query().Get("seconds") == "" {
+			r = r.Clone(r.Context())
+			query := r.URL.Query()
+			query.Set("seconds", strconv.Itoa(max(1, int(srv.WriteTimeout.Seconds())/2)))
+			r.URL.RawQuery = query.Encode()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ignoreBody drops the body of requests whose handler doesn't read one, so a
+// made-up email in it can't move Config.MailLimiter off the caller's account
+func ignoreBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.NoBody
+		next.ServeHTTP(w, r)
+	})
+}
+
 // RegisterRoutes sets up the application's routes.
-func RegisterRoutes(apiCfg *handlers.APIConfig, authLimiter, genericLimiter *middleware.RateLimiter) chi.Router {
+func RegisterRoutes(apiCfg *handlers.APIConfig, authLimiter, genericLimiter middleware.Limiter, cfg Config) chi.Router {
 
 	r := chi.NewRouter()
 
-	r.Use(middleware.CorsMiddleware)
-	r.Use(middleware.LoggingMiddleware)
+	authenticate := cfg.Auth
+	if authenticate == nil {
+		authenticate = middleware.AuthMiddleware
+	}
+
+	var mailLimiter middleware.Limiter = middleware.NoopLimiter{}
+	if cfg.MailLimiter != nil {
+		mailLimiter = cfg.MailLimiter
+	}
+
+	strictCORS := middleware.NewCORS(cfg.CORS)
+	publicCORS := strictCORS
+	if cfg.PublicCORS != nil {
+		publicCORS = middleware.NewCORS(*cfg.PublicCORS)
+	}
+
+	// heavy limits how many requests an expensive endpoint runs at once, with
+	// slots of its own for each route it's called for
+	heavy := func() func(http.Handler) http.Handler {
+		return middleware.ConcurrencyLimit(cfg.MaxInFlight)
+	}
+
+	security := middleware.DefaultSecurityHeadersConfig()
+	if cfg.Security != nil {
+		security = *cfg.Security
+	}
+
+	r.Use(middleware.RequestIDMiddleware)
+	r.Use(middleware.SecurityHeaders(security))
+	r.Use(middleware.DrainBody)
+	if cfg.Metrics != nil {
+		r.Use(cfg.Metrics.Middleware)
+	}
+	r.Use(middleware.NewLoggingMiddleware(cfg.Logging))
 
-	// Root endpoint
-	r.With(middleware.RateLimitMiddleware(genericLimiter)).Get("/", apiCfg.RootHandler)
+	// guard applies a group's CORS policy ahead of the shared request checks,
+	// so maintenance and body limit errors still carry CORS headers
+	guard := func(r chi.Router, cors func(http.Handler) http.Handler) {
+		r.Use(cors)
+		if cfg.Maintenance != nil {
+			r.Use(cfg.Maintenance.Middleware)
+		}
+		r.Use(middleware.MaxBodySize(cfg.MaxBodySize))
+		r.Use(handlers.Envelope)
+	}
+
+	r.Group(func(r chi.Router) {
+		guard(r, strictCORS)
+
+		// Unmatched paths and methods, including preflights, get the strict policy
+		r.NotFound(http.NotFound)
+		r.MethodNotAllowed(methodNotAllowed)
+
+		// Prometheus scrape endpoint, for admins and scrapers on internal networks
+		if cfg.Metrics != nil {
+			r.With(genericLimiter.Middleware, authenticate, apiCfg.RequireAdminOrInternal).Get("/metrics", cfg.Metrics.Handler().ServeHTTP)
+		}
+
+		// Root endpoint
+		r.With(genericLimiter.Middleware).Get("/", apiCfg.RootHandler)
+
+		// Profiling, off unless asked for since it exposes internals
+		if cfg.Pprof {
+			r.Route("/debug/pprof", func(r chi.Router) {
+				r.Use(authenticate)
+				r.Use(apiCfg.RequireAdminOrInternal)
+				mountPprof(r)
+			})
+		}
+	})
 
 	// API v1 routes
 	r.Route("/v1", func(r chi.Router) {
-		// Health endpoints
-		r.With(middleware.RateLimitMiddleware(genericLimiter)).Get("/readiness", apiCfg.ReadinessHandler)
-		r.With(middleware.RateLimitMiddleware(genericLimiter)).Get("/healthz", apiCfg.HealthzHandler)
-		r.Get("/err", apiCfg.ErrorHandler)
+		// Public read endpoints, which may be open to more origins
+		r.Group(func(r chi.Router) {
+			guard(r, publicCORS)
+			r.Use(genericLimiter.Middleware)
 
-		// User authentication routes
-		r.With(middleware.RateLimitMiddleware(authLimiter)).Post("/users", apiCfg.SignupHandler)
-		r.With(middleware.RateLimitMiddleware(authLimiter)).Post("/login", apiCfg.LoginHandler)
+			// Leaderboard
+			r.Get("/leaderboard", apiCfg.GetLeaderboardHandler)
+			r.With(heavy()).Get("/leaderboard/export", apiCfg.ExportLeaderboardHandler)
 
-		// Protected routes
-		r.Group(func(r chi.Router) {
-			r.Use(middleware.AuthMiddleware)
-
-			r.Get("/me", apiCfg.GetMeHandler)
-			r.Get("/users", apiCfg.ListUsersHandler)
-			r.Get("/users/{id}", apiCfg.GetUserByIDHandler)
-			r.Get("/users/username/{username}", apiCfg.GetUserByUsernameHandler)
-			r.Put("/users/{id}", apiCfg.UpdateUserHandler)
-			r.Delete("/users/{id}", apiCfg.DeleteUserHandler)
-			r.Post("/users/{id}/profile-picture", apiCfg.UploadProfilePictureHandler)
+			// Global stats for the public dashboard
+			r.Get("/stats", apiCfg.GetStatsHandler)
+
+			// Avatars are public so they can be used directly in <img> tags
+			r.Get("/users/{id}/avatar", apiCfg.GetAvatarHandler)
+
+			for _, path := range publicPaths {
+				r.Options(path, preflight)
+			}
 		})
 
-		// Leaderboard
-		r.With(middleware.RateLimitMiddleware(genericLimiter)).Get("/leaderboard", apiCfg.GetLeaderboardHandler)
+		r.Group(func(r chi.Router) {
+			guard(r, strictCORS)
+
+			// Health endpoints
+			r.With(genericLimiter.Middleware).Get("/readiness", apiCfg.ReadinessHandler)
+			r.With(genericLimiter.Middleware).Get("/healthz", apiCfg.HealthzHandler)
+			r.Get("/err", apiCfg.ErrorHandler)
+
+			// User authentication routes
+			r.With(authLimiter.Middleware).Post("/users", apiCfg.SignupHandler)
+			r.With(authLimiter.Middleware).Post("/login", apiCfg.LoginHandler)
+			r.With(authLimiter.Middleware).Post("/token/refresh", apiCfg.RefreshTokenHandler)
+			r.With(authLimiter.Middleware).Post("/email-change/confirm", apiCfg.ConfirmEmailChangeHandler)
+			r.With(authLimiter.Middleware).Post("/verify-email", apiCfg.VerifyEmailHandler)
+
+			// Protected routes, where the generic limit's method weights make writes cost more
+			r.Group(func(r chi.Router) {
+				r.Use(genericLimiter.Middleware)
+				r.Use(authenticate)
+				r.Use(middleware.CSRF)
+
+				r.Get("/me", apiCfg.GetMeHandler)
+				r.Get("/token/introspect", apiCfg.IntrospectTokenHandler)
+				r.With(authLimiter.Middleware).Post("/token/refresh-claims", apiCfg.RefreshClaimsHandler)
+				r.Get("/users", apiCfg.ListUsersHandler)
+				r.Get("/users/{id}", apiCfg.GetUserByIDHandler)
+				r.Get("/users/username/{username}", apiCfg.GetUserByUsernameHandler)
+				r.Put("/users/{id}", apiCfg.UpdateUserHandler)
+				r.Delete("/users/{id}", apiCfg.DeleteUserHandler)
+				r.With(authLimiter.Middleware, mailLimiter.Middleware).Post("/users/{id}/email-change", apiCfg.RequestEmailChangeHandler)
+				r.With(ignoreBody, mailLimiter.Middleware).Post("/resend-verification", apiCfg.ResendVerificationHandler)
+				r.With(middleware.MaxBodySize(handlers.MaxUploadSize)).Post("/users/{id}/profile-picture", apiCfg.UploadProfilePictureHandler)
+				r.Get("/users/{id}/head-to-head/{otherId}", apiCfg.GetHeadToHeadHandler)
+				r.Post("/users/{id}/block", apiCfg.BlockUserHandler)
+				r.Delete("/users/{id}/block", apiCfg.UnblockUserHandler)
+
+				// Games
+				r.Post("/games", apiCfg.RecordGameHandler)
+
+				// Admin maintenance
+				r.Route("/admin", func(r chi.Router) {
+					r.Use(apiCfg.RequireAdmin)
+
+					r.With(heavy()).Post("/storage/gc", apiCfg.StorageGCHandler)
+					r.With(heavy()).Post("/profile-pictures/reprocess", apiCfg.ReprocessProfilePicturesHandler)
+					r.Get("/users", apiCfg.SearchUsersHandler)
+					r.With(heavy()).Post("/users/bulk-delete", apiCfg.BulkDeleteUsersHandler)
+					r.With(heavy()).Get("/users/export", apiCfg.ExportUsersHandler)
+				})
+
+				// Build and dependency versions for incidents
+				r.With(apiCfg.RequireAdmin).Get("/debug/info", apiCfg.DebugInfoHandler)
+			})
+		})
 	})
 
 	return r
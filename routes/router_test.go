@@ -0,0 +1,266 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/db/database"
+	"github.com/froggu-tantei/ToT/handlers"
+	"github.com/froggu-tantei/ToT/middleware"
+	"github.com/froggu-tantei/ToT/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// leaderboardStore serves an empty leaderboard; any other query panics through the nil Store
+type leaderboardStore struct {
+	database.Store
+}
+
+func (leaderboardStore) GetLeaderBoardLastModified(ctx context.Context) (pgtype.Timestamp, error) {
+	return pgtype.Timestamp{}, nil
+}
+
+func (leaderboardStore) CountLeaderBoard(ctx context.Context, minGames int32) (int64, error) {
+	return 0, nil
+}
+
+func (leaderboardStore) GetLeaderBoard(ctx context.Context, arg database.GetLeaderBoardParams) ([]database.GetLeaderBoardRow, error) {
+	return []database.GetLeaderBoardRow{}, nil
+}
+
+func TestRegisterRoutesCORSPerGroup(t *testing.T) {
+	const frontend = "https://tot.example"
+	const elsewhere = "https://fan-site.example"
+
+	router := RegisterRoutes(handlers.NewAPIConfig(leaderboardStore{}, nil), middleware.NoopLimiter{}, middleware.NoopLimiter{}, Config{
+		CORS:       middleware.CORSConfig{AllowedOrigins: []string{frontend}},
+		PublicCORS: &middleware.CORSConfig{AllowedOrigins: []string{"*"}},
+	})
+
+	serve := func(method, path, origin string, preflight bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", origin)
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		origin    string
+		preflight bool
+		allowed   bool
+	}{
+		{name: "leaderboard_get_any_origin", method: "GET", path: "/v1/leaderboard", origin: elsewhere, allowed: true},
+		{name: "leaderboard_preflight_any_origin", method: "OPTIONS", path: "/v1/leaderboard", origin: elsewhere, preflight: true, allowed: true},
+		{name: "avatar_preflight_any_origin", method: "OPTIONS", path: "/v1/users/123/avatar", origin: elsewhere, preflight: true, allowed: true},
+		{name: "login_preflight_other_origin", method: "OPTIONS", path: "/v1/login", origin: elsewhere, preflight: true, allowed: false},
+		{name: "login_preflight_frontend", method: "OPTIONS", path: "/v1/login", origin: frontend, preflight: true, allowed: true},
+		{name: "user_preflight_other_origin", method: "OPTIONS", path: "/v1/users/123", origin: elsewhere, preflight: true, allowed: false},
+		{name: "user_preflight_frontend", method: "OPTIONS", path: "/v1/users/123", origin: frontend, preflight: true, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.path, tt.origin, tt.preflight)
+
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tt.allowed && allowOrigin == "" {
+				t.Errorf("Expected %s %s from %s to be allowed, got status %d and no Access-Control-Allow-Origin", tt.method, tt.path, tt.origin, w.Code)
+			}
+			if !tt.allowed && allowOrigin != "" {
+				t.Errorf("Expected %s %s from %s to be blocked, got Access-Control-Allow-Origin %q", tt.method, tt.path, tt.origin, allowOrigin)
+			}
+			if tt.method == "GET" && w.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// Unknown routes and methods keep their usual statuses
+	if w := serve("GET", "/v1/nope", frontend, false); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown path, got %d", w.Code)
+	}
+	if w := serve("PATCH", "/v1/login", frontend, false); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for an unknown method, got %d", w.Code)
+	}
+}
+
+func TestRegisterRoutesSecurityHeaders(t *testing.T) {
+	router := RegisterRoutes(handlers.NewAPIConfig(leaderboardStore{}, nil), middleware.NoopLimiter{}, middleware.NoopLimiter{}, Config{})
+
+	// Files mounted on the router afterwards, like /uploads/, get them too
+	router.Handle("/uploads/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, path := range []string{"/v1/leaderboard", "/uploads/picture.png", "/v1/nope"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("Expected nosniff on %s, got %q", path, got)
+		}
+		if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+			t.Errorf("Expected X-Frame-Options DENY on %s, got %q", path, got)
+		}
+		if got := w.Header().Get("Referrer-Policy"); got == "" {
+			t.Errorf("Expected a Referrer-Policy on %s", path)
+		}
+	}
+}
+
+// userStore looks users up by ID; any other query panics through the nil Store
+type userStore struct {
+	database.Store
+	users map[uuid.UUID]database.User
+}
+
+func (s userStore) GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return database.User{}, pgx.ErrNoRows
+	}
+	return user, nil
+}
+
+func TestRegisterRoutesMethodWeights(t *testing.T) {
+	limiter := middleware.NewRateLimiter(middleware.RateLimiterConfig{
+		Rate:            0.001,
+		Capacity:        4,
+		MaxBuckets:      100,
+		CleanupInterval: time.Minute,
+		MethodWeights:   map[string]int{"POST": 4},
+	})
+	defer limiter.Close()
+	router := RegisterRoutes(handlers.NewAPIConfig(leaderboardStore{}, nil), middleware.NoopLimiter{}, limiter, Config{})
+
+	// serve sends an anonymous request from its own client, so the handlers
+	// refuse it without querying and only the limiter's answer matters
+	serve := func(method, path, ip string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := range 4 {
+		if code := serve("GET", "/v1/me", "192.0.2.1"); code == http.StatusTooManyRequests {
+			t.Fatalf("Expected GET %d to fit the quota, got 429", i+1)
+		}
+	}
+	if code := serve("GET", "/v1/me", "192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the fifth GET to be limited, got %d", code)
+	}
+
+	// One POST costs the whole quota
+	if code := serve("POST", "/v1/games", "192.0.2.2"); code == http.StatusTooManyRequests {
+		t.Fatal("Expected the first POST to fit the quota, got 429")
+	}
+	if code := serve("GET", "/v1/me", "192.0.2.2"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected a GET after a weighted POST to be limited, got %d", code)
+	}
+}
+
+func TestRegisterRoutesPprof(t *testing.T) {
+	admin := database.User{ID: uuid.New(), Username: "admin", Role: models.RoleAdmin}
+	player := database.User{ID: uuid.New(), Username: "player", Role: models.RoleUser}
+	apiCfg := handlers.NewAPIConfig(userStore{users: map[uuid.UUID]database.User{admin.ID: admin, player.ID: player}}, nil)
+
+	// as authenticates every request with the given claims, or none when nil
+	as := func(claims *auth.Claims) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if claims != nil {
+					r = r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, claims))
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	tests := []struct {
+		name           string
+		enabled        bool
+		claims         *auth.Claims
+		path           string
+		expectedStatus int
+	}{
+		{name: "disabled", enabled: false, claims: &auth.Claims{UserID: admin.ID}, path: "/debug/pprof/", expectedStatus: http.StatusNotFound},
+		{name: "disabled_profile", enabled: false, claims: &auth.Claims{UserID: admin.ID}, path: "/debug/pprof/heap", expectedStatus: http.StatusNotFound},
+		{name: "anonymous", enabled: true, path: "/debug/pprof/", expectedStatus: http.StatusUnauthorized},
+		{name: "not_admin", enabled: true, claims: &auth.Claims{UserID: player.ID, Username: player.Username}, path: "/debug/pprof/", expectedStatus: http.StatusForbidden},
+		{name: "user_named_like_service", enabled: true, claims: &auth.Claims{UserID: uuid.New(), Username: middleware.ServiceUsername}, path: "/debug/pprof/", expectedStatus: http.StatusUnauthorized},
+		{name: "admin_index", enabled: true, claims: &auth.Claims{UserID: admin.ID}, path: "/debug/pprof/", expectedStatus: http.StatusOK},
+		{name: "admin_heap", enabled: true, claims: &auth.Claims{UserID: admin.ID}, path: "/debug/pprof/heap", expectedStatus: http.StatusOK},
+		{name: "internal_goroutines", enabled: true, claims: &auth.Claims{Username: middleware.ServiceUsername}, path: "/debug/pprof/goroutine?debug=1", expectedStatus: http.StatusOK},
+		// Metrics are always mounted here and guarded the same way
+		{name: "metrics_anonymous", path: "/metrics", expectedStatus: http.StatusUnauthorized},
+		{name: "metrics_not_admin", claims: &auth.Claims{UserID: player.ID, Username: player.Username}, path: "/metrics", expectedStatus: http.StatusForbidden},
+		{name: "metrics_admin", claims: &auth.Claims{UserID: admin.ID}, path: "/metrics", expectedStatus: http.StatusOK},
+		{name: "metrics_internal", claims: &auth.Claims{Username: middleware.ServiceUsername}, path: "/metrics", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := RegisterRoutes(apiCfg, middleware.NoopLimiter{}, middleware.NoopLimiter{}, Config{
+				Auth:    as(tt.claims),
+				Pprof:   tt.enabled,
+				Metrics: middleware.NewHTTPMetrics(nil),
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestProfileSeconds(t *testing.T) {
+	var seconds string
+	handler := profileSeconds(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seconds = r.URL.Query().Get("seconds")
+	}))
+	withServer := func(req *http.Request, writeTimeout time.Duration) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), http.ServerContextKey, &http.Server{WriteTimeout: writeTimeout}))
+	}
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		expected string
+	}{
+		{name: "default_under_timeout", req: withServer(httptest.NewRequest("GET", "/debug/pprof/profile", nil), 10*time.Second), expected: "5"},
+		{name: "short_timeout", req: withServer(httptest.NewRequest("GET", "/debug/pprof/profile", nil), time.Second), expected: "1"},
+		{name: "explicit_kept", req: withServer(httptest.NewRequest("GET", "/debug/pprof/profile?seconds=3", nil), 10*time.Second), expected: "3"},
+		{name: "no_timeout", req: withServer(httptest.NewRequest("GET", "/debug/pprof/profile", nil), 0), expected: ""},
+		{name: "no_server", req: httptest.NewRequest("GET", "/debug/pprof/profile", nil), expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seconds = "unset"
+			handler.ServeHTTP(httptest.NewRecorder(), tt.req)
+			if seconds != tt.expected {
+				t.Errorf("Expected seconds %q, got %q", tt.expected, seconds)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/middleware"
+)
+
+// startupConfig is the effective configuration logged at boot, so a
+// misconfigured deployment shows up in the first log lines rather than on the
+// first failing request. It must never hold secrets.
+type startupConfig struct {
+	Addr              string
+	Database          string // DB_URL with any password redacted
+	PoolMaxConns      int32
+	PoolMinConns      int32
+	QueryTimeout      time.Duration
+	StorageBackend    string
+	RateLimitEnabled  bool
+	AuthRateLimit     string
+	GenericRateLimit  string
+	TrustedProxies    int
+	CORSOrigins       []string
+	PublicCORSOrigins []string
+	AccessExpiry      time.Duration
+	RefreshExpiry     time.Duration
+	Metrics           bool
+	Maintenance       bool
+}
+
+// newStartupConfig collects the non-secret settings main resolved
+func newStartupConfig(addr, dbURL string, poolMaxConns, poolMinConns int32, queryTimeout time.Duration, storageBackend string, rateLimitEnabled bool, authConfig, genericConfig middleware.RateLimiterConfig, cors, publicCORS middleware.CORSConfig, metrics, maintenance bool) (startupConfig, error) {
+	accessExpiry, refreshExpiry, err := auth.TokenExpiries()
+	if err != nil {
+		return startupConfig{}, err
+	}
+	if storageBackend == "" {
+		storageBackend = "local"
+	}
+
+	return startupConfig{
+		Addr:              addr,
+		Database:          redactURL(dbURL),
+		PoolMaxConns:      poolMaxConns,
+		PoolMinConns:      poolMinConns,
+		QueryTimeout:      queryTimeout,
+		StorageBackend:    storageBackend,
+		RateLimitEnabled:  rateLimitEnabled,
+		AuthRateLimit:     describeRateLimit(authConfig),
+		GenericRateLimit:  describeRateLimit(genericConfig),
+		TrustedProxies:    len(authConfig.TrustedProxies),
+		CORSOrigins:       cors.Origins(),
+		PublicCORSOrigins: publicCORS.Origins(),
+		AccessExpiry:      accessExpiry,
+		RefreshExpiry:     refreshExpiry,
+		Metrics:           metrics,
+		Maintenance:       maintenance,
+	}, nil
+}
+
+// String formats the configuration as key=value pairs for a single log line
+func (c startupConfig) String() string {
+	pairs := []string{
+		"addr=" + c.Addr,
+		"db=" + c.Database,
+		fmt.Sprintf("pool_max_conns=%d", c.PoolMaxConns),
+		fmt.Sprintf("pool_min_conns=%d", c.PoolMinConns),
+		"query_timeout=" + c.QueryTimeout.String(),
+		"storage=" + c.StorageBackend,
+		fmt.Sprintf("rate_limit=%t", c.RateLimitEnabled),
+		"auth_rate_limit=" + c.AuthRateLimit,
+		"generic_rate_limit=" + c.GenericRateLimit,
+		fmt.Sprintf("trusted_proxies=%d", c.TrustedProxies),
+		"cors_origins=" + strings.Join(c.CORSOrigins, ","),
+		"public_cors_origins=" + strings.Join(c.PublicCORSOrigins, ","),
+		"jwt_access_expiry=" + c.AccessExpiry.String(),
+		"jwt_refresh_expiry=" + c.RefreshExpiry.String(),
+		fmt.Sprintf("metrics=%t", c.Metrics),
+		fmt.Sprintf("maintenance=%t", c.Maintenance),
+	}
+	return strings.Join(pairs, " ")
+}
+
+// describeRateLimit summarizes a limiter config, e.g. "burst:3,rate:0.05/s"
+func describeRateLimit(config middleware.RateLimiterConfig) string {
+	return fmt.Sprintf("burst:%d,rate:%.3g/s", config.Capacity, config.Rate)
+}
+
+// redactURL hides the password in a connection URL. Anything that doesn't
+// parse is hidden entirely rather than risk logging credentials.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return "[redacted]"
+	}
+	// Passwords can also ride along in the query string
+	query := u.Query()
+	for key := range query {
+		if strings.Contains(strings.ToLower(key), "password") {
+			query.Set(key, "xxxxx")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.Redacted()
+}
+
+// maxConnectDelay caps the backoff between database connection attempts
+const maxConnectDelay = 30 * time.Second
+
+// pinger is a database connection that can be checked, like *pgxpool.Pool
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// waitForDatabase pings db up to attempts times, doubling the delay between
+// tries, so a container started a moment before Postgres doesn't crash-loop
+func waitForDatabase(ctx context.Context, db pinger, attempts int, delay time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = db.Ping(ctx); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		log.Printf("Database not ready (attempt %d/%d): %v, retrying in %v", attempt, attempts, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxConnectDelay)
+	}
+	return fmt.Errorf("database unavailable after %d attempts: %w", attempts, err)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/froggu-tantei/ToT/auth"
+	"github.com/froggu-tantei/ToT/middleware"
+)
+
+func TestStartupConfigRedactsSecrets(t *testing.T) {
+	const jwtSecret = "super-secret-signing-key"
+	const dbPassword = "hunter2-db-password"
+	if err := auth.Configure(auth.Settings{Secret: jwtSecret, AccessExpiry: time.Hour, RefreshExpiry: 7 * 24 * time.Hour}); err != nil {
+		t.Fatalf("Failed to configure auth: %v", err)
+	}
+	t.Cleanup(auth.Reset)
+
+	tests := []struct {
+		name  string
+		dbURL string
+	}{
+		{name: "password_in_userinfo", dbURL: "postgres://tot:" + dbPassword + "@db.internal:5432/tot?sslmode=disable"},
+		{name: "password_in_query", dbURL: "postgres://db.internal:5432/tot?user=tot&password=" + dbPassword},
+		{name: "unparseable_url", dbURL: "host=db.internal password=" + dbPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := middleware.RateLimiterConfig{Rate: 0.5, Capacity: 30}
+			startup, err := newStartupConfig(":8080", tt.dbURL, 10, 0, 5*time.Second, "", true, limits, limits, middleware.CORSConfig{}, middleware.CORSConfig{}, false, false)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			out := startup.String()
+			for _, secret := range []string{jwtSecret, dbPassword} {
+				if strings.Contains(out, secret) {
+					t.Errorf("Startup config leaks a secret: %s", out)
+				}
+			}
+			for _, expected := range []string{"addr=:8080", "storage=local", "jwt_access_expiry=1h0m0s", "generic_rate_limit=burst:30,rate:0.5/s"} {
+				if !strings.Contains(out, expected) {
+					t.Errorf("Expected %q in %s", expected, out)
+				}
+			}
+		})
+	}
+}
+
+// flakyDB refuses the first failures pings, then comes up
+type flakyDB struct {
+	failures int
+	pings    int
+}
+
+func (db *flakyDB) Ping(ctx context.Context) error {
+	db.pings++
+	if db.pings <= db.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestWaitForDatabase(t *testing.T) {
+	db := &flakyDB{failures: 3}
+	if err := waitForDatabase(context.Background(), db, 5, time.Millisecond); err != nil {
+		t.Fatalf("Expected the database to come up, got %v", err)
+	}
+	if db.pings != 4 {
+		t.Errorf("Expected 4 pings, got %d", db.pings)
+	}
+
+	// Giving up reports the last error
+	db = &flakyDB{failures: 3}
+	err := waitForDatabase(context.Background(), db, 2, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Expected the ping error after 2 attempts, got %v", err)
+	}
+	if db.pings != 2 {
+		t.Errorf("Expected 2 pings, got %d", db.pings)
+	}
+
+	// Shutting down stops the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitForDatabase(ctx, &flakyDB{failures: 1}, 5, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the wait to be canceled, got %v", err)
+	}
+}
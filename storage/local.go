@@ -1,9 +1,10 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"io"
-	"mime/multipart"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,16 +25,11 @@ func NewLocalStorage(uploadDir, baseURL string) *LocalStorage {
 }
 
 // Store saves a file to the local filesystem and returns its relative path
-func (ls *LocalStorage) Store(file multipart.File, filename string) (string, error) {
+func (ls *LocalStorage) Store(file io.Reader, filename string) (string, error) {
 	// Validate filename to prevent directory traversal
-	if strings.Contains(filename, "..") || strings.Contains(filename, "/") || strings.Contains(filename, "\\") {
-		return "", errors.New("invalid filename")
-	}
-
-	// Additional filename validation - basic safety checks
-	cleanFilename := filepath.Base(filename)
-	if len(cleanFilename) == 0 || cleanFilename == "." || cleanFilename == ".." {
-		return "", errors.New("invalid filename")
+	cleanFilename, err := SanitizeFilename(filename)
+	if err != nil {
+		return "", err
 	}
 
 	// Create upload directory if it doesn't exist
@@ -68,8 +64,10 @@ func (ls *LocalStorage) Store(file multipart.File, filename string) (string, err
 	}
 	defer dst.Close()
 
-	// Copy file content
+	// Copy file content, removing a partial file so it isn't mistaken for a complete one
 	if _, err := io.Copy(dst, file); err != nil {
+		dst.Close()
+		os.Remove(cleanedPath)
 		return "", err
 	}
 
@@ -77,6 +75,36 @@ func (ls *LocalStorage) Store(file multipart.File, filename string) (string, err
 	return "/" + filepath.Join(filepath.Base(ls.UploadDir), cleanFilename), nil
 }
 
+// Open opens a stored file by the path Store returned
+func (ls *LocalStorage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	// Paths look like "/uploads/name", relative to the upload directory's parent
+	rel := strings.TrimPrefix(path, "/"+filepath.Base(ls.UploadDir)+"/")
+	fullPath := filepath.Join(ls.UploadDir, filepath.FromSlash(rel))
+
+	// Refuse anything that resolves outside the upload directory
+	if relCheck, err := filepath.Rel(ls.UploadDir, fullPath); err != nil || strings.HasPrefix(relCheck, "..") {
+		return nil, errors.New("invalid file path")
+	}
+
+	return os.Open(fullPath)
+}
+
+// Exists reports whether a file was stored under filename
+func (ls *LocalStorage) Exists(ctx context.Context, filename string) (bool, error) {
+	cleanFilename := filepath.Base(filename)
+	if cleanFilename != filename || cleanFilename == "." || cleanFilename == ".." {
+		return false, errors.New("invalid filename")
+	}
+
+	_, err := os.Stat(filepath.Join(ls.UploadDir, cleanFilename))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // Delete removes a file from the local filesystem
 func (ls *LocalStorage) Delete(path string) error {
 	// Handle paths that start with "/"
@@ -107,3 +135,38 @@ func (ls *LocalStorage) GetPublicURL(path string) string {
 
 	return ls.BaseURL + path
 }
+
+// List walks the upload directory and returns the paths of files under prefix
+func (ls *LocalStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	paths := []string{}
+	base := filepath.Base(ls.UploadDir)
+
+	err := filepath.WalkDir(ls.UploadDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == ls.UploadDir {
+				return fs.SkipAll // Nothing uploaded yet
+			}
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(ls.UploadDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, prefix) {
+			paths = append(paths, "/"+base+"/"+rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLocalStorageList(t *testing.T) {
+	uploadDir := filepath.Join(t.TempDir(), "uploads")
+	ls := NewLocalStorage(uploadDir, "")
+
+	// Missing directory lists as empty
+	paths, err := ls.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unexpected error listing missing dir: %v", err)
+	}
+	if len(paths) != 0 {
+		t.Errorf("Expected no paths, got %v", paths)
+	}
+
+	for _, name := range []string{"a.png", "b.jpg", "avatars/c.png"} {
+		path := filepath.Join(uploadDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0640); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{prefix: "", expected: []string{"/uploads/a.png", "/uploads/avatars/c.png", "/uploads/b.jpg"}},
+		{prefix: "a", expected: []string{"/uploads/a.png", "/uploads/avatars/c.png"}},
+		{prefix: "avatars/", expected: []string{"/uploads/avatars/c.png"}},
+		{prefix: "z", expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run("prefix_"+tt.prefix, func(t *testing.T) {
+			paths, err := ls.List(context.Background(), tt.prefix)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			slices.Sort(paths)
+			if !slices.Equal(paths, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, paths)
+			}
+		})
+	}
+}
+
+func TestLocalStorageListMatchesStore(t *testing.T) {
+	dir := t.TempDir()
+	ls := NewLocalStorage(filepath.Join(dir, "uploads"), "")
+
+	src, err := os.Create(filepath.Join(dir, "src.png"))
+	if err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+	defer src.Close()
+
+	stored, err := ls.Store(src, "avatar.png")
+	if err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+
+	paths, err := ls.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(paths, []string{stored}) {
+		t.Errorf("Expected List to return the stored path %q, got %v", stored, paths)
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+)
+
+// Migrate copies every file from src to dst, streaming one file at a time.
+// Files are stored under their base name, matching how uploads are named, and
+// files already present at dst are skipped so an interrupted run can be resumed.
+// Each copy must keep the path src had for it, since that is what the users
+// table refers to; a backend laid out differently stops the run, with the
+// stray copy removed, rather than leave references to files it can't serve.
+// Between local and S3 storage that means an S3 key prefix of "uploads".
+// It returns the number of files copied.
+func Migrate(ctx context.Context, src, dst FileStorage) (migrated int, err error) {
+	paths, err := src.List(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("listing source: %w", err)
+	}
+
+	for i, srcPath := range paths {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+
+		filename := path.Base(srcPath)
+		exists, err := dst.Exists(ctx, filename)
+		if err != nil {
+			return migrated, fmt.Errorf("checking %s: %w", filename, err)
+		}
+		if exists {
+			log.Printf("[%d/%d] Skipped %s (already at destination)", i+1, len(paths), filename)
+			continue
+		}
+
+		if err := copyFile(ctx, src, dst, srcPath, filename); err != nil {
+			return migrated, err
+		}
+		migrated++
+		log.Printf("[%d/%d] Migrated %s", i+1, len(paths), filename)
+	}
+
+	return migrated, nil
+}
+
+// copyFile streams a single file from src to dst, checking it lands at the same path
+func copyFile(ctx context.Context, src, dst FileStorage, srcPath, filename string) error {
+	reader, err := src.Open(ctx, srcPath)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", srcPath, err)
+	}
+	defer reader.Close()
+
+	dstPath, err := dst.Store(reader, filename)
+	if err != nil {
+		return fmt.Errorf("storing %s: %w", filename, err)
+	}
+	if dstPath != srcPath {
+		_ = dst.Delete(dstPath)
+		return fmt.Errorf("%s would move to %s, breaking the references to it: the destination must store files under the same directory", srcPath, dstPath)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeUpload puts a file straight into a local storage's upload directory
+func writeUpload(t *testing.T, ls *LocalStorage, name string, content []byte) {
+	t.Helper()
+	if err := os.MkdirAll(ls.UploadDir, 0750); err != nil {
+		t.Fatalf("Failed to create upload dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(ls.UploadDir, name), content, 0640); err != nil {
+		t.Fatalf("Failed to write upload: %v", err)
+	}
+}
+
+func TestMigrateBetweenLocalStorages(t *testing.T) {
+	src := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"), "")
+	dst := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"), "")
+
+	files := map[string][]byte{
+		"a.png": []byte("first image"),
+		"b.jpg": bytes.Repeat([]byte("x"), 64*1024),
+		"c.gif": {},
+	}
+	for name, content := range files {
+		writeUpload(t, src, name, content)
+	}
+
+	migrated, err := Migrate(context.Background(), src, dst)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if migrated != len(files) {
+		t.Errorf("Expected %d files migrated, got %d", len(files), migrated)
+	}
+
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dst.UploadDir, name))
+		if err != nil {
+			t.Fatalf("Expected %s at destination: %v", name, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("Content mismatch for %s", name)
+		}
+	}
+
+	// A second run finds everything already present
+	migrated, err = Migrate(context.Background(), src, dst)
+	if err != nil {
+		t.Fatalf("Unexpected error on rerun: %v", err)
+	}
+	if migrated != 0 {
+		t.Errorf("Expected rerun to copy nothing, got %d", migrated)
+	}
+}
+
+func TestMigrateLocalToS3(t *testing.T) {
+	src := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"), "")
+	client := &memoryS3{objects: map[string][]byte{}}
+	dst := &S3Storage{Client: client, BucketName: "bucket", BaseURL: "https://cdn.example.com", KeyPrefix: "uploads"}
+
+	content := []byte("profile picture")
+	writeUpload(t, src, "a.png", content)
+
+	// This is what a user's profile_picture holds before the move
+	ref, err := src.Store(bytes.NewReader(content), "a.png")
+	if err != nil {
+		t.Fatalf("Failed to store source file: %v", err)
+	}
+
+	if _, err := Migrate(context.Background(), src, dst); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The unchanged reference resolves on the destination
+	reader, err := dst.Open(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("Expected %s to open on the destination: %v", ref, err)
+	}
+	defer reader.Close()
+	got, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Content mismatch for %s: %q (%v)", ref, got, err)
+	}
+	if url := dst.GetPublicURL(ref); url != "https://cdn.example.com/uploads/a.png" {
+		t.Errorf("Unexpected public URL %q", url)
+	}
+}
+
+func TestMigrateRejectsDifferentLayout(t *testing.T) {
+	src := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"), "")
+	client := &memoryS3{objects: map[string][]byte{}}
+	dst := &S3Storage{Client: client, BucketName: "bucket"}
+	writeUpload(t, src, "a.png", []byte("data"))
+
+	if _, err := Migrate(context.Background(), src, dst); err == nil {
+		t.Fatal("Expected error when the destination stores files elsewhere")
+	}
+	if len(client.objects) != 0 {
+		t.Errorf("Expected the stray copy to be removed, got %d objects", len(client.objects))
+	}
+}
+
+func TestMigrateSkipsExistingFiles(t *testing.T) {
+	src := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"), "")
+	dst := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"), "")
+
+	writeUpload(t, src, "a.png", []byte("new"))
+	writeUpload(t, src, "b.png", []byte("new"))
+	writeUpload(t, dst, "a.png", []byte("existing"))
+
+	migrated, err := Migrate(context.Background(), src, dst)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if migrated != 1 {
+		t.Errorf("Expected 1 file migrated, got %d", migrated)
+	}
+
+	// The existing file is left untouched
+	got, err := os.ReadFile(filepath.Join(dst.UploadDir, "a.png"))
+	if err != nil || string(got) != "existing" {
+		t.Errorf("Expected existing destination file to be kept, got %q (%v)", got, err)
+	}
+}
+
+func TestMigrateCancelled(t *testing.T) {
+	src := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"), "")
+	dst := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"), "")
+	writeUpload(t, src, "a.png", []byte("data"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := Migrate(ctx, src, dst); err == nil {
+		t.Error("Expected error for cancelled context")
+	}
+}
@@ -2,23 +2,43 @@ package storage
 
 import (
 	"context"
-	"mime/multipart"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+// S3API is the subset of the S3 client S3Storage uses, so tests can swap in a fake
+type S3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
 // S3Storage implements FileStorage for AWS S3
 type S3Storage struct {
-	Client     *s3.Client
+	Client     S3API
 	BucketName string
 	Region     string
 	BaseURL    string
+	// KeyPrefix is the directory new objects are stored under, empty stores
+	// them at the bucket root as buckets set up before it existed do. Setting
+	// it to "uploads", the directory LocalStorage is given, keeps a file's
+	// "/uploads/name" path when it moves between backends.
+	KeyPrefix string
 }
 
-// NewS3Storage creates a new S3Storage instance
-func NewS3Storage(bucketName, region, baseURL string) (*S3Storage, error) {
+// NewS3Storage creates a new S3Storage instance storing objects under keyPrefix
+func NewS3Storage(bucketName, region, baseURL, keyPrefix string) (*S3Storage, error) {
 	// Load AWS configuration
 	cfg, err := config.LoadDefaultConfig(context.TODO(),
 		config.WithRegion(region),
@@ -35,17 +55,33 @@ func NewS3Storage(bucketName, region, baseURL string) (*S3Storage, error) {
 		BucketName: bucketName,
 		Region:     region,
 		BaseURL:    baseURL,
+		KeyPrefix:  strings.Trim(keyPrefix, "/"),
 	}, nil
 }
 
+// key returns the object key a sanitized filename is stored under
+func (s *S3Storage) key(filename string) string {
+	if s.KeyPrefix == "" {
+		return filename
+	}
+	return s.KeyPrefix + "/" + filename
+}
+
 // Store uploads a file to S3 and returns its public URL
-func (s *S3Storage) Store(file multipart.File, filename string) (string, error) {
+func (s *S3Storage) Store(file io.Reader, filename string) (string, error) {
 	ctx := context.Background()
 
+	// Keys are checked like local filenames, so one can't nest or escape a prefix
+	filename, err := SanitizeFilename(filename)
+	if err != nil {
+		return "", err
+	}
+
 	// Upload the file to S3
-	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
+	key := s.key(filename)
+	_, err = s.Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(s.BucketName),
-		Key:    aws.String(filename),
+		Key:    aws.String(key),
 		Body:   file,
 	})
 	if err != nil {
@@ -53,22 +89,71 @@ func (s *S3Storage) Store(file multipart.File, filename string) (string, error)
 	}
 
 	// Return the path to the file
-	return "/" + filename, nil
+	return "/" + key, nil
+}
+
+// objectKey turns a path Store or List returned into its object key. Keys may
+// be nested, but ".." segments, backslashes and control characters are
+// refused so a tampered path can't reach keys outside what was stored.
+func objectKey(path string) (string, error) {
+	key := strings.TrimPrefix(path, "/")
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) ||
+		slices.Contains(strings.Split(key, "/"), "..") || strings.IndexFunc(key, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidFilename, path)
+	}
+	return key, nil
+}
+
+// Open streams an object from S3 by the path Store returned
+func (s *S3Storage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	key, err := objectKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Exists reports whether an object was stored under filename
+func (s *S3Storage) Exists(ctx context.Context, filename string) (bool, error) {
+	filename, err := SanitizeFilename(filename)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(s.key(filename)),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // Delete removes a file from S3
 func (s *S3Storage) Delete(path string) error {
 	ctx := context.Background()
 
-	// Remove leading slash if present
-	if path != "" && path[0] == '/' {
-		path = path[1:]
+	key, err := objectKey(path)
+	if err != nil {
+		return err
 	}
 
 	// Delete the file from S3
-	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+	_, err = s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.BucketName),
-		Key:    aws.String(path),
+		Key:    aws.String(key),
 	})
 	return err
 }
@@ -91,3 +176,29 @@ func (s *S3Storage) GetPublicURL(path string) string {
 	// Return the standard S3 URL
 	return "https://" + s.BucketName + ".s3." + s.Region + ".amazonaws.com/" + path
 }
+
+// List returns the paths of objects under KeyPrefix whose name starts with
+// prefix, following continuation tokens so buckets with more than 1000 objects
+// are fully listed. Objects outside KeyPrefix belong to something else sharing
+// the bucket and are never returned, so callers can't delete them by mistake.
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.BucketName),
+	}
+	if key := s.key(prefix); key != "" {
+		input.Prefix = aws.String(key)
+	}
+
+	paths := []string{}
+	paginator := s3.NewListObjectsV2Paginator(s.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			paths = append(paths, "/"+aws.ToString(object.Key))
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 serves ListObjectsV2 from fixed pages chained by continuation tokens
+type fakeS3 struct {
+	S3API
+
+	pages    map[string][]string // Continuation token ("" for the first page) to keys
+	next     map[string]string   // Continuation token to the token of the following page
+	prefixes []string            // Prefix sent with each call
+	err      error
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.prefixes = append(f.prefixes, aws.ToString(params.Prefix))
+
+	token := aws.ToString(params.ContinuationToken)
+	out := &s3.ListObjectsV2Output{}
+	for _, key := range f.pages[token] {
+		out.Contents = append(out.Contents, types.Object{Key: aws.String(key)})
+	}
+	if next, ok := f.next[token]; ok {
+		out.IsTruncated = aws.Bool(true)
+		out.NextContinuationToken = aws.String(next)
+	}
+	return out, nil
+}
+
+// recordingS3 records the keys of object calls, prefixed by the operation
+type recordingS3 struct {
+	S3API
+	keys []string
+}
+
+func (r *recordingS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	io.Copy(io.Discard, params.Body)
+	r.keys = append(r.keys, "PutObject "+aws.ToString(params.Key))
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (r *recordingS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	r.keys = append(r.keys, "GetObject "+aws.ToString(params.Key))
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func (r *recordingS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	r.keys = append(r.keys, "HeadObject "+aws.ToString(params.Key))
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func (r *recordingS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	r.keys = append(r.keys, "DeleteObject "+aws.ToString(params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// memoryS3 keeps objects in a map, enough to store and read files back
+type memoryS3 struct {
+	S3API
+	objects map[string][]byte
+}
+
+func (m *memoryS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[aws.ToString(params.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *memoryS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	data, ok := m.objects[aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (m *memoryS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if _, ok := m.objects[aws.ToString(params.Key)]; !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func (m *memoryS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	delete(m.objects, aws.ToString(params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *memoryS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	out := &s3.ListObjectsV2Output{}
+	for _, key := range slices.Sorted(maps.Keys(m.objects)) {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			out.Contents = append(out.Contents, types.Object{Key: aws.String(key)})
+		}
+	}
+	return out, nil
+}
+
+func TestS3StorageRejectsTraversalKeys(t *testing.T) {
+	client := &recordingS3{}
+	s := &S3Storage{Client: client, BucketName: "bucket"}
+	ctx := context.Background()
+
+	for _, path := range []string{"/../secret", "//other/key", "/avatars/../../secret", `/..\secret`, "/a\x00b", "/", ""} {
+		if _, err := s.Open(ctx, path); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("Open(%q): expected ErrInvalidFilename, got %v", path, err)
+		}
+		if err := s.Delete(path); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("Delete(%q): expected ErrInvalidFilename, got %v", path, err)
+		}
+	}
+	for _, filename := range []string{"../secret", "/avatar.png", "avatars/a.png", `..\secret`} {
+		if _, err := s.Exists(ctx, filename); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("Exists(%q): expected ErrInvalidFilename, got %v", filename, err)
+		}
+	}
+	if len(client.keys) != 0 {
+		t.Fatalf("Expected no calls to S3, got %q", client.keys)
+	}
+
+	// Paths Store and List hand out still work, nested ones included
+	if reader, err := s.Open(ctx, "/avatars/a.png"); err != nil {
+		t.Errorf("Open of a nested key: unexpected error %v", err)
+	} else {
+		reader.Close()
+	}
+	if err := s.Delete("/avatar.png"); err != nil {
+		t.Errorf("Delete: unexpected error %v", err)
+	}
+	if _, err := s.Exists(ctx, "avatar.png"); err != nil {
+		t.Errorf("Exists: unexpected error %v", err)
+	}
+	expected := []string{"GetObject avatars/a.png", "DeleteObject avatar.png", "HeadObject avatar.png"}
+	if !slices.Equal(client.keys, expected) {
+		t.Errorf("Expected calls %q, got %q", expected, client.keys)
+	}
+}
+
+func TestS3StorageListPaginates(t *testing.T) {
+	client := &fakeS3{
+		pages: map[string][]string{
+			"":      {"a.png", "b.png"},
+			"page2": {"c.png"},
+			"page3": {"d.png"},
+		},
+		next: map[string]string{"": "page2", "page2": "page3"},
+	}
+	s := &S3Storage{Client: client, BucketName: "bucket"}
+
+	paths, err := s.List(context.Background(), "avatars/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{"/a.png", "/b.png", "/c.png", "/d.png"}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+	if len(client.prefixes) != 3 {
+		t.Errorf("Expected 3 list calls, got %d", len(client.prefixes))
+	}
+	for _, prefix := range client.prefixes {
+		if prefix != "avatars/" {
+			t.Errorf("Expected prefix to be sent on every page, got %q", prefix)
+		}
+	}
+}
+
+func TestS3StorageListEmpty(t *testing.T) {
+	s := &S3Storage{Client: &fakeS3{}, BucketName: "bucket"}
+
+	paths, err := s.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if paths == nil || len(paths) != 0 {
+		t.Errorf("Expected empty non-nil list, got %#v", paths)
+	}
+}
+
+func TestS3StorageListError(t *testing.T) {
+	s := &S3Storage{Client: &fakeS3{err: errors.New("access denied")}, BucketName: "bucket"}
+
+	if _, err := s.List(context.Background(), ""); err == nil {
+		t.Error("Expected list error to be returned")
+	}
+}
+
+func TestS3StorageKeyPrefix(t *testing.T) {
+	client := &memoryS3{objects: map[string][]byte{"legacy.png": []byte("old")}}
+	s := &S3Storage{Client: client, BucketName: "bucket", BaseURL: "https://cdn.example.com", KeyPrefix: "uploads"}
+	ctx := context.Background()
+
+	path, err := s.Store(strings.NewReader("new"), "avatar.png")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != "/uploads/avatar.png" {
+		t.Errorf("Expected path /uploads/avatar.png, got %q", path)
+	}
+	if _, ok := client.objects["uploads/avatar.png"]; !ok {
+		t.Errorf("Expected object under uploads/, got keys %v", slices.Collect(maps.Keys(client.objects)))
+	}
+	if exists, err := s.Exists(ctx, "avatar.png"); err != nil || !exists {
+		t.Errorf("Expected stored file to exist, got %v (%v)", exists, err)
+	}
+	if got := s.GetPublicURL(path); got != "https://cdn.example.com/uploads/avatar.png" {
+		t.Errorf("Unexpected public URL %q", got)
+	}
+
+	// Paths stored before the prefix still resolve
+	reader, err := s.Open(ctx, "/legacy.png")
+	if err != nil {
+		t.Fatalf("Open of a legacy path: unexpected error %v", err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); string(data) != "old" {
+		t.Errorf("Expected legacy content, got %q", data)
+	}
+}
+
+func TestS3StorageListScopedToKeyPrefix(t *testing.T) {
+	client := &memoryS3{objects: map[string][]byte{
+		"uploads/a.png":      nil,
+		"uploads/b.png":      nil,
+		"uploadsextra/c.png": nil,
+		"backups/db.sql":     nil,
+		"legacy.png":         nil,
+	}}
+	s := &S3Storage{Client: client, BucketName: "bucket", KeyPrefix: "uploads"}
+
+	paths, err := s.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"/uploads/a.png", "/uploads/b.png"}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+
+	paths, err = s.List(context.Background(), "b")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(paths, []string{"/uploads/b.png"}) {
+		t.Errorf("Expected only /uploads/b.png, got %v", paths)
+	}
+}
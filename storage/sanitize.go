@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxFilenameLength is the longest filename, in bytes, a backend will store.
+// It matches the usual filesystem limit and is well under S3's key limit.
+const MaxFilenameLength = 255
+
+// ErrInvalidFilename is returned by Store for names SanitizeFilename rejects
+var ErrInvalidFilename = errors.New("invalid filename")
+
+// SanitizeFilename checks that name is safe to store as a single file or
+// object key: no path separators or traversal, no control characters and no
+// more than MaxFilenameLength bytes. Every backend calls it before writing, so
+// a name is either accepted everywhere or nowhere. It returns the name to
+// store under.
+func SanitizeFilename(name string) (string, error) {
+	switch {
+	case name == "", name == ".", name == "..":
+		return "", fmt.Errorf("%w: %q", ErrInvalidFilename, name)
+	case len(name) > MaxFilenameLength:
+		return "", fmt.Errorf("%w: longer than %d bytes", ErrInvalidFilename, MaxFilenameLength)
+	case !utf8.ValidString(name):
+		return "", fmt.Errorf("%w: not valid UTF-8", ErrInvalidFilename)
+	case strings.Contains(name, ".."), strings.ContainsAny(name, `/\`):
+		// On S3 a slash would nest the key and ".." could climb out of a prefix
+		return "", fmt.Errorf("%w: %q contains a path", ErrInvalidFilename, name)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return "", fmt.Errorf("%w: %q contains a control character", ErrInvalidFilename, name)
+	}
+	return name, nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// unsafeFilenames are rejected by SanitizeFilename and so by every backend
+var unsafeFilenames = map[string]string{
+	"empty":            "",
+	"dot":              ".",
+	"dot_dot":          "..",
+	"traversal":        "../secret.png",
+	"embedded_dot_dot": "a..b.png",
+	"leading_slash":    "/avatar.png",
+	"nested":           "avatars/a.png",
+	"backslash":        `..\avatar.png`,
+	"nul":              "avatar\x00.png",
+	"newline":          "avatar\n.png",
+	"delete":           "avatar\x7f.png",
+	"invalid_utf8":     "avatar\xff.png",
+	"over_length":      strings.Repeat("a", MaxFilenameLength-3) + ".png",
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	for name, filename := range unsafeFilenames {
+		t.Run(name, func(t *testing.T) {
+			if _, err := SanitizeFilename(filename); !errors.Is(err, ErrInvalidFilename) {
+				t.Errorf("Expected ErrInvalidFilename for %q, got %v", filename, err)
+			}
+		})
+	}
+
+	for _, filename := range []string{
+		"0b7c3e4a-9f1d-4c2e-8a6b-2f5d9e1c7a30_5e2f8c1d-3a4b-4f6e-9d7c-1b8a2e3f4c5d.png",
+		"avatar.png",
+		strings.Repeat("a", MaxFilenameLength-4) + ".png",
+	} {
+		got, err := SanitizeFilename(filename)
+		if err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", filename, err)
+		}
+		if got != filename {
+			t.Errorf("Expected %q back unchanged, got %q", filename, got)
+		}
+	}
+}
+
+func TestLocalStorageStoreRejectsUnsafeFilenames(t *testing.T) {
+	dir := t.TempDir()
+	ls := NewLocalStorage(filepath.Join(dir, "uploads"), "")
+
+	for name, filename := range unsafeFilenames {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ls.Store(strings.NewReader("data"), filename); !errors.Is(err, ErrInvalidFilename) {
+				t.Errorf("Expected ErrInvalidFilename, got %v", err)
+			}
+		})
+	}
+
+	// Nothing escaped into the parent directory
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read dir: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "uploads" {
+			t.Errorf("Unexpected file %q outside the upload dir", entry.Name())
+		}
+	}
+}
+
+func TestS3StorageStoreRejectsUnsafeFilenames(t *testing.T) {
+	client := &recordingS3{}
+	s := &S3Storage{Client: client, BucketName: "bucket"}
+
+	for name, filename := range unsafeFilenames {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.Store(strings.NewReader("data"), filename); !errors.Is(err, ErrInvalidFilename) {
+				t.Errorf("Expected ErrInvalidFilename, got %v", err)
+			}
+		})
+	}
+	if len(client.keys) != 0 {
+		t.Errorf("Expected no calls to S3, got keys %q", client.keys)
+	}
+
+	path, err := s.Store(strings.NewReader("data"), "avatar.png")
+	if err != nil {
+		t.Fatalf("Unexpected error storing a safe name: %v", err)
+	}
+	if path != "/avatar.png" || len(client.keys) != 1 || client.keys[0] != "PutObject avatar.png" {
+		t.Errorf("Expected avatar.png stored at /avatar.png, got path %q keys %q", path, client.keys)
+	}
+}
@@ -1,17 +1,28 @@
 package storage
 
 import (
-	"mime/multipart"
+	"context"
+	"io"
 )
 
 // FileStorage defines the interface for file operations
 type FileStorage interface {
 	// Store saves a file and returns its public path
-	Store(file multipart.File, filename string) (string, error)
+	Store(file io.Reader, filename string) (string, error)
+
+	// Open returns a reader for a file by the path Store returned
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+
+	// Exists reports whether a file was stored under filename
+	Exists(ctx context.Context, filename string) (bool, error)
 
 	// Delete removes a file by its path
 	Delete(path string) error
 
 	// GetPublicURL returns the public URL for a stored file
 	GetPublicURL(path string) string
+
+	// List returns the paths of stored files whose name starts with prefix,
+	// in the same form Store returns. An empty prefix lists everything.
+	List(ctx context.Context, prefix string) ([]string, error)
 }